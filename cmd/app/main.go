@@ -37,6 +37,8 @@ func main() {
 
 	application := app.New(ctx, cfg)
 	go application.GRPCApp.MustRun(ctx)
+	go application.HTTPApp.MustRun(ctx)
+	go application.KeyManager.Run(ctx)
 	go application.RedpandaClient.Start(ctx)
 
 	stop := make(chan os.Signal, 1)
@@ -44,6 +46,8 @@ func main() {
 	<-stop
 
 	application.GRPCApp.Stop(ctx)
+	application.HTTPApp.Stop(ctx)
+	application.KeyManager.Stop(ctx)
 	application.RedpandaClient.Stop(ctx)
 	log.Info(ctx, "application stopped")
 }
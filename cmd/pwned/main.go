@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/pwned"
+)
+
+// Builds the breached passwords index from a HIBP hash list sorted by hash.
+// The input is either a single file of "<sha1>:<count>" lines or a directory of
+// range files named by the 5 character hash prefix with "<suffix>:<count>" lines
+func main() {
+	var in, out string
+
+	flag.StringVar(&in, "in", "", "hash list file or directory of range files")
+	flag.StringVar(&out, "out", "pwned.idx", "index file")
+	flag.Parse()
+
+	if in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	count, err := build(in, out)
+	if err != nil {
+		os.Remove(out)
+
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d hashes read, index written to %s\n", count, out)
+}
+
+func build(in, out string) (int, error) {
+	stat, err := os.Stat(in)
+	if err != nil {
+		return 0, err
+	}
+
+	files := []string{in}
+	if stat.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(in, "*.txt")); err != nil {
+			return 0, err
+		}
+
+		sort.Strings(files)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	w := pwned.NewWriter(bw)
+
+	count := 0
+	for _, file := range files {
+		// range files hold hash suffixes, the prefix is the file name
+		prefix := ""
+		if stat.IsDir() {
+			prefix = strings.TrimSuffix(filepath.Base(file), ".txt")
+		}
+
+		n, err := addFile(w, file, prefix)
+		if err != nil {
+			return 0, err
+		}
+
+		count += n
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+
+	return count, f.Sync()
+}
+
+func addFile(w *pwned.Writer, file, prefix string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		hash, err := pwned.ParseHash(prefix + scanner.Text())
+		if err != nil {
+			return 0, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+
+		if err := w.Add(hash); err != nil {
+			return 0, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+
+		count++
+	}
+
+	return count, scanner.Err()
+}
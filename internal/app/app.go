@@ -2,21 +2,42 @@ package app
 
 import (
 	"context"
+	"log/slog"
+	"strings"
 
 	grpcapp "github.com/hesoyamTM/apphelper-sso/internal/app/grpc"
+	httpapp "github.com/hesoyamTM/apphelper-sso/internal/app/http"
 	"github.com/hesoyamTM/apphelper-sso/internal/clients/redpanda"
 	"github.com/hesoyamTM/apphelper-sso/internal/config"
-	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	grpcauth "github.com/hesoyamTM/apphelper-sso/internal/grpc/auth"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/password"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/pwned"
 	"github.com/hesoyamTM/apphelper-sso/internal/migrations"
 	"github.com/hesoyamTM/apphelper-sso/internal/services/auth"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/clients"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/impersonation"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/invites"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/keys"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/lockout"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/mfa"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/oauth"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/organizations"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/passkey"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/personaltokens"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/roles"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/serviceaccounts"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/tokens"
 	"github.com/hesoyamTM/apphelper-sso/internal/storage/psql"
 	"github.com/hesoyamTM/apphelper-sso/internal/storage/redis"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
 )
 
 const migrationsDir = "migrations"
 
 type App struct {
 	GRPCApp        *grpcapp.App
+	HTTPApp        *httpapp.App
+	KeyManager     *keys.Manager
 	RedpandaClient *redpanda.RedPandaClient
 }
 
@@ -38,9 +59,12 @@ func New(ctx context.Context, cfg *config.Config) *App {
 		panic(err)
 	}
 
-	rDB := redis.New(ctx, cfg.Redis)
+	rDB, err := redis.New(ctx, cfg.Redis)
+	if err != nil {
+		panic(err)
+	}
 
-	privKey, err := jwt.DecodePrivateKey(cfg.PrivateKey)
+	keyManager, err := keys.New(ctx, psqlDB, cfg.KeysUpdateInterval, cfg.AccessTokenTTL)
 	if err != nil {
 		panic(err)
 	}
@@ -50,28 +74,136 @@ func New(ctx context.Context, cfg *config.Config) *App {
 		panic(err)
 	}
 
-	authService := auth.New(
+	lockoutService := lockout.New(ctx, rDB, cfg.Lockout)
+
+	mfaService := mfa.New(ctx, psqlDB, psqlDB, lockoutService, cfg.TOTPIssuer)
+
+	passkeyService, err := passkey.New(ctx, psqlDB, psqlDB, rDB, cfg.WebAuthn)
+	if err != nil {
+		panic(err)
+	}
+
+	var breachedPasswords password.BreachedPasswords
+	if cfg.PasswordPolicy.BreachedIndex != "" {
+		index, err := pwned.Open(cfg.PasswordPolicy.BreachedIndex)
+		if err != nil {
+			panic(err)
+		}
+
+		breachedPasswords = index
+	}
+
+	// the iss of the access tokens is the one of the id tokens and the discovery document
+	issuer := strings.TrimSuffix(cfg.OAuth.Issuer, "/")
+
+	roleService := roles.New(ctx, psqlDB)
+	orgService := organizations.New(ctx, psqlDB)
+	personalTokenService := personaltokens.New(ctx, psqlDB, psqlDB, roleService, issuer, cfg.TokenAudience)
+
+	passwordHasher := password.NewHasher(cfg.Password)
+	passwordPolicy := password.NewPolicy(cfg.PasswordPolicy, breachedPasswords)
+
+	authService := auth.New(ctx, auth.Deps{
+		RedpandaClient:  redpandaClient,
+		UserStorage:     psqlDB,
+		SessionsStorage: rDB,
+		CodeStorage:     rDB,
+		TokenStorage:    rDB,
+		MFAChallenges:   rDB,
+		MFA:             mfaService,
+		Passkeys:        passkeyService,
+		Lockout:         lockoutService,
+		Roles:           roleService,
+		Organizations:   orgService,
+		PasswordHasher:  passwordHasher,
+		PasswordPolicy:  passwordPolicy,
+		KeyProvider:     keyManager,
+	}, auth.Config{
+		PasswordHistory: cfg.PasswordPolicy.History,
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+		CodeTTL:         cfg.CodeTTL,
+		TokenTTL:        cfg.TokenTTL,
+		MFAChallengeTTL: cfg.MFAChallengeTTL,
+		Issuer:          issuer,
+		Audience:        cfg.TokenAudience,
+	})
+
+	inviteService := invites.New(
 		ctx,
 		redpandaClient,
 		psqlDB,
+		psqlDB,
+		orgService,
+		authService,
+		passwordHasher,
+		passwordPolicy,
+		cfg.InviteTTL,
+	)
+
+	clientService := clients.New(ctx, psqlDB, cfg.AccessTokenTTL)
+
+	serviceAccountService := serviceaccounts.New(ctx, psqlDB, rDB)
+
+	impersonationService := impersonation.New(
+		ctx,
+		redpandaClient,
+		psqlDB,
+		psqlDB,
+		roleService,
+		keyManager,
+		cfg.ImpersonationTTL,
+		issuer,
+		cfg.TokenAudience,
+	)
+
+	oauthService := oauth.New(
+		ctx,
+		clientService,
 		rDB,
 		rDB,
-		rDB,
+		authService,
+		psqlDB,
+		keyManager,
+		serviceAccountService,
 		cfg.AccessTokenTTL,
-		cfg.RefreshTokenTTL,
-		cfg.CodeTTL,
-		cfg.TokenTTL,
-		privKey,
+		cfg.TokenAudience,
+		cfg.OAuth,
 	)
 
-	if err != nil {
-		panic(err)
-	}
+	tokenService := tokens.New(ctx, keyManager, rDB, rDB, personalTokenService, issuer, cfg.TokenAudience)
+
+	authInterceptor := authorization.NewServerWithKeySet(
+		slog.Default(),
+		grpcauth.AuthMethods(),
+		keyManager.Subscribe(),
+		authorization.WithIssuer(issuer),
+		authorization.WithAudience(cfg.TokenAudience),
+		authorization.WithDenyList(rDB),
+		authorization.WithPermissions(grpcauth.MethodPermissions()),
+		authorization.WithPersonalAccessTokens(personalTokenService),
+	)
 
-	grpcApp := grpcapp.New(ctx, authService, cfg.Grpc)
+	grpcApp := grpcapp.New(ctx, grpcauth.Services{
+		Auth:            authService,
+		MFA:             mfaService,
+		Passkeys:        passkeyService,
+		Clients:         clientService,
+		Devices:         oauthService,
+		Tokens:          tokenService,
+		Roles:           roleService,
+		Organizations:   orgService,
+		Invites:         inviteService,
+		PersonalTokens:  personalTokenService,
+		ServiceAccounts: serviceAccountService,
+		Impersonation:   impersonationService,
+	}, authInterceptor, cfg.Grpc)
+	httpApp := httpapp.New(ctx, keyManager, oauthService, tokenService, cfg.Http)
 
 	return &App{
 		GRPCApp:        grpcApp,
+		HTTPApp:        httpApp,
+		KeyManager:     keyManager,
 		RedpandaClient: redpandaClient,
 	}
 }
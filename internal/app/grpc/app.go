@@ -7,6 +7,8 @@ import (
 
 	"github.com/hesoyamTM/apphelper-sso/internal/config"
 	"github.com/hesoyamTM/apphelper-sso/internal/grpc/auth"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/device"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
 	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
 	"go.opentelemetry.io/otel"
 
@@ -23,7 +25,12 @@ type App struct {
 	config     config.GRPC
 }
 
-func New(ctx context.Context, authServ auth.Auth, config config.GRPC) *App {
+func New(ctx context.Context, services auth.Services, authInterceptor *authorization.ServerInterceptor, config config.GRPC) *App {
+	trustedProxies, err := device.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
 	so := opentelemetry.ServerOption(opentelemetry.Options{
 		MetricsOptions: opentelemetry.MetricsOptions{
 			MeterProvider: otel.GetMeterProvider(),
@@ -37,10 +44,14 @@ func New(ctx context.Context, authServ auth.Auth, config config.GRPC) *App {
 
 	gRPCServer := grpc.NewServer(
 		so,
-		grpc.UnaryInterceptor(logger.LoggingInterceptor(ctx)),
+		grpc.ChainUnaryInterceptor(
+			logger.LoggingInterceptor(ctx),
+			device.UnaryServerInterceptor(trustedProxies),
+			authInterceptor.Unary(),
+		),
 	)
 
-	auth.RegisterServer(gRPCServer, authServ)
+	auth.RegisterServer(gRPCServer, services)
 
 	return &App{
 		log:        logger.GetLoggerFromCtx(ctx),
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/config"
+	"github.com/hesoyamTM/apphelper-sso/internal/http/oauth"
+	"github.com/hesoyamTM/apphelper-sso/internal/http/wellknown"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/device"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type App struct {
+	log        *logger.Logger
+	httpServer *http.Server
+	config     config.HTTP
+}
+
+func New(ctx context.Context, keysProvider wellknown.KeysProvider, oauthService oauth.OAuth, tokenService oauth.Tokens, config config.HTTP) *App {
+	trustedProxies, err := device.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+
+	wellknown.RegisterHandlers(mux, keysProvider)
+	oauth.RegisterHandlers(mux, oauthService, tokenService)
+
+	httpServer := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", config.Host, config.Port),
+		Handler: logger.LoggingMiddleware(ctx)(device.Middleware(trustedProxies)(mux)),
+	}
+
+	return &App{
+		log:        logger.GetLoggerFromCtx(ctx),
+		httpServer: httpServer,
+		config:     config,
+	}
+}
+
+func (a *App) MustRun(ctx context.Context) {
+	if err := a.run(ctx); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) run(ctx context.Context) error {
+	a.log.Info(ctx, fmt.Sprintf("http server is running on %s:%d", a.config.Host, a.config.Port))
+
+	if err := a.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to run server: %w", err)
+	}
+
+	return nil
+}
+
+func (a *App) Stop(ctx context.Context) {
+	a.log.Info(ctx, "http server is stopping")
+
+	if err := a.httpServer.Shutdown(ctx); err != nil {
+		a.log.Error(ctx, fmt.Sprintf("failed to stop http server: %v", err))
+	}
+}
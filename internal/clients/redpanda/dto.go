@@ -1,5 +1,7 @@
 package redpanda
 
+import "time"
+
 type UserRegisteredEvent struct {
 	UserID  string `json:"user_id"`
 	Email   string `json:"email"`
@@ -12,3 +14,37 @@ type VerificationCodeUpdatedEvent struct {
 	Email string `json:"user_id"`
 	Code  string `json:"code"`
 }
+
+type RefreshTokenReusedEvent struct {
+	UserID string `json:"user_id"`
+}
+
+type AccountLockedEvent struct {
+	UserID      string    `json:"user_id"`
+	Email       string    `json:"email"`
+	IP          string    `json:"ip"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// UserInvitedEvent carries the invite token for the notification service to mail,
+// it is sent again with a new token when the invite is resent
+type UserInvitedEvent struct {
+	UserID         string    `json:"user_id"`
+	Email          string    `json:"email"`
+	Name           string    `json:"name"`
+	Surname        string    `json:"surname"`
+	OrganizationID string    `json:"organization_id,omitempty"`
+	InvitedBy      string    `json:"invited_by"`
+	Token          string    `json:"token"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// UserImpersonatedEvent is sent when an admin gets a token to act as a user,
+// TokenID is the jti of the token
+type UserImpersonatedEvent struct {
+	UserID    string    `json:"user_id"`
+	ActorID   string    `json:"actor_id"`
+	Reason    string    `json:"reason"`
+	TokenID   string    `json:"token_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -53,11 +53,71 @@ func (c *RedPandaClient) VerificationCodeUpdated(ctx context.Context, user *Veri
 	return nil
 }
 
+func (c *RedPandaClient) RefreshTokenReused(ctx context.Context, event *RefreshTokenReusedEvent) error {
+	const op = "redpanda.RedPandaClient.RefreshTokenReused"
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := c.sendMessage(ctx, refreshTokenReusedTopic, value); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (c *RedPandaClient) AccountLocked(ctx context.Context, event *AccountLockedEvent) error {
+	const op = "redpanda.RedPandaClient.AccountLocked"
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := c.sendMessage(ctx, accountLockedTopic, value); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (c *RedPandaClient) UserInvited(ctx context.Context, event *UserInvitedEvent) error {
+	const op = "redpanda.RedPandaClient.UserInvited"
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := c.sendMessage(ctx, userInvitedTopic, value); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (c *RedPandaClient) UserImpersonated(ctx context.Context, event *UserImpersonatedEvent) error {
+	const op = "redpanda.RedPandaClient.UserImpersonated"
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := c.sendMessage(ctx, userImpersonatedTopic, value); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
 func (c *RedPandaClient) sendMessage(ctx context.Context, topic string, value []byte) error {
 	const op = "redpanda.RedPandaClient.sendMessage"
 
 	msg := sarama.ProducerMessage{
-		Topic: userRegisteredTopic,
+		Topic: topic,
 		Value: sarama.ByteEncoder(value),
 	}
 
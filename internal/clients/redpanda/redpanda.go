@@ -15,6 +15,10 @@ const (
 	userRegisteredTopic     = "sso.auth.registered"
 	passwordChangedTopic    = "sso.auth.password.changed"
 	verificationCodeUpdated = "sso.auth.code.updated"
+	refreshTokenReusedTopic = "sso.auth.refresh_token.reused"
+	accountLockedTopic      = "sso.auth.account.locked"
+	userInvitedTopic        = "sso.auth.user.invited"
+	userImpersonatedTopic   = "sso.auth.user.impersonated"
 )
 
 type RedPandaClient struct {
@@ -8,6 +8,10 @@ import (
 	"time"
 
 	"github.com/hesoyamTM/apphelper-notification/pkg/redpanda"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/password"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/lockout"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/oauth"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/passkey"
 	"github.com/hesoyamTM/apphelper-sso/internal/storage/psql"
 	"github.com/hesoyamTM/apphelper-sso/internal/storage/redis"
 	"github.com/hesoyamTM/apphelper-sso/pkg/observability"
@@ -16,24 +20,52 @@ import (
 )
 
 type Config struct {
-	Env        string `yaml:"env" env-required:"true" env:"ENV"`
-	PrivateKey string `yaml:"public_key" env-required:"true" env:"PRIVATE_KEY"`
+	Env string `yaml:"env" env-required:"true" env:"ENV"`
+
+	KeysUpdateInterval time.Duration `yaml:"keys_update_interval" env-required:"true" env:"KEYS_UPDATE_INTERVAL"`
 
 	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" env-required:"true" env:"ACCESS_TOKEN_TTL"`
 	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env-required:"true" env:"REFRESH_TOKEN_TTL"`
 	CodeTTL         time.Duration `yaml:"code_ttl" env-required:"true" env:"CODE_TTL"`
 	TokenTTL        time.Duration `yaml:"token_ttl" env-required:"true" env:"TOKEN_TTL"`
-
-	Grpc          GRPC                     `yaml:"grpc"`
-	Psql          psql.PsqlConfig          `yaml:"psql"`
-	Redis         redis.RedisConfig        `yaml:"redis"`
-	Observability observability.OtelConfig `yaml:"observability"`
-	Redpanda      redpanda.RedpandaConfig  `yaml:"redpanda"`
+	MFAChallengeTTL time.Duration `yaml:"mfa_challenge_ttl" env-required:"true" env:"MFA_CHALLENGE_TTL"`
+	InviteTTL       time.Duration `yaml:"invite_ttl" env-required:"true" env:"INVITE_TTL"`
+
+	// lifetime of the tokens issued by Impersonate, they cannot be refreshed
+	ImpersonationTTL time.Duration `yaml:"impersonation_ttl" env-required:"true" env:"IMPERSONATION_TTL"`
+
+	TOTPIssuer string `yaml:"totp_issuer" env-required:"true" env:"TOTP_ISSUER"`
+
+	// aud of the access tokens, the services accepting them check it
+	TokenAudience string `yaml:"token_audience" env-required:"true" env:"TOKEN_AUDIENCE"`
+
+	Grpc           GRPC                     `yaml:"grpc"`
+	Http           HTTP                     `yaml:"http"`
+	Psql           psql.PsqlConfig          `yaml:"psql"`
+	Redis          redis.RedisConfig        `yaml:"redis"`
+	Observability  observability.OtelConfig `yaml:"observability"`
+	Redpanda       redpanda.RedpandaConfig  `yaml:"redpanda"`
+	WebAuthn       passkey.Config           `yaml:"webauthn"`
+	Lockout        lockout.Config           `yaml:"lockout"`
+	Password       password.Params          `yaml:"password"`
+	PasswordPolicy password.PolicyConfig    `yaml:"password_policy"`
+	OAuth          oauth.Config             `yaml:"oauth"`
 }
 
 type GRPC struct {
 	Host string `yaml:"host" env-required:"true" env:"GRPC_HOST"`
 	Port int    `yaml:"port" env-required:"true" env:"GRPC_PORT"`
+
+	// CIDRs of the proxies whose x-forwarded-for and x-real-ip are honored, such as the api gateway
+	TrustedProxies []string `yaml:"trusted_proxies" env:"GRPC_TRUSTED_PROXIES" env-separator:","`
+}
+
+type HTTP struct {
+	Host string `yaml:"host" env-required:"true" env:"HTTP_HOST"`
+	Port int    `yaml:"port" env-required:"true" env:"HTTP_PORT"`
+
+	// CIDRs of the proxies whose X-Forwarded-For and X-Real-IP are honored
+	TrustedProxies []string `yaml:"trusted_proxies" env:"HTTP_TRUSTED_PROXIES" env-separator:","`
 }
 
 func fetchConfigPath() string {
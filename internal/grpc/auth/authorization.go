@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/roles"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userMethods act on the user of the request, the handlers check the caller is that user
+var userMethods = []string{
+	"UpdateUser",
+	"DeleteUser",
+	"ListSessions",
+	"RevokeSession",
+	"RevokeAllSessions",
+	"EnrollTOTP",
+	"ConfirmTOTP",
+	"DisableTOTP",
+	"RegenerateRecoveryCodes",
+	"BeginPasskeyRegistration",
+	"FinishPasskeyRegistration",
+	"ApproveDevice",
+	"ListUserRoles",
+	"CreatePersonalAccessToken",
+	"ListPersonalAccessTokens",
+	"RevokePersonalAccessToken",
+}
+
+// tenantMethods are scoped to the organization of the caller by the handlers
+var tenantMethods = []string{
+	"GetUser",
+	"GetUsers",
+}
+
+// adminMethods require a permission whoever the caller is
+var adminMethods = map[string]string{
+	"CreateClient":       roles.PermissionClientsWrite,
+	"RotateClientSecret": roles.PermissionClientsWrite,
+	"DisableClient":      roles.PermissionClientsWrite,
+	"CreateRole":         roles.PermissionRolesWrite,
+	"UpdateRole":         roles.PermissionRolesWrite,
+	"DeleteRole":         roles.PermissionRolesWrite,
+	"ListRoles":          roles.PermissionRolesWrite,
+	"AssignRole":         roles.PermissionRolesWrite,
+	"UnassignRole":       roles.PermissionRolesWrite,
+
+	"CreateOrganization":         roles.PermissionOrganizationsWrite,
+	"GetOrganization":            roles.PermissionOrganizationsRead,
+	"ListOrganizations":          roles.PermissionOrganizationsRead,
+	"UpdateOrganizationSettings": roles.PermissionOrganizationsWrite,
+	"AddOrganizationMember":      roles.PermissionOrganizationsWrite,
+	"SetOrganizationMemberRoles": roles.PermissionOrganizationsWrite,
+	"ListOrganizationMembers":    roles.PermissionOrganizationsRead,
+
+	// invites are made in the organization of the caller
+	"InviteUser":   roles.PermissionUsersWrite,
+	"ListInvites":  roles.PermissionUsersRead,
+	"ResendInvite": roles.PermissionUsersWrite,
+	"RevokeInvite": roles.PermissionUsersWrite,
+
+	// the handlers also require the permissions granted to the account
+	"CreateServiceAccount":    roles.PermissionServiceAccountsWrite,
+	"ListServiceAccounts":     roles.PermissionServiceAccountsWrite,
+	"RotateServiceAccountKey": roles.PermissionServiceAccountsWrite,
+	"DisableServiceAccount":   roles.PermissionServiceAccountsWrite,
+
+	"Impersonate": roles.PermissionUsersImpersonate,
+
+	// the tokens of any user or client, clients introspect theirs with the http endpoints
+	"Introspect":  roles.PermissionTokensRead,
+	"RevokeToken": roles.PermissionTokensWrite,
+}
+
+// credentialMethods give lasting access to the account of the user,
+// users call them only with the access token of an interactive login
+var credentialMethods = []string{
+	"DeleteUser",
+	"EnrollTOTP",
+	"ConfirmTOTP",
+	"DisableTOTP",
+	"RegenerateRecoveryCodes",
+	"BeginPasskeyRegistration",
+	"FinishPasskeyRegistration",
+	"ApproveDevice",
+	"CreatePersonalAccessToken",
+}
+
+// AuthMethods returns the full method names that require an access token
+func AuthMethods() map[string]bool {
+	methods := make(map[string]bool, len(userMethods)+len(tenantMethods))
+	for _, method := range userMethods {
+		methods[fullMethod(method)] = true
+	}
+	for _, method := range tenantMethods {
+		methods[fullMethod(method)] = true
+	}
+
+	return methods
+}
+
+// MethodPermissions returns the permissions required by full method name
+func MethodPermissions() map[string]string {
+	permissions := make(map[string]string, len(adminMethods))
+	for method, permission := range adminMethods {
+		permissions[fullMethod(method)] = permission
+	}
+
+	return permissions
+}
+
+func fullMethod(method string) string {
+	return "/" + ssov1.Auth_ServiceDesc.ServiceName + "/" + method
+}
+
+// authorizeUser allows the user itself and, when permission is not empty, the users and
+// service accounts that have the permission. The roles a user has in an organization
+// only grant the permission over the users of that organization
+func (s *serverAPI) authorizeUser(ctx context.Context, userId uuid.UUID, permission string) error {
+	uid, ok := authorization.UserId(ctx)
+	if !ok {
+		if _, ok := authorization.ServiceAccountId(ctx); !ok {
+			return status.Error(codes.Unauthenticated, "authorization token is not provided")
+		}
+	}
+
+	// credentials outlive the token they are created with, a personal access token or
+	// an admin impersonating the user must not be able to extend their access with them
+	if ok && isCredentialMethod(ctx) && !authorization.Interactive(ctx) {
+		return status.Error(codes.PermissionDenied, "an interactive login is required")
+	}
+
+	if ok && uid == userId.String() {
+		return nil
+	}
+
+	if permission == "" || !authorization.HasPermission(ctx, permission) {
+		return status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	orgId, err := callerOrganization(ctx)
+	if err != nil {
+		return err
+	}
+
+	// platform admins and service accounts are outside of any organization
+	if orgId == uuid.Nil {
+		return nil
+	}
+
+	// the user is looked up in the organization of the caller,
+	// users of other organizations are not found
+	if _, err := s.authService.GetUser(ctx, orgId, userId); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return status.Error(codes.PermissionDenied, "permission denied")
+		}
+
+		return status.Error(codes.Internal, "Internal error")
+	}
+
+	return nil
+}
+
+func isCredentialMethod(ctx context.Context) bool {
+	method, _ := grpc.Method(ctx)
+
+	return slices.ContainsFunc(credentialMethods, func(credentialMethod string) bool {
+		return fullMethod(credentialMethod) == method
+	})
+}
+
+// callerScope returns the organization the users the caller looks up are in, models.AnyOrganization
+// for service accounts and platform admins, who manage sso across the organizations
+func callerScope(ctx context.Context) (uuid.UUID, error) {
+	orgId, err := callerOrganization(ctx)
+	if err != nil || orgId != uuid.Nil {
+		return orgId, err
+	}
+
+	if _, ok := authorization.ServiceAccountId(ctx); ok {
+		return models.AnyOrganization, nil
+	}
+
+	if slices.ContainsFunc(roles.PlatformPermissions(), func(permission string) bool {
+		return authorization.HasPermission(ctx, permission)
+	}) {
+		return models.AnyOrganization, nil
+	}
+
+	return uuid.Nil, nil
+}
+
+// callerOrganization returns the organization of the caller, uuid.Nil for users outside of any
+func callerOrganization(ctx context.Context) (uuid.UUID, error) {
+	orgId, ok := authorization.Organization(ctx)
+	if !ok {
+		return uuid.Nil, nil
+	}
+
+	id, err := uuid.Parse(orgId)
+	if err != nil {
+		return uuid.Nil, status.Error(codes.Unauthenticated, "access token is invalid")
+	}
+
+	return id, nil
+}
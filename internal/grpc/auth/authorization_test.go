@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/roles"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeAuth knows the users of the organizations, the methods the tests do not call are left unimplemented
+type fakeAuth struct {
+	Auth
+
+	users map[uuid.UUID]models.User
+}
+
+func (a fakeAuth) GetUser(ctx context.Context, organizationId, id uuid.UUID) (models.User, error) {
+	user, ok := a.users[id]
+	if !ok || (organizationId != models.AnyOrganization && user.OrganizationId != organizationId) {
+		return models.User{}, services.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (a fakeAuth) GetUsers(ctx context.Context, organizationId uuid.UUID, ids []uuid.UUID) ([]models.User, error) {
+	users := make([]models.User, 0, len(ids))
+	for _, id := range ids {
+		if user, err := a.GetUser(ctx, organizationId, id); err == nil {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+func (a fakeAuth) ListSessions(ctx context.Context, userId uuid.UUID) ([]models.Session, error) {
+	return nil, nil
+}
+
+func (a fakeAuth) RevokeSession(ctx context.Context, userId uuid.UUID, sessionId string) error {
+	return nil
+}
+
+func (a fakeAuth) RevokeAllSessions(ctx context.Context, userId uuid.UUID) error {
+	return nil
+}
+
+type fakeRoles struct {
+	Roles
+}
+
+func (r fakeRoles) UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	return nil, nil
+}
+
+type fakeImpersonation struct{}
+
+func (i fakeImpersonation) Impersonate(ctx context.Context, actorId, userId uuid.UUID, reason string) (models.Impersonation, string, error) {
+	return models.Impersonation{ActorId: actorId, UserId: userId}, "token", nil
+}
+
+type tenants struct {
+	server *serverAPI
+
+	// a user of the organization of the admin and a user of another one
+	member, stranger uuid.UUID
+	orgId            uuid.UUID
+}
+
+func setupTenants() tenants {
+	t := tenants{
+		member:   uuid.New(),
+		stranger: uuid.New(),
+		orgId:    uuid.New(),
+	}
+
+	users := map[uuid.UUID]models.User{
+		t.member:   {UserInfo: models.UserInfo{Id: t.member}, OrganizationId: t.orgId},
+		t.stranger: {UserInfo: models.UserInfo{Id: t.stranger}, OrganizationId: uuid.New()},
+	}
+
+	t.server = &serverAPI{
+		authService: fakeAuth{users: users},
+		roleService: fakeRoles{},
+		impService:  fakeImpersonation{},
+	}
+
+	return t
+}
+
+// adminContext is the context of a request authorized with the access token of an admin
+// of the organization, uuid.Nil for a platform admin
+func adminContext(orgId uuid.UUID) context.Context {
+	ctx := context.WithValue(context.Background(), authorization.Uid, uuid.NewString())
+	if orgId != uuid.Nil {
+		ctx = context.WithValue(ctx, authorization.OrganizationId, orgId.String())
+	}
+
+	return context.WithValue(ctx, authorization.Permissions, []string{roles.PermissionUsersRead, roles.PermissionUsersWrite})
+}
+
+// checkTenantScope calls the method as an admin of the organization on its member and on
+// a user of another organization, and as a platform admin on the user of another organization
+func checkTenantScope(t *testing.T, tenants tenants, name string, call func(ctx context.Context, userId string) error) {
+	t.Helper()
+
+	if err := call(adminContext(tenants.orgId), tenants.member.String()); err != nil {
+		t.Errorf("%s: unexpected error for a member: %v", name, err)
+	}
+
+	if err := call(adminContext(tenants.orgId), tenants.stranger.String()); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("%s: expected permission denied for a user of another organization, got %v", name, err)
+	}
+
+	if err := call(adminContext(uuid.Nil), tenants.stranger.String()); err != nil {
+		t.Errorf("%s: unexpected error for a platform admin: %v", name, err)
+	}
+}
+
+func TestAuthorizeUserCrossTenant(t *testing.T) {
+	tenants := setupTenants()
+	s := tenants.server
+
+	checkTenantScope(t, tenants, "ListSessions", func(ctx context.Context, userId string) error {
+		_, err := s.ListSessions(ctx, &ssov1.ListSessionsRequest{UserId: userId})
+		return err
+	})
+
+	checkTenantScope(t, tenants, "RevokeSession", func(ctx context.Context, userId string) error {
+		_, err := s.RevokeSession(ctx, &ssov1.RevokeSessionRequest{UserId: userId, SessionId: uuid.NewString()})
+		return err
+	})
+
+	checkTenantScope(t, tenants, "RevokeAllSessions", func(ctx context.Context, userId string) error {
+		_, err := s.RevokeAllSessions(ctx, &ssov1.RevokeAllSessionsRequest{UserId: userId})
+		return err
+	})
+
+	checkTenantScope(t, tenants, "ListUserRoles", func(ctx context.Context, userId string) error {
+		_, err := s.ListUserRoles(ctx, &ssov1.ListUserRolesRequest{UserId: userId})
+		return err
+	})
+}
+
+func TestAuthorizeUserSelf(t *testing.T) {
+	tenants := setupTenants()
+
+	ctx := context.WithValue(context.Background(), authorization.Uid, tenants.stranger.String())
+	ctx = context.WithValue(ctx, authorization.OrganizationId, tenants.orgId.String())
+
+	if err := tenants.server.authorizeUser(ctx, tenants.stranger, ""); err != nil {
+		t.Errorf("unexpected error for the user itself: %v", err)
+	}
+
+	if err := tenants.server.authorizeUser(ctx, tenants.member, roles.PermissionUsersRead); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected permission denied without the permission, got %v", err)
+	}
+}
+
+func TestGetUserCrossTenant(t *testing.T) {
+	tenants := setupTenants()
+	s := tenants.server
+
+	getUser := func(ctx context.Context) error {
+		_, err := s.GetUser(ctx, &ssov1.GetUserRequest{UserId: tenants.stranger.String()})
+		return err
+	}
+
+	getUsers := func(ctx context.Context) (int, error) {
+		resp, err := s.GetUsers(ctx, &ssov1.GetUsersRequest{UserIds: []string{tenants.member.String(), tenants.stranger.String()}})
+		return len(resp.GetUsers()), err
+	}
+
+	platformAdmin := context.WithValue(context.Background(), authorization.Uid, uuid.NewString())
+	platformAdmin = context.WithValue(platformAdmin, authorization.Permissions, []string{roles.PermissionRolesWrite})
+
+	serviceAccount := context.WithValue(context.Background(), authorization.ServiceAccount, uuid.NewString())
+
+	for name, ctx := range map[string]context.Context{"platform admin": platformAdmin, "service account": serviceAccount} {
+		if err := getUser(ctx); err != nil {
+			t.Errorf("%s: unexpected error for a user of an organization: %v", name, err)
+		}
+
+		if n, err := getUsers(ctx); err != nil || n != 2 {
+			t.Errorf("%s: expected the users of both organizations, got %d, %v", name, n, err)
+		}
+	}
+
+	if err := getUser(adminContext(tenants.orgId)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected a user of another organization not to be found, got %v", err)
+	}
+
+	if n, err := getUsers(adminContext(tenants.orgId)); err != nil || n != 1 {
+		t.Errorf("expected only the member, got %d, %v", n, err)
+	}
+
+	// users outside of any organization without a platform permission see only the users outside of any
+	user := context.WithValue(context.Background(), authorization.Uid, uuid.NewString())
+	if err := getUser(user); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected a user of an organization not to be found by a user outside of any, got %v", err)
+	}
+}
+
+func TestImpersonateCrossTenant(t *testing.T) {
+	tenants := setupTenants()
+
+	impersonate := func(ctx context.Context, userId string) error {
+		ctx = context.WithValue(ctx, authorization.Permissions, []string{roles.PermissionUsersImpersonate})
+		_, err := tenants.server.Impersonate(ctx, &ssov1.ImpersonateRequest{UserId: userId, Reason: "support ticket"})
+		return err
+	}
+
+	checkTenantScope(t, tenants, "Impersonate", impersonate)
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *serverAPI) CreateClient(ctx context.Context, req *ssov1.CreateClientRequest) (*ssov1.CreateClientResponse, error) {
+	if err := validateCreateClient(ctx, req.GetName(), req.GetRedirectUris(), req.GetScopes(), req.GetAccessTokenTtlSeconds()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	client, secret, err := s.clientService.Create(ctx, models.OAuthClient{
+		Name:           req.GetName(),
+		RedirectURIs:   req.GetRedirectUris(),
+		GrantTypes:     req.GetGrantTypes(),
+		Scopes:         req.GetScopes(),
+		AccessTokenTTL: time.Duration(req.GetAccessTokenTtlSeconds()) * time.Second,
+	}, req.GetPublic())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidClient) {
+			return nil, status.Error(codes.InvalidArgument, "invalid client metadata")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.CreateClientResponse{
+		ClientId:     client.Id,
+		ClientSecret: secret,
+	}, nil
+}
+
+func (s *serverAPI) RotateClientSecret(ctx context.Context, req *ssov1.RotateClientSecretRequest) (*ssov1.RotateClientSecretResponse, error) {
+	if err := validateClientId(ctx, req.GetClientId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	secret, err := s.clientService.RotateSecret(ctx, req.GetClientId())
+	if err != nil {
+		if errors.Is(err, services.ErrClientNotFound) {
+			return nil, status.Error(codes.NotFound, "client not found")
+		}
+		if errors.Is(err, services.ErrInvalidClient) {
+			return nil, status.Error(codes.FailedPrecondition, "public clients have no secret")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.RotateClientSecretResponse{
+		ClientSecret: secret,
+	}, nil
+}
+
+func (s *serverAPI) DisableClient(ctx context.Context, req *ssov1.DisableClientRequest) (*ssov1.DisableClientResponse, error) {
+	if err := validateClientId(ctx, req.GetClientId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.clientService.Disable(ctx, req.GetClientId()); err != nil {
+		if errors.Is(err, services.ErrClientNotFound) {
+			return nil, status.Error(codes.NotFound, "client not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.DisableClientResponse{}, nil
+}
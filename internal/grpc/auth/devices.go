@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *serverAPI) ApproveDevice(ctx context.Context, req *ssov1.ApproveDeviceRequest) (*ssov1.ApproveDeviceResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateUserCode(ctx, req.GetUserCode()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.authorizeUser(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	if err := s.deviceService.ApproveDevice(ctx, id, req.GetUserCode()); err != nil {
+		if errors.Is(err, services.ErrUserCodeNotFound) {
+			return nil, status.Error(codes.NotFound, "invalid or expired user code")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.ApproveDeviceResponse{}, nil
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/roles"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *serverAPI) Impersonate(ctx context.Context, req *ssov1.ImpersonateRequest) (*ssov1.ImpersonateResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateImpersonate(ctx, req.GetReason()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	// the act claim names a person, and an admin acting as a user cannot act as someone else
+	uid, ok := authorization.UserId(ctx)
+	if _, impersonating := authorization.ActorId(ctx); !ok || impersonating {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	actorId, err := uuid.Parse(uid)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "access token is invalid")
+	}
+
+	// admins of an organization impersonate only its members
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersImpersonate); err != nil {
+		return nil, err
+	}
+
+	impersonation, token, err := s.impService.Impersonate(ctx, actorId, id, req.GetReason())
+	if err != nil {
+		if errors.Is(err, services.ErrImpersonationNotAllowed) {
+			return nil, status.Error(codes.InvalidArgument, "cannot impersonate yourself")
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.ImpersonateResponse{
+		AccessToken: token,
+		ExpiresAt:   timestamppb.New(impersonation.ExpiresAt),
+	}, nil
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *serverAPI) InviteUser(ctx context.Context, req *ssov1.InviteUserRequest) (*ssov1.InviteUserResponse, error) {
+	if err := validateInviteUser(ctx, req.GetEmail(), req.GetName(), req.GetSurname()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	orgId, err := callerOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, _ := authorization.UserId(ctx)
+	invitedBy, err := uuid.Parse(uid)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "access token is invalid")
+	}
+
+	invite, err := s.inviteService.Invite(ctx, models.Invite{
+		OrganizationId: orgId,
+		Email:          req.GetEmail(),
+		Name:           req.GetName(),
+		Surname:        req.GetSurname(),
+		InvitedBy:      invitedBy,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrUserAlreadyExists) {
+			return nil, status.Error(codes.AlreadyExists, "user already exists")
+		}
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.NotFound, "organization not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.InviteUserResponse{
+		UserId: invite.UserId.String(),
+	}, nil
+}
+
+func (s *serverAPI) AcceptInvite(ctx context.Context, req *ssov1.AcceptInviteRequest) (*ssov1.AcceptInviteResponse, error) {
+	if err := validateAcceptInvite(ctx, req.GetToken(), req.GetPassword()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	tokens, err := s.inviteService.Accept(ctx, req.GetToken(), req.GetPassword())
+	if err != nil {
+		var policyErr *services.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyError("password", policyErr)
+		}
+		if errors.Is(err, services.ErrInviteNotFound) {
+			return nil, status.Error(codes.NotFound, "invite is invalid or has expired")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.AcceptInviteResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) ListInvites(ctx context.Context, req *ssov1.ListInvitesRequest) (*ssov1.ListInvitesResponse, error) {
+	orgId, err := callerOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := s.inviteService.List(ctx, orgId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	invitesResp := make([]*ssov1.Invite, len(invites))
+	for i := range invites {
+		invitesResp[i] = &ssov1.Invite{
+			UserId:    invites[i].UserId.String(),
+			Email:     invites[i].Email,
+			Name:      invites[i].Name,
+			Surname:   invites[i].Surname,
+			ExpiresAt: timestamppb.New(invites[i].ExpiresAt),
+			CreatedAt: timestamppb.New(invites[i].CreatedAt),
+		}
+		if invites[i].InvitedBy != uuid.Nil {
+			invitesResp[i].InvitedBy = invites[i].InvitedBy.String()
+		}
+	}
+
+	return &ssov1.ListInvitesResponse{
+		Invites: invitesResp,
+	}, nil
+}
+
+func (s *serverAPI) ResendInvite(ctx context.Context, req *ssov1.ResendInviteRequest) (*ssov1.ResendInviteResponse, error) {
+	orgId, userId, err := inviteRequest(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.inviteService.Resend(ctx, orgId, userId); err != nil {
+		if errors.Is(err, services.ErrInviteNotFound) {
+			return nil, status.Error(codes.NotFound, "invite not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.ResendInviteResponse{}, nil
+}
+
+func (s *serverAPI) RevokeInvite(ctx context.Context, req *ssov1.RevokeInviteRequest) (*ssov1.RevokeInviteResponse, error) {
+	orgId, userId, err := inviteRequest(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.inviteService.Revoke(ctx, orgId, userId); err != nil {
+		if errors.Is(err, services.ErrInviteNotFound) {
+			return nil, status.Error(codes.NotFound, "invite not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.RevokeInviteResponse{}, nil
+}
+
+// inviteRequest returns the organization of the caller and the invited user of the request
+func inviteRequest(ctx context.Context, userId string) (uuid.UUID, uuid.UUID, error) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	orgId, err := callerOrganization(ctx)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	return orgId, id, nil
+}
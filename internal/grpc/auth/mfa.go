@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *serverAPI) LoginMFA(ctx context.Context, req *ssov1.LoginMFARequest) (*ssov1.LoginMFAResponse, error) {
+	if err := validateLoginMFA(ctx, req.GetChallengeToken(), req.GetCode()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	tokens, err := s.authService.LoginMFA(ctx, req.GetChallengeToken(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+		if errors.Is(err, services.ErrNotAuthorized) {
+			return nil, status.Error(codes.Unauthenticated, "not authorized")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.LoginMFAResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) EnrollTOTP(ctx context.Context, req *ssov1.EnrollTOTPRequest) (*ssov1.EnrollTOTPResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := s.authorizeUser(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	enrollment, err := s.mfaService.EnrollTOTP(ctx, id)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return nil, status.Error(codes.InvalidArgument, "user not found")
+		}
+		if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+			return nil, status.Error(codes.FailedPrecondition, "mfa already enabled")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.EnrollTOTPResponse{
+		Secret: enrollment.Secret,
+		Uri:    enrollment.URI,
+	}, nil
+}
+
+func (s *serverAPI) ConfirmTOTP(ctx context.Context, req *ssov1.ConfirmTOTPRequest) (*ssov1.ConfirmTOTPResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateMFACode(ctx, req.GetCode()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.authorizeUser(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := s.mfaService.ConfirmTOTP(ctx, id, req.GetCode())
+	if err != nil {
+		return nil, mfaError(err)
+	}
+
+	return &ssov1.ConfirmTOTPResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *serverAPI) DisableTOTP(ctx context.Context, req *ssov1.DisableTOTPRequest) (*ssov1.DisableTOTPResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateMFACode(ctx, req.GetCode()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.authorizeUser(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	if err := s.mfaService.DisableTOTP(ctx, id, req.GetCode()); err != nil {
+		return nil, mfaError(err)
+	}
+
+	return &ssov1.DisableTOTPResponse{}, nil
+}
+
+func (s *serverAPI) RegenerateRecoveryCodes(ctx context.Context, req *ssov1.RegenerateRecoveryCodesRequest) (*ssov1.RegenerateRecoveryCodesResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateMFACode(ctx, req.GetCode()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.authorizeUser(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := s.mfaService.RegenerateRecoveryCodes(ctx, id, req.GetCode())
+	if err != nil {
+		return nil, mfaError(err)
+	}
+
+	return &ssov1.RegenerateRecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func mfaError(err error) error {
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		return status.Error(codes.InvalidArgument, "invalid code")
+	}
+	if errors.Is(err, services.ErrMFANotEnabled) {
+		return status.Error(codes.FailedPrecondition, "mfa not enabled")
+	}
+	if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		return status.Error(codes.FailedPrecondition, "mfa already enabled")
+	}
+	if errors.Is(err, services.ErrAccountLocked) {
+		return status.Error(codes.ResourceExhausted, "too many failed attempts, try again later")
+	}
+
+	return status.Error(codes.Internal, "Internal error")
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *serverAPI) CreateOrganization(ctx context.Context, req *ssov1.CreateOrganizationRequest) (*ssov1.CreateOrganizationResponse, error) {
+	if err := validateOrganization(ctx, req.GetSlug(), req.GetName()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	org, err := s.orgService.Create(ctx, models.Organization{
+		Slug:         req.GetSlug(),
+		Name:         req.GetName(),
+		ScopedEmails: req.GetScopedEmails(),
+		Settings:     organizationSettings(req.GetSettings()),
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidOrganization) {
+			return nil, status.Error(codes.InvalidArgument, "invalid organization")
+		}
+		if errors.Is(err, services.ErrOrganizationExists) {
+			return nil, status.Error(codes.AlreadyExists, "organization already exists")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.CreateOrganizationResponse{
+		Organization: organizationResponse(org),
+	}, nil
+}
+
+func (s *serverAPI) GetOrganization(ctx context.Context, req *ssov1.GetOrganizationRequest) (*ssov1.GetOrganizationResponse, error) {
+	id, err := uuid.Parse(req.GetOrganizationId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid organization id")
+	}
+
+	org, err := s.orgService.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.NotFound, "organization not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.GetOrganizationResponse{
+		Organization: organizationResponse(org),
+	}, nil
+}
+
+func (s *serverAPI) ListOrganizations(ctx context.Context, req *ssov1.ListOrganizationsRequest) (*ssov1.ListOrganizationsResponse, error) {
+	orgs, err := s.orgService.List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	orgsResp := make([]*ssov1.Organization, len(orgs))
+	for i := range orgs {
+		orgsResp[i] = organizationResponse(orgs[i])
+	}
+
+	return &ssov1.ListOrganizationsResponse{
+		Organizations: orgsResp,
+	}, nil
+}
+
+func (s *serverAPI) UpdateOrganizationSettings(ctx context.Context, req *ssov1.UpdateOrganizationSettingsRequest) (*ssov1.UpdateOrganizationSettingsResponse, error) {
+	id, err := uuid.Parse(req.GetOrganizationId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid organization id")
+	}
+
+	if err := s.orgService.UpdateSettings(ctx, id, organizationSettings(req.GetSettings())); err != nil {
+		if errors.Is(err, services.ErrInvalidOrganization) {
+			return nil, status.Error(codes.InvalidArgument, "invalid organization settings")
+		}
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.NotFound, "organization not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.UpdateOrganizationSettingsResponse{}, nil
+}
+
+func (s *serverAPI) AddOrganizationMember(ctx context.Context, req *ssov1.AddOrganizationMemberRequest) (*ssov1.AddOrganizationMemberResponse, error) {
+	member, err := memberRequest(ctx, req.GetOrganizationId(), req.GetUserId(), req.GetRoles())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.orgService.AddMember(ctx, member); err != nil {
+		if errors.Is(err, services.ErrMemberExists) {
+			return nil, status.Error(codes.AlreadyExists, "user is already a member of an organization")
+		}
+		if errors.Is(err, services.ErrUserAlreadyExists) {
+			return nil, status.Error(codes.AlreadyExists, "email is taken in the organization")
+		}
+
+		return nil, memberError(err)
+	}
+
+	return &ssov1.AddOrganizationMemberResponse{}, nil
+}
+
+func (s *serverAPI) SetOrganizationMemberRoles(ctx context.Context, req *ssov1.SetOrganizationMemberRolesRequest) (*ssov1.SetOrganizationMemberRolesResponse, error) {
+	member, err := memberRequest(ctx, req.GetOrganizationId(), req.GetUserId(), req.GetRoles())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.orgService.SetMemberRoles(ctx, member); err != nil {
+		if errors.Is(err, services.ErrMemberNotFound) {
+			return nil, status.Error(codes.NotFound, "member not found")
+		}
+
+		return nil, memberError(err)
+	}
+
+	return &ssov1.SetOrganizationMemberRolesResponse{}, nil
+}
+
+func (s *serverAPI) ListOrganizationMembers(ctx context.Context, req *ssov1.ListOrganizationMembersRequest) (*ssov1.ListOrganizationMembersResponse, error) {
+	id, err := uuid.Parse(req.GetOrganizationId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid organization id")
+	}
+
+	members, err := s.orgService.Members(ctx, id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	membersResp := make([]*ssov1.OrganizationMember, len(members))
+	for i := range members {
+		membersResp[i] = &ssov1.OrganizationMember{
+			UserId:    members[i].UserId.String(),
+			Roles:     members[i].Roles,
+			CreatedAt: timestamppb.New(members[i].CreatedAt),
+		}
+	}
+
+	return &ssov1.ListOrganizationMembersResponse{
+		Members: membersResp,
+	}, nil
+}
+
+// requestOrganization parses the organization a request is made in, empty for none
+func requestOrganization(organizationId string) (uuid.UUID, error) {
+	if organizationId == "" {
+		return uuid.Nil, nil
+	}
+
+	id, err := uuid.Parse(organizationId)
+	if err != nil {
+		return uuid.Nil, status.Error(codes.InvalidArgument, "invalid organization id")
+	}
+
+	return id, nil
+}
+
+func memberRequest(ctx context.Context, organizationId, userId string, roles []string) (models.Member, error) {
+	orgId, err := uuid.Parse(organizationId)
+	if err != nil {
+		return models.Member{}, status.Error(codes.InvalidArgument, "invalid organization id")
+	}
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return models.Member{}, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateMemberRoles(ctx, roles); err != nil {
+		return models.Member{}, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	return models.Member{
+		OrganizationId: orgId,
+		UserId:         id,
+		Roles:          roles,
+	}, nil
+}
+
+// memberError maps the errors common to the member methods
+func memberError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrOrganizationNotFound):
+		return status.Error(codes.NotFound, "organization not found")
+	case errors.Is(err, services.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, services.ErrRoleNotFound):
+		return status.Error(codes.NotFound, "role not found")
+	}
+
+	return status.Error(codes.Internal, "Internal error")
+}
+
+func organizationSettings(settings *ssov1.OrganizationSettings) models.OrganizationSettings {
+	return models.OrganizationSettings{
+		AccessTokenTTL:   time.Duration(settings.GetAccessTokenTtlSeconds()) * time.Second,
+		RefreshTokenTTL:  time.Duration(settings.GetRefreshTokenTtlSeconds()) * time.Second,
+		OpenRegistration: settings.GetOpenRegistration(),
+		PasswordPolicy: models.PasswordPolicySettings{
+			MinLength:     int(settings.GetPasswordMinLength()),
+			MinScore:      int(settings.GetPasswordMinScore()),
+			History:       int(settings.GetPasswordHistory()),
+			RequireLower:  settings.GetPasswordRequireLower(),
+			RequireUpper:  settings.GetPasswordRequireUpper(),
+			RequireDigit:  settings.GetPasswordRequireDigit(),
+			RequireSymbol: settings.GetPasswordRequireSymbol(),
+		},
+	}
+}
+
+func organizationResponse(org models.Organization) *ssov1.Organization {
+	policy := org.Settings.PasswordPolicy
+
+	return &ssov1.Organization{
+		Id:           org.Id.String(),
+		Slug:         org.Slug,
+		Name:         org.Name,
+		ScopedEmails: org.ScopedEmails,
+		Settings: &ssov1.OrganizationSettings{
+			AccessTokenTtlSeconds:  int64(org.Settings.AccessTokenTTL / time.Second),
+			RefreshTokenTtlSeconds: int64(org.Settings.RefreshTokenTTL / time.Second),
+			OpenRegistration:       org.Settings.OpenRegistration,
+			PasswordMinLength:      int32(policy.MinLength),
+			PasswordMinScore:       int32(policy.MinScore),
+			PasswordHistory:        int32(policy.History),
+			PasswordRequireLower:   policy.RequireLower,
+			PasswordRequireUpper:   policy.RequireUpper,
+			PasswordRequireDigit:   policy.RequireDigit,
+			PasswordRequireSymbol:  policy.RequireSymbol,
+		},
+		CreatedAt: timestamppb.New(org.CreatedAt),
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *serverAPI) BeginPasskeyRegistration(ctx context.Context, req *ssov1.BeginPasskeyRegistrationRequest) (*ssov1.BeginPasskeyRegistrationResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := s.authorizeUser(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	ceremony, err := s.passkeyService.BeginRegistration(ctx, id)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return nil, status.Error(codes.InvalidArgument, "user not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.BeginPasskeyRegistrationResponse{
+		CeremonyToken: ceremony.Token,
+		Options:       ceremony.Options,
+	}, nil
+}
+
+func (s *serverAPI) FinishPasskeyRegistration(ctx context.Context, req *ssov1.FinishPasskeyRegistrationRequest) (*ssov1.FinishPasskeyRegistrationResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateFinishPasskeyRegistration(ctx, req.GetCeremonyToken(), req.GetName(), req.GetCredential()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.authorizeUser(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	err = s.passkeyService.FinishRegistration(ctx, id, req.GetCeremonyToken(), req.GetName(), req.GetCredential())
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return nil, status.Error(codes.InvalidArgument, "user not found")
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credential")
+		}
+		if errors.Is(err, services.ErrNotAuthorized) {
+			return nil, status.Error(codes.Unauthenticated, "not authorized")
+		}
+		if errors.Is(err, services.ErrPasskeyExists) {
+			return nil, status.Error(codes.AlreadyExists, "passkey already exists")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.FinishPasskeyRegistrationResponse{}, nil
+}
+
+func (s *serverAPI) BeginPasskeyLogin(ctx context.Context, req *ssov1.BeginPasskeyLoginRequest) (*ssov1.BeginPasskeyLoginResponse, error) {
+	ceremony, err := s.passkeyService.BeginLogin(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.BeginPasskeyLoginResponse{
+		CeremonyToken: ceremony.Token,
+		Options:       ceremony.Options,
+	}, nil
+}
+
+func (s *serverAPI) FinishPasskeyLogin(ctx context.Context, req *ssov1.FinishPasskeyLoginRequest) (*ssov1.FinishPasskeyLoginResponse, error) {
+	if err := validateFinishPasskeyLogin(ctx, req.GetCeremonyToken(), req.GetCredential()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	tokens, err := s.authService.LoginPasskey(ctx, req.GetCeremonyToken(), req.GetCredential())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credential")
+		}
+		if errors.Is(err, services.ErrNotAuthorized) {
+			return nil, status.Error(codes.Unauthenticated, "not authorized")
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			return nil, status.Error(codes.InvalidArgument, "user not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.FinishPasskeyLoginResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
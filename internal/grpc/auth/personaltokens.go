@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/roles"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *serverAPI) CreatePersonalAccessToken(ctx context.Context, req *ssov1.CreatePersonalAccessTokenRequest) (*ssov1.CreatePersonalAccessTokenResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateCreatePersonalAccessToken(ctx, req.GetName(), req.GetScopes(), req.GetExpiresInSeconds()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	// the scopes are permissions of the user, no one else creates its tokens
+	if err := s.authorizeUser(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	token := models.PersonalAccessToken{
+		UserId: id,
+		Name:   req.GetName(),
+		Scopes: req.GetScopes(),
+	}
+	if req.GetExpiresInSeconds() > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(req.GetExpiresInSeconds()) * time.Second)
+	}
+
+	token, secret, err := s.patService.Create(ctx, token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPersonalAccessToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid personal access token")
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.CreatePersonalAccessTokenResponse{
+		Token:               secret,
+		PersonalAccessToken: personalAccessTokenResponse(token),
+	}, nil
+}
+
+func (s *serverAPI) ListPersonalAccessTokens(ctx context.Context, req *ssov1.ListPersonalAccessTokensRequest) (*ssov1.ListPersonalAccessTokensResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersRead); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.patService.List(ctx, id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	tokensResp := make([]*ssov1.PersonalAccessToken, len(tokens))
+	for i := range tokens {
+		tokensResp[i] = personalAccessTokenResponse(tokens[i])
+	}
+
+	return &ssov1.ListPersonalAccessTokensResponse{
+		PersonalAccessTokens: tokensResp,
+	}, nil
+}
+
+func (s *serverAPI) RevokePersonalAccessToken(ctx context.Context, req *ssov1.RevokePersonalAccessTokenRequest) (*ssov1.RevokePersonalAccessTokenResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	tokenId, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid personal access token id")
+	}
+
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersWrite); err != nil {
+		return nil, err
+	}
+
+	if err := s.patService.Revoke(ctx, id, tokenId); err != nil {
+		if errors.Is(err, services.ErrPersonalAccessTokenNotFound) {
+			return nil, status.Error(codes.NotFound, "personal access token not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.RevokePersonalAccessTokenResponse{}, nil
+}
+
+// personalAccessTokenResponse leaves the expiration and the last use unset when there are none
+func personalAccessTokenResponse(token models.PersonalAccessToken) *ssov1.PersonalAccessToken {
+	resp := &ssov1.PersonalAccessToken{
+		Id:        token.Id.String(),
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: timestamppb.New(token.CreatedAt),
+	}
+	if !token.ExpiresAt.IsZero() {
+		resp.ExpiresAt = timestamppb.New(token.ExpiresAt)
+	}
+	if !token.LastUsedAt.IsZero() {
+		resp.LastUsedAt = timestamppb.New(token.LastUsedAt)
+	}
+
+	return resp
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakePersonalTokens struct {
+	PersonalTokens
+}
+
+func (p fakePersonalTokens) Create(ctx context.Context, token models.PersonalAccessToken) (models.PersonalAccessToken, string, error) {
+	token.Id = uuid.New()
+
+	return token, authorization.PersonalAccessTokenPrefix + "secret", nil
+}
+
+func (p fakePersonalTokens) List(ctx context.Context, userId uuid.UUID) ([]models.PersonalAccessToken, error) {
+	return nil, nil
+}
+
+func (p fakePersonalTokens) Revoke(ctx context.Context, userId, id uuid.UUID) error {
+	return nil
+}
+
+func TestPersonalAccessTokensCrossTenant(t *testing.T) {
+	tenants := setupTenants()
+	s := tenants.server
+	s.patService = fakePersonalTokens{}
+
+	checkTenantScope(t, tenants, "ListPersonalAccessTokens", func(ctx context.Context, userId string) error {
+		_, err := s.ListPersonalAccessTokens(ctx, &ssov1.ListPersonalAccessTokensRequest{UserId: userId})
+		return err
+	})
+
+	checkTenantScope(t, tenants, "RevokePersonalAccessToken", func(ctx context.Context, userId string) error {
+		_, err := s.RevokePersonalAccessToken(ctx, &ssov1.RevokePersonalAccessTokenRequest{UserId: userId, Id: uuid.NewString()})
+		return err
+	})
+}
+
+// personalTokenVerifier accepts every personal access token as a token of the user
+type personalTokenVerifier struct {
+	userId uuid.UUID
+}
+
+func (v personalTokenVerifier) VerifyPersonalAccessToken(ctx context.Context, token string) (jwks.Claims, error) {
+	return jwks.Claims{UserId: v.userId.String()}, nil
+}
+
+// methodStream names the method of a call made without a grpc server
+type methodStream struct {
+	grpc.ServerTransportStream
+
+	method string
+}
+
+func (s methodStream) Method() string {
+	return s.method
+}
+
+func TestCreatePersonalAccessTokenRequiresLogin(t *testing.T) {
+	prKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userId := uuid.New()
+	s := &serverAPI{patService: fakePersonalTokens{}}
+
+	keySetCh := make(chan jwks.JWKS, 1)
+	keySetCh <- jwks.NewJWKS(&prKey.PublicKey)
+	close(keySetCh)
+
+	interceptor := authorization.NewServerWithKeySet(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		AuthMethods(),
+		keySetCh,
+		authorization.WithPersonalAccessTokens(personalTokenVerifier{userId: userId}),
+	)
+
+	method := fullMethod("CreatePersonalAccessToken")
+	call := func(token string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+		ctx = grpc.NewContextWithServerTransportStream(ctx, methodStream{method: method})
+
+		_, err := interceptor.Unary()(ctx, &ssov1.CreatePersonalAccessTokenRequest{UserId: userId.String(), Name: "ci"},
+			&grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req any) (any, error) {
+				return s.CreatePersonalAccessToken(ctx, req.(*ssov1.CreatePersonalAccessTokenRequest))
+			})
+
+		return err
+	}
+
+	accessToken := func(token jwt.AccessToken) string {
+		token.User = models.UserInfo{Id: userId}
+
+		signed, err := jwt.NewAccessToken(token, time.Minute, prKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signed
+	}
+
+	// the key set is received in the background
+	deadline := time.Now().Add(time.Second)
+	for err := call(accessToken(jwt.AccessToken{})); err != nil; err = call(accessToken(jwt.AccessToken{})) {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected error for a login token: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// tokens of clients and of impersonating admins do not pass the interceptor of sso
+	rejected := map[string]struct {
+		token string
+		code  codes.Code
+	}{
+		"personal access token": {authorization.PersonalAccessTokenPrefix + "secret", codes.PermissionDenied},
+		"client token":          {accessToken(jwt.AccessToken{ClientId: "journal"}), codes.Unauthenticated},
+		"impersonation token":   {accessToken(jwt.AccessToken{ActorId: uuid.New()}), codes.Unauthenticated},
+	}
+
+	for name, tc := range rejected {
+		t.Run(name, func(t *testing.T) {
+			if code := status.Code(call(tc.token)); code != tc.code {
+				t.Fatalf("expected %v, got %v", tc.code, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/roles"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *serverAPI) CreateRole(ctx context.Context, req *ssov1.CreateRoleRequest) (*ssov1.CreateRoleResponse, error) {
+	if err := validateRole(ctx, req.GetName(), req.GetDescription(), req.GetPermissions()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	err := s.roleService.Create(ctx, models.Role{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Permissions: req.GetPermissions(),
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRole) {
+			return nil, status.Error(codes.InvalidArgument, "invalid role")
+		}
+		if errors.Is(err, services.ErrRoleExists) {
+			return nil, status.Error(codes.AlreadyExists, "role already exists")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.CreateRoleResponse{}, nil
+}
+
+func (s *serverAPI) UpdateRole(ctx context.Context, req *ssov1.UpdateRoleRequest) (*ssov1.UpdateRoleResponse, error) {
+	if err := validateRole(ctx, req.GetName(), req.GetDescription(), req.GetPermissions()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	err := s.roleService.Update(ctx, models.Role{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Permissions: req.GetPermissions(),
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRole) {
+			return nil, status.Error(codes.InvalidArgument, "invalid role")
+		}
+		if errors.Is(err, services.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.UpdateRoleResponse{}, nil
+}
+
+func (s *serverAPI) DeleteRole(ctx context.Context, req *ssov1.DeleteRoleRequest) (*ssov1.DeleteRoleResponse, error) {
+	if err := validateRoleName(ctx, req.GetName()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.roleService.Delete(ctx, req.GetName()); err != nil {
+		if errors.Is(err, services.ErrInvalidRole) {
+			return nil, status.Error(codes.FailedPrecondition, "the role cannot be deleted")
+		}
+		if errors.Is(err, services.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.DeleteRoleResponse{}, nil
+}
+
+func (s *serverAPI) ListRoles(ctx context.Context, req *ssov1.ListRolesRequest) (*ssov1.ListRolesResponse, error) {
+	roles, err := s.roleService.List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.ListRolesResponse{
+		Roles: rolesResponse(roles),
+	}, nil
+}
+
+func (s *serverAPI) AssignRole(ctx context.Context, req *ssov1.AssignRoleRequest) (*ssov1.AssignRoleResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateRoleName(ctx, req.GetRole()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.roleService.Assign(ctx, id, req.GetRole()); err != nil {
+		if errors.Is(err, services.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.AssignRoleResponse{}, nil
+}
+
+func (s *serverAPI) UnassignRole(ctx context.Context, req *ssov1.UnassignRoleRequest) (*ssov1.UnassignRoleResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateRoleName(ctx, req.GetRole()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.roleService.Unassign(ctx, id, req.GetRole()); err != nil {
+		if errors.Is(err, services.ErrRoleNotAssigned) {
+			return nil, status.Error(codes.NotFound, "role not assigned")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.UnassignRoleResponse{}, nil
+}
+
+func (s *serverAPI) ListUserRoles(ctx context.Context, req *ssov1.ListUserRolesRequest) (*ssov1.ListUserRolesResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersRead); err != nil {
+		return nil, err
+	}
+
+	roles, err := s.roleService.UserRoles(ctx, id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.ListUserRolesResponse{
+		Roles: rolesResponse(roles),
+	}, nil
+}
+
+func rolesResponse(roles []models.Role) []*ssov1.Role {
+	rolesResp := make([]*ssov1.Role, len(roles))
+	for i := range roles {
+		rolesResp[i] = &ssov1.Role{
+			Name:        roles[i].Name,
+			Description: roles[i].Description,
+			Permissions: roles[i].Permissions,
+			CreatedAt:   timestamppb.New(roles[i].CreatedAt),
+		}
+	}
+
+	return rolesResp
+}
@@ -2,11 +2,14 @@ package auth
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"errors"
 
 	"github.com/google/uuid"
 	"github.com/hesoyamTM/apphelper-sso/internal/models"
 	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/roles"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
 
 	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
 	"google.golang.org/grpc"
@@ -15,27 +18,146 @@ import (
 )
 
 type Auth interface {
-	Register(ctx context.Context, name, surname, login, password string) (models.JWTokens, error)
-	Login(ctx context.Context, login, password string) (models.JWTokens, error)
+	Register(ctx context.Context, organizationId uuid.UUID, name, surname, login, password string) (models.JWTokens, error)
+	Login(ctx context.Context, organizationId uuid.UUID, login, password string) (models.LoginResult, error)
+	LoginMFA(ctx context.Context, challengeToken, code string) (models.JWTokens, error)
+	LoginPasskey(ctx context.Context, ceremonyToken string, response []byte) (models.JWTokens, error)
 	Logout(ctx context.Context, refreshToken string) error
 	RefreshToken(ctx context.Context, refreshToken string) (models.JWTokens, error)
-	GetUser(ctx context.Context, id uuid.UUID) (models.User, error)
-	GetUsers(ctx context.Context, ids []uuid.UUID) ([]models.User, error)
-	UpdateUser(ctx context.Context, user models.UserInfo) error
-	DeleteUser(ctx context.Context, id uuid.UUID) error
-	ChangePassword(ctx context.Context, email, newPassword, token string) error
+	GetUser(ctx context.Context, organizationId, id uuid.UUID) (models.User, error)
+	GetUsers(ctx context.Context, organizationId uuid.UUID, ids []uuid.UUID) ([]models.User, error)
+	UpdateUser(ctx context.Context, organizationId uuid.UUID, user models.UserInfo) error
+	DeleteUser(ctx context.Context, organizationId, id uuid.UUID) error
+	ChangePassword(ctx context.Context, organizationId uuid.UUID, email, newPassword, token string) error
 	SendVerificationEmail(ctx context.Context, email string) error
-	SendPasswordResetEmail(ctx context.Context, email string) error
+	SendPasswordResetEmail(ctx context.Context, organizationId uuid.UUID, email string) error
 	VerifyEmail(ctx context.Context, email, code string) error
+	PublicKeys(ctx context.Context) (jwks.JWKS, error)
+	ListSessions(ctx context.Context, userId uuid.UUID) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userId uuid.UUID, sessionId string) error
+	RevokeAllSessions(ctx context.Context, userId uuid.UUID) error
+}
+
+type MFA interface {
+	EnrollTOTP(ctx context.Context, userId uuid.UUID) (models.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userId uuid.UUID, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, userId uuid.UUID, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userId uuid.UUID, code string) ([]string, error)
+}
+
+type Passkeys interface {
+	BeginRegistration(ctx context.Context, userId uuid.UUID) (models.PasskeyCeremony, error)
+	FinishRegistration(ctx context.Context, userId uuid.UUID, ceremonyToken, name string, response []byte) error
+	BeginLogin(ctx context.Context) (models.PasskeyCeremony, error)
+}
+
+type Clients interface {
+	Create(ctx context.Context, client models.OAuthClient, public bool) (models.OAuthClient, string, error)
+	RotateSecret(ctx context.Context, clientId string) (string, error)
+	Disable(ctx context.Context, clientId string) error
+}
+
+type Devices interface {
+	ApproveDevice(ctx context.Context, userId uuid.UUID, userCode string) error
+}
+
+type Tokens interface {
+	Introspect(ctx context.Context, token, tokenTypeHint string) (models.TokenIntrospection, error)
+	Revoke(ctx context.Context, clientId, token, tokenTypeHint string) error
+}
+
+type Roles interface {
+	Create(ctx context.Context, role models.Role) error
+	Update(ctx context.Context, role models.Role) error
+	Delete(ctx context.Context, name string) error
+	List(ctx context.Context) ([]models.Role, error)
+	Assign(ctx context.Context, userId uuid.UUID, role string) error
+	Unassign(ctx context.Context, userId uuid.UUID, role string) error
+	UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+}
+
+type Organizations interface {
+	Create(ctx context.Context, org models.Organization) (models.Organization, error)
+	UpdateSettings(ctx context.Context, id uuid.UUID, settings models.OrganizationSettings) error
+	Get(ctx context.Context, id uuid.UUID) (models.Organization, error)
+	List(ctx context.Context) ([]models.Organization, error)
+	AddMember(ctx context.Context, member models.Member) error
+	SetMemberRoles(ctx context.Context, member models.Member) error
+	Members(ctx context.Context, organizationId uuid.UUID) ([]models.Member, error)
+}
+
+type Invites interface {
+	Invite(ctx context.Context, invite models.Invite) (models.Invite, error)
+	Accept(ctx context.Context, inviteToken, password string) (models.JWTokens, error)
+	List(ctx context.Context, organizationId uuid.UUID) ([]models.Invite, error)
+	Resend(ctx context.Context, organizationId, userId uuid.UUID) error
+	Revoke(ctx context.Context, organizationId, userId uuid.UUID) error
+}
+
+type PersonalTokens interface {
+	Create(ctx context.Context, token models.PersonalAccessToken) (models.PersonalAccessToken, string, error)
+	List(ctx context.Context, userId uuid.UUID) ([]models.PersonalAccessToken, error)
+	Revoke(ctx context.Context, userId, id uuid.UUID) error
+}
+
+type ServiceAccounts interface {
+	Create(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error)
+	List(ctx context.Context) ([]models.ServiceAccount, error)
+	RotateKey(ctx context.Context, id uuid.UUID, publicKey *ecdsa.PublicKey) error
+	Disable(ctx context.Context, id uuid.UUID) error
+}
+
+type Impersonation interface {
+	Impersonate(ctx context.Context, actorId, userId uuid.UUID, reason string) (models.Impersonation, string, error)
 }
 
 type serverAPI struct {
-	authService Auth
+	authService    Auth
+	mfaService     MFA
+	passkeyService Passkeys
+	clientService  Clients
+	deviceService  Devices
+	tokenService   Tokens
+	roleService    Roles
+	orgService     Organizations
+	inviteService  Invites
+	patService     PersonalTokens
+	saService      ServiceAccounts
+	impService     Impersonation
 	ssov1.UnimplementedAuthServer
 }
 
-func RegisterServer(gRpc *grpc.Server, authService Auth) {
-	ssov1.RegisterAuthServer(gRpc, &serverAPI{authService: authService})
+// Services are the services behind the RPCs of the server
+type Services struct {
+	Auth            Auth
+	MFA             MFA
+	Passkeys        Passkeys
+	Clients         Clients
+	Devices         Devices
+	Tokens          Tokens
+	Roles           Roles
+	Organizations   Organizations
+	Invites         Invites
+	PersonalTokens  PersonalTokens
+	ServiceAccounts ServiceAccounts
+	Impersonation   Impersonation
+}
+
+func RegisterServer(gRpc *grpc.Server, services Services) {
+	ssov1.RegisterAuthServer(gRpc, &serverAPI{
+		authService:    services.Auth,
+		mfaService:     services.MFA,
+		passkeyService: services.Passkeys,
+		clientService:  services.Clients,
+		deviceService:  services.Devices,
+		tokenService:   services.Tokens,
+		roleService:    services.Roles,
+		orgService:     services.Organizations,
+		inviteService:  services.Invites,
+		patService:     services.PersonalTokens,
+		saService:      services.ServiceAccounts,
+		impService:     services.Impersonation,
+	})
 }
 
 func (s *serverAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.LoginResponse, error) {
@@ -46,21 +168,33 @@ func (s *serverAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.
 		return nil, status.Error(codes.InvalidArgument, "validation error")
 	}
 
-	tokens, err := s.authService.Login(ctx, login, pass)
+	orgId, err := requestOrganization(req.GetOrganizationId())
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.authService.Login(ctx, orgId, login, pass)
 	if err != nil {
+		if errors.Is(err, services.ErrAccountLocked) {
+			return nil, status.Error(codes.ResourceExhausted, "too many failed attempts, try again later")
+		}
 		if errors.Is(err, services.ErrInvalidCredentials) {
 			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
 		}
 		if errors.Is(err, services.ErrUserNotFound) {
 			return nil, status.Error(codes.InvalidArgument, "user not found")
 		}
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.NotFound, "organization not found")
+		}
 
 		return nil, status.Error(codes.InvalidArgument, "internal error")
 	}
 
 	return &ssov1.LoginResponse{
-		AccessToken:  tokens.AccessToken,
-		RefreshToken: tokens.RefreshToken,
+		AccessToken:       result.Tokens.AccessToken,
+		RefreshToken:      result.Tokens.RefreshToken,
+		MfaChallengeToken: result.MFAChallengeToken,
 	}, nil
 }
 
@@ -74,11 +208,26 @@ func (s *serverAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*
 		return nil, status.Error(codes.InvalidArgument, "validation error")
 	}
 
-	tokens, err := s.authService.Register(ctx, name, surname, login, pass)
+	orgId, err := requestOrganization(req.GetOrganizationId())
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.authService.Register(ctx, orgId, name, surname, login, pass)
 	if err != nil {
+		var policyErr *services.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyError("password", policyErr)
+		}
 		if errors.Is(err, services.ErrUserAlreadyExists) {
 			return nil, status.Error(codes.InvalidArgument, "user already exists")
 		}
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.NotFound, "organization not found")
+		}
+		if errors.Is(err, services.ErrRegistrationClosed) {
+			return nil, status.Error(codes.PermissionDenied, "registration is closed")
+		}
 
 		return nil, status.Error(codes.InvalidArgument, "internal error")
 	}
@@ -95,7 +244,12 @@ func (s *serverAPI) GetUser(ctx context.Context, req *ssov1.GetUserRequest) (*ss
 		return nil, status.Error(codes.InvalidArgument, "invalid user id")
 	}
 
-	user, err := s.authService.GetUser(ctx, id)
+	orgId, err := callerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.authService.GetUser(ctx, orgId, id)
 	if err != nil {
 		if errors.Is(err, services.ErrUserNotFound) {
 			return nil, status.Error(codes.InvalidArgument, "user not found")
@@ -125,7 +279,12 @@ func (s *serverAPI) GetUsers(ctx context.Context, req *ssov1.GetUsersRequest) (*
 		return nil, status.Error(codes.InvalidArgument, "validation error")
 	}
 
-	users, err := s.authService.GetUsers(ctx, idsUUID)
+	orgId, err := callerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.authService.GetUsers(ctx, orgId, idsUUID)
 	if err != nil {
 		if errors.Is(err, services.ErrUserNotFound) {
 			return nil, status.Error(codes.InvalidArgument, "user not found")
@@ -180,7 +339,9 @@ func (s *serverAPI) UpdateUser(ctx context.Context, req *ssov1.UpdateUserRequest
 		return nil, status.Error(codes.InvalidArgument, "invalid user id")
 	}
 
-	// TODO: check user authentication
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersWrite); err != nil {
+		return nil, err
+	}
 
 	user := models.UserInfo{
 		Id:      id,
@@ -192,7 +353,12 @@ func (s *serverAPI) UpdateUser(ctx context.Context, req *ssov1.UpdateUserRequest
 		return nil, status.Error(codes.InvalidArgument, "validation error")
 	}
 
-	if err := s.authService.UpdateUser(ctx, user); err != nil {
+	orgId, err := callerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.UpdateUser(ctx, orgId, user); err != nil {
 		if errors.Is(err, services.ErrUserNotFound) {
 			return nil, status.Error(codes.InvalidArgument, "user not found")
 		}
@@ -209,9 +375,16 @@ func (s *serverAPI) DeleteUser(ctx context.Context, req *ssov1.DeleteUserRequest
 		return nil, status.Error(codes.InvalidArgument, "invalid user id")
 	}
 
-	// TODO: check user authentication
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersWrite); err != nil {
+		return nil, err
+	}
 
-	if err := s.authService.DeleteUser(ctx, id); err != nil {
+	orgId, err := callerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.DeleteUser(ctx, orgId, id); err != nil {
 		if errors.Is(err, services.ErrUserNotFound) {
 			return nil, status.Error(codes.InvalidArgument, "user not found")
 		}
@@ -249,7 +422,16 @@ func (s *serverAPI) ChangePassword(ctx context.Context, req *ssov1.ChangePasswor
 		return nil, status.Error(codes.InvalidArgument, "validation error")
 	}
 
-	if err := s.authService.ChangePassword(ctx, email, newPassword, token); err != nil {
+	orgId, err := requestOrganization(req.GetOrganizationId())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.ChangePassword(ctx, orgId, email, newPassword, token); err != nil {
+		var policyErr *services.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyError("new_password", policyErr)
+		}
 		if errors.Is(err, services.ErrNotAuthorized) {
 			return nil, status.Error(codes.Unauthenticated, "not authorized")
 		}
@@ -259,6 +441,9 @@ func (s *serverAPI) ChangePassword(ctx context.Context, req *ssov1.ChangePasswor
 		if errors.Is(err, services.ErrUserNotFound) {
 			return nil, status.Error(codes.InvalidArgument, "user not found")
 		}
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.NotFound, "organization not found")
+		}
 
 		return nil, status.Error(codes.Internal, "Internal error")
 	}
@@ -289,3 +474,27 @@ func (s *serverAPI) VerifyEmail(ctx context.Context, req *ssov1.VerifyEmailReque
 
 	return &ssov1.VerifyEmailResponse{}, nil
 }
+
+func (s *serverAPI) GetJWKS(ctx context.Context, req *ssov1.GetJWKSRequest) (*ssov1.GetJWKSResponse, error) {
+	keySet, err := s.authService.PublicKeys(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	keys := make([]*ssov1.Jwk, len(keySet.Keys))
+	for i, key := range keySet.Keys {
+		keys[i] = &ssov1.Jwk{
+			Kty: key.Kty,
+			Crv: key.Crv,
+			X:   key.X,
+			Y:   key.Y,
+			Kid: key.Kid,
+			Use: key.Use,
+			Alg: key.Alg,
+		}
+	}
+
+	return &ssov1.GetJWKSResponse{
+		Keys: keys,
+	}, nil
+}
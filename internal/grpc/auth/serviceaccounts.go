@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *serverAPI) CreateServiceAccount(ctx context.Context, req *ssov1.CreateServiceAccountRequest) (*ssov1.CreateServiceAccountResponse, error) {
+	if err := validateCreateServiceAccount(ctx, req.GetName(), req.GetPublicKey(), req.GetPermissions()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	publicKey, err := jwt.ParsePublicKey(req.GetPublicKey())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "public key must be a PEM encoded P-256 key")
+	}
+
+	// no one grants permissions they do not have
+	for _, permission := range req.GetPermissions() {
+		if !authorization.HasPermission(ctx, permission) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+	}
+
+	account, err := s.saService.Create(ctx, models.ServiceAccount{
+		Name:        req.GetName(),
+		PublicKey:   publicKey,
+		Permissions: req.GetPermissions(),
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidServiceAccount) {
+			return nil, status.Error(codes.InvalidArgument, "invalid service account")
+		}
+		if errors.Is(err, services.ErrServiceAccountExists) {
+			return nil, status.Error(codes.AlreadyExists, "service account already exists")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.CreateServiceAccountResponse{
+		ServiceAccount: serviceAccountResponse(account),
+	}, nil
+}
+
+func (s *serverAPI) ListServiceAccounts(ctx context.Context, req *ssov1.ListServiceAccountsRequest) (*ssov1.ListServiceAccountsResponse, error) {
+	accounts, err := s.saService.List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	accountsResp := make([]*ssov1.ServiceAccount, len(accounts))
+	for i := range accounts {
+		accountsResp[i] = serviceAccountResponse(accounts[i])
+	}
+
+	return &ssov1.ListServiceAccountsResponse{
+		ServiceAccounts: accountsResp,
+	}, nil
+}
+
+func (s *serverAPI) RotateServiceAccountKey(ctx context.Context, req *ssov1.RotateServiceAccountKeyRequest) (*ssov1.RotateServiceAccountKeyResponse, error) {
+	id, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid service account id")
+	}
+
+	publicKey, err := jwt.ParsePublicKey(req.GetPublicKey())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "public key must be a PEM encoded P-256 key")
+	}
+
+	if err := s.saService.RotateKey(ctx, id, publicKey); err != nil {
+		if errors.Is(err, services.ErrServiceAccountNotFound) {
+			return nil, status.Error(codes.NotFound, "service account not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.RotateServiceAccountKeyResponse{}, nil
+}
+
+func (s *serverAPI) DisableServiceAccount(ctx context.Context, req *ssov1.DisableServiceAccountRequest) (*ssov1.DisableServiceAccountResponse, error) {
+	id, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid service account id")
+	}
+
+	if err := s.saService.Disable(ctx, id); err != nil {
+		if errors.Is(err, services.ErrServiceAccountNotFound) {
+			return nil, status.Error(codes.NotFound, "service account not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.DisableServiceAccountResponse{}, nil
+}
+
+func serviceAccountResponse(account models.ServiceAccount) *ssov1.ServiceAccount {
+	return &ssov1.ServiceAccount{
+		Id:          account.Id.String(),
+		Name:        account.Name,
+		Permissions: account.Permissions,
+		Disabled:    account.Disabled,
+		CreatedAt:   timestamppb.New(account.CreatedAt),
+	}
+}
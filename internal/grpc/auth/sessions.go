@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/roles"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *serverAPI) ListSessions(ctx context.Context, req *ssov1.ListSessionsRequest) (*ssov1.ListSessionsResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersRead); err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.authService.ListSessions(ctx, id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	sessionsResp := make([]*ssov1.Session, len(sessions))
+	for i := range sessions {
+		sessionsResp[i] = &ssov1.Session{
+			Id:         sessions[i].Id,
+			UserAgent:  sessions[i].UserAgent,
+			Ip:         sessions[i].IP,
+			CreatedAt:  timestamppb.New(sessions[i].CreatedAt),
+			LastUsedAt: timestamppb.New(sessions[i].LastUsedAt),
+		}
+	}
+
+	return &ssov1.ListSessionsResponse{
+		Sessions: sessionsResp,
+	}, nil
+}
+
+func (s *serverAPI) RevokeSession(ctx context.Context, req *ssov1.RevokeSessionRequest) (*ssov1.RevokeSessionResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := validateRevokeSession(ctx, req.GetSessionId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersWrite); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.RevokeSession(ctx, id, req.GetSessionId()); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return nil, status.Error(codes.NotFound, "session not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.RevokeSessionResponse{}, nil
+}
+
+func (s *serverAPI) RevokeAllSessions(ctx context.Context, req *ssov1.RevokeAllSessionsRequest) (*ssov1.RevokeAllSessionsResponse, error) {
+	id, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id")
+	}
+
+	if err := s.authorizeUser(ctx, id, roles.PermissionUsersWrite); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.RevokeAllSessions(ctx, id); err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.RevokeAllSessionsResponse{}, nil
+}
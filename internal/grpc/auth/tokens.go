@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *serverAPI) Introspect(ctx context.Context, req *ssov1.IntrospectRequest) (*ssov1.IntrospectResponse, error) {
+	if err := validateToken(ctx, req.GetToken(), req.GetTokenTypeHint()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	introspection, err := s.tokenService.Introspect(ctx, req.GetToken(), req.GetTokenTypeHint())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	if !introspection.Active {
+		return &ssov1.IntrospectResponse{}, nil
+	}
+
+	return &ssov1.IntrospectResponse{
+		Active:    true,
+		TokenType: introspection.TokenType,
+		Sub:       introspection.Subject,
+		ClientId:  introspection.ClientId,
+		Aud:       introspection.Audience,
+		Scope:     introspection.Scope,
+		Jti:       introspection.JTI,
+		Exp:       introspection.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (s *serverAPI) RevokeToken(ctx context.Context, req *ssov1.RevokeTokenRequest) (*ssov1.RevokeTokenResponse, error) {
+	if err := validateToken(ctx, req.GetToken(), req.GetTokenTypeHint()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "validation error")
+	}
+
+	if err := s.tokenService.Revoke(ctx, "", req.GetToken(), req.GetTokenTypeHint()); err != nil {
+		return nil, status.Error(codes.Internal, "Internal error")
+	}
+
+	return &ssov1.RevokeTokenResponse{}, nil
+}
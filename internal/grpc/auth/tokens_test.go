@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+
+	ssov1 "github.com/hesoyamTM/apphelper-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTokenMethodsRequireAuth(t *testing.T) {
+	interceptor := authorization.NewServerWithKeySet(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		AuthMethods(),
+		make(chan jwks.JWKS),
+		authorization.WithPermissions(MethodPermissions()),
+	)
+
+	s := &serverAPI{}
+
+	calls := map[string]func(ctx context.Context) error{
+		"Introspect": func(ctx context.Context) error {
+			_, err := s.Introspect(ctx, &ssov1.IntrospectRequest{Token: "token"})
+			return err
+		},
+		"RevokeToken": func(ctx context.Context) error {
+			_, err := s.RevokeToken(ctx, &ssov1.RevokeTokenRequest{Token: "token"})
+			return err
+		},
+	}
+
+	for method, call := range calls {
+		t.Run(method, func(t *testing.T) {
+			_, err := interceptor.Unary()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: fullMethod(method)},
+				func(ctx context.Context, req any) (any, error) {
+					return nil, call(ctx)
+				})
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("expected unauthenticated for an anonymous call, got %v", err)
+			}
+		})
+	}
+}
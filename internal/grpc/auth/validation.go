@@ -8,6 +8,10 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func validateRegister(ctx context.Context, name, surname, login, pass string) error {
@@ -24,7 +28,7 @@ func validateRegister(ctx context.Context, name, surname, login, pass string) er
 		fmt.Println("login " + err.Error())
 		return err
 	}
-	if err := validate.VarCtx(ctx, pass, "required,gte=8,lte=20"); err != nil {
+	if err := validate.VarCtx(ctx, pass, "required"); err != nil {
 		fmt.Println("password " + err.Error())
 		return err
 	}
@@ -36,7 +40,7 @@ func validateLogin(ctx context.Context, login, pass string) error {
 	if err := validate.VarCtx(ctx, login, "required,lte=50"); err != nil {
 		return err
 	}
-	if err := validate.VarCtx(ctx, pass, "required,gte=8,lte=20"); err != nil {
+	if err := validate.VarCtx(ctx, pass, "required"); err != nil {
 		return err
 	}
 	return nil
@@ -78,7 +82,7 @@ func validateUpdateUser(ctx context.Context, user models.UserInfo) error {
 
 func validateChangePassword(ctx context.Context, newPassword, token string) error {
 	validate := validator.New()
-	if err := validate.VarCtx(ctx, newPassword, "required,gte=8,lte=20"); err != nil {
+	if err := validate.VarCtx(ctx, newPassword, "required"); err != nil {
 		return err
 	}
 	if err := validate.VarCtx(ctx, token, "required"); err != nil {
@@ -86,3 +90,219 @@ func validateChangePassword(ctx context.Context, newPassword, token string) erro
 	}
 	return nil
 }
+
+func validateRevokeSession(ctx context.Context, sessionId string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, sessionId, "required,uuid"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateLoginMFA(ctx context.Context, challengeToken, code string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, challengeToken, "required"); err != nil {
+		return err
+	}
+	return validateMFACode(ctx, code)
+}
+
+func validateMFACode(ctx context.Context, code string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, code, "required,lte=20"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateFinishPasskeyRegistration(ctx context.Context, ceremonyToken, name string, credential []byte) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, ceremonyToken, "required"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, name, "lte=64"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, credential, "required"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateFinishPasskeyLogin(ctx context.Context, ceremonyToken string, credential []byte) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, ceremonyToken, "required"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, credential, "required"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateCreateClient(ctx context.Context, name string, redirectURIs, scopes []string, accessTokenTTLSeconds int64) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, name, "required,lte=64"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, redirectURIs, "dive,url"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, scopes, "dive,required,lte=64"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, accessTokenTTLSeconds, "gte=0"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateClientId(ctx context.Context, clientId string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, clientId, "required,lte=64"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateUserCode(ctx context.Context, userCode string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, userCode, "required,lte=20"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateToken(ctx context.Context, token, tokenTypeHint string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, token, "required,lte=4096"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, tokenTypeHint, "omitempty,oneof=access_token refresh_token"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateRole(ctx context.Context, name, description string, permissions []string) error {
+	validate := validator.New()
+	if err := validateRoleName(ctx, name); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, description, "lte=256"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, permissions, "lte=100,dive,required,lte=128"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateRoleName(ctx context.Context, name string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, name, "required,lte=64"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateOrganization(ctx context.Context, slug, name string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, slug, "required,lte=64"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, name, "required,lte=128"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateMemberRoles(ctx context.Context, roles []string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, roles, "lte=100,dive,required,lte=64"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateInviteUser(ctx context.Context, email, name, surname string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, email, "required,email,lte=50"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, name, "required,lte=20"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, surname, "required,lte=20"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateAcceptInvite(ctx context.Context, token, password string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, token, "required"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, password, "required"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateCreatePersonalAccessToken(ctx context.Context, name string, scopes []string, expiresInSeconds int64) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, name, "required,lte=64"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, scopes, "lte=100,dive,required,lte=64"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, expiresInSeconds, "gte=0"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateCreateServiceAccount(ctx context.Context, name, publicKey string, permissions []string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, name, "required,lte=64"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, publicKey, "required,lte=1024"); err != nil {
+		return err
+	}
+	if err := validate.VarCtx(ctx, permissions, "lte=100,dive,required,lte=64"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateImpersonate(ctx context.Context, reason string) error {
+	validate := validator.New()
+	if err := validate.VarCtx(ctx, reason, "required,lte=256"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// passwordPolicyError returns InvalidArgument with a field violation for every broken password rule
+func passwordPolicyError(field string, policyErr *services.PasswordPolicyError) error {
+	st := status.New(codes.InvalidArgument, "password does not meet the policy")
+
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range policyErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Reason:      violation.Reason,
+			Description: violation.Description,
+		})
+	}
+
+	detailed, err := st.WithDetails(badRequest)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -0,0 +1,401 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/services/oauth"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type OAuth interface {
+	Authorize(ctx context.Context, req models.AuthorizationRequest, bearerToken string) (string, error)
+	Exchange(ctx context.Context, req models.TokenRequest) (models.OAuthTokens, error)
+	AuthorizeDevice(ctx context.Context, clientId, clientSecret, scope string) (models.DeviceCode, error)
+	UserInfo(ctx context.Context, bearerToken string) (map[string]any, error)
+	AuthenticateClient(ctx context.Context, clientId, clientSecret string) (models.OAuthClient, error)
+	Configuration() models.OpenIDConfiguration
+}
+
+type Tokens interface {
+	Introspect(ctx context.Context, token, tokenTypeHint string) (models.TokenIntrospection, error)
+	Revoke(ctx context.Context, clientId, token, tokenTypeHint string) error
+}
+
+type handler struct {
+	oauthService OAuth
+	tokenService Tokens
+}
+
+func RegisterHandlers(mux *http.ServeMux, oauthService OAuth, tokenService Tokens) {
+	h := &handler{oauthService: oauthService, tokenService: tokenService}
+
+	// the login page sends the request back with POST and the access token of the user
+	mux.HandleFunc("GET /authorize", h.authorize)
+	mux.HandleFunc("POST /authorize", h.authorize)
+	mux.HandleFunc("POST /token", h.token)
+	mux.HandleFunc("POST /device_authorization", h.deviceAuthorization)
+	mux.HandleFunc("GET /userinfo", h.userInfo)
+	mux.HandleFunc("POST /userinfo", h.userInfo)
+	mux.HandleFunc("POST /introspect", h.introspect)
+	mux.HandleFunc("POST /revoke", h.revoke)
+	mux.HandleFunc("GET /.well-known/openid-configuration", h.configuration)
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type deviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// introspectionResponse of RFC 7662 section 2.2, inactive tokens have active only
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	ClientId  string   `json:"client_id,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	// extensions for personal access tokens
+	OrgId       string   `json:"org_id,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.GetLoggerFromCtx(ctx)
+
+	req := models.AuthorizationRequest{
+		ResponseType:        r.FormValue("response_type"),
+		ClientId:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		Scope:               r.FormValue("scope"),
+		State:               r.FormValue("state"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+		Nonce:               r.FormValue("nonce"),
+	}
+
+	redirect, err := h.oauthService.Authorize(ctx, req, r.Header.Get("Authorization"))
+	if err != nil {
+		var oauthErr *oauth.Error
+		if errors.As(err, &oauthErr) {
+			writeError(ctx, w, http.StatusBadRequest, oauthErr)
+			return
+		}
+
+		log.Error(ctx, "failed to authorize", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.GetLoggerFromCtx(ctx)
+
+	clientId, clientSecret, ok := clientCredentials(r)
+	if !ok {
+		writeError(ctx, w, http.StatusUnauthorized, &oauth.Error{Code: oauth.ErrCodeInvalidClient, Description: "malformed client credentials"})
+		return
+	}
+
+	req := models.TokenRequest{
+		GrantType:    r.PostFormValue("grant_type"),
+		Code:         r.PostFormValue("code"),
+		RedirectURI:  r.PostFormValue("redirect_uri"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		CodeVerifier: r.PostFormValue("code_verifier"),
+		Scope:        r.PostFormValue("scope"),
+		DeviceCode:   r.PostFormValue("device_code"),
+		RefreshToken: r.PostFormValue("refresh_token"),
+
+		ClientAssertionType: r.PostFormValue("client_assertion_type"),
+		ClientAssertion:     r.PostFormValue("client_assertion"),
+	}
+
+	tokens, err := h.oauthService.Exchange(ctx, req)
+	if err != nil {
+		var oauthErr *oauth.Error
+		if errors.As(err, &oauthErr) {
+			status := http.StatusBadRequest
+			if oauthErr.Code == oauth.ErrCodeInvalidClient {
+				status = http.StatusUnauthorized
+			}
+
+			writeError(ctx, w, status, oauthErr)
+			return
+		}
+
+		log.Error(ctx, "failed to exchange code", zap.Error(err))
+		writeError(ctx, w, http.StatusInternalServerError, &oauth.Error{Code: "server_error"})
+		return
+	}
+
+	writeJSON(ctx, w, http.StatusOK, tokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		IdToken:      tokens.IdToken,
+		Scope:        tokens.Scope,
+	})
+}
+
+func (h *handler) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.GetLoggerFromCtx(ctx)
+
+	clientId, clientSecret, ok := clientCredentials(r)
+	if !ok {
+		writeError(ctx, w, http.StatusUnauthorized, &oauth.Error{Code: oauth.ErrCodeInvalidClient, Description: "malformed client credentials"})
+		return
+	}
+
+	deviceCode, err := h.oauthService.AuthorizeDevice(ctx, clientId, clientSecret, r.PostFormValue("scope"))
+	if err != nil {
+		var oauthErr *oauth.Error
+		if errors.As(err, &oauthErr) {
+			status := http.StatusBadRequest
+			if oauthErr.Code == oauth.ErrCodeInvalidClient {
+				status = http.StatusUnauthorized
+			}
+
+			writeError(ctx, w, status, oauthErr)
+			return
+		}
+
+		log.Error(ctx, "failed to authorize device", zap.Error(err))
+		writeError(ctx, w, http.StatusInternalServerError, &oauth.Error{Code: "server_error"})
+		return
+	}
+
+	writeJSON(ctx, w, http.StatusOK, deviceCodeResponse{
+		DeviceCode:              deviceCode.DeviceCode,
+		UserCode:                deviceCode.UserCode,
+		VerificationURI:         deviceCode.VerificationURI,
+		VerificationURIComplete: deviceCode.VerificationURIComplete,
+		ExpiresIn:               int64(deviceCode.ExpiresIn.Seconds()),
+		Interval:                int64(deviceCode.Interval.Seconds()),
+	})
+}
+
+func (h *handler) userInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.GetLoggerFromCtx(ctx)
+
+	claims, err := h.oauthService.UserInfo(ctx, r.Header.Get("Authorization"))
+	if err != nil {
+		var oauthErr *oauth.Error
+		if errors.As(err, &oauthErr) {
+			status := http.StatusUnauthorized
+			if oauthErr.Code == oauth.ErrCodeInsufficientScope {
+				status = http.StatusForbidden
+			}
+
+			// RFC 6750 section 3
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, error_description=%q", oauthErr.Code, oauthErr.Description))
+			writeError(ctx, w, status, oauthErr)
+			return
+		}
+
+		log.Error(ctx, "failed to provide user info", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(ctx, w, http.StatusOK, claims)
+}
+
+func (h *handler) introspect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.GetLoggerFromCtx(ctx)
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	// anyone can act as a public client, so they must not learn about the tokens of others
+	if client.Public() {
+		writeError(ctx, w, http.StatusUnauthorized, &oauth.Error{Code: oauth.ErrCodeInvalidClient, Description: "public clients can not introspect tokens"})
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeError(ctx, w, http.StatusBadRequest, &oauth.Error{Code: oauth.ErrCodeInvalidRequest, Description: "token is required"})
+		return
+	}
+
+	introspection, err := h.tokenService.Introspect(ctx, token, r.PostFormValue("token_type_hint"))
+	if err != nil {
+		log.Error(ctx, "failed to introspect token", zap.Error(err))
+		writeError(ctx, w, http.StatusInternalServerError, &oauth.Error{Code: "server_error"})
+		return
+	}
+
+	if !introspection.Active {
+		writeJSON(ctx, w, http.StatusOK, introspectionResponse{})
+		return
+	}
+
+	resp := introspectionResponse{
+		Active:      true,
+		TokenType:   introspection.TokenType,
+		Sub:         introspection.Subject,
+		ClientId:    introspection.ClientId,
+		Aud:         introspection.Audience,
+		Scope:       introspection.Scope,
+		Jti:         introspection.JTI,
+		OrgId:       introspection.OrganizationId,
+		Permissions: introspection.Permissions,
+	}
+	// personal access tokens may not expire
+	if !introspection.ExpiresAt.IsZero() {
+		resp.Exp = introspection.ExpiresAt.Unix()
+	}
+
+	writeJSON(ctx, w, http.StatusOK, resp)
+}
+
+func (h *handler) revoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.GetLoggerFromCtx(ctx)
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	// public clients revoke only the tokens issued to them
+	var owner string
+	if client.Public() {
+		owner = client.Id
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeError(ctx, w, http.StatusBadRequest, &oauth.Error{Code: oauth.ErrCodeInvalidRequest, Description: "token is required"})
+		return
+	}
+
+	if err := h.tokenService.Revoke(ctx, owner, token, r.PostFormValue("token_type_hint")); err != nil {
+		if errors.Is(err, services.ErrNotAuthorized) {
+			writeError(ctx, w, http.StatusBadRequest, &oauth.Error{Code: oauth.ErrCodeUnauthorizedClient, Description: "the token was not issued to the client"})
+			return
+		}
+
+		log.Error(ctx, "failed to revoke token", zap.Error(err))
+		writeError(ctx, w, http.StatusServiceUnavailable, &oauth.Error{Code: "server_error"})
+		return
+	}
+
+	// invalid tokens are revoked too (RFC 7009 section 2.2)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient writes the error response if the client fails to authenticate
+func (h *handler) authenticateClient(w http.ResponseWriter, r *http.Request) (models.OAuthClient, bool) {
+	ctx := r.Context()
+	log := logger.GetLoggerFromCtx(ctx)
+
+	clientId, clientSecret, ok := clientCredentials(r)
+	if !ok {
+		writeError(ctx, w, http.StatusUnauthorized, &oauth.Error{Code: oauth.ErrCodeInvalidClient, Description: "malformed client credentials"})
+		return models.OAuthClient{}, false
+	}
+
+	client, err := h.oauthService.AuthenticateClient(ctx, clientId, clientSecret)
+	if err != nil {
+		var oauthErr *oauth.Error
+		if errors.As(err, &oauthErr) {
+			writeError(ctx, w, http.StatusUnauthorized, oauthErr)
+			return models.OAuthClient{}, false
+		}
+
+		log.Error(ctx, "failed to authenticate client", zap.Error(err))
+		writeError(ctx, w, http.StatusInternalServerError, &oauth.Error{Code: "server_error"})
+		return models.OAuthClient{}, false
+	}
+
+	return client, true
+}
+
+func (h *handler) configuration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if err := json.NewEncoder(w).Encode(h.oauthService.Configuration()); err != nil {
+		logger.GetLoggerFromCtx(r.Context()).Error(r.Context(), "failed to encode openid configuration", zap.Error(err))
+	}
+}
+
+// clientCredentials reads the client id and secret from the basic authorization header
+// or the request body (RFC 6749 section 2.3.1)
+func clientCredentials(r *http.Request) (string, string, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return r.PostFormValue("client_id"), r.PostFormValue("client_secret"), true
+	}
+
+	clientId, err := url.QueryUnescape(username)
+	if err != nil {
+		return "", "", false
+	}
+
+	clientSecret, err := url.QueryUnescape(password)
+	if err != nil {
+		return "", "", false
+	}
+
+	return clientId, clientSecret, true
+}
+
+func writeError(ctx context.Context, w http.ResponseWriter, status int, err *oauth.Error) {
+	writeJSON(ctx, w, status, errorResponse{
+		Error:            err.Code,
+		ErrorDescription: err.Description,
+	})
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	// token responses must not be cached (RFC 6749 section 5.1)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to encode response", zap.Error(err))
+	}
+}
@@ -0,0 +1,44 @@
+package wellknown
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type KeysProvider interface {
+	PublicKeys(ctx context.Context) (jwks.JWKS, error)
+}
+
+type handler struct {
+	keysProvider KeysProvider
+}
+
+func RegisterHandlers(mux *http.ServeMux, keysProvider KeysProvider) {
+	h := &handler{keysProvider: keysProvider}
+
+	mux.HandleFunc("GET /.well-known/jwks.json", h.jwks)
+}
+
+func (h *handler) jwks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.GetLoggerFromCtx(ctx)
+
+	keySet, err := h.keysProvider.PublicKeys(ctx)
+	if err != nil {
+		log.Error(ctx, "failed to provide public keys", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if err := json.NewEncoder(w).Encode(keySet); err != nil {
+		log.Error(ctx, "failed to encode jwks", zap.Error(err))
+	}
+}
@@ -0,0 +1,154 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+type ctxKey string
+
+const Key ctxKey = "device"
+
+func WithDevice(ctx context.Context, device models.Device) context.Context {
+	return context.WithValue(ctx, Key, device)
+}
+
+func FromContext(ctx context.Context) models.Device {
+	device, _ := ctx.Value(Key).(models.Device)
+	return device
+}
+
+// TrustedProxies are the networks of the proxies whose forwarding headers are honored
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses CIDRs, a single address is a network of its own
+func ParseTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+			}
+
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UnaryServerInterceptor stores the client user agent and ip in the request context.
+// The ip forwarded by the api gateway is used only if the peer is a trusted proxy
+func UnaryServerInterceptor(proxies TrustedProxies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		var device models.Device
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		device.UserAgent = first(md.Get("grpcgateway-user-agent"), md.Get("user-agent"))
+
+		if p, ok := peer.FromContext(ctx); ok {
+			device.IP = proxies.clientIP(p.Addr.String(), strings.Join(md.Get("x-forwarded-for"), ","), first(md.Get("x-real-ip")))
+		}
+
+		return handler(WithDevice(ctx, device), req)
+	}
+}
+
+// Middleware is UnaryServerInterceptor for http handlers
+func Middleware(proxies TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			device := models.Device{
+				UserAgent: r.UserAgent(),
+				IP:        proxies.clientIP(r.RemoteAddr, strings.Join(r.Header.Values("X-Forwarded-For"), ","), r.Header.Get("X-Real-IP")),
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithDevice(r.Context(), device)))
+		})
+	}
+}
+
+// clientIP returns the peer address unless it is a trusted proxy. Otherwise the forwarded
+// addresses are walked from the right, the first one not of a trusted proxy is the client,
+// since the addresses on its left are set by the client itself and can be forged
+func (p TrustedProxies) clientIP(peerAddr, forwardedFor, realIP string) string {
+	host := hostOf(peerAddr)
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !p.trusts(addr) {
+		return host
+	}
+
+	if forwardedFor == "" {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(realIP)); err == nil {
+			return addr.String()
+		}
+
+		return host
+	}
+
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		addr = hop
+		if !p.trusts(hop) {
+			break
+		}
+	}
+
+	return addr.String()
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+func first(values ...[]string) string {
+	for _, v := range values {
+		if len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+	}
+
+	return ""
+}
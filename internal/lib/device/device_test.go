@@ -0,0 +1,44 @@
+package device
+
+import (
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		peerAddr     string
+		forwardedFor string
+		realIP       string
+		expected     string
+	}{
+		{"untrusted peer", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted peer", "10.0.0.1:5000", "198.51.100.1", "", "198.51.100.1"},
+		{"forged by the client", "10.0.0.1:5000", "192.0.2.1, 198.51.100.1", "", "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1:5000", "198.51.100.1, 10.0.0.2", "", "198.51.100.1"},
+		{"real ip", "[::1]:5000", "", "198.51.100.2", "198.51.100.2"},
+		{"no headers", "10.0.0.1:5000", "", "", "10.0.0.1"},
+		{"invalid hop", "10.0.0.1:5000", "unknown, 10.0.0.2", "", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		if got := proxies.clientIP(tt.peerAddr, tt.forwardedFor, tt.realIP); got != tt.expected {
+			t.Errorf("%s: unexpected ip %q, expected %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("expected an error for an invalid cidr")
+	}
+
+	if _, err := ParseTrustedProxies([]string{"proxy"}); err == nil {
+		t.Errorf("expected an error for an invalid address")
+	}
+}
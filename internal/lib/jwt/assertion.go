@@ -0,0 +1,93 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ClientAssertionType is the client_assertion_type of private_key_jwt (RFC 7523 section 2.2)
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// ClientAssertion is a verified assertion a client authenticates with, Subject is the client
+type ClientAssertion struct {
+	Subject   string
+	Id        string
+	ExpiresAt time.Time
+}
+
+// NewClientAssertion signs an assertion of the subject for the token endpoint of the audience
+func NewClientAssertion(subject, audience string, duration time.Duration, prKey *ecdsa.PrivateKey) (string, error) {
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Issuer:    subject,
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+	})
+
+	return token.SignedString(prKey)
+}
+
+// ParseClientAssertion verifies an assertion with the key publicKey returns for its subject
+// (RFC 7523 section 3). Assertions living longer than maxLifetime are rejected, so that
+// their ids only have to be remembered for that long
+func ParseClientAssertion(assertion string, publicKey func(subject string) (*ecdsa.PublicKey, error), audience string, maxLifetime time.Duration) (ClientAssertion, error) {
+	const op = "jwt.ParseClientAssertion"
+
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(assertion, &claims, func(t *jwt.Token) (interface{}, error) {
+		return publicKey(claims.Subject)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(Leeway),
+	)
+	if err != nil {
+		return ClientAssertion{}, fmt.Errorf("%s: %w", op, errors.Join(ErrUnauthorized, err))
+	}
+
+	if claims.Issuer != claims.Subject || claims.ID == "" || claims.IssuedAt == nil ||
+		claims.ExpiresAt.Sub(claims.IssuedAt.Time) > maxLifetime {
+		return ClientAssertion{}, fmt.Errorf("%s: %w", op, ErrUnauthorized)
+	}
+
+	return ClientAssertion{
+		Subject:   claims.Subject,
+		Id:        claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// ParsePublicKey parses a PEM encoded P-256 public key, the key private_key_jwt assertions are signed with
+func ParsePublicKey(pemEncoded string) (*ecdsa.PublicKey, error) {
+	const op = "jwt.ParsePublicKey"
+
+	block, _ := pem.Decode([]byte(pemEncoded))
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block", op)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve.Params().Name != "P-256" {
+		return nil, fmt.Errorf("%s: not a P-256 key", op)
+	}
+
+	return publicKey, nil
+}
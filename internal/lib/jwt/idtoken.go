@@ -0,0 +1,45 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDToken is an OpenID Connect ID token. UserClaims are the standard claims
+// of the user released by the requested scopes
+type IDToken struct {
+	Issuer     string
+	Subject    string
+	Audience   string
+	Nonce      string
+	AuthTime   time.Time
+	UserClaims map[string]any
+}
+
+func NewIDToken(idToken IDToken, duration time.Duration, prKey *ecdsa.PrivateKey) (string, error) {
+	token := jwt.New(jwt.SigningMethodES256)
+	token.Header["kid"] = jwks.KeyID(&prKey.PublicKey)
+
+	claims := token.Claims.(jwt.MapClaims)
+	for name, value := range idToken.UserClaims {
+		claims[name] = value
+	}
+
+	now := time.Now()
+
+	claims["iss"] = idToken.Issuer
+	claims["sub"] = idToken.Subject
+	claims["aud"] = idToken.Audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
+	claims["auth_time"] = idToken.AuthTime.Unix()
+	if idToken.Nonce != "" {
+		claims["nonce"] = idToken.Nonce
+	}
+
+	return token.SignedString(prKey)
+}
@@ -53,3 +53,12 @@ func DecodePrivateKey(pemEncoded string) (*ecdsa.PrivateKey, error) {
 
 	return privateKey, nil
 }
+
+func EncodePrivateKey(privateKey *ecdsa.PrivateKey) (string, error) {
+	x509Encoded, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x509Encoded})), nil
+}
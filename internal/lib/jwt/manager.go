@@ -4,10 +4,12 @@ import (
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -17,16 +19,82 @@ var (
 	ErrUnauthorized = errors.New("unauthorized")
 )
 
-func NewTokens(user models.UserInfo, duration time.Duration, prKey *ecdsa.PrivateKey) (models.JWTokens, error) {
-	token := jwt.New(jwt.SigningMethodES256)
+// Leeway tolerates the clock skew between sso and the services verifying its tokens
+const Leeway = 30 * time.Second
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.Id
-	claims["name"] = user.Name
-	claims["surname"] = user.Surname
-	claims["exp"] = time.Now().Add(duration).Unix()
+// AccessToken is an access token to issue. Issuer and Audience are the iss and aud
+// of the environment, User is empty for tokens issued to a client for itself.
+// ServiceAccountId makes the service account the subject, ActorId sets the act claim.
+// Id is the jti, a random one is used if it is not set
+type AccessToken struct {
+	Id             uuid.UUID
+	Issuer         string
+	Audience       string
+	User           models.UserInfo
+	OrganizationId uuid.UUID
+	ClientId       string
+	Scope          string
+	Roles          []string
+	Permissions    []string
 
-	tokenString, err := token.SignedString(prKey)
+	ServiceAccountId uuid.UUID
+	ActorId          uuid.UUID
+}
+
+// NewAccessToken issues an access token, the subject is the user or the client acting on its own behalf
+func NewAccessToken(accessToken AccessToken, duration time.Duration, prKey *ecdsa.PrivateKey) (string, error) {
+	now := time.Now()
+
+	id := accessToken.Id
+	if id == uuid.Nil {
+		id = uuid.New()
+	}
+
+	claims := jwks.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id.String(),
+			Issuer:    accessToken.Issuer,
+			Subject:   accessToken.ClientId,
+			Audience:  jwt.ClaimStrings{accessToken.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		},
+		ClientId:    accessToken.ClientId,
+		Scope:       accessToken.Scope,
+		Roles:       accessToken.Roles,
+		Permissions: accessToken.Permissions,
+	}
+
+	if accessToken.User.Id != uuid.Nil {
+		claims.Subject = accessToken.User.Id.String()
+		claims.UserId = claims.Subject
+		claims.Name = accessToken.User.Name
+		claims.Surname = accessToken.User.Surname
+	}
+
+	if accessToken.ServiceAccountId != uuid.Nil {
+		claims.Subject = accessToken.ServiceAccountId.String()
+		claims.PrincipalType = jwks.PrincipalTypeService
+	}
+
+	if accessToken.ActorId != uuid.Nil {
+		claims.Actor = &jwks.Actor{Subject: accessToken.ActorId.String()}
+	}
+
+	if accessToken.OrganizationId != uuid.Nil {
+		claims.OrganizationId = accessToken.OrganizationId.String()
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = jwks.KeyID(&prKey.PublicKey)
+
+	return token.SignedString(prKey)
+}
+
+// NewTokens issues an access token and a refresh token to the user
+func NewTokens(accessToken AccessToken, duration time.Duration, prKey *ecdsa.PrivateKey) (models.JWTokens, error) {
+	tokenString, err := NewAccessToken(accessToken, duration, prKey)
 	if err != nil {
 		return models.JWTokens{}, err
 	}
@@ -37,23 +105,159 @@ func NewTokens(user models.UserInfo, duration time.Duration, prKey *ecdsa.Privat
 	}, nil
 }
 
-func VerifyBearerToken(bearerToken string, publicKey *ecdsa.PublicKey) (string, error) {
+// VerifyBearerToken returns the user id of a bearer token. The issuer and the audience
+// are not checked when empty, in all the Verify functions
+func VerifyBearerToken(bearerToken string, publicKey *ecdsa.PublicKey, issuer, audience string) (string, error) {
 	const op = "jwt.VerifyBearerToken"
 
-	token := strings.Split(bearerToken, " ")[1]
-
-	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+	uid, err := verify(bearerToken, func(t *jwt.Token) (interface{}, error) {
 		return publicKey, nil
-	})
+	}, issuer, audience)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return uid, nil
+}
+
+// VerifyBearerTokenWithKeySet selects the verification key by the kid token header
+func VerifyBearerTokenWithKeySet(bearerToken string, keySet jwks.JWKS, issuer, audience string) (string, error) {
+	const op = "jwt.VerifyBearerTokenWithKeySet"
+
+	uid, err := verify(bearerToken, keySetFunc(keySet), issuer, audience)
 	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	claims := parsed.Claims.(jwt.MapClaims)
+	return uid, nil
+}
+
+// VerifyUserToken accepts only tokens issued by Login, so that a token issued
+// to an OAuth client or to an impersonating admin cannot be used to act as the user elsewhere
+func VerifyUserToken(bearerToken string, keySet jwks.JWKS, issuer, audience string) (string, error) {
+	const op = "jwt.VerifyUserToken"
+
+	claims, err := verifyClaims(bearerToken, keySetFunc(keySet), issuer, audience)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
 
-	if int64(claims["exp"].(float64)) < time.Now().Unix() {
+	if claims.UserId == "" || claims.ClientId != "" || claims.Actor != nil {
 		return "", fmt.Errorf("%s: %w", op, ErrUnauthorized)
 	}
 
-	return claims["uid"].(string), nil
+	return claims.UserId, nil
+}
+
+// VerifyClientToken accepts only tokens issued to an OAuth client on behalf of a user,
+// the aud of which is the client
+func VerifyClientToken(bearerToken string, keySet jwks.JWKS, issuer string) (jwks.Claims, error) {
+	const op = "jwt.VerifyClientToken"
+
+	claims, err := verifyClaims(bearerToken, keySetFunc(keySet), issuer, "")
+	if err != nil {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if claims.UserId == "" || claims.ClientId == "" || !slices.Contains(claims.Audience, claims.ClientId) {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, ErrUnauthorized)
+	}
+
+	return claims, nil
+}
+
+// VerifyAccessToken verifies a bearer token and returns its claims
+func VerifyAccessToken(bearerToken string, keySet jwks.JWKS, issuer, audience string) (jwks.Claims, error) {
+	const op = "jwt.VerifyAccessToken"
+
+	claims, err := verifyClaims(bearerToken, keySetFunc(keySet), issuer, audience)
+	if err != nil {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return claims, nil
+}
+
+// ParseAccessToken verifies a token without the bearer prefix and returns its claims.
+// The aud is either the audience or the client of a token issued to a client
+func ParseAccessToken(token string, keySet jwks.JWKS, issuer, audience string) (jwks.Claims, error) {
+	const op = "jwt.ParseAccessToken"
+
+	claims, err := parseClaims(token, keySetFunc(keySet), issuer, "")
+	if err != nil {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if audience != "" && !slices.Contains(claims.Audience, audience) &&
+		(claims.ClientId == "" || !slices.Contains(claims.Audience, claims.ClientId)) {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, ErrUnauthorized)
+	}
+
+	return claims, nil
+}
+
+func keySetFunc(keySet jwks.JWKS) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			// tokens issued before key ids were introduced
+			if len(keySet.Keys) == 1 {
+				return keySet.Keys[0].PublicKey()
+			}
+
+			return nil, jwks.ErrKeyNotFound
+		}
+
+		return keySet.Key(kid)
+	}
+}
+
+func verify(bearerToken string, keyFunc jwt.Keyfunc, issuer, audience string) (string, error) {
+	claims, err := verifyClaims(bearerToken, keyFunc, issuer, audience)
+	if err != nil {
+		return "", err
+	}
+
+	if claims.UserId == "" {
+		return "", ErrUnauthorized
+	}
+
+	return claims.UserId, nil
+}
+
+func verifyClaims(bearerToken string, keyFunc jwt.Keyfunc, issuer, audience string) (jwks.Claims, error) {
+	parts := strings.Split(bearerToken, " ")
+	if len(parts) != 2 {
+		return jwks.Claims{}, ErrUnauthorized
+	}
+
+	return parseClaims(parts[1], keyFunc, issuer, audience)
+}
+
+func parseClaims(token string, keyFunc jwt.Keyfunc, issuer, audience string) (jwks.Claims, error) {
+	opts := []jwt.ParserOption{
+		// the header must not choose the algorithm the key is used with
+		jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(Leeway),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+
+	var claims jwks.Claims
+	_, err := jwt.ParseWithClaims(token, &claims, keyFunc, opts...)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return jwks.Claims{}, ErrUnauthorized
+		}
+
+		return jwks.Claims{}, err
+	}
+
+	return claims, nil
 }
@@ -0,0 +1,142 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatchedPassword = errors.New("password does not match the hash")
+	ErrUnknownAlgorithm   = errors.New("unknown hash algorithm")
+	ErrInvalidHash        = errors.New("invalid hash")
+)
+
+// Params of argon2id, the memory is in KiB
+type Params struct {
+	Memory      uint32 `yaml:"memory" env-required:"true" env:"PASSWORD_MEMORY"`
+	Iterations  uint32 `yaml:"iterations" env-required:"true" env:"PASSWORD_ITERATIONS"`
+	Parallelism uint8  `yaml:"parallelism" env-required:"true" env:"PASSWORD_PARALLELISM"`
+}
+
+const (
+	saltLen = 16
+	keyLen  = 32
+)
+
+// Hasher hashes new passwords with argon2id and verifies argon2id and bcrypt hashes.
+// Hashes are stored in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Hasher struct {
+	params Params
+}
+
+func NewHasher(params Params) *Hasher {
+	return &Hasher{params: params}
+}
+
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, keyLen)
+
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.params.Memory,
+		h.params.Iterations,
+		h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)), nil
+}
+
+// Verify returns ErrMismatchedPassword if the password does not match the hash
+func (h *Hasher) Verify(hash []byte, password string) error {
+	if isBcrypt(hash) {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return ErrMismatchedPassword
+			}
+
+			return err
+		}
+
+		return nil
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatchedPassword
+	}
+
+	return nil
+}
+
+// NeedsRehash reports whether the hash was made by another algorithm or with other params
+func (h *Hasher) NeedsRehash(hash []byte) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params != h.params
+}
+
+func isBcrypt(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) ||
+		bytes.HasPrefix(hash, []byte("$2b$")) ||
+		bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func decodeArgon2id(hash []byte) (Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) < 2 || parts[0] != "" {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	if parts[1] != "argon2id" {
+		return Params{}, nil, nil, ErrUnknownAlgorithm
+	}
+
+	if len(parts) != 6 {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	var params Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
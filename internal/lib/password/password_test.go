@@ -0,0 +1,110 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap params to keep the tests fast
+var testParams = Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+}
+
+func TestHashAndVerify(t *testing.T) {
+	hasher := NewHasher(testParams)
+
+	hash, err := hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("unexpected hash format: %s", hash)
+	}
+
+	if err := hasher.Verify(hash, "correct horse battery staple"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := hasher.Verify(hash, "wrong"); !errors.Is(err, ErrMismatchedPassword) {
+		t.Errorf("expected mismatched password, got %v", err)
+	}
+
+	if hasher.NeedsRehash(hash) {
+		t.Errorf("fresh hash needs rehash")
+	}
+}
+
+func TestLongPasswords(t *testing.T) {
+	hasher := NewHasher(testParams)
+
+	// bcrypt ignores everything after 72 bytes
+	prefix := strings.Repeat("a", 72)
+
+	hash, err := hasher.Hash(prefix + "b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := hasher.Verify(hash, prefix+"c"); !errors.Is(err, ErrMismatchedPassword) {
+		t.Errorf("expected mismatched password, got %v", err)
+	}
+}
+
+func TestBcryptHashes(t *testing.T) {
+	hasher := NewHasher(testParams)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := hasher.Verify(hash, "password"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := hasher.Verify(hash, "wrong"); !errors.Is(err, ErrMismatchedPassword) {
+		t.Errorf("expected mismatched password, got %v", err)
+	}
+
+	if !hasher.NeedsRehash(hash) {
+		t.Errorf("bcrypt hash does not need rehash")
+	}
+}
+
+func TestNeedsRehashOnParamsChange(t *testing.T) {
+	hash, err := NewHasher(testParams).Hash("password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stronger := testParams
+	stronger.Iterations = 2
+
+	hasher := NewHasher(stronger)
+
+	if err := hasher.Verify(hash, "password"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !hasher.NeedsRehash(hash) {
+		t.Errorf("hash with old params does not need rehash")
+	}
+}
+
+func TestInvalidHash(t *testing.T) {
+	hasher := NewHasher(testParams)
+
+	if err := hasher.Verify([]byte("$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA"), "password"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("expected unknown algorithm, got %v", err)
+	}
+
+	if err := hasher.Verify([]byte("plain"), "password"); !errors.Is(err, ErrInvalidHash) {
+		t.Errorf("expected invalid hash, got %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ccojocar/zxcvbn-go"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+)
+
+// Violation reasons
+const (
+	ReasonTooShort      = "PASSWORD_TOO_SHORT"
+	ReasonTooLong       = "PASSWORD_TOO_LONG"
+	ReasonMissingLower  = "PASSWORD_MISSING_LOWERCASE"
+	ReasonMissingUpper  = "PASSWORD_MISSING_UPPERCASE"
+	ReasonMissingDigit  = "PASSWORD_MISSING_DIGIT"
+	ReasonMissingSymbol = "PASSWORD_MISSING_SYMBOL"
+	ReasonContainsUser  = "PASSWORD_CONTAINS_USER_INFO"
+	ReasonTooWeak       = "PASSWORD_TOO_WEAK"
+	ReasonBreached      = "PASSWORD_BREACHED"
+	ReasonReused        = "PASSWORD_REUSED"
+)
+
+const (
+	// shorter user inputs match too many passwords by chance
+	minUserInputLen       = 3
+	maxStrengthEstimation = 256
+)
+
+type PolicyConfig struct {
+	MinLength int `yaml:"min_length" env-required:"true" env:"PASSWORD_MIN_LENGTH"`
+	MaxLength int `yaml:"max_length" env-required:"true" env:"PASSWORD_MAX_LENGTH"`
+
+	RequireLower  bool `yaml:"require_lower" env:"PASSWORD_REQUIRE_LOWER"`
+	RequireUpper  bool `yaml:"require_upper" env:"PASSWORD_REQUIRE_UPPER"`
+	RequireDigit  bool `yaml:"require_digit" env:"PASSWORD_REQUIRE_DIGIT"`
+	RequireSymbol bool `yaml:"require_symbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+
+	// zxcvbn score from 0 (too guessable) to 4 (very unguessable), 0 disables the check
+	MinScore int `yaml:"min_score" env:"PASSWORD_MIN_SCORE"`
+
+	// index of breached passwords built by cmd/pwned, empty disables the check
+	BreachedIndex string `yaml:"breached_index" env:"PASSWORD_BREACHED_INDEX"`
+
+	// number of previous passwords that cannot be reused, the current one never can
+	History int `yaml:"history" env:"PASSWORD_HISTORY"`
+}
+
+// BreachedPasswords is a set of passwords known from data breaches
+type BreachedPasswords interface {
+	Contains(password string) (bool, error)
+}
+
+// Violation is a password policy rule the password breaks
+type Violation struct {
+	Reason      string
+	Description string
+}
+
+type Policy struct {
+	cfg PolicyConfig
+
+	breached BreachedPasswords
+}
+
+// NewPolicy creates a policy, breached may be nil to skip the breach check
+func NewPolicy(cfg PolicyConfig, breached BreachedPasswords) *Policy {
+	return &Policy{
+		cfg:      cfg,
+		breached: breached,
+	}
+}
+
+// Check returns every rule the password breaks. The user inputs are the email, name, etc.
+// that must not be a part of the password
+func (p *Policy) Check(password string, userInputs ...string) ([]Violation, error) {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.cfg.MinLength {
+		violations = append(violations, Violation{
+			Reason:      ReasonTooShort,
+			Description: fmt.Sprintf("password must be at least %d characters long", p.cfg.MinLength),
+		})
+	}
+
+	if p.cfg.MaxLength > 0 && length > p.cfg.MaxLength {
+		violations = append(violations, Violation{
+			Reason:      ReasonTooLong,
+			Description: fmt.Sprintf("password must be at most %d characters long", p.cfg.MaxLength),
+		})
+	}
+
+	classes := []struct {
+		required bool
+		is       func(rune) bool
+		reason   string
+		desc     string
+	}{
+		{p.cfg.RequireLower, unicode.IsLower, ReasonMissingLower, "password must contain a lowercase letter"},
+		{p.cfg.RequireUpper, unicode.IsUpper, ReasonMissingUpper, "password must contain an uppercase letter"},
+		{p.cfg.RequireDigit, unicode.IsDigit, ReasonMissingDigit, "password must contain a digit"},
+		{p.cfg.RequireSymbol, isSymbol, ReasonMissingSymbol, "password must contain a symbol"},
+	}
+
+	for _, class := range classes {
+		if class.required && !strings.ContainsFunc(password, class.is) {
+			violations = append(violations, Violation{
+				Reason:      class.reason,
+				Description: class.desc,
+			})
+		}
+	}
+
+	inputs := userInputTokens(userInputs)
+
+	lower := strings.ToLower(password)
+	for _, input := range inputs {
+		if strings.Contains(lower, input) {
+			violations = append(violations, Violation{
+				Reason:      ReasonContainsUser,
+				Description: "password must not contain your email or name",
+			})
+
+			break
+		}
+	}
+
+	// the estimation gets slow on long inputs, which are strong enough anyway
+	if p.cfg.MinScore > 0 && length <= maxStrengthEstimation {
+		if zxcvbn.PasswordStrength(password, inputs).Score < p.cfg.MinScore {
+			violations = append(violations, Violation{
+				Reason:      ReasonTooWeak,
+				Description: "password is too easy to guess",
+			})
+		}
+	}
+
+	if p.breached != nil {
+		breached, err := p.breached.Contains(password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check breached passwords: %w", err)
+		}
+
+		if breached {
+			violations = append(violations, Violation{
+				Reason:      ReasonBreached,
+				Description: "password appeared in a data breach",
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+// CheckWith checks the password against the policy tightened by the settings of an organization
+func (p *Policy) CheckWith(settings models.PasswordPolicySettings, password string, userInputs ...string) ([]Violation, error) {
+	return p.tighten(settings).Check(password, userInputs...)
+}
+
+// tighten returns the policy with the stricter of its rules and the settings
+func (p *Policy) tighten(settings models.PasswordPolicySettings) *Policy {
+	cfg := p.cfg
+
+	cfg.MinLength = max(cfg.MinLength, settings.MinLength)
+	cfg.MinScore = max(cfg.MinScore, settings.MinScore)
+	cfg.History = max(cfg.History, settings.History)
+	cfg.RequireLower = cfg.RequireLower || settings.RequireLower
+	cfg.RequireUpper = cfg.RequireUpper || settings.RequireUpper
+	cfg.RequireDigit = cfg.RequireDigit || settings.RequireDigit
+	cfg.RequireSymbol = cfg.RequireSymbol || settings.RequireSymbol
+
+	return &Policy{
+		cfg:      cfg,
+		breached: p.breached,
+	}
+}
+
+// userInputTokens lowercases the inputs and adds the local part of emails
+func userInputTokens(userInputs []string) []string {
+	var tokens []string
+
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+
+		parts := []string{input}
+		if local, _, ok := strings.Cut(input, "@"); ok {
+			parts = append(parts, local)
+		}
+
+		for _, part := range parts {
+			if utf8.RuneCountInString(part) >= minUserInputLen {
+				tokens = append(tokens, part)
+			}
+		}
+	}
+
+	return tokens
+}
+
+func isSymbol(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r)
+}
@@ -0,0 +1,126 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+)
+
+type breachedPasswords map[string]bool
+
+func (b breachedPasswords) Contains(password string) (bool, error) {
+	return b[password], nil
+}
+
+func TestPolicy(t *testing.T) {
+	policy := NewPolicy(PolicyConfig{
+		MinLength:     10,
+		MaxLength:     64,
+		RequireLower:  true,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		MinScore:      3,
+	}, breachedPasswords{"Tr0ub4dor&3": true})
+
+	tests := []struct {
+		name     string
+		password string
+		reasons  []string
+	}{
+		{
+			name:     "strong password",
+			password: "Tr0ub4dor&3-horse-Staple",
+		},
+		{
+			name:     "short password",
+			password: "aA1!",
+			reasons:  []string{ReasonTooShort, ReasonTooWeak},
+		},
+		{
+			name:     "long password",
+			password: strings.Repeat("aA1!", 20),
+			reasons:  []string{ReasonTooLong},
+		},
+		{
+			name:     "missing classes",
+			password: "correcthorsebatterystaple",
+			reasons:  []string{ReasonMissingUpper, ReasonMissingDigit, ReasonMissingSymbol},
+		},
+		{
+			name:     "contains email",
+			password: "John.Doe-Zq7$kV9w",
+			reasons:  []string{ReasonContainsUser},
+		},
+		{
+			name:     "breached password",
+			password: "Tr0ub4dor&3",
+			reasons:  []string{ReasonBreached},
+		},
+		{
+			name:     "common password",
+			password: "Password123!",
+			reasons:  []string{ReasonTooWeak},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			violations, err := policy.Check(test.password, "john.doe@example.com", "John", "Doe")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var reasons []string
+			for _, violation := range violations {
+				reasons = append(reasons, violation.Reason)
+			}
+
+			if strings.Join(reasons, ",") != strings.Join(test.reasons, ",") {
+				t.Errorf("unexpected violations: %v, expected %v", reasons, test.reasons)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckWith(t *testing.T) {
+	policy := NewPolicy(PolicyConfig{
+		MinLength:    8,
+		MaxLength:    64,
+		RequireLower: true,
+	}, nil)
+
+	settings := models.PasswordPolicySettings{
+		MinLength:    12,
+		RequireDigit: true,
+	}
+
+	violations, err := policy.CheckWith(settings, "correcthorse")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(violations) != 1 || violations[0].Reason != ReasonMissingDigit {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+
+	violations, err = policy.CheckWith(settings, "c0rrect")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(violations) != 1 || violations[0].Reason != ReasonTooShort {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+
+	// the settings cannot relax the policy
+	violations, err = policy.CheckWith(models.PasswordPolicySettings{MinLength: 4}, "C0RRECT")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(violations) != 2 {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+}
@@ -0,0 +1,219 @@
+// Package pwned looks passwords up in a local index of breached password SHA-1 hashes.
+//
+// The index is a sorted list of hashes bucketed by their first two bytes:
+//
+//	records  | 18 bytes per hash, the hash without the bucket prefix, sorted
+//	buckets  | 65537 little endian uint64, the index of the first record of every bucket
+//	magic    | 8 bytes
+//
+// A lookup is a binary search over the records of one bucket.
+package pwned
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	magic = "PWNDIDX1"
+
+	bucketCount = 1 << 16
+	prefixLen   = 2
+	recordLen   = sha1.Size - prefixLen
+	bucketsLen  = (bucketCount + 1) * 8
+	footerLen   = bucketsLen + len(magic)
+)
+
+var (
+	ErrInvalidIndex = errors.New("invalid index")
+	ErrNotSorted    = errors.New("hashes are not sorted")
+	ErrInvalidHash  = errors.New("invalid hash")
+)
+
+type Index struct {
+	r       io.ReaderAt
+	closer  io.Closer
+	buckets []uint64
+}
+
+// Open opens the index file. The records stay on disk and are read on lookups
+func Open(path string) (*Index, error) {
+	const op = "pwned.Open"
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	index, err := NewIndex(f, stat.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	index.closer = f
+
+	return index, nil
+}
+
+func NewIndex(r io.ReaderAt, size int64) (*Index, error) {
+	if size < int64(footerLen) {
+		return nil, ErrInvalidIndex
+	}
+
+	footer := make([]byte, footerLen)
+	if _, err := r.ReadAt(footer, size-int64(footerLen)); err != nil {
+		return nil, err
+	}
+
+	if string(footer[bucketsLen:]) != magic {
+		return nil, ErrInvalidIndex
+	}
+
+	buckets := make([]uint64, bucketCount+1)
+	for i := range buckets {
+		buckets[i] = binary.LittleEndian.Uint64(footer[i*8:])
+	}
+
+	if int64(buckets[bucketCount])*recordLen != size-int64(footerLen) {
+		return nil, ErrInvalidIndex
+	}
+
+	return &Index{
+		r:       r,
+		buckets: buckets,
+	}, nil
+}
+
+// Contains reports whether the password is in the index
+func (i *Index) Contains(password string) (bool, error) {
+	return i.ContainsHash(sha1.Sum([]byte(password)))
+}
+
+func (i *Index) ContainsHash(hash [sha1.Size]byte) (bool, error) {
+	bucket := int(binary.BigEndian.Uint16(hash[:prefixLen]))
+	from, to := i.buckets[bucket], i.buckets[bucket+1]
+	suffix := hash[prefixLen:]
+
+	record := make([]byte, recordLen)
+
+	var readErr error
+	n := sort.Search(int(to-from), func(j int) bool {
+		if readErr != nil {
+			return true
+		}
+
+		if _, err := i.r.ReadAt(record, int64(from+uint64(j))*recordLen); err != nil {
+			readErr = err
+			return true
+		}
+
+		return bytes.Compare(record, suffix) >= 0
+	})
+
+	if readErr != nil {
+		return false, readErr
+	}
+
+	if n == int(to-from) {
+		return false, nil
+	}
+
+	// record holds the last record the search read, not necessarily the one at n
+	if _, err := i.r.ReadAt(record, int64(from+uint64(n))*recordLen); err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(record, suffix), nil
+}
+
+func (i *Index) Close() error {
+	if i.closer == nil {
+		return nil
+	}
+
+	return i.closer.Close()
+}
+
+// Writer builds an index from hashes added in ascending order
+type Writer struct {
+	w       io.Writer
+	buckets []uint64
+	count   uint64
+	last    [sha1.Size]byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:       w,
+		buckets: make([]uint64, bucketCount+1),
+	}
+}
+
+// Add writes the hash. Duplicates are skipped, hashes out of order return ErrNotSorted
+func (w *Writer) Add(hash [sha1.Size]byte) error {
+	if w.count > 0 {
+		switch bytes.Compare(hash[:], w.last[:]) {
+		case 0:
+			return nil
+		case -1:
+			return ErrNotSorted
+		}
+	}
+
+	if _, err := w.w.Write(hash[prefixLen:]); err != nil {
+		return err
+	}
+
+	w.buckets[int(binary.BigEndian.Uint16(hash[:prefixLen]))+1]++
+	w.count++
+	w.last = hash
+
+	return nil
+}
+
+// Close writes the bucket table. It does not close the underlying writer
+func (w *Writer) Close() error {
+	footer := make([]byte, footerLen)
+
+	var offset uint64
+	for i := range w.buckets {
+		offset += w.buckets[i]
+		binary.LittleEndian.PutUint64(footer[i*8:], offset)
+	}
+
+	copy(footer[bucketsLen:], magic)
+
+	_, err := w.w.Write(footer)
+	return err
+}
+
+// ParseHash parses a line of a HIBP hash list, "<sha1 hex>" optionally followed by ":<count>"
+func ParseHash(line string) ([sha1.Size]byte, error) {
+	var hash [sha1.Size]byte
+
+	line, _, _ = strings.Cut(strings.TrimSpace(line), ":")
+	if len(line) != hex.EncodedLen(sha1.Size) {
+		return hash, ErrInvalidHash
+	}
+
+	if _, err := hex.Decode(hash[:], []byte(line)); err != nil {
+		return hash, ErrInvalidHash
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,162 @@
+package pwned
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func buildIndex(t *testing.T, passwords ...string) *Index {
+	t.Helper()
+
+	hashes := make([][sha1.Size]byte, 0, len(passwords))
+	for _, password := range passwords {
+		hashes = append(hashes, sha1.Sum([]byte(password)))
+	}
+
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	for _, hash := range hashes {
+		if err := w.Add(hash); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	index, err := NewIndex(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return index
+}
+
+func TestIndex(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein", "password", "iloveyou"}
+
+	// the last bucket
+	for i := 0; ; i++ {
+		password := fmt.Sprintf("password%d", i)
+		if hash := sha1.Sum([]byte(password)); hash[0] == 0xff && hash[1] == 0xff {
+			breached = append(breached, password)
+			break
+		}
+	}
+
+	index := buildIndex(t, breached...)
+
+	for _, password := range breached {
+		found, err := index.Contains(password)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !found {
+			t.Errorf("%q is not found", password)
+		}
+	}
+
+	for _, password := range []string{"Password", "correct horse battery staple", ""} {
+		found, err := index.Contains(password)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if found {
+			t.Errorf("%q is found", password)
+		}
+	}
+}
+
+func TestDenseBucket(t *testing.T) {
+	var breached []string
+
+	// every hash is in the bucket of sha1("password0")
+	first := sha1.Sum([]byte("password0"))
+	for i := 0; len(breached) < 64; i++ {
+		password := fmt.Sprintf("password%d", i)
+		if hash := sha1.Sum([]byte(password)); hash[0] == first[0] && hash[1] == first[1] {
+			breached = append(breached, password)
+		}
+	}
+
+	index := buildIndex(t, breached...)
+
+	for _, password := range breached {
+		found, err := index.Contains(password)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !found {
+			t.Errorf("%q is not found", password)
+		}
+	}
+}
+
+func TestEmptyIndex(t *testing.T) {
+	index := buildIndex(t)
+
+	found, err := index.Contains("password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if found {
+		t.Errorf("password is found in an empty index")
+	}
+}
+
+func TestWriterNotSorted(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+
+	if err := w.Add(sha1.Sum([]byte("b"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// sha1("a") < sha1("b")
+	if err := w.Add(sha1.Sum([]byte("a"))); !errors.Is(err, ErrNotSorted) {
+		t.Errorf("expected not sorted, got %v", err)
+	}
+}
+
+func TestInvalidIndex(t *testing.T) {
+	data := make([]byte, footerLen)
+
+	if _, err := NewIndex(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("expected invalid index, got %v", err)
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	expected := sha1.Sum([]byte("password"))
+
+	for _, line := range []string{
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8",
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:10434004\r\n",
+	} {
+		hash, err := ParseHash(line)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if hash != expected {
+			t.Errorf("unexpected hash of %q", line)
+		}
+	}
+
+	if _, err := ParseHash("5BAA61E4C9B93F3F0682250B6CF8331B7EE68F:1"); !errors.Is(err, ErrInvalidHash) {
+		t.Errorf("expected invalid hash, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// test vectors from RFC 6238 appendix B, SHA1 mode
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		code, err := Code(secret, Step(time.Unix(test.time, 0)))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if code != test.code {
+			t.Errorf("unexpected code at %d: %s, want %s", test.time, code, test.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now()
+
+	code, err := Code(secret, Step(now.Add(-Period*time.Second)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	step, err := Validate(secret, code, now)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if step != Step(now)-1 {
+		t.Errorf("unexpected step: %d", step)
+	}
+
+	code, err = Code(secret, Step(now.Add(-2*Period*time.Second)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := Validate(secret, code, now); err != ErrInvalidCode {
+		t.Errorf("expected invalid code, got: %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Impersonation is an entry of the audit trail of admins acting as users,
+// Id is the jti of the access token issued to the admin
+type Impersonation struct {
+	Id        uuid.UUID
+	ActorId   uuid.UUID
+	UserId    uuid.UUID
+	Reason    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invite is a pending invitation. The invited user exists without a password
+// and cannot log in until the invite is accepted
+type Invite struct {
+	UserId         uuid.UUID
+	OrganizationId uuid.UUID
+	Email          string
+	Name           string
+	Surname        string
+	// uuid.Nil if the user who sent the invite was deleted
+	InvitedBy uuid.UUID
+	// hash of the current invite token, resending the invite replaces it
+	TokenHash []byte
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
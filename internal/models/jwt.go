@@ -1,6 +1,31 @@
 package models
 
+import "time"
+
 type JWTokens struct {
 	AccessToken  string
 	RefreshToken string
 }
+
+type LoginResult struct {
+	Tokens JWTokens
+
+	// set instead of Tokens when the user has to pass the second factor
+	MFAChallengeToken string
+}
+
+// TokenIntrospection is the state of a token (RFC 7662), only Active is set for inactive tokens
+type TokenIntrospection struct {
+	Active    bool
+	TokenType string
+	Subject   string
+	ClientId  string
+	Audience  []string
+	Scope     string
+	JTI       string
+	ExpiresAt time.Time
+	// organization of the user and permissions of the token, for the services
+	// verifying personal access tokens
+	OrganizationId string
+	Permissions    []string
+}
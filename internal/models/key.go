@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+type SigningKey struct {
+	Id         string
+	PrivateKey string // PEM encoded
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
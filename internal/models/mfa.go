@@ -0,0 +1,15 @@
+package models
+
+import "github.com/google/uuid"
+
+type TOTP struct {
+	UserId       uuid.UUID
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
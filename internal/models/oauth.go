@@ -0,0 +1,116 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuthClient is an application that users log in to through the authorization server
+type OAuthClient struct {
+	Id   string
+	Name string
+	// SecretHash is empty for public clients, which cannot keep a secret
+	SecretHash   []byte
+	RedirectURIs []string
+	GrantTypes   []string
+	Scopes       []string
+	// AccessTokenTTL overrides the default lifetime of access tokens issued to the client if set
+	AccessTokenTTL time.Duration
+	Disabled       bool
+	CreatedAt      time.Time
+}
+
+// Public reports whether the client has no secret to authenticate with
+func (c OAuthClient) Public() bool {
+	return len(c.SecretHash) == 0
+}
+
+// AuthorizationRequest is the request of the authorization endpoint (RFC 6749 section 4.1.1)
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// AuthorizationGrant is what an authorization code stands for
+type AuthorizationGrant struct {
+	ClientId      string    `json:"client_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	UserId        uuid.UUID `json:"user_id"`
+	Scope         string    `json:"scope"`
+	CodeChallenge string    `json:"code_challenge"`
+	Nonce         string    `json:"nonce,omitempty"`
+	AuthTime      time.Time `json:"auth_time"`
+}
+
+// TokenRequest is the request of the token endpoint (RFC 6749 sections 4.1.3, 4.4.2 and 6, RFC 8628 section 3.4)
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientId     string
+	ClientSecret string
+	CodeVerifier string
+	// Scope is requested by the client credentials grant (RFC 6749 section 4.4.2)
+	Scope        string
+	DeviceCode   string
+	RefreshToken string
+	// private_key_jwt client authentication of service accounts (RFC 7523 section 2.2)
+	ClientAssertionType string
+	ClientAssertion     string
+}
+
+// DeviceAuthorization is a pending device authorization request (RFC 8628), approved by the user
+// who entered the user code
+type DeviceAuthorization struct {
+	ClientId   string
+	Scope      string
+	UserId     uuid.UUID
+	Approved   bool
+	ApprovedAt time.Time
+}
+
+// DeviceCode is the response of the device authorization endpoint (RFC 8628 section 3.2)
+type DeviceCode struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               time.Duration
+	Interval                time.Duration
+}
+
+type OAuthTokens struct {
+	AccessToken  string
+	RefreshToken string
+	// IdToken is issued only for the openid scope
+	IdToken   string
+	ExpiresIn time.Duration
+	Scope     string
+}
+
+// OpenIDConfiguration is the OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Organization is a tenant. Users that are not members of an organization
+// belong to no tenant
+type Organization struct {
+	Id   uuid.UUID
+	Slug string
+	Name string
+	// emails are unique within the organization instead of across sso,
+	// fixed when the organization is created
+	ScopedEmails bool
+	Settings     OrganizationSettings
+	CreatedAt    time.Time
+}
+
+// AnyOrganization scopes a lookup of users to every organization and to the users outside of any,
+// for the callers that manage sso across the organizations
+var AnyOrganization = uuid.Max
+
+// EmailScope is the scope the emails of the users of the organization are unique in
+func (o Organization) EmailScope() uuid.UUID {
+	if o.ScopedEmails {
+		return o.Id
+	}
+
+	return uuid.Nil
+}
+
+// OrganizationSettings override the settings of sso for the members of the organization,
+// zero values keep them
+type OrganizationSettings struct {
+	AccessTokenTTL  time.Duration `json:"access_token_ttl,omitempty"`
+	RefreshTokenTTL time.Duration `json:"refresh_token_ttl,omitempty"`
+	// anyone may register in the organization, otherwise users are added by its admins
+	OpenRegistration bool                   `json:"open_registration,omitempty"`
+	PasswordPolicy   PasswordPolicySettings `json:"password_policy"`
+}
+
+// PasswordPolicySettings tighten the password policy of sso, they cannot relax it
+type PasswordPolicySettings struct {
+	MinLength     int  `json:"min_length,omitempty"`
+	MinScore      int  `json:"min_score,omitempty"`
+	History       int  `json:"history,omitempty"`
+	RequireLower  bool `json:"require_lower,omitempty"`
+	RequireUpper  bool `json:"require_upper,omitempty"`
+	RequireDigit  bool `json:"require_digit,omitempty"`
+	RequireSymbol bool `json:"require_symbol,omitempty"`
+}
+
+// Member is a user of an organization with the roles they have in it
+type Member struct {
+	OrganizationId uuid.UUID
+	UserId         uuid.UUID
+	Roles          []string
+	CreatedAt      time.Time
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Passkey is a webauthn credential registered by a user
+type Passkey struct {
+	Id              []byte
+	UserId          uuid.UUID
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	AAGUID          []byte
+	SignCount       uint32
+	CloneWarning    bool
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      time.Time
+}
+
+// PasskeyCeremony is sent to the client to start a registration or a login.
+// Options are the json options for navigator.credentials
+type PasskeyCeremony struct {
+	Token   string
+	Options []byte
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PersonalAccessToken is a long-lived token a user creates for scripts. Only the hash
+// of the token is stored, the token itself is shown once when it is created
+type PersonalAccessToken struct {
+	Id        uuid.UUID
+	UserId    uuid.UUID
+	Name      string
+	TokenHash []byte
+	// permissions the token is limited to, the user must still have them
+	Scopes []string
+	// zero if the token does not expire
+	ExpiresAt time.Time
+	// zero if the token was never used
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+}
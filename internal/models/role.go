@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Role is a named set of permissions granted to the users it is assigned to
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+	CreatedAt   time.Time
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"crypto/ecdsa"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ServiceAccount is a principal of an internal service. It has no password or email,
+// it authenticates with assertions signed by the private key of PublicKey (RFC 7523)
+type ServiceAccount struct {
+	Id        uuid.UUID
+	Name      string
+	PublicKey *ecdsa.PublicKey
+	// permissions of the tokens issued to the account
+	Permissions []string
+	Disabled    bool
+	CreatedAt   time.Time
+}
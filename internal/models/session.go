@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// RefreshToken is an active refresh token, one that was not rotated yet.
+// ClientId and Scope are set for the sessions opened by an OAuth grant, the tokens
+// are refreshed with the same client and scope
+type RefreshToken struct {
+	UserId    uuid.UUID
+	SessionId string
+	ClientId  string
+	Scope     string
+	ExpiresAt time.Time
+}
+
+type Session struct {
+	Id     string
+	UserId uuid.UUID
+	Device
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
@@ -17,4 +17,6 @@ type UserAuth struct {
 type User struct {
 	UserInfo
 	UserAuth
+	// uuid.Nil if the user is not a member of an organization
+	OrganizationId uuid.UUID
 }
@@ -5,35 +5,46 @@ import (
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/hesoyamTM/apphelper-sso/internal/clients/redpanda"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/device"
 	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/password"
 	"github.com/hesoyamTM/apphelper-sso/internal/models"
 	"github.com/hesoyamTM/apphelper-sso/internal/services"
 	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
 	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
 	"go.uber.org/zap"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
+const maxMFAAttempts = 5
+
+// UserStorage scopes the queries by the ids of callers to their organization,
+// ProvideUserById is only used with ids sso issued itself
 type UserStorage interface {
-	CrateUser(ctx context.Context, name, surname, email string, passHash []byte) (uuid.UUID, error)
+	CrateUser(ctx context.Context, organizationId, emailScope uuid.UUID, name, surname, email string, passHash []byte) (uuid.UUID, error)
 	ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error)
-	ProvideUserByEmail(ctx context.Context, email string) (models.User, error)
-	ProvideUsersById(ctx context.Context, ids []uuid.UUID) ([]models.User, error)
-	UpdateUser(ctx context.Context, user models.UserInfo) error
-	ChangePassword(ctx context.Context, email string, newPassword []byte) error
-	DeleteUser(ctx context.Context, id uuid.UUID) error
+	ProvideUserByEmail(ctx context.Context, emailScope uuid.UUID, email string) (models.User, error)
+	ProvideUsersById(ctx context.Context, organizationId uuid.UUID, ids []uuid.UUID) ([]models.User, error)
+	UpdateUser(ctx context.Context, organizationId uuid.UUID, user models.UserInfo) error
+	ChangePassword(ctx context.Context, userId uuid.UUID, newPassword []byte) error
+	ChangePasswordWithHistory(ctx context.Context, userId uuid.UUID, passHash []byte, historySize int) error
+	ProvidePasswordHistory(ctx context.Context, userId uuid.UUID, limit int) ([][]byte, error)
+	DeleteUser(ctx context.Context, organizationId, id uuid.UUID) error
 }
 
 type SessionsStorage interface {
-	CreateSession(ctx context.Context, userId uuid.UUID, refreshToken string, expiration time.Duration) error
-	UpdateSession(ctx context.Context, oldRefreshToken, newRefreshToken string, expiration time.Duration) error
-	ProvideUser(ctx context.Context, refreshToken string) (uuid.UUID, error) //returns user id
+	CreateSession(ctx context.Context, token models.RefreshToken, refreshToken string, device models.Device, expiration time.Duration) error
+	UpdateSession(ctx context.Context, oldRefreshToken, newRefreshToken string, device models.Device, expiration time.Duration) error
+	ProvideSession(ctx context.Context, refreshToken string) (models.RefreshToken, error)
 	DeleteSession(ctx context.Context, refreshToken string) error
+	ListSessions(ctx context.Context, userId uuid.UUID) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userId uuid.UUID, sessionId string) error
+	RevokeAllSessions(ctx context.Context, userId uuid.UUID) error
 }
 
 type CodeStorage interface {
@@ -48,10 +59,58 @@ type TokenStorage interface {
 	DeleteChangePasswordToken(ctx context.Context, email string) error
 }
 
+type MFAChallengeStorage interface {
+	CreateMFAChallenge(ctx context.Context, challengeToken string, userId uuid.UUID, ttl time.Duration) error
+	ProvideMFAChallenge(ctx context.Context, challengeToken string) (uuid.UUID, error)
+	IncrMFAChallengeAttempts(ctx context.Context, challengeToken string) (int64, error)
+	DeleteMFAChallenge(ctx context.Context, challengeToken string) error
+}
+
+type MFA interface {
+	Enabled(ctx context.Context, userId uuid.UUID) (bool, error)
+	Verify(ctx context.Context, userId uuid.UUID, code string) error
+}
+
+type Passkeys interface {
+	FinishLogin(ctx context.Context, ceremonyToken string, response []byte) (uuid.UUID, error)
+}
+
+type Lockout interface {
+	Check(ctx context.Context, email, ip string) error
+	Fail(ctx context.Context, email, ip string) (time.Time, error)
+	Reset(ctx context.Context, email string) error
+}
+
+type RoleProvider interface {
+	UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+	MemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+}
+
+type OrganizationProvider interface {
+	Get(ctx context.Context, id uuid.UUID) (models.Organization, error)
+}
+
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	Verify(hash []byte, password string) error
+	NeedsRehash(hash []byte) bool
+}
+
+type PasswordPolicy interface {
+	CheckWith(settings models.PasswordPolicySettings, password string, userInputs ...string) ([]password.Violation, error)
+}
+
+type KeyProvider interface {
+	SigningKey() *ecdsa.PrivateKey
+	PublicKeys(ctx context.Context) (jwks.JWKS, error)
+}
+
 type RedpandaClient interface {
 	UserRegistered(ctx context.Context, user *redpanda.UserRegisteredEvent) error
 	PasswordChanged(ctx context.Context, user *redpanda.UserRegisteredEvent) error
 	VerificationCodeUpdated(ctx context.Context, user *redpanda.VerificationCodeUpdatedEvent) error
+	RefreshTokenReused(ctx context.Context, event *redpanda.RefreshTokenReusedEvent) error
+	AccountLocked(ctx context.Context, event *redpanda.AccountLockedEvent) error
 }
 
 type Auth struct {
@@ -63,84 +122,165 @@ type Auth struct {
 	sessionsStorage SessionsStorage
 	codeStorage     CodeStorage
 	tokenStorage    TokenStorage
+	mfaChallenges   MFAChallengeStorage
+
+	mfa      MFA
+	passkeys Passkeys
+	lockout  Lockout
+	roles    RoleProvider
+
+	organizations OrganizationProvider
+
+	passwordHasher PasswordHasher
+	passwordPolicy PasswordPolicy
+	// number of previous passwords that cannot be reused
+	passwordHistory int
 
 	accessTokenTTL  time.Duration
 	refreshTokenTTL time.Duration
 	codeTTL         time.Duration
 	tokenTTL        time.Duration
+	mfaChallengeTTL time.Duration
+
+	// iss and aud of the access tokens
+	issuer   string
+	audience string
 
-	privateKey *ecdsa.PrivateKey
-}
-
-func New(ctx context.Context,
-	redpandaClient RedpandaClient,
-	uStorage UserStorage,
-	sStorage SessionsStorage,
-	cStorage CodeStorage,
-	tStorage TokenStorage,
-	accessTokenTTL time.Duration,
-	refreshTokenTTL time.Duration,
-	codeTTL time.Duration,
-	tokenTTL time.Duration,
-	privateKey *ecdsa.PrivateKey,
-) *Auth {
-	authService := &Auth{
+	keyProvider KeyProvider
+}
+
+// Deps are the services and storages Auth depends on
+type Deps struct {
+	RedpandaClient RedpandaClient
+
+	UserStorage     UserStorage
+	SessionsStorage SessionsStorage
+	CodeStorage     CodeStorage
+	TokenStorage    TokenStorage
+	MFAChallenges   MFAChallengeStorage
+
+	MFA      MFA
+	Passkeys Passkeys
+	Lockout  Lockout
+	Roles    RoleProvider
+
+	Organizations OrganizationProvider
+
+	PasswordHasher PasswordHasher
+	PasswordPolicy PasswordPolicy
+
+	KeyProvider KeyProvider
+}
+
+// Config are the settings of Auth
+type Config struct {
+	// number of previous passwords that cannot be reused
+	PasswordHistory int
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	CodeTTL         time.Duration
+	TokenTTL        time.Duration
+	MFAChallengeTTL time.Duration
+
+	// iss and aud of the access tokens
+	Issuer   string
+	Audience string
+}
+
+func New(ctx context.Context, deps Deps, cfg Config) *Auth {
+	return &Auth{
 		log: logger.GetLoggerFromCtx(ctx),
 
-		redpandaClient: redpandaClient,
+		redpandaClient: deps.RedpandaClient,
 
-		userStorage:     uStorage,
-		sessionsStorage: sStorage,
-		codeStorage:     cStorage,
-		tokenStorage:    tStorage,
+		userStorage:     deps.UserStorage,
+		sessionsStorage: deps.SessionsStorage,
+		codeStorage:     deps.CodeStorage,
+		tokenStorage:    deps.TokenStorage,
+		mfaChallenges:   deps.MFAChallenges,
 
-		accessTokenTTL:  accessTokenTTL,
-		refreshTokenTTL: refreshTokenTTL,
-		codeTTL:         codeTTL,
-		tokenTTL:        tokenTTL,
+		mfa:      deps.MFA,
+		passkeys: deps.Passkeys,
+		lockout:  deps.Lockout,
+		roles:    deps.Roles,
 
-		privateKey: privateKey,
-	}
+		organizations: deps.Organizations,
+
+		passwordHasher:  deps.PasswordHasher,
+		passwordPolicy:  deps.PasswordPolicy,
+		passwordHistory: cfg.PasswordHistory,
+
+		accessTokenTTL:  cfg.AccessTokenTTL,
+		refreshTokenTTL: cfg.RefreshTokenTTL,
+		codeTTL:         cfg.CodeTTL,
+		tokenTTL:        cfg.TokenTTL,
+		mfaChallengeTTL: cfg.MFAChallengeTTL,
 
-	return authService
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+
+		keyProvider: deps.KeyProvider,
+	}
 }
 
-func (a *Auth) Register(ctx context.Context, name, surname, email, password string) (models.JWTokens, error) {
+// Register creates a user in the organization, uuid.Nil registers the user outside of any
+func (a *Auth) Register(ctx context.Context, organizationId uuid.UUID, name, surname, email, password string) (models.JWTokens, error) {
 	const op = "auth.Register"
 	log := logger.GetLoggerFromCtx(ctx)
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	org, err := a.organization(ctx, organizationId)
+	if err != nil {
+		log.Error(ctx, "failed to provide organization", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if organizationId != uuid.Nil && !org.Settings.OpenRegistration {
+		log.Error(ctx, "registration is closed", zap.String("organization_id", organizationId.String()))
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrRegistrationClosed)
+	}
+
+	if err := a.checkPasswordPolicy(org.Settings.PasswordPolicy, password, email, name, surname); err != nil {
+		log.Error(ctx, "password does not meet the policy", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.passwordHasher.Hash(password)
 	if err != nil {
 		log.Error(ctx, "failed to generate hash from password", zap.Error(err))
 
 		return models.JWTokens{}, fmt.Errorf("failed to generate hash: %w", err)
 	}
 
-	userId, err := a.userStorage.CrateUser(ctx, name, surname, email, passHash)
+	userId, err := a.userStorage.CrateUser(ctx, org.Id, org.EmailScope(), name, surname, email, passHash)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserExists) {
 			log.Error(ctx, "user already exists", zap.Error(err))
 			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrUserAlreadyExists)
 		}
+		if errors.Is(err, storage.ErrOrganizationNotFound) {
+			log.Error(ctx, "organization not found", zap.Error(err))
+			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrOrganizationNotFound)
+		}
 
 		log.Error(ctx, "failed to create user", zap.Error(err))
 		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	user := models.UserInfo{
-		Id:      userId,
-		Name:    name,
-		Surname: surname,
+	user := models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    name,
+			Surname: surname,
+		},
+		OrganizationId: org.Id,
 	}
 
-	tokens, err := jwt.NewTokens(user, a.accessTokenTTL, a.privateKey)
+	tokens, err := a.newSession(ctx, user, "", "", 0)
 	if err != nil {
-		log.Error(ctx, "failed to generate tokens", zap.Error(err))
-
-		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
-	}
-
-	if err = a.sessionsStorage.CreateSession(ctx, userId, tokens.RefreshToken, a.refreshTokenTTL); err != nil {
 		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -163,12 +303,215 @@ func (a *Auth) Register(ctx context.Context, name, surname, email, password stri
 	return tokens, nil
 }
 
-func (a *Auth) Login(ctx context.Context, email, password string) (models.JWTokens, error) {
+// Login authorizes a user of the organization, uuid.Nil for users outside of any.
+// Organizations that scope the emails need to be named, in the others the email is enough
+func (a *Auth) Login(ctx context.Context, organizationId uuid.UUID, email, password string) (models.LoginResult, error) {
 	const op = "auth.Login"
 	log := logger.GetLoggerFromCtx(ctx) //a.log.With(slog.String("login", login), slog.String("op", op))
 	log.Info(ctx, "authorize user")
 
-	user, err := a.userStorage.ProvideUserByEmail(ctx, email)
+	ip := device.FromContext(ctx).IP
+
+	if err := a.lockout.Check(ctx, email, ip); err != nil {
+		log.Error(ctx, "login is locked", zap.Error(err))
+
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	org, err := a.organization(ctx, organizationId)
+	if err != nil {
+		log.Error(ctx, "failed to provide organization", zap.Error(err))
+
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userStorage.ProvideUserByEmail(ctx, org.EmailScope(), email)
+	if err == nil && organizationId != uuid.Nil && user.OrganizationId != organizationId {
+		// the email belongs to a user of another organization
+		err = storage.ErrUserNotFound
+	}
+	if err != nil {
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			// unknown emails are counted as well, so guessing them is throttled by ip
+			if err := a.loginFailed(ctx, email, ip, models.User{}); err != nil {
+				return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+			}
+
+			return models.LoginResult{}, fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+		}
+
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.passwordHasher.Verify(user.PassHash, password); err != nil {
+		log.Error(ctx, "incorrect password", zap.Error(err))
+
+		if err := a.loginFailed(ctx, email, ip, user); err != nil {
+			return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+	}
+
+	if a.passwordHasher.NeedsRehash(user.PassHash) {
+		a.rehashPassword(ctx, user.UserAuth.Id, password)
+	}
+
+	mfaEnabled, err := a.mfa.Enabled(ctx, user.UserAuth.Id)
+	if err != nil {
+		log.Error(ctx, "failed to check mfa", zap.Error(err))
+
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the failures are kept until the second factor passes, see LoginMFA
+	if mfaEnabled {
+		challengeToken := uuid.NewString()
+
+		if err := a.mfaChallenges.CreateMFAChallenge(ctx, challengeToken, user.UserAuth.Id, a.mfaChallengeTTL); err != nil {
+			log.Error(ctx, "failed to create mfa challenge", zap.Error(err))
+
+			return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return models.LoginResult{MFAChallengeToken: challengeToken}, nil
+	}
+
+	if err := a.lockout.Reset(ctx, email); err != nil {
+		log.Error(ctx, "failed to reset login failures", zap.Error(err))
+
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.newSession(ctx, user, "", "", 0)
+	if err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.LoginResult{Tokens: tokens}, nil
+}
+
+// checkPasswordPolicy returns services.PasswordPolicyError if the password breaks the policy
+// tightened by the settings of an organization
+func (a *Auth) checkPasswordPolicy(settings models.PasswordPolicySettings, password string, userInputs ...string) error {
+	violations, err := a.passwordPolicy.CheckWith(settings, password, userInputs...)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return &services.PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// checkPasswordReuse returns services.PasswordPolicyError if the password is the current
+// or one of the historySize previous passwords of the user
+func (a *Auth) checkPasswordReuse(ctx context.Context, user models.User, newPassword string, historySize int) error {
+	hashes := [][]byte{user.UserAuth.PassHash}
+
+	if historySize > 0 {
+		history, err := a.userStorage.ProvidePasswordHistory(ctx, user.UserAuth.Id, historySize)
+		if err != nil {
+			return err
+		}
+
+		hashes = append(hashes, history...)
+	}
+
+	for _, hash := range hashes {
+		err := a.passwordHasher.Verify(hash, newPassword)
+		if err == nil {
+			return &services.PasswordPolicyError{Violations: []password.Violation{{
+				Reason:      password.ReasonReused,
+				Description: "password was used recently",
+			}}}
+		}
+
+		if !errors.Is(err, password.ErrMismatchedPassword) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rehashPassword upgrades a hash made by an outdated algorithm or params.
+// The login goes on if it fails, the hash is upgraded on the next one
+func (a *Auth) rehashPassword(ctx context.Context, userId uuid.UUID, password string) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	passHash, err := a.passwordHasher.Hash(password)
+	if err != nil {
+		log.Error(ctx, "failed to rehash password", zap.Error(err))
+
+		return
+	}
+
+	if err := a.userStorage.ChangePassword(ctx, userId, passHash); err != nil {
+		log.Error(ctx, "failed to save rehashed password", zap.Error(err))
+
+		return
+	}
+
+	log.Info(ctx, "password rehashed")
+}
+
+// loginFailed counts the failure and returns services.ErrAccountLocked if it locks the account
+func (a *Auth) loginFailed(ctx context.Context, email, ip string, user models.User) error {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	lockedUntil, err := a.lockout.Fail(ctx, email, ip)
+	if err != nil {
+		log.Error(ctx, "failed to count login failure", zap.Error(err))
+
+		return err
+	}
+
+	if lockedUntil.IsZero() {
+		return nil
+	}
+
+	log.Error(ctx, "account locked", zap.String("ip", ip), zap.Time("locked_until", lockedUntil))
+
+	// the owner is warned only about existing accounts
+	if user.UserAuth.Id != uuid.Nil {
+		event := &redpanda.AccountLockedEvent{
+			UserID:      user.UserAuth.Id.String(),
+			Email:       email,
+			IP:          ip,
+			LockedUntil: lockedUntil,
+		}
+
+		if err := a.redpandaClient.AccountLocked(ctx, event); err != nil {
+			log.Error(ctx, "failed to send account locked event", zap.Error(err))
+		}
+	}
+
+	return services.ErrAccountLocked
+}
+
+// LoginMFA completes the login started by Login with a totp or recovery code.
+// Wrong codes count as failed logins of the account like wrong passwords
+func (a *Auth) LoginMFA(ctx context.Context, challengeToken, code string) (models.JWTokens, error) {
+	const op = "auth.LoginMFA"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	userId, err := a.mfaChallenges.ProvideMFAChallenge(ctx, challengeToken)
+	if err != nil {
+		log.Error(ctx, "failed to provide mfa challenge", zap.Error(err))
+
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrNotAuthorized)
+		}
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userStorage.ProvideUserById(ctx, userId)
 	if err != nil {
 		log.Error(ctx, "failed to provide user", zap.Error(err))
 
@@ -179,22 +522,223 @@ func (a *Auth) Login(ctx context.Context, email, password string) (models.JWToke
 		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		log.Error(ctx, "incorrect password", zap.Error(err))
+	ip := device.FromContext(ctx).IP
+
+	if err := a.lockout.Check(ctx, user.Email, ip); err != nil {
+		log.Error(ctx, "login is locked", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfa.Verify(ctx, userId, code); err != nil {
+		log.Error(ctx, "failed to verify mfa code", zap.Error(err))
+
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			attempts, err := a.mfaChallenges.IncrMFAChallengeAttempts(ctx, challengeToken)
+			if err == nil && attempts >= maxMFAAttempts {
+				// the user has to start over with the password
+				err = a.mfaChallenges.DeleteMFAChallenge(ctx, challengeToken)
+			}
+			if err != nil {
+				log.Error(ctx, "failed to count mfa attempt", zap.Error(err))
+			}
+
+			if err := a.loginFailed(ctx, user.Email, ip, user); err != nil {
+				return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfaChallenges.DeleteMFAChallenge(ctx, challengeToken); err != nil {
+		log.Error(ctx, "failed to delete mfa challenge", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.lockout.Reset(ctx, user.Email); err != nil {
+		log.Error(ctx, "failed to reset login failures", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.newSession(ctx, user, "", "", 0)
+	if err != nil {
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// LoginPasskey completes the passkey login started by the passkey service.
+// A passkey verifies the user itself, so the second factor is not asked for
+func (a *Auth) LoginPasskey(ctx context.Context, ceremonyToken string, response []byte) (models.JWTokens, error) {
+	const op = "auth.LoginPasskey"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	userId, err := a.passkeys.FinishLogin(ctx, ceremonyToken, response)
+	if err != nil {
+		log.Error(ctx, "failed to verify passkey", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
 
-		return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+	user, err := a.userStorage.ProvideUserById(ctx, userId)
+	if err != nil {
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+		}
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	tokens, err := jwt.NewTokens(user.UserInfo, a.accessTokenTTL, a.privateKey)
+	tokens, err := a.newSession(ctx, user, "", "", 0)
+	if err != nil {
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// newSession issues a token pair and opens a session for its refresh token.
+// A zero accessTokenTTL keeps the lifetime set for the organization of the user
+func (a *Auth) newSession(ctx context.Context, user models.User, clientId, scope string, accessTokenTTL time.Duration) (models.JWTokens, error) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	orgAccessTokenTTL, refreshTokenTTL, err := a.tokenTTLs(ctx, user.OrganizationId)
+	if err != nil {
+		log.Error(ctx, "failed to provide organization", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("failed to provide organization: %w", err)
+	}
+
+	if accessTokenTTL == 0 {
+		accessTokenTTL = orgAccessTokenTTL
+	}
+
+	accessToken, err := a.accessToken(ctx, user, clientId, scope)
+	if err != nil {
+		log.Error(ctx, "failed to provide user roles", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("failed to provide user roles: %w", err)
+	}
+
+	tokens, err := jwt.NewTokens(accessToken, accessTokenTTL, a.keyProvider.SigningKey())
 	if err != nil {
 		log.Error(ctx, "failed to generate tokens", zap.Error(err))
 
 		return models.JWTokens{}, fmt.Errorf("failed to generate tokens: %w", err)
 	}
 
-	if err = a.sessionsStorage.CreateSession(ctx, user.UserAuth.Id, tokens.RefreshToken, a.refreshTokenTTL); err != nil {
+	session := models.RefreshToken{
+		UserId:   user.UserInfo.Id,
+		ClientId: clientId,
+		Scope:    scope,
+	}
+	if err = a.sessionsStorage.CreateSession(ctx, session, tokens.RefreshToken, device.FromContext(ctx), refreshTokenTTL); err != nil {
 		log.Error(ctx, "failed to create session", zap.Error(err))
 
+		return models.JWTokens{}, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// accessToken is the access token of the user, clientId is empty for tokens issued by Login.
+// Tokens issued to a client are limited by their scope and carry no roles
+func (a *Auth) accessToken(ctx context.Context, user models.User, clientId, scope string) (jwt.AccessToken, error) {
+	accessToken := jwt.AccessToken{
+		Issuer:         a.issuer,
+		Audience:       a.audience,
+		User:           user.UserInfo,
+		OrganizationId: user.OrganizationId,
+		ClientId:       clientId,
+		Scope:          scope,
+	}
+
+	// tokens issued to a client are for the client only, not for the services accepting Login tokens
+	if clientId != "" {
+		accessToken.Audience = clientId
+
+		return accessToken, nil
+	}
+
+	roles, err := a.roles.UserRoles(ctx, user.UserInfo.Id)
+	if err != nil {
+		return jwt.AccessToken{}, err
+	}
+
+	if user.OrganizationId != uuid.Nil {
+		memberRoles, err := a.roles.MemberRoles(ctx, user.UserInfo.Id)
+		if err != nil {
+			return jwt.AccessToken{}, err
+		}
+
+		roles = append(roles, memberRoles...)
+	}
+
+	for _, role := range roles {
+		accessToken.Roles = append(accessToken.Roles, role.Name)
+
+		for _, permission := range role.Permissions {
+			if !slices.Contains(accessToken.Permissions, permission) {
+				accessToken.Permissions = append(accessToken.Permissions, permission)
+			}
+		}
+	}
+
+	return accessToken, nil
+}
+
+// organization returns the organization of the id, the zero organization for uuid.Nil
+func (a *Auth) organization(ctx context.Context, id uuid.UUID) (models.Organization, error) {
+	if id == uuid.Nil {
+		return models.Organization{}, nil
+	}
+
+	return a.organizations.Get(ctx, id)
+}
+
+// tokenTTLs returns the lifetimes of the access and refresh tokens in the organization
+func (a *Auth) tokenTTLs(ctx context.Context, organizationId uuid.UUID) (time.Duration, time.Duration, error) {
+	org, err := a.organization(ctx, organizationId)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	accessTokenTTL, refreshTokenTTL := a.accessTokenTTL, a.refreshTokenTTL
+	if org.Settings.AccessTokenTTL > 0 {
+		accessTokenTTL = org.Settings.AccessTokenTTL
+	}
+	if org.Settings.RefreshTokenTTL > 0 {
+		refreshTokenTTL = org.Settings.RefreshTokenTTL
+	}
+
+	return accessTokenTTL, refreshTokenTTL, nil
+}
+
+// IssueTokens opens a session for a user authorized by an OAuth grant,
+// the access token is issued to the client with the granted scope and the lifetime of the client
+func (a *Auth) IssueTokens(ctx context.Context, userId uuid.UUID, clientId, scope string, accessTokenTTL time.Duration) (models.JWTokens, error) {
+	const op = "auth.IssueTokens"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	user, err := a.userStorage.ProvideUserById(ctx, userId)
+	if err != nil {
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+		}
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.newSession(ctx, user, clientId, scope, accessTokenTTL)
+	if err != nil {
 		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -218,74 +762,142 @@ func (a *Auth) Logout(ctx context.Context, refreshToken string) error {
 	return nil
 }
 
+// RefreshToken rotates a refresh token issued by Login, the refresh tokens
+// of OAuth clients are only refreshed at the token endpoint
 func (a *Auth) RefreshToken(ctx context.Context, refreshToken string) (models.JWTokens, error) {
 	const op = "auth.RefreshToken"
+
+	tokens, _, err := a.refresh(ctx, "", refreshToken, 0)
+	if err != nil {
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// RefreshClientTokens rotates a refresh token issued to the client by an OAuth grant
+// and returns the scope of the grant, the tokens are refreshed with the same scope
+func (a *Auth) RefreshClientTokens(ctx context.Context, clientId, refreshToken string, accessTokenTTL time.Duration) (models.JWTokens, string, error) {
+	const op = "auth.RefreshClientTokens"
+
+	tokens, scope, err := a.refresh(ctx, clientId, refreshToken, accessTokenTTL)
+	if err != nil {
+		return models.JWTokens{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, scope, nil
+}
+
+// refresh rotates a refresh token of a session opened for the client, clientId is empty for Login.
+// A zero accessTokenTTL keeps the lifetime set for the organization of the user
+func (a *Auth) refresh(ctx context.Context, clientId, refreshToken string, accessTokenTTL time.Duration) (models.JWTokens, string, error) {
 	log := logger.GetLoggerFromCtx(ctx) //a.log.With(slog.String("op", op))
 
-	userId, err := a.sessionsStorage.ProvideUser(ctx, refreshToken)
+	session, err := a.sessionsStorage.ProvideSession(ctx, refreshToken)
 	if err != nil {
-		log.Error(ctx, "failed to provide user", zap.Error(err))
+		log.Error(ctx, "failed to provide session", zap.Error(err))
 
 		if errors.Is(err, storage.ErrSessionNotFound) {
-			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrNotAuthorized)
+			return models.JWTokens{}, "", services.ErrNotAuthorized
 		}
 
-		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+		return models.JWTokens{}, "", err
 	}
 
+	// the token is neither rotated nor revoked when presented by someone else than its client
+	if session.ClientId != clientId {
+		log.Info(ctx, "refresh token of another client", zap.String("client_id", clientId))
+
+		return models.JWTokens{}, "", services.ErrNotAuthorized
+	}
+
+	userId := session.UserId
+
 	user, err := a.userStorage.ProvideUserById(ctx, userId)
 	if err != nil {
 		log.Error(ctx, "failed to provide user", zap.Error(err))
 
 		if errors.Is(err, storage.ErrUserNotFound) {
-			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+			return models.JWTokens{}, "", services.ErrUserNotFound
 		}
 
-		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+		return models.JWTokens{}, "", err
 	}
 
-	newTokens, err := jwt.NewTokens(user.UserInfo, a.accessTokenTTL, a.privateKey)
+	orgAccessTokenTTL, refreshTokenTTL, err := a.tokenTTLs(ctx, user.OrganizationId)
+	if err != nil {
+		log.Error(ctx, "failed to provide organization", zap.Error(err))
+
+		return models.JWTokens{}, "", err
+	}
+
+	if accessTokenTTL == 0 {
+		accessTokenTTL = orgAccessTokenTTL
+	}
+
+	accessToken, err := a.accessToken(ctx, user, session.ClientId, session.Scope)
+	if err != nil {
+		log.Error(ctx, "failed to provide user roles", zap.Error(err))
+
+		return models.JWTokens{}, "", err
+	}
+
+	newTokens, err := jwt.NewTokens(accessToken, accessTokenTTL, a.keyProvider.SigningKey())
 	if err != nil {
 		log.Error(ctx, "failed to generate tokens", zap.Error(err))
 
-		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+		return models.JWTokens{}, "", err
 	}
 
-	if err = a.sessionsStorage.UpdateSession(ctx, refreshToken, newTokens.RefreshToken, a.refreshTokenTTL); err != nil {
+	if err = a.sessionsStorage.UpdateSession(ctx, refreshToken, newTokens.RefreshToken, device.FromContext(ctx), refreshTokenTTL); err != nil {
 		log.Error(ctx, "failed to update session", zap.Error(err))
 
+		if errors.Is(err, storage.ErrRefreshTokenReused) {
+			// the storage has already revoked the whole token family
+			log.Error(ctx, "refresh token reuse detected", zap.String("user_id", userId.String()))
+
+			if err := a.redpandaClient.RefreshTokenReused(ctx, &redpanda.RefreshTokenReusedEvent{
+				UserID: userId.String(),
+			}); err != nil {
+				log.Error(ctx, "failed to send refresh token reused event", zap.Error(err))
+			}
+
+			return models.JWTokens{}, "", services.ErrNotAuthorized
+		}
 		if errors.Is(err, storage.ErrSessionNotFound) {
-			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrNotAuthorized)
+			return models.JWTokens{}, "", services.ErrNotAuthorized
 		}
-		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+		return models.JWTokens{}, "", err
 	}
 
-	return newTokens, nil
+	return newTokens, session.Scope, nil
 }
 
-func (a *Auth) GetUser(ctx context.Context, id uuid.UUID) (models.User, error) {
+// GetUser returns a user of the organization, uuid.Nil for users outside of any
+func (a *Auth) GetUser(ctx context.Context, organizationId, id uuid.UUID) (models.User, error) {
 	const op = "auth.GetUser"
 	log := logger.GetLoggerFromCtx(ctx) //a.log.With(slog.String("op", op))
-	_ = log
 
-	user, err := a.userStorage.ProvideUserById(ctx, id)
+	users, err := a.userStorage.ProvideUsersById(ctx, organizationId, []uuid.UUID{id})
 	if err != nil {
 		log.Error(ctx, "failed to provide user", zap.Error(err))
 
-		if errors.Is(err, storage.ErrUserNotFound) {
-			return models.User{}, fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
-		}
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return user, nil
+	if len(users) == 0 {
+		return models.User{}, fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+	}
+
+	return users[0], nil
 }
 
-func (a *Auth) GetUsers(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+// GetUsers returns the users of the organization among the ids, the others are left out
+func (a *Auth) GetUsers(ctx context.Context, organizationId uuid.UUID, ids []uuid.UUID) ([]models.User, error) {
 	const op = "auth.GetUsers"
 	log := logger.GetLoggerFromCtx(ctx) //a.log.With(slog.String("op", op))
 
-	users, err := a.userStorage.ProvideUsersById(ctx, ids)
+	users, err := a.userStorage.ProvideUsersById(ctx, organizationId, ids)
 	if err != nil {
 		log.Error(ctx, "failed to provide user", zap.Error(err))
 
@@ -298,11 +910,11 @@ func (a *Auth) GetUsers(ctx context.Context, ids []uuid.UUID) ([]models.User, er
 	return users, nil
 }
 
-func (a *Auth) UpdateUser(ctx context.Context, user models.UserInfo) error {
+func (a *Auth) UpdateUser(ctx context.Context, organizationId uuid.UUID, user models.UserInfo) error {
 	const op = "auth.UpdateUser"
 	log := logger.GetLoggerFromCtx(ctx)
 
-	if err := a.userStorage.UpdateUser(ctx, user); err != nil {
+	if err := a.userStorage.UpdateUser(ctx, organizationId, user); err != nil {
 		log.Error(ctx, "failed to update user", zap.Error(err))
 
 		if errors.Is(err, storage.ErrUserNotFound) {
@@ -315,11 +927,11 @@ func (a *Auth) UpdateUser(ctx context.Context, user models.UserInfo) error {
 	return nil
 }
 
-func (a *Auth) DeleteUser(ctx context.Context, id uuid.UUID) error {
+func (a *Auth) DeleteUser(ctx context.Context, organizationId, id uuid.UUID) error {
 	const op = "auth.DeleteUser"
 	log := logger.GetLoggerFromCtx(ctx)
 
-	if err := a.userStorage.DeleteUser(ctx, id); err != nil {
+	if err := a.userStorage.DeleteUser(ctx, organizationId, id); err != nil {
 		log.Error(ctx, "failed to delete user", zap.Error(err))
 
 		if errors.Is(err, storage.ErrUserNotFound) {
@@ -329,6 +941,56 @@ func (a *Auth) DeleteUser(ctx context.Context, id uuid.UUID) error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	if err := a.sessionsStorage.RevokeAllSessions(ctx, id); err != nil {
+		log.Error(ctx, "failed to revoke sessions", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *Auth) ListSessions(ctx context.Context, userId uuid.UUID) ([]models.Session, error) {
+	const op = "auth.ListSessions"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	sessions, err := a.sessionsStorage.ListSessions(ctx, userId)
+	if err != nil {
+		log.Error(ctx, "failed to list sessions", zap.Error(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+func (a *Auth) RevokeSession(ctx context.Context, userId uuid.UUID, sessionId string) error {
+	const op = "auth.RevokeSession"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := a.sessionsStorage.RevokeSession(ctx, userId, sessionId); err != nil {
+		log.Error(ctx, "failed to revoke session", zap.Error(err))
+
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrSessionNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *Auth) RevokeAllSessions(ctx context.Context, userId uuid.UUID) error {
+	const op = "auth.RevokeAllSessions"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := a.sessionsStorage.RevokeAllSessions(ctx, userId); err != nil {
+		log.Error(ctx, "failed to revoke sessions", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
 	return nil
 }
 
@@ -375,12 +1037,17 @@ func (s *Auth) VerifyEmail(ctx context.Context, email, code string) error {
 	return nil
 }
 
-func (s *Auth) SendPasswordResetEmail(ctx context.Context, email string) error {
+func (s *Auth) SendPasswordResetEmail(ctx context.Context, organizationId uuid.UUID, email string) error {
 	const op = "auth.SendPasswordResetEmail"
 
+	org, err := s.organization(ctx, organizationId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
 	code := uuid.New().String()
 
-	if err := s.tokenStorage.CreateChangePasswordToken(ctx, email, code, s.tokenTTL); err != nil {
+	if err := s.tokenStorage.CreateChangePasswordToken(ctx, resetTokenKey(org.EmailScope(), email), code, s.tokenTTL); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -398,10 +1065,17 @@ func (s *Auth) SendPasswordResetEmail(ctx context.Context, email string) error {
 	return nil
 }
 
-func (s *Auth) ChangePassword(ctx context.Context, email, newPassword, token string) error {
+func (s *Auth) ChangePassword(ctx context.Context, organizationId uuid.UUID, email, newPassword, token string) error {
 	const op = "auth.ChangePassword"
 
-	tok, err := s.tokenStorage.ProvideChangePasswordToken(ctx, email)
+	org, err := s.organization(ctx, organizationId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokenKey := resetTokenKey(org.EmailScope(), email)
+
+	tok, err := s.tokenStorage.ProvideChangePasswordToken(ctx, tokenKey)
 	if err != nil {
 		if errors.Is(err, storage.ErrChangePasswordTokenNotFound) {
 			return fmt.Errorf("%s: %w", op, services.ErrNotAuthorized)
@@ -414,18 +1088,67 @@ func (s *Auth) ChangePassword(ctx context.Context, email, newPassword, token str
 		return fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
 	}
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	user, err := s.userStorage.ProvideUserByEmail(ctx, org.EmailScope(), email)
+	if err == nil && organizationId != uuid.Nil && user.OrganizationId != organizationId {
+		err = storage.ErrUserNotFound
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the policy is the one of the organization the user is a member of
+	// even if the organization does not scope the emails and is not named
+	org, err = s.organization(ctx, user.OrganizationId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.checkPasswordPolicy(org.Settings.PasswordPolicy, newPassword, email, user.UserInfo.Name, user.UserInfo.Surname); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	historySize := max(s.passwordHistory, org.Settings.PasswordPolicy.History)
+
+	if err := s.checkPasswordReuse(ctx, user, newPassword, historySize); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := s.passwordHasher.Hash(newPassword)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := s.userStorage.ChangePassword(ctx, email, passHash); err != nil {
+	if err := s.userStorage.ChangePasswordWithHistory(ctx, user.UserAuth.Id, passHash, historySize); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// sessions opened with the old password must not outlive it
+	if err := s.sessionsStorage.RevokeAllSessions(ctx, user.UserAuth.Id); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := s.tokenStorage.DeleteChangePasswordToken(ctx, email); err != nil {
+	if err := s.tokenStorage.DeleteChangePasswordToken(ctx, tokenKey); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
+
+// PublicKeys returns the key set used to verify access tokens
+func (a *Auth) PublicKeys(ctx context.Context) (jwks.JWKS, error) {
+	return a.keyProvider.PublicKeys(ctx)
+}
+
+// resetTokenKey keys the password reset tokens by the email in its scope,
+// the same email can belong to users of organizations that scope the emails
+func resetTokenKey(emailScope uuid.UUID, email string) string {
+	if emailScope == uuid.Nil {
+		return email
+	}
+
+	return emailScope.String() + ":" + email
+}
@@ -2,16 +2,41 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/clients/redpanda"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/password"
 	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
 	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
 	"github.com/stretchr/testify/mock"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// cheap params to keep the tests fast
+var testPasswordHasher = password.NewHasher(password.Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+})
+
+var testPasswordPolicy = password.NewPolicy(password.PolicyConfig{
+	MinLength: 8,
+	MaxLength: 64,
+}, nil)
+
+const (
+	testIssuer   = "https://sso.example.com"
+	testAudience = "apphelper"
+)
+
 func TestRegister(t *testing.T) {
 	// Mock setup
 	mockUserStorage := &MockUserStorage{}
@@ -19,9 +44,13 @@ func TestRegister(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
-	mockUserStorage.On("CrateUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(uuid.New(), nil)
-	mockSessionsStorage.On("CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockUserStorage.On("CrateUser", mock.Anything, uuid.Nil, uuid.Nil, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(uuid.New(), nil)
+	mockSessionsStorage.On("CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	mockCodeStorage.On("CreateVerificationCode", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	mockRedpandaClient.On("UserRegistered", mock.Anything, mock.Anything).Return(nil)
 
@@ -41,19 +70,31 @@ func TestRegister(t *testing.T) {
 	tokenStorage := mockTokenStorage
 	redpandaClient := mockRedpandaClient
 
-	authService := New(
-		ctx,
-		redpandaClient,
-		userStorage,
-		sessionsStorage,
-		codeStorage,
-		tokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  redpandaClient,
+		UserStorage:     userStorage,
+		SessionsStorage: sessionsStorage,
+		CodeStorage:     codeStorage,
+		TokenStorage:    tokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
 	name := "John"
@@ -61,7 +102,7 @@ func TestRegister(t *testing.T) {
 	email := "john.doe@example.com"
 	password := "password"
 
-	tokens, err := authService.Register(ctx, name, surname, email, password)
+	tokens, err := authService.Register(ctx, uuid.Nil, name, surname, email, password)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -81,6 +122,89 @@ func TestRegister(t *testing.T) {
 	mockRedpandaClient.AssertExpectations(t)
 }
 
+func TestRegisterWeakPassword(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	policy := password.NewPolicy(password.PolicyConfig{
+		MinLength:    8,
+		MaxLength:    64,
+		RequireDigit: true,
+	}, nil)
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  policy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	_, err = authService.Register(ctx, uuid.Nil, "John", "Doe", "john.doe@example.com", "johndoe")
+
+	var policyErr *services.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected password policy error, got %v", err)
+	}
+
+	var reasons []string
+	for _, violation := range policyErr.Violations {
+		reasons = append(reasons, violation.Reason)
+	}
+
+	expected := []string{password.ReasonTooShort, password.ReasonMissingDigit, password.ReasonContainsUser}
+	if len(reasons) != len(expected) {
+		t.Fatalf("unexpected violations: %v, expected %v", reasons, expected)
+	}
+
+	for i := range expected {
+		if reasons[i] != expected[i] {
+			t.Errorf("unexpected violations: %v, expected %v", reasons, expected)
+		}
+	}
+
+	// assertions
+	mockUserStorage.AssertNotCalled(t, "CrateUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestLogin(t *testing.T) {
 	// Mock setup
 	mockUserStorage := &MockUserStorage{}
@@ -88,13 +212,17 @@ func TestLogin(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	passHash, err := testPasswordHasher.Hash("password")
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	mockUserStorage.On("ProvideUserByEmail", mock.Anything, "john.doe@example.com").Return(models.User{
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, uuid.Nil, "john.doe@example.com").Return(models.User{
 		UserInfo: models.UserInfo{
 			Id:      uuid.New(),
 			Name:    "John",
@@ -106,7 +234,10 @@ func TestLogin(t *testing.T) {
 			PassHash: passHash,
 		},
 	}, nil)
-	mockSessionsStorage.On("CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockSessionsStorage.On("CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockMFA.On("Enabled", mock.Anything, mock.Anything).Return(false, nil)
+	mockLockout.On("Check", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLockout.On("Reset", mock.Anything, mock.Anything).Return(nil)
 
 	privKey, err := genRandomPrivateKey()
 	if err != nil {
@@ -119,37 +250,53 @@ func TestLogin(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
 	email := "john.doe@example.com"
 	password := "password"
 
-	tokens, err := authService.Login(ctx, email, password)
+	result, err := authService.Login(ctx, uuid.Nil, email, password)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	if tokens.AccessToken == "" {
+	if result.Tokens.AccessToken == "" {
 		t.Errorf("access token is empty")
 	}
 
-	if tokens.RefreshToken == "" {
+	if result.Tokens.RefreshToken == "" {
 		t.Errorf("refresh token is empty")
 	}
 
+	if result.MFAChallengeToken != "" {
+		t.Errorf("unexpected mfa challenge")
+	}
+
 	// assertions
 	mockUserStorage.AssertExpectations(t)
 	mockSessionsStorage.AssertExpectations(t)
@@ -158,6 +305,498 @@ func TestLogin(t *testing.T) {
 	mockRedpandaClient.AssertExpectations(t)
 }
 
+func TestLoginAccountLocked(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	userId := uuid.New()
+	email := "john.doe@example.com"
+
+	passHash, err := testPasswordHasher.Hash("password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	user := models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    "John",
+			Surname: "Doe",
+		},
+		UserAuth: models.UserAuth{
+			Id:       userId,
+			PassHash: passHash,
+		},
+	}
+
+	lockedUntil := time.Now().Add(time.Minute)
+
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, uuid.Nil, email).Return(user, nil)
+	mockLockout.On("Check", mock.Anything, email, mock.Anything).Return(nil).Once()
+	mockLockout.On("Fail", mock.Anything, email, mock.Anything).Return(lockedUntil, nil)
+	mockRedpandaClient.On("AccountLocked", mock.Anything, &redpanda.AccountLockedEvent{
+		UserID:      userId.String(),
+		Email:       email,
+		LockedUntil: lockedUntil,
+	}).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	_, err = authService.Login(ctx, uuid.Nil, email, "wrong password")
+	if !errors.Is(err, services.ErrAccountLocked) {
+		t.Errorf("expected account locked, got %v", err)
+	}
+
+	mockLockout.On("Check", mock.Anything, email, mock.Anything).Return(services.ErrAccountLocked)
+
+	// the right password does not help while the account is locked
+	_, err = authService.Login(ctx, uuid.Nil, email, "password")
+	if !errors.Is(err, services.ErrAccountLocked) {
+		t.Errorf("expected account locked, got %v", err)
+	}
+
+	// assertions
+	mockLockout.AssertExpectations(t)
+	mockRedpandaClient.AssertExpectations(t)
+	mockLockout.AssertNotCalled(t, "Reset", mock.Anything, mock.Anything)
+	mockSessionsStorage.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	userId := uuid.New()
+	email := "john.doe@example.com"
+
+	// users registered before argon2id have bcrypt hashes
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var newHash []byte
+
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, uuid.Nil, email).Return(models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    "John",
+			Surname: "Doe",
+		},
+		UserAuth: models.UserAuth{
+			Id:       userId,
+			PassHash: passHash,
+		},
+	}, nil)
+	mockUserStorage.On("ChangePassword", mock.Anything, userId, mock.Anything).Run(func(args mock.Arguments) {
+		newHash = args.Get(2).([]byte)
+	}).Return(nil)
+	mockSessionsStorage.On("CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockMFA.On("Enabled", mock.Anything, mock.Anything).Return(false, nil)
+	mockLockout.On("Check", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLockout.On("Reset", mock.Anything, mock.Anything).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	if _, err := authService.Login(ctx, uuid.Nil, email, "password"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if testPasswordHasher.NeedsRehash(newHash) {
+		t.Errorf("password is not rehashed")
+	}
+
+	if err := testPasswordHasher.Verify(newHash, "password"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// assertions
+	mockUserStorage.AssertExpectations(t)
+	mockSessionsStorage.AssertExpectations(t)
+}
+
+func TestLoginMFA(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	userId := uuid.New()
+	code := "123456"
+
+	passHash, err := testPasswordHasher.Hash("password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	user := models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    "John",
+			Surname: "Doe",
+		},
+		UserAuth: models.UserAuth{
+			Id:       userId,
+			Email:    "john.doe@example.com",
+			PassHash: passHash,
+		},
+	}
+
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, uuid.Nil, "john.doe@example.com").Return(user, nil)
+	mockUserStorage.On("ProvideUserById", mock.Anything, userId).Return(user, nil)
+	mockMFA.On("Enabled", mock.Anything, userId).Return(true, nil)
+	mockLockout.On("Check", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLockout.On("Reset", mock.Anything, mock.Anything).Return(nil)
+	mockMFA.On("Verify", mock.Anything, userId, code).Return(nil)
+	mockMFAChallengeStorage.On("CreateMFAChallenge", mock.Anything, mock.Anything, userId, time.Minute).Return(nil)
+	mockMFAChallengeStorage.On("ProvideMFAChallenge", mock.Anything, mock.Anything).Return(userId, nil)
+	mockMFAChallengeStorage.On("DeleteMFAChallenge", mock.Anything, mock.Anything).Return(nil)
+	mockSessionsStorage.On("CreateSession", mock.Anything, models.RefreshToken{UserId: userId}, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	result, err := authService.Login(ctx, uuid.Nil, "john.doe@example.com", "password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if result.Tokens.AccessToken != "" {
+		t.Errorf("tokens issued before the second factor")
+	}
+
+	if result.MFAChallengeToken == "" {
+		t.Fatalf("mfa challenge token is empty")
+	}
+
+	// the failures are reset only after the second factor
+	mockLockout.AssertNotCalled(t, "Reset", mock.Anything, mock.Anything)
+
+	tokens, err := authService.LoginMFA(ctx, result.MFAChallengeToken, code)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if tokens.AccessToken == "" {
+		t.Errorf("access token is empty")
+	}
+
+	if tokens.RefreshToken == "" {
+		t.Errorf("refresh token is empty")
+	}
+
+	// assertions
+	mockUserStorage.AssertExpectations(t)
+	mockSessionsStorage.AssertExpectations(t)
+	mockMFAChallengeStorage.AssertExpectations(t)
+	mockMFA.AssertExpectations(t)
+	mockLockout.AssertCalled(t, "Reset", mock.Anything, "john.doe@example.com")
+}
+
+func TestLoginMFAWrongCode(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	userId := uuid.New()
+	challengeToken := uuid.NewString()
+	lockedUntil := time.Now().Add(time.Minute)
+
+	user := models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    "John",
+			Surname: "Doe",
+		},
+		UserAuth: models.UserAuth{
+			Id:    userId,
+			Email: "john.doe@example.com",
+		},
+	}
+
+	mockMFAChallengeStorage.On("ProvideMFAChallenge", mock.Anything, challengeToken).Return(userId, nil)
+	mockMFAChallengeStorage.On("IncrMFAChallengeAttempts", mock.Anything, challengeToken).Return(int64(1), nil)
+	mockUserStorage.On("ProvideUserById", mock.Anything, userId).Return(user, nil)
+	mockLockout.On("Check", mock.Anything, "john.doe@example.com", mock.Anything).Return(nil)
+	mockLockout.On("Fail", mock.Anything, "john.doe@example.com", mock.Anything).Return(lockedUntil, nil)
+	mockMFA.On("Verify", mock.Anything, userId, "000000").Return(services.ErrInvalidCredentials)
+	mockRedpandaClient.On("AccountLocked", mock.Anything, mock.Anything).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	_, err = authService.LoginMFA(ctx, challengeToken, "000000")
+	if !errors.Is(err, services.ErrAccountLocked) {
+		t.Errorf("expected account locked, got %v", err)
+	}
+
+	// assertions
+	mockMFAChallengeStorage.AssertExpectations(t)
+	mockLockout.AssertExpectations(t)
+	mockRedpandaClient.AssertExpectations(t)
+	mockLockout.AssertNotCalled(t, "Reset", mock.Anything, mock.Anything)
+	mockSessionsStorage.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLoginPasskey(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	userId := uuid.New()
+	ceremonyToken := uuid.NewString()
+	response := []byte(`{"id":"credential"}`)
+
+	user := models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    "John",
+			Surname: "Doe",
+		},
+	}
+
+	mockPasskeys.On("FinishLogin", mock.Anything, ceremonyToken, response).Return(userId, nil)
+	mockUserStorage.On("ProvideUserById", mock.Anything, userId).Return(user, nil)
+	mockSessionsStorage.On("CreateSession", mock.Anything, models.RefreshToken{UserId: userId}, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	tokens, err := authService.LoginPasskey(ctx, ceremonyToken, response)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if tokens.AccessToken == "" {
+		t.Errorf("access token is empty")
+	}
+
+	if tokens.RefreshToken == "" {
+		t.Errorf("refresh token is empty")
+	}
+
+	// mfa is not asked for
+	mockMFA.AssertNotCalled(t, "Enabled", mock.Anything, mock.Anything)
+
+	// assertions
+	mockPasskeys.AssertExpectations(t)
+	mockUserStorage.AssertExpectations(t)
+	mockSessionsStorage.AssertExpectations(t)
+}
+
 func TestLogout(t *testing.T) {
 	// Mock setup
 	mockUserStorage := &MockUserStorage{}
@@ -165,6 +804,10 @@ func TestLogout(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	refreshToken := "refresh-token"
 
@@ -181,53 +824,237 @@ func TestLogout(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	if err := authService.Logout(ctx, refreshToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// assertions
+	mockSessionsStorage.AssertExpectations(t)
+}
+
+func TestRefreshToken(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	refreshToken := "refresh-token"
+
+	mockSessionsStorage.On("ProvideSession", mock.Anything, refreshToken).Return(models.RefreshToken{UserId: uuid.New()}, nil)
+	mockUserStorage.On("ProvideUserById", mock.Anything, mock.Anything).Return(models.User{
+		UserInfo: models.UserInfo{
+			Id:      uuid.New(),
+			Name:    "John",
+			Surname: "Doe",
+		},
+		UserAuth: models.UserAuth{
+			Id:       uuid.New(),
+			Email:    "john.doe@example.com",
+			PassHash: []byte{},
+		},
+	}, nil)
+	mockSessionsStorage.On("UpdateSession", mock.Anything, refreshToken, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	tokens, err := authService.RefreshToken(ctx, refreshToken)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if tokens.AccessToken == "" {
+		t.Errorf("access token is empty")
+	}
+
+	if tokens.RefreshToken == "" {
+		t.Errorf("refresh token is empty")
+	}
+
+	// assertions
+	mockSessionsStorage.AssertExpectations(t)
+	mockUserStorage.AssertExpectations(t)
+}
+
+func TestRefreshTokenReused(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	refreshToken := "rotated-refresh-token"
+	userId := uuid.New()
+
+	mockSessionsStorage.On("ProvideSession", mock.Anything, refreshToken).Return(models.RefreshToken{UserId: userId}, nil)
+	mockUserStorage.On("ProvideUserById", mock.Anything, userId).Return(models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    "John",
+			Surname: "Doe",
+		},
+		UserAuth: models.UserAuth{
+			Id:       userId,
+			Email:    "john.doe@example.com",
+			PassHash: []byte{},
+		},
+	}, nil)
+	mockSessionsStorage.On("UpdateSession", mock.Anything, refreshToken, mock.Anything, mock.Anything, mock.Anything).Return(storage.ErrRefreshTokenReused)
+	mockRedpandaClient.On("RefreshTokenReused", mock.Anything, &redpanda.RefreshTokenReusedEvent{UserID: userId.String()}).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
-	if err := authService.Logout(ctx, refreshToken); err != nil {
+	tokens, err := authService.RefreshToken(ctx, refreshToken)
+	if !errors.Is(err, services.ErrNotAuthorized) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
+	if tokens.AccessToken != "" || tokens.RefreshToken != "" {
+		t.Errorf("tokens issued for reused refresh token")
+	}
+
 	// assertions
 	mockSessionsStorage.AssertExpectations(t)
+	mockRedpandaClient.AssertExpectations(t)
 }
 
-func TestRefreshToken(t *testing.T) {
+func TestRefreshClientTokens(t *testing.T) {
 	// Mock setup
 	mockUserStorage := &MockUserStorage{}
 	mockSessionsStorage := &MockSessionsStorage{}
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
-	refreshToken := "refresh-token"
+	refreshToken := "client-refresh-token"
+	userId := uuid.New()
 
-	mockSessionsStorage.On("ProvideUser", mock.Anything, refreshToken).Return(uuid.New(), nil)
-	mockUserStorage.On("ProvideUserById", mock.Anything, mock.Anything).Return(models.User{
+	mockSessionsStorage.On("ProvideSession", mock.Anything, refreshToken).Return(models.RefreshToken{
+		UserId:   userId,
+		ClientId: "journal",
+		Scope:    "openid profile",
+	}, nil)
+	mockUserStorage.On("ProvideUserById", mock.Anything, userId).Return(models.User{
 		UserInfo: models.UserInfo{
-			Id:      uuid.New(),
+			Id:      userId,
 			Name:    "John",
 			Surname: "Doe",
 		},
-		UserAuth: models.UserAuth{
-			Id:       uuid.New(),
-			Email:    "john.doe@example.com",
-			PassHash: []byte{},
-		},
 	}, nil)
-	mockSessionsStorage.On("UpdateSession", mock.Anything, refreshToken, mock.Anything, mock.Anything).Return(nil)
+	mockSessionsStorage.On("UpdateSession", mock.Anything, refreshToken, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	privKey, err := genRandomPrivateKey()
 	if err != nil {
@@ -240,32 +1067,66 @@ func TestRefreshToken(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{roles: []models.Role{{Name: "admin", Permissions: []string{"sso:users:write"}}}},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
-	tokens, err := authService.RefreshToken(ctx, refreshToken)
+	// the token of a client is not refreshed as a token issued by Login, nor by another client
+	if _, err := authService.RefreshToken(ctx, refreshToken); !errors.Is(err, services.ErrNotAuthorized) {
+		t.Errorf("expected not authorized, got %v", err)
+	}
+
+	if _, _, err := authService.RefreshClientTokens(ctx, "other", refreshToken, time.Minute); !errors.Is(err, services.ErrNotAuthorized) {
+		t.Errorf("expected not authorized, got %v", err)
+	}
+
+	mockSessionsStorage.AssertNotCalled(t, "UpdateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	tokens, scope, err := authService.RefreshClientTokens(ctx, "journal", refreshToken, time.Minute)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if tokens.AccessToken == "" {
-		t.Errorf("access token is empty")
+	if scope != "openid profile" {
+		t.Errorf("unexpected scope: %q", scope)
 	}
 
-	if tokens.RefreshToken == "" {
-		t.Errorf("refresh token is empty")
+	claims, err := jwt.ParseAccessToken(tokens.AccessToken, jwks.NewJWKS(&privKey.PublicKey), testIssuer, testAudience)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the refreshed token is limited to the grant like the token it replaces
+	if claims.ClientId != "journal" || claims.Scope != "openid profile" || len(claims.Roles) != 0 || len(claims.Permissions) != 0 {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// the token is for the client, not for the services accepting the tokens of Login
+	if len(claims.Audience) != 1 || claims.Audience[0] != "journal" {
+		t.Errorf("unexpected audience: %v", claims.Audience)
 	}
 
 	// assertions
@@ -280,10 +1141,14 @@ func TestGetUser(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	userId := uuid.New()
 
-	mockUserStorage.On("ProvideUserById", mock.Anything, userId).Return(models.User{
+	mockUserStorage.On("ProvideUsersById", mock.Anything, uuid.Nil, []uuid.UUID{userId}).Return([]models.User{{
 		UserInfo: models.UserInfo{
 			Id:      userId,
 			Name:    "John",
@@ -294,7 +1159,7 @@ func TestGetUser(t *testing.T) {
 			Email:    "john.doe@example.com",
 			PassHash: []byte{},
 		},
-	}, nil)
+	}}, nil)
 
 	privKey, err := genRandomPrivateKey()
 	if err != nil {
@@ -307,22 +1172,34 @@ func TestGetUser(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
-	user, err := authService.GetUser(ctx, userId)
+	user, err := authService.GetUser(ctx, uuid.Nil, userId)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -346,10 +1223,14 @@ func TestGetUsers(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	userId := uuid.New()
 
-	mockUserStorage.On("ProvideUsersById", mock.Anything, []uuid.UUID{userId}).Return([]models.User{
+	mockUserStorage.On("ProvideUsersById", mock.Anything, uuid.Nil, []uuid.UUID{userId}).Return([]models.User{
 		{
 			UserInfo: models.UserInfo{
 				Id:      userId,
@@ -375,22 +1256,34 @@ func TestGetUsers(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
-	users, err := authService.GetUsers(ctx, []uuid.UUID{userId})
+	users, err := authService.GetUsers(ctx, uuid.Nil, []uuid.UUID{userId})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -418,10 +1311,14 @@ func TestUpdateUser(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	userId := uuid.New()
 
-	mockUserStorage.On("UpdateUser", mock.Anything,
+	mockUserStorage.On("UpdateUser", mock.Anything, uuid.Nil,
 		models.UserInfo{
 			Id:      userId,
 			Name:    "John",
@@ -439,22 +1336,34 @@ func TestUpdateUser(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
-	if err := authService.UpdateUser(ctx,
+	if err := authService.UpdateUser(ctx, uuid.Nil,
 		models.UserInfo{
 			Id:      userId,
 			Name:    "John",
@@ -474,10 +1383,15 @@ func TestDeleteUser(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	userId := uuid.New()
 
-	mockUserStorage.On("DeleteUser", mock.Anything, userId).Return(nil)
+	mockUserStorage.On("DeleteUser", mock.Anything, uuid.Nil, userId).Return(nil)
+	mockSessionsStorage.On("RevokeAllSessions", mock.Anything, userId).Return(nil)
 
 	privKey, err := genRandomPrivateKey()
 	if err != nil {
@@ -490,27 +1404,40 @@ func TestDeleteUser(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
-	if err := authService.DeleteUser(ctx, userId); err != nil {
+	if err := authService.DeleteUser(ctx, uuid.Nil, userId); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	// assertions
 	mockUserStorage.AssertExpectations(t)
+	mockSessionsStorage.AssertExpectations(t)
 }
 
 func TestSendVerificationEmail(t *testing.T) {
@@ -520,6 +1447,10 @@ func TestSendVerificationEmail(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	email := "john.doe@example.com"
 
@@ -537,19 +1468,31 @@ func TestSendVerificationEmail(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
 	if err := authService.SendVerificationEmail(ctx, email); err != nil {
@@ -568,6 +1511,10 @@ func TestSendPasswordResetEmail(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	email := "john.doe@example.com"
 
@@ -585,22 +1532,34 @@ func TestSendPasswordResetEmail(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
-	if err := authService.SendPasswordResetEmail(ctx, email); err != nil {
+	if err := authService.SendPasswordResetEmail(ctx, uuid.Nil, email); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
@@ -616,6 +1575,10 @@ func TestVerifyEmail(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	email := "john.doe@example.com"
 	code := "123456"
@@ -634,19 +1597,31 @@ func TestVerifyEmail(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
 	if err := authService.VerifyEmail(ctx, email, code); err != nil {
@@ -664,13 +1639,34 @@ func TestChangePassword(t *testing.T) {
 	mockCodeStorage := &MockCodeStorage{}
 	mockTokenStorage := &MockTokenStorage{}
 	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
 
 	email := "john.doe@example.com"
 	newPassword := "new-password"
 	token := "123456"
+	userId := uuid.New()
+
+	passHash, err := testPasswordHasher.Hash("password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	oldHash, err := testPasswordHasher.Hash("old-password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
 
 	mockTokenStorage.On("ProvideChangePasswordToken", mock.Anything, email).Return(token, nil)
-	mockUserStorage.On("ChangePassword", mock.Anything, email, mock.Anything).Return(nil)
+	mockUserStorage.On("ChangePasswordWithHistory", mock.Anything, userId, mock.Anything, 3).Return(nil)
+	mockUserStorage.On("ProvidePasswordHistory", mock.Anything, userId, 3).Return([][]byte{oldHash}, nil)
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, uuid.Nil, email).Return(models.User{
+		UserInfo: models.UserInfo{Id: userId},
+		UserAuth: models.UserAuth{Id: userId, Email: email, PassHash: passHash},
+	}, nil)
+	mockSessionsStorage.On("RevokeAllSessions", mock.Anything, userId).Return(nil)
 	mockTokenStorage.On("DeleteChangePasswordToken", mock.Anything, email).Return(nil)
 
 	privKey, err := genRandomPrivateKey()
@@ -684,26 +1680,455 @@ func TestChangePassword(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	authService := New(
-		ctx,
-		mockRedpandaClient,
-		mockUserStorage,
-		mockSessionsStorage,
-		mockCodeStorage,
-		mockTokenStorage,
-		time.Hour,
-		time.Hour,
-		time.Minute,
-		time.Minute,
-		privKey,
-	)
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
 
 	// Test
-	if err := authService.ChangePassword(ctx, email, newPassword, token); err != nil {
+	if err := authService.ChangePassword(ctx, uuid.Nil, email, newPassword, token); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	// assertions
 	mockTokenStorage.AssertExpectations(t)
 	mockUserStorage.AssertExpectations(t)
+	mockSessionsStorage.AssertExpectations(t)
+}
+
+func TestChangePasswordReused(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	email := "john.doe@example.com"
+	token := "123456"
+	userId := uuid.New()
+
+	passHash, err := testPasswordHasher.Hash("password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	oldHash, err := testPasswordHasher.Hash("old-password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockTokenStorage.On("ProvideChangePasswordToken", mock.Anything, email).Return(token, nil)
+	mockUserStorage.On("ProvidePasswordHistory", mock.Anything, userId, 3).Return([][]byte{oldHash}, nil)
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, uuid.Nil, email).Return(models.User{
+		UserInfo: models.UserInfo{Id: userId},
+		UserAuth: models.UserAuth{Id: userId, Email: email, PassHash: passHash},
+	}, nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	for _, reused := range []string{"password", "old-password"} {
+		err := authService.ChangePassword(ctx, uuid.Nil, email, reused, token)
+
+		var policyErr *services.PasswordPolicyError
+		if !errors.As(err, &policyErr) {
+			t.Fatalf("expected password policy error, got %v", err)
+		}
+
+		if policyErr.Violations[0].Reason != password.ReasonReused {
+			t.Errorf("unexpected violations: %v", policyErr.Violations)
+		}
+	}
+
+	// assertions
+	mockUserStorage.AssertNotCalled(t, "ChangePasswordWithHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockSessionsStorage.AssertNotCalled(t, "RevokeAllSessions", mock.Anything, mock.Anything)
+}
+
+func TestPublicKeys(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	userId := uuid.New()
+
+	passHash, err := testPasswordHasher.Hash("password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, uuid.Nil, "john.doe@example.com").Return(models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    "John",
+			Surname: "Doe",
+		},
+		UserAuth: models.UserAuth{
+			Id:       userId,
+			Email:    "john.doe@example.com",
+			PassHash: passHash,
+		},
+	}, nil)
+	mockSessionsStorage.On("CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockMFA.On("Enabled", mock.Anything, mock.Anything).Return(false, nil)
+	mockLockout.On("Check", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLockout.On("Reset", mock.Anything, mock.Anything).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles: StaticRoleProvider{roles: []models.Role{
+			{Name: "teacher", Permissions: []string{"report:reports:read"}},
+			{Name: "admin", Permissions: []string{"report:reports:read", "sso:users:write"}},
+		}},
+		Organizations:  StaticOrganizationProvider{},
+		PasswordHasher: testPasswordHasher,
+		PasswordPolicy: testPasswordPolicy,
+		KeyProvider:    &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	result, err := authService.Login(ctx, uuid.Nil, "john.doe@example.com", "password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	keySet, err := authService.PublicKeys(ctx)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(keySet.Keys) != 1 {
+		t.Fatalf("unexpected number of keys: %d", len(keySet.Keys))
+	}
+
+	if keySet.Keys[0].Kid != jwks.KeyID(&privKey.PublicKey) {
+		t.Errorf("unexpected key id: %v", keySet.Keys[0].Kid)
+	}
+
+	uid, err := jwt.VerifyBearerTokenWithKeySet("Bearer "+result.Tokens.AccessToken, keySet, testIssuer, testAudience)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if uid != userId.String() {
+		t.Errorf("unexpected user id: %v", uid)
+	}
+
+	claims, err := jwt.VerifyAccessToken("Bearer "+result.Tokens.AccessToken, keySet, testIssuer, testAudience)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !slices.Equal(claims.Roles, []string{"teacher", "admin"}) ||
+		!slices.Equal(claims.Permissions, []string{"report:reports:read", "sso:users:write"}) {
+		t.Errorf("unexpected roles %v and permissions %v", claims.Roles, claims.Permissions)
+	}
+
+	// tokens of another environment signed with the same key
+	if _, err := jwt.VerifyBearerTokenWithKeySet("Bearer "+result.Tokens.AccessToken, keySet, testIssuer, "staging"); err == nil {
+		t.Errorf("token is accepted by another audience")
+	}
+
+	if _, err := jwt.VerifyBearerTokenWithKeySet("Bearer "+result.Tokens.AccessToken, keySet, "https://sso.staging.example.com", testAudience); err == nil {
+		t.Errorf("token is accepted from another issuer")
+	}
+
+	// assertions
+	mockUserStorage.AssertExpectations(t)
+	mockSessionsStorage.AssertExpectations(t)
+}
+
+func TestLoginOrganization(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	userId := uuid.New()
+	org := models.Organization{
+		Id:           uuid.New(),
+		Slug:         "acme",
+		ScopedEmails: true,
+		Settings: models.OrganizationSettings{
+			AccessTokenTTL:  5 * time.Minute,
+			RefreshTokenTTL: 24 * time.Hour,
+		},
+	}
+	otherOrg := models.Organization{Id: uuid.New(), Slug: "globex"}
+
+	passHash, err := testPasswordHasher.Hash("password")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, org.Id, "john.doe@example.com").Return(models.User{
+		UserInfo: models.UserInfo{
+			Id:      userId,
+			Name:    "John",
+			Surname: "Doe",
+		},
+		UserAuth: models.UserAuth{
+			Id:       userId,
+			Email:    "john.doe@example.com",
+			PassHash: passHash,
+		},
+		OrganizationId: org.Id,
+	}, nil)
+	mockUserStorage.On("ProvideUserByEmail", mock.Anything, uuid.Nil, "john.doe@example.com").Return(models.User{
+		UserInfo: models.UserInfo{Id: uuid.New()},
+		UserAuth: models.UserAuth{
+			Email:    "john.doe@example.com",
+			PassHash: passHash,
+		},
+	}, nil)
+	mockSessionsStorage.On("CreateSession", mock.Anything, models.RefreshToken{UserId: userId}, mock.Anything, mock.Anything, 24*time.Hour).Return(nil)
+	mockMFA.On("Enabled", mock.Anything, mock.Anything).Return(false, nil)
+	mockLockout.On("Check", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLockout.On("Fail", mock.Anything, mock.Anything, mock.Anything).Return(time.Time{}, nil)
+	mockLockout.On("Reset", mock.Anything, mock.Anything).Return(nil)
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles: StaticRoleProvider{
+			roles:       []models.Role{{Name: "student", Permissions: []string{"report:reports:read"}}},
+			memberRoles: []models.Role{{Name: "teacher", Permissions: []string{"report:reports:write"}}},
+		},
+		Organizations:  StaticOrganizationProvider{org.Id: org, otherOrg.Id: otherOrg},
+		PasswordHasher: testPasswordHasher,
+		PasswordPolicy: testPasswordPolicy,
+		KeyProvider:    &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	result, err := authService.Login(ctx, org.Id, "john.doe@example.com", "password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	keySet, err := authService.PublicKeys(ctx)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	claims, err := jwt.VerifyAccessToken("Bearer "+result.Tokens.AccessToken, keySet, testIssuer, testAudience)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.OrganizationId != org.Id.String() {
+		t.Errorf("unexpected organization: %v", claims.OrganizationId)
+	}
+
+	if !slices.Equal(claims.Roles, []string{"student", "teacher"}) ||
+		!slices.Equal(claims.Permissions, []string{"report:reports:read", "report:reports:write"}) {
+		t.Errorf("unexpected roles %v and permissions %v", claims.Roles, claims.Permissions)
+	}
+
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != org.Settings.AccessTokenTTL {
+		t.Errorf("unexpected access token lifetime: %v", ttl)
+	}
+
+	// the email of a user outside of the organization
+	if _, err := authService.Login(ctx, otherOrg.Id, "john.doe@example.com", "password"); !errors.Is(err, services.ErrUserNotFound) {
+		t.Errorf("expected user not found, got %v", err)
+	}
+
+	if _, err := authService.Login(ctx, uuid.New(), "john.doe@example.com", "password"); !errors.Is(err, services.ErrOrganizationNotFound) {
+		t.Errorf("expected organization not found, got %v", err)
+	}
+
+	// assertions
+	mockUserStorage.AssertExpectations(t)
+	mockSessionsStorage.AssertExpectations(t)
+}
+
+func TestRegisterRegistrationClosed(t *testing.T) {
+	// Mock setup
+	mockUserStorage := &MockUserStorage{}
+	mockSessionsStorage := &MockSessionsStorage{}
+	mockCodeStorage := &MockCodeStorage{}
+	mockTokenStorage := &MockTokenStorage{}
+	mockRedpandaClient := &MockRedpandaClient{}
+	mockMFAChallengeStorage := &MockMFAChallengeStorage{}
+	mockMFA := &MockMFA{}
+	mockPasskeys := &MockPasskeys{}
+	mockLockout := &MockLockout{}
+
+	org := models.Organization{Id: uuid.New(), Slug: "acme"}
+
+	privKey, err := genRandomPrivateKey()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Test setup
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	authService := New(ctx, Deps{
+		RedpandaClient:  mockRedpandaClient,
+		UserStorage:     mockUserStorage,
+		SessionsStorage: mockSessionsStorage,
+		CodeStorage:     mockCodeStorage,
+		TokenStorage:    mockTokenStorage,
+		MFAChallenges:   mockMFAChallengeStorage,
+		MFA:             mockMFA,
+		Passkeys:        mockPasskeys,
+		Lockout:         mockLockout,
+		Roles:           StaticRoleProvider{},
+		Organizations:   StaticOrganizationProvider{org.Id: org},
+		PasswordHasher:  testPasswordHasher,
+		PasswordPolicy:  testPasswordPolicy,
+		KeyProvider:     &StaticKeyProvider{privKey},
+	}, Config{
+		PasswordHistory: 3,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		TokenTTL:        time.Minute,
+		MFAChallengeTTL: time.Minute,
+		Issuer:          testIssuer,
+		Audience:        testAudience,
+	})
+
+	// Test
+	_, err = authService.Register(ctx, org.Id, "John", "Doe", "john.doe@example.com", "correct horse battery staple")
+	if !errors.Is(err, services.ErrRegistrationClosed) {
+		t.Errorf("expected registration closed, got %v", err)
+	}
+
+	// assertions
+	mockUserStorage.AssertNotCalled(t, "CrateUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
@@ -10,6 +10,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/hesoyamTM/apphelper-sso/internal/clients/redpanda"
 	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -17,8 +19,8 @@ type MockUserStorage struct {
 	mock.Mock
 }
 
-func (m *MockUserStorage) CrateUser(ctx context.Context, name, surname, email string, passHash []byte) (uuid.UUID, error) {
-	args := m.Called(ctx, name, surname, email, passHash)
+func (m *MockUserStorage) CrateUser(ctx context.Context, organizationId, emailScope uuid.UUID, name, surname, email string, passHash []byte) (uuid.UUID, error) {
+	args := m.Called(ctx, organizationId, emailScope, name, surname, email, passHash)
 	return args.Get(0).(uuid.UUID), args.Error(1)
 }
 
@@ -27,28 +29,38 @@ func (m *MockUserStorage) ProvideUserById(ctx context.Context, id uuid.UUID) (mo
 	return args.Get(0).(models.User), args.Error(1)
 }
 
-func (m *MockUserStorage) ProvideUserByEmail(ctx context.Context, email string) (models.User, error) {
-	args := m.Called(ctx, email)
+func (m *MockUserStorage) ProvideUserByEmail(ctx context.Context, emailScope uuid.UUID, email string) (models.User, error) {
+	args := m.Called(ctx, emailScope, email)
 	return args.Get(0).(models.User), args.Error(1)
 }
 
-func (m *MockUserStorage) ProvideUsersById(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
-	args := m.Called(ctx, ids)
+func (m *MockUserStorage) ProvideUsersById(ctx context.Context, organizationId uuid.UUID, ids []uuid.UUID) ([]models.User, error) {
+	args := m.Called(ctx, organizationId, ids)
 	return args.Get(0).([]models.User), args.Error(1)
 }
 
-func (m *MockUserStorage) UpdateUser(ctx context.Context, user models.UserInfo) error {
-	args := m.Called(ctx, user)
+func (m *MockUserStorage) UpdateUser(ctx context.Context, organizationId uuid.UUID, user models.UserInfo) error {
+	args := m.Called(ctx, organizationId, user)
 	return args.Error(0)
 }
 
-func (m *MockUserStorage) ChangePassword(ctx context.Context, email string, newPassword []byte) error {
-	args := m.Called(ctx, email, newPassword)
+func (m *MockUserStorage) ChangePassword(ctx context.Context, userId uuid.UUID, newPassword []byte) error {
+	args := m.Called(ctx, userId, newPassword)
 	return args.Error(0)
 }
 
-func (m *MockUserStorage) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	args := m.Called(ctx, id)
+func (m *MockUserStorage) ChangePasswordWithHistory(ctx context.Context, userId uuid.UUID, passHash []byte, historySize int) error {
+	args := m.Called(ctx, userId, passHash, historySize)
+	return args.Error(0)
+}
+
+func (m *MockUserStorage) ProvidePasswordHistory(ctx context.Context, userId uuid.UUID, limit int) ([][]byte, error) {
+	args := m.Called(ctx, userId, limit)
+	return args.Get(0).([][]byte), args.Error(1)
+}
+
+func (m *MockUserStorage) DeleteUser(ctx context.Context, organizationId, id uuid.UUID) error {
+	args := m.Called(ctx, organizationId, id)
 	return args.Error(0)
 }
 
@@ -56,19 +68,19 @@ type MockSessionsStorage struct {
 	mock.Mock
 }
 
-func (m *MockSessionsStorage) CreateSession(ctx context.Context, userId uuid.UUID, refreshToken string, expiration time.Duration) error {
-	args := m.Called(ctx, userId, refreshToken, expiration)
+func (m *MockSessionsStorage) CreateSession(ctx context.Context, token models.RefreshToken, refreshToken string, device models.Device, expiration time.Duration) error {
+	args := m.Called(ctx, token, refreshToken, device, expiration)
 	return args.Error(0)
 }
 
-func (m *MockSessionsStorage) UpdateSession(ctx context.Context, oldRefreshToken, newRefreshToken string, expiration time.Duration) error {
-	args := m.Called(ctx, oldRefreshToken, newRefreshToken, expiration)
+func (m *MockSessionsStorage) UpdateSession(ctx context.Context, oldRefreshToken, newRefreshToken string, device models.Device, expiration time.Duration) error {
+	args := m.Called(ctx, oldRefreshToken, newRefreshToken, device, expiration)
 	return args.Error(0)
 }
 
-func (m *MockSessionsStorage) ProvideUser(ctx context.Context, refreshToken string) (uuid.UUID, error) {
+func (m *MockSessionsStorage) ProvideSession(ctx context.Context, refreshToken string) (models.RefreshToken, error) {
 	args := m.Called(ctx, refreshToken)
-	return args.Get(0).(uuid.UUID), args.Error(1)
+	return args.Get(0).(models.RefreshToken), args.Error(1)
 }
 
 func (m *MockSessionsStorage) DeleteSession(ctx context.Context, refreshToken string) error {
@@ -76,6 +88,21 @@ func (m *MockSessionsStorage) DeleteSession(ctx context.Context, refreshToken st
 	return args.Error(0)
 }
 
+func (m *MockSessionsStorage) ListSessions(ctx context.Context, userId uuid.UUID) ([]models.Session, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).([]models.Session), args.Error(1)
+}
+
+func (m *MockSessionsStorage) RevokeSession(ctx context.Context, userId uuid.UUID, sessionId string) error {
+	args := m.Called(ctx, userId, sessionId)
+	return args.Error(0)
+}
+
+func (m *MockSessionsStorage) RevokeAllSessions(ctx context.Context, userId uuid.UUID) error {
+	args := m.Called(ctx, userId)
+	return args.Error(0)
+}
+
 type MockCodeStorage struct {
 	mock.Mock
 }
@@ -114,6 +141,72 @@ func (m *MockTokenStorage) DeleteChangePasswordToken(ctx context.Context, email
 	return args.Error(0)
 }
 
+type MockMFAChallengeStorage struct {
+	mock.Mock
+}
+
+func (m *MockMFAChallengeStorage) CreateMFAChallenge(ctx context.Context, challengeToken string, userId uuid.UUID, ttl time.Duration) error {
+	args := m.Called(ctx, challengeToken, userId, ttl)
+	return args.Error(0)
+}
+
+func (m *MockMFAChallengeStorage) ProvideMFAChallenge(ctx context.Context, challengeToken string) (uuid.UUID, error) {
+	args := m.Called(ctx, challengeToken)
+	return args.Get(0).(uuid.UUID), args.Error(1)
+}
+
+func (m *MockMFAChallengeStorage) IncrMFAChallengeAttempts(ctx context.Context, challengeToken string) (int64, error) {
+	args := m.Called(ctx, challengeToken)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockMFAChallengeStorage) DeleteMFAChallenge(ctx context.Context, challengeToken string) error {
+	args := m.Called(ctx, challengeToken)
+	return args.Error(0)
+}
+
+type MockMFA struct {
+	mock.Mock
+}
+
+func (m *MockMFA) Enabled(ctx context.Context, userId uuid.UUID) (bool, error) {
+	args := m.Called(ctx, userId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMFA) Verify(ctx context.Context, userId uuid.UUID, code string) error {
+	args := m.Called(ctx, userId, code)
+	return args.Error(0)
+}
+
+type MockPasskeys struct {
+	mock.Mock
+}
+
+func (m *MockPasskeys) FinishLogin(ctx context.Context, ceremonyToken string, response []byte) (uuid.UUID, error) {
+	args := m.Called(ctx, ceremonyToken, response)
+	return args.Get(0).(uuid.UUID), args.Error(1)
+}
+
+type MockLockout struct {
+	mock.Mock
+}
+
+func (m *MockLockout) Check(ctx context.Context, email, ip string) error {
+	args := m.Called(ctx, email, ip)
+	return args.Error(0)
+}
+
+func (m *MockLockout) Fail(ctx context.Context, email, ip string) (time.Time, error) {
+	args := m.Called(ctx, email, ip)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockLockout) Reset(ctx context.Context, email string) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
 type MockRedpandaClient struct {
 	mock.Mock
 }
@@ -128,11 +221,33 @@ func (m *MockRedpandaClient) PasswordChanged(ctx context.Context, user *redpanda
 	return args.Error(0)
 }
 
+func (m *MockRedpandaClient) AccountLocked(ctx context.Context, event *redpanda.AccountLockedEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
 func (m *MockRedpandaClient) VerificationCodeUpdated(ctx context.Context, user *redpanda.VerificationCodeUpdatedEvent) error {
 	args := m.Called(ctx, user)
 	return args.Error(0)
 }
 
+func (m *MockRedpandaClient) RefreshTokenReused(ctx context.Context, event *redpanda.RefreshTokenReusedEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+type StaticKeyProvider struct {
+	privateKey *ecdsa.PrivateKey
+}
+
+func (p *StaticKeyProvider) SigningKey() *ecdsa.PrivateKey {
+	return p.privateKey
+}
+
+func (p *StaticKeyProvider) PublicKeys(ctx context.Context) (jwks.JWKS, error) {
+	return jwks.NewJWKS(&p.privateKey.PublicKey), nil
+}
+
 func genRandomPrivateKey() (*ecdsa.PrivateKey, error) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -141,3 +256,29 @@ func genRandomPrivateKey() (*ecdsa.PrivateKey, error) {
 
 	return key, nil
 }
+
+// StaticRoleProvider returns the same roles for every user
+type StaticRoleProvider struct {
+	roles       []models.Role
+	memberRoles []models.Role
+}
+
+func (p StaticRoleProvider) UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	return p.roles, nil
+}
+
+func (p StaticRoleProvider) MemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	return p.memberRoles, nil
+}
+
+// StaticOrganizationProvider knows the organizations by their id
+type StaticOrganizationProvider map[uuid.UUID]models.Organization
+
+func (p StaticOrganizationProvider) Get(ctx context.Context, id uuid.UUID) (models.Organization, error) {
+	org, ok := p[id]
+	if !ok {
+		return models.Organization{}, services.ErrOrganizationNotFound
+	}
+
+	return org, nil
+}
@@ -0,0 +1,241 @@
+package clients
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+
+	secretLen = 32
+)
+
+type ClientStorage interface {
+	SaveClient(ctx context.Context, client models.OAuthClient) error
+	ProvideClient(ctx context.Context, clientId string) (models.OAuthClient, error)
+	UpdateClientSecret(ctx context.Context, clientId string, secretHash []byte) error
+	DisableClient(ctx context.Context, clientId string) error
+}
+
+// Clients is the registry of OAuth clients
+type Clients struct {
+	log *logger.Logger
+
+	clientStorage ClientStorage
+
+	// signing keys are retired once the tokens of the default lifetime expire,
+	// so clients can only shorten it
+	maxAccessTokenTTL time.Duration
+}
+
+func New(ctx context.Context, clientStorage ClientStorage, maxAccessTokenTTL time.Duration) *Clients {
+	return &Clients{
+		log:               logger.GetLoggerFromCtx(ctx),
+		clientStorage:     clientStorage,
+		maxAccessTokenTTL: maxAccessTokenTTL,
+	}
+}
+
+// Create registers a client and returns it with its id. The secret is returned only
+// here and by RotateSecret, it is empty for public clients
+func (c *Clients) Create(ctx context.Context, client models.OAuthClient, public bool) (models.OAuthClient, string, error) {
+	const op = "clients.Create"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := validateClient(client, public, c.maxAccessTokenTTL); err != nil {
+		return models.OAuthClient{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	client.Id = uuid.NewString()
+	client.SecretHash = nil
+	client.Disabled = false
+
+	secret := ""
+	if !public {
+		var err error
+		if secret, err = randomSecret(); err != nil {
+			return models.OAuthClient{}, "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		client.SecretHash = hashSecret(secret)
+	}
+
+	if err := c.clientStorage.SaveClient(ctx, client); err != nil {
+		log.Error(ctx, "failed to save client", zap.Error(err))
+
+		return models.OAuthClient{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "client created", zap.String("client_id", client.Id))
+
+	return client, secret, nil
+}
+
+// RotateSecret replaces the secret of a confidential client, the old secret stops working at once
+func (c *Clients) RotateSecret(ctx context.Context, clientId string) (string, error) {
+	const op = "clients.RotateSecret"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	client, err := c.ProvideClient(ctx, clientId)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(client.SecretHash) == 0 {
+		return "", fmt.Errorf("%s: %w", op, services.ErrInvalidClient)
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := c.clientStorage.UpdateClientSecret(ctx, clientId, hashSecret(secret)); err != nil {
+		log.Error(ctx, "failed to update client secret", zap.Error(err))
+
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return "", fmt.Errorf("%s: %w", op, services.ErrClientNotFound)
+		}
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "client secret rotated", zap.String("client_id", clientId))
+
+	return secret, nil
+}
+
+// Disable stops the client from obtaining tokens. Tokens issued before stay valid until they expire
+func (c *Clients) Disable(ctx context.Context, clientId string) error {
+	const op = "clients.Disable"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := c.clientStorage.DisableClient(ctx, clientId); err != nil {
+		log.Error(ctx, "failed to disable client", zap.Error(err))
+
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrClientNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "client disabled", zap.String("client_id", clientId))
+
+	return nil
+}
+
+// ProvideClient returns an enabled client
+func (c *Clients) ProvideClient(ctx context.Context, clientId string) (models.OAuthClient, error) {
+	const op = "clients.ProvideClient"
+
+	client, err := c.clientStorage.ProvideClient(ctx, clientId)
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return models.OAuthClient{}, fmt.Errorf("%s: %w", op, services.ErrClientNotFound)
+		}
+
+		return models.OAuthClient{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if client.Disabled {
+		return models.OAuthClient{}, fmt.Errorf("%s: %w", op, services.ErrClientNotFound)
+	}
+
+	return client, nil
+}
+
+// AuthenticateClient checks the secret of a confidential client. Public clients are
+// authenticated by their id only and must not send a secret
+func (c *Clients) AuthenticateClient(ctx context.Context, clientId, secret string) (models.OAuthClient, error) {
+	const op = "clients.AuthenticateClient"
+
+	client, err := c.ProvideClient(ctx, clientId)
+	if err != nil {
+		return models.OAuthClient{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(client.SecretHash) == 0 {
+		if secret != "" {
+			return models.OAuthClient{}, fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+		}
+
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare(hashSecret(secret), client.SecretHash) != 1 {
+		return models.OAuthClient{}, fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+	}
+
+	return client, nil
+}
+
+func validateClient(client models.OAuthClient, public bool, maxAccessTokenTTL time.Duration) error {
+	if len(client.GrantTypes) == 0 {
+		return services.ErrInvalidClient
+	}
+
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case GrantAuthorizationCode:
+			if len(client.RedirectURIs) == 0 {
+				return services.ErrInvalidClient
+			}
+		case GrantClientCredentials:
+			// a public client cannot authenticate itself
+			if public {
+				return services.ErrInvalidClient
+			}
+		case GrantDeviceCode:
+		default:
+			return services.ErrInvalidClient
+		}
+	}
+
+	for _, redirectURI := range client.RedirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return services.ErrInvalidClient
+		}
+	}
+
+	if client.AccessTokenTTL < 0 || client.AccessTokenTTL > maxAccessTokenTTL || slices.Contains(client.Scopes, "") {
+		return services.ErrInvalidClient
+	}
+
+	return nil
+}
+
+// secrets are random, a fast hash is enough to keep them from leaking with the database
+func hashSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+
+	return sum[:]
+}
+
+func randomSecret() (string, error) {
+	b := make([]byte, secretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
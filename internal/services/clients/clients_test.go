@@ -0,0 +1,193 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryClientStorage map[string]models.OAuthClient
+
+func (s memoryClientStorage) SaveClient(ctx context.Context, client models.OAuthClient) error {
+	if _, ok := s[client.Id]; ok {
+		return storage.ErrClientExists
+	}
+
+	s[client.Id] = client
+
+	return nil
+}
+
+func (s memoryClientStorage) ProvideClient(ctx context.Context, clientId string) (models.OAuthClient, error) {
+	client, ok := s[clientId]
+	if !ok {
+		return models.OAuthClient{}, storage.ErrClientNotFound
+	}
+
+	return client, nil
+}
+
+func (s memoryClientStorage) UpdateClientSecret(ctx context.Context, clientId string, secretHash []byte) error {
+	client, ok := s[clientId]
+	if !ok {
+		return storage.ErrClientNotFound
+	}
+
+	client.SecretHash = secretHash
+	s[clientId] = client
+
+	return nil
+}
+
+func (s memoryClientStorage) DisableClient(ctx context.Context, clientId string) error {
+	client, ok := s[clientId]
+	if !ok {
+		return storage.ErrClientNotFound
+	}
+
+	client.Disabled = true
+	s[clientId] = client
+
+	return nil
+}
+
+func setup(t *testing.T) (context.Context, *Clients) {
+	t.Helper()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	return ctx, New(ctx, memoryClientStorage{}, time.Hour)
+}
+
+func TestConfidentialClient(t *testing.T) {
+	ctx, clients := setup(t)
+
+	client, secret, err := clients.Create(ctx, models.OAuthClient{
+		Name:       "report",
+		GrantTypes: []string{GrantClientCredentials},
+		Scopes:     []string{"users:read"},
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if client.Id == "" || secret == "" {
+		t.Fatalf("client id or secret is empty")
+	}
+
+	if _, err := clients.AuthenticateClient(ctx, client.Id, secret); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := clients.AuthenticateClient(ctx, client.Id, "wrong"); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+
+	rotated, err := clients.RotateSecret(ctx, client.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := clients.AuthenticateClient(ctx, client.Id, secret); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("old secret still works: %v", err)
+	}
+
+	if _, err := clients.AuthenticateClient(ctx, client.Id, rotated); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := clients.Disable(ctx, client.Id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := clients.AuthenticateClient(ctx, client.Id, rotated); !errors.Is(err, services.ErrClientNotFound) {
+		t.Errorf("expected client not found, got %v", err)
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	ctx, clients := setup(t)
+
+	client, secret, err := clients.Create(ctx, models.OAuthClient{
+		Name:         "web",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		GrantTypes:   []string{GrantAuthorizationCode},
+		Scopes:       []string{"openid"},
+	}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if secret != "" {
+		t.Errorf("public client got a secret")
+	}
+
+	if _, err := clients.AuthenticateClient(ctx, client.Id, ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := clients.RotateSecret(ctx, client.Id); !errors.Is(err, services.ErrInvalidClient) {
+		t.Errorf("expected invalid client, got %v", err)
+	}
+}
+
+func TestCreateInvalidClient(t *testing.T) {
+	ctx, clients := setup(t)
+
+	tests := []struct {
+		name   string
+		client models.OAuthClient
+		public bool
+	}{
+		{
+			name:   "no grant types",
+			client: models.OAuthClient{Name: "none"},
+		},
+		{
+			name:   "unknown grant type",
+			client: models.OAuthClient{Name: "implicit", GrantTypes: []string{"implicit"}},
+		},
+		{
+			name:   "authorization code without redirect uris",
+			client: models.OAuthClient{Name: "web", GrantTypes: []string{GrantAuthorizationCode}},
+		},
+		{
+			name:   "public client credentials",
+			client: models.OAuthClient{Name: "cli", GrantTypes: []string{GrantClientCredentials}},
+			public: true,
+		},
+		{
+			name: "token ttl over the default",
+			client: models.OAuthClient{
+				Name:           "report",
+				GrantTypes:     []string{GrantClientCredentials},
+				AccessTokenTTL: 2 * time.Hour,
+			},
+		},
+		{
+			name: "relative redirect uri",
+			client: models.OAuthClient{
+				Name:         "web",
+				RedirectURIs: []string{"/callback"},
+				GrantTypes:   []string{GrantAuthorizationCode},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := clients.Create(ctx, test.client, test.public); !errors.Is(err, services.ErrInvalidClient) {
+				t.Errorf("expected invalid client, got %v", err)
+			}
+		})
+	}
+}
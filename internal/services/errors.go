@@ -1,10 +1,63 @@
 package services
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/password"
+)
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrUserAlreadyExists  = errors.New("user already exists")
 	ErrNotAuthorized      = errors.New("not authorized")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrMFAAlreadyEnabled  = errors.New("mfa already enabled")
+	ErrMFANotEnabled      = errors.New("mfa not enabled")
+	ErrPasskeyExists      = errors.New("passkey already exists")
+	ErrAccountLocked      = errors.New("account locked")
+	ErrClientNotFound     = errors.New("client not found")
+	ErrInvalidClient      = errors.New("invalid client metadata")
+	ErrUserCodeNotFound   = errors.New("user code not found")
+	ErrRoleExists         = errors.New("role already exists")
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleNotAssigned    = errors.New("role not assigned")
+	ErrInvalidRole        = errors.New("invalid role")
+)
+
+var (
+	ErrOrganizationExists   = errors.New("organization already exists")
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrInvalidOrganization  = errors.New("invalid organization")
+	ErrMemberExists         = errors.New("user is already a member of an organization")
+	ErrMemberNotFound       = errors.New("member not found")
+	ErrRegistrationClosed   = errors.New("registration is closed")
 )
+
+var (
+	ErrInviteNotFound = errors.New("invite not found")
+)
+
+var (
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	ErrInvalidPersonalAccessToken  = errors.New("invalid personal access token")
+)
+
+var (
+	ErrServiceAccountExists   = errors.New("service account already exists")
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrInvalidServiceAccount  = errors.New("invalid service account")
+)
+
+var (
+	ErrImpersonationNotAllowed = errors.New("impersonation not allowed")
+)
+
+// PasswordPolicyError lists the password policy rules a new password breaks
+type PasswordPolicyError struct {
+	Violations []password.Violation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy"
+}
@@ -0,0 +1,201 @@
+package impersonation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/clients/redpanda"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// the permissions of sso are not granted to an admin acting as a user,
+// the token is for reproducing problems in the services
+const ssoPermissionPrefix = "sso:"
+
+type AuditStorage interface {
+	SaveImpersonation(ctx context.Context, impersonation models.Impersonation) error
+}
+
+type UserProvider interface {
+	ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error)
+}
+
+type RoleProvider interface {
+	UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+	MemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+}
+
+type KeyProvider interface {
+	SigningKey() *ecdsa.PrivateKey
+}
+
+type RedpandaClient interface {
+	UserImpersonated(ctx context.Context, event *redpanda.UserImpersonatedEvent) error
+}
+
+// Impersonation issues access tokens to admins acting as users, every one is audited
+type Impersonation struct {
+	log *logger.Logger
+
+	redpandaClient RedpandaClient
+
+	auditStorage AuditStorage
+	userProvider UserProvider
+	roles        RoleProvider
+
+	keyProvider KeyProvider
+	tokenTTL    time.Duration
+	// iss and aud of the access tokens
+	issuer   string
+	audience string
+}
+
+func New(ctx context.Context,
+	redpandaClient RedpandaClient,
+	auditStorage AuditStorage,
+	userProvider UserProvider,
+	roles RoleProvider,
+	keyProvider KeyProvider,
+	tokenTTL time.Duration,
+	issuer string,
+	audience string,
+) *Impersonation {
+	return &Impersonation{
+		log: logger.GetLoggerFromCtx(ctx),
+
+		redpandaClient: redpandaClient,
+
+		auditStorage: auditStorage,
+		userProvider: userProvider,
+		roles:        roles,
+
+		keyProvider: keyProvider,
+		tokenTTL:    tokenTTL,
+		issuer:      issuer,
+		audience:    audience,
+	}
+}
+
+// Impersonate issues a short-lived access token of the user with the act claim naming the admin.
+// No refresh token is issued. The impersonation is saved to the audit trail and published
+// before the token is returned, the token is not returned if either fails
+func (i *Impersonation) Impersonate(ctx context.Context, actorId, userId uuid.UUID, reason string) (models.Impersonation, string, error) {
+	const op = "impersonation.Impersonate"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if actorId == userId {
+		return models.Impersonation{}, "", fmt.Errorf("%s: %w", op, services.ErrImpersonationNotAllowed)
+	}
+
+	user, err := i.userProvider.ProvideUserById(ctx, userId)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Impersonation{}, "", fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+		}
+
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		return models.Impersonation{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	accessToken, err := i.accessToken(ctx, user)
+	if err != nil {
+		log.Error(ctx, "failed to provide user roles", zap.Error(err))
+
+		return models.Impersonation{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	impersonation := models.Impersonation{
+		Id:        uuid.New(),
+		ActorId:   actorId,
+		UserId:    userId,
+		Reason:    reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(i.tokenTTL),
+	}
+
+	accessToken.Id = impersonation.Id
+	accessToken.ActorId = actorId
+
+	token, err := jwt.NewAccessToken(accessToken, i.tokenTTL, i.keyProvider.SigningKey())
+	if err != nil {
+		log.Error(ctx, "failed to generate impersonation token", zap.Error(err))
+
+		return models.Impersonation{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := i.auditStorage.SaveImpersonation(ctx, impersonation); err != nil {
+		log.Error(ctx, "failed to save impersonation", zap.Error(err))
+
+		return models.Impersonation{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	event := &redpanda.UserImpersonatedEvent{
+		UserID:    userId.String(),
+		ActorID:   actorId.String(),
+		Reason:    reason,
+		TokenID:   impersonation.Id.String(),
+		ExpiresAt: impersonation.ExpiresAt,
+	}
+	if err := i.redpandaClient.UserImpersonated(ctx, event); err != nil {
+		log.Error(ctx, "failed to send user impersonated event", zap.Error(err))
+
+		return models.Impersonation{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "user impersonated",
+		zap.String("user_id", userId.String()),
+		zap.String("actor_id", actorId.String()),
+		zap.String("token_id", impersonation.Id.String()),
+	)
+
+	return impersonation, token, nil
+}
+
+// accessToken is the access token Login would issue to the user without the permissions of sso
+func (i *Impersonation) accessToken(ctx context.Context, user models.User) (jwt.AccessToken, error) {
+	accessToken := jwt.AccessToken{
+		Issuer:         i.issuer,
+		Audience:       i.audience,
+		User:           user.UserInfo,
+		OrganizationId: user.OrganizationId,
+	}
+
+	roles, err := i.roles.UserRoles(ctx, user.UserInfo.Id)
+	if err != nil {
+		return jwt.AccessToken{}, err
+	}
+
+	if user.OrganizationId != uuid.Nil {
+		memberRoles, err := i.roles.MemberRoles(ctx, user.UserInfo.Id)
+		if err != nil {
+			return jwt.AccessToken{}, err
+		}
+
+		roles = append(roles, memberRoles...)
+	}
+
+	for _, role := range roles {
+		accessToken.Roles = append(accessToken.Roles, role.Name)
+
+		for _, permission := range role.Permissions {
+			if !strings.HasPrefix(permission, ssoPermissionPrefix) && !slices.Contains(accessToken.Permissions, permission) {
+				accessToken.Permissions = append(accessToken.Permissions, permission)
+			}
+		}
+	}
+
+	return accessToken, nil
+}
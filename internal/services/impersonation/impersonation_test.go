@@ -0,0 +1,149 @@
+package impersonation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/clients/redpanda"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+const (
+	issuer   = "https://sso.example.com"
+	audience = "apphelper"
+)
+
+type memoryAuditStorage struct {
+	impersonations []models.Impersonation
+	err            error
+}
+
+func (s *memoryAuditStorage) SaveImpersonation(ctx context.Context, impersonation models.Impersonation) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.impersonations = append(s.impersonations, impersonation)
+
+	return nil
+}
+
+type userProvider map[uuid.UUID]models.User
+
+func (p userProvider) ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error) {
+	user, ok := p[id]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+type roleProvider struct{}
+
+func (roleProvider) UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	return []models.Role{{Name: "admin", Permissions: []string{"sso:users:write", "journal:read"}}}, nil
+}
+
+func (roleProvider) MemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	return []models.Role{{Name: "teacher", Permissions: []string{"journal:write"}}}, nil
+}
+
+type keyProvider struct {
+	prKey *ecdsa.PrivateKey
+}
+
+func (p keyProvider) SigningKey() *ecdsa.PrivateKey {
+	return p.prKey
+}
+
+type redpandaClient struct {
+	events []*redpanda.UserImpersonatedEvent
+}
+
+func (c *redpandaClient) UserImpersonated(ctx context.Context, event *redpanda.UserImpersonatedEvent) error {
+	c.events = append(c.events, event)
+
+	return nil
+}
+
+func TestImpersonate(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prKey, _, err := jwt.GenerateKeys()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	actorId, userId := uuid.New(), uuid.New()
+	user := models.User{
+		UserInfo:       models.UserInfo{Id: userId, Name: "John", Surname: "Doe"},
+		OrganizationId: uuid.New(),
+	}
+
+	audit := &memoryAuditStorage{}
+	events := &redpandaClient{}
+	service := New(ctx, events, audit, userProvider{userId: user}, roleProvider{}, keyProvider{prKey}, 15*time.Minute, issuer, audience)
+
+	impersonation, token, err := service.Impersonate(ctx, actorId, userId, "ticket 42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims, err := jwt.ParseAccessToken(token, jwks.NewJWKS(&prKey.PublicKey), issuer, audience)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.UserId != userId.String() || claims.Actor == nil || claims.Actor.Subject != actorId.String() || claims.ID != impersonation.Id.String() {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	if !slices.Equal(claims.Permissions, []string{"journal:read", "journal:write"}) || claims.OrganizationId != user.OrganizationId.String() {
+		t.Errorf("unexpected permissions: %v", claims.Permissions)
+	}
+
+	if time.Until(claims.ExpiresAt.Time) > 15*time.Minute {
+		t.Errorf("unexpected expiration: %v", claims.ExpiresAt)
+	}
+
+	if len(audit.impersonations) != 1 || audit.impersonations[0].ActorId != actorId || audit.impersonations[0].Reason != "ticket 42" {
+		t.Errorf("unexpected audit trail: %+v", audit.impersonations)
+	}
+
+	if len(events.events) != 1 || events.events[0].TokenID != impersonation.Id.String() {
+		t.Errorf("unexpected events: %+v", events.events)
+	}
+
+	// an impersonation token does not pass for a token issued by Login
+	if _, err := jwt.VerifyUserToken("Bearer "+token, jwks.NewJWKS(&prKey.PublicKey), issuer, audience); err == nil {
+		t.Errorf("impersonation token is accepted as a login token")
+	}
+
+	if _, _, err := service.Impersonate(ctx, actorId, actorId, "self"); !errors.Is(err, services.ErrImpersonationNotAllowed) {
+		t.Errorf("expected impersonation not allowed, got %v", err)
+	}
+
+	if _, _, err := service.Impersonate(ctx, actorId, uuid.New(), "unknown"); !errors.Is(err, services.ErrUserNotFound) {
+		t.Errorf("expected user not found, got %v", err)
+	}
+
+	// no token leaves without an audit entry
+	audit.err = errors.New("database is down")
+	if _, token, err := service.Impersonate(ctx, actorId, userId, "ticket 43"); err == nil || token != "" {
+		t.Errorf("expected an error, got token %q and %v", token, err)
+	}
+}
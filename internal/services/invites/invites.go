@@ -0,0 +1,350 @@
+package invites
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/clients/redpanda"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/password"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// invite tokens are opaque, only their hash is stored,
+// so that they do not depend on the rotating signing keys
+const tokenLen = 32
+
+type InviteStorage interface {
+	SaveInvite(ctx context.Context, invite models.Invite, emailScope uuid.UUID) (uuid.UUID, error)
+	ProvideInvite(ctx context.Context, organizationId, userId uuid.UUID) (models.Invite, error)
+	ProvideInviteByToken(ctx context.Context, tokenHash []byte) (models.Invite, error)
+	ProvideInvites(ctx context.Context, organizationId uuid.UUID) ([]models.Invite, error)
+	UpdateInviteToken(ctx context.Context, userId uuid.UUID, tokenHash []byte, expiresAt time.Time) error
+	AcceptInvite(ctx context.Context, userId uuid.UUID, tokenHash []byte, passHash []byte) error
+	DeleteInvite(ctx context.Context, organizationId, userId uuid.UUID) error
+}
+
+type UserProvider interface {
+	ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error)
+}
+
+type OrganizationProvider interface {
+	Get(ctx context.Context, id uuid.UUID) (models.Organization, error)
+}
+
+type TokenIssuer interface {
+	IssueTokens(ctx context.Context, userId uuid.UUID, clientId, scope string, accessTokenTTL time.Duration) (models.JWTokens, error)
+}
+
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+}
+
+type PasswordPolicy interface {
+	CheckWith(settings models.PasswordPolicySettings, password string, userInputs ...string) ([]password.Violation, error)
+}
+
+type RedpandaClient interface {
+	UserInvited(ctx context.Context, event *redpanda.UserInvitedEvent) error
+}
+
+// Invites onboards users invited by an admin instead of registering themselves
+type Invites struct {
+	log *logger.Logger
+
+	redpandaClient RedpandaClient
+
+	inviteStorage InviteStorage
+	userProvider  UserProvider
+	organizations OrganizationProvider
+	tokenIssuer   TokenIssuer
+
+	passwordHasher PasswordHasher
+	passwordPolicy PasswordPolicy
+
+	inviteTTL time.Duration
+}
+
+func New(ctx context.Context,
+	redpandaClient RedpandaClient,
+	inviteStorage InviteStorage,
+	userProvider UserProvider,
+	organizations OrganizationProvider,
+	tokenIssuer TokenIssuer,
+	passwordHasher PasswordHasher,
+	passwordPolicy PasswordPolicy,
+	inviteTTL time.Duration,
+) *Invites {
+	return &Invites{
+		log: logger.GetLoggerFromCtx(ctx),
+
+		redpandaClient: redpandaClient,
+
+		inviteStorage: inviteStorage,
+		userProvider:  userProvider,
+		organizations: organizations,
+		tokenIssuer:   tokenIssuer,
+
+		passwordHasher: passwordHasher,
+		passwordPolicy: passwordPolicy,
+
+		inviteTTL: inviteTTL,
+	}
+}
+
+// Invite creates a pending user in the organization of the invite and sends the invite token
+// to the notification service, uuid.Nil invites a user outside of any organization
+func (i *Invites) Invite(ctx context.Context, invite models.Invite) (models.Invite, error) {
+	const op = "invites.Invite"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	var emailScope uuid.UUID
+	if invite.OrganizationId != uuid.Nil {
+		org, err := i.organizations.Get(ctx, invite.OrganizationId)
+		if err != nil {
+			log.Error(ctx, "failed to provide organization", zap.Error(err))
+
+			return models.Invite{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		emailScope = org.EmailScope()
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return models.Invite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	invite.TokenHash = hashToken(token)
+	invite.ExpiresAt = time.Now().Add(i.inviteTTL)
+
+	userId, err := i.inviteStorage.SaveInvite(ctx, invite, emailScope)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			return models.Invite{}, fmt.Errorf("%s: %w", op, services.ErrUserAlreadyExists)
+		}
+		if errors.Is(err, storage.ErrOrganizationNotFound) {
+			return models.Invite{}, fmt.Errorf("%s: %w", op, services.ErrOrganizationNotFound)
+		}
+
+		log.Error(ctx, "failed to save invite", zap.Error(err))
+
+		return models.Invite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	invite.UserId = userId
+
+	if err := i.send(ctx, invite, token); err != nil {
+		return models.Invite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "user invited", zap.String("user_id", userId.String()), zap.String("invited_by", invite.InvitedBy.String()))
+
+	return invite, nil
+}
+
+// Accept sets the password of the invited user and opens a session like Login
+func (i *Invites) Accept(ctx context.Context, inviteToken, newPassword string) (models.JWTokens, error) {
+	const op = "invites.Accept"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	tokenHash := hashToken(inviteToken)
+
+	invite, err := i.inviteStorage.ProvideInviteByToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrInviteNotFound) {
+			log.Info(ctx, "invalid invite token")
+
+			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrInviteNotFound)
+		}
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := i.userProvider.ProvideUserById(ctx, invite.UserId)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			// the invite was revoked
+			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrInviteNotFound)
+		}
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := i.checkPasswordPolicy(ctx, user, newPassword); err != nil {
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := i.passwordHasher.Hash(newPassword)
+	if err != nil {
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := i.inviteStorage.AcceptInvite(ctx, invite.UserId, tokenHash, passHash); err != nil {
+		if errors.Is(err, storage.ErrInviteNotFound) {
+			return models.JWTokens{}, fmt.Errorf("%s: %w", op, services.ErrInviteNotFound)
+		}
+
+		log.Error(ctx, "failed to accept invite", zap.Error(err))
+
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "invite accepted", zap.String("user_id", invite.UserId.String()))
+
+	tokens, err := i.tokenIssuer.IssueTokens(ctx, invite.UserId, "", "", 0)
+	if err != nil {
+		return models.JWTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// List returns the pending invites of the organization, uuid.Nil for users outside of any
+func (i *Invites) List(ctx context.Context, organizationId uuid.UUID) ([]models.Invite, error) {
+	const op = "invites.List"
+
+	invites, err := i.inviteStorage.ProvideInvites(ctx, organizationId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invites, nil
+}
+
+// Resend sends a new invite token with a new expiration, the previous token stops working
+func (i *Invites) Resend(ctx context.Context, organizationId, userId uuid.UUID) error {
+	const op = "invites.Resend"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	invite, err := i.inviteStorage.ProvideInvite(ctx, organizationId, userId)
+	if err != nil {
+		if errors.Is(err, storage.ErrInviteNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrInviteNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	invite.TokenHash = hashToken(token)
+	invite.ExpiresAt = time.Now().Add(i.inviteTTL)
+
+	if err := i.inviteStorage.UpdateInviteToken(ctx, userId, invite.TokenHash, invite.ExpiresAt); err != nil {
+		if errors.Is(err, storage.ErrInviteNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrInviteNotFound)
+		}
+
+		log.Error(ctx, "failed to update invite token", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := i.send(ctx, invite, token); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "invite resent", zap.String("user_id", userId.String()))
+
+	return nil
+}
+
+// Revoke deletes the invite and the pending user
+func (i *Invites) Revoke(ctx context.Context, organizationId, userId uuid.UUID) error {
+	const op = "invites.Revoke"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := i.inviteStorage.DeleteInvite(ctx, organizationId, userId); err != nil {
+		if errors.Is(err, storage.ErrInviteNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrInviteNotFound)
+		}
+
+		log.Error(ctx, "failed to delete invite", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "invite revoked", zap.String("user_id", userId.String()))
+
+	return nil
+}
+
+// send publishes the invite token to the notification service
+func (i *Invites) send(ctx context.Context, invite models.Invite, token string) error {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	event := &redpanda.UserInvitedEvent{
+		UserID:    invite.UserId.String(),
+		Email:     invite.Email,
+		Name:      invite.Name,
+		Surname:   invite.Surname,
+		InvitedBy: invite.InvitedBy.String(),
+		Token:     token,
+		ExpiresAt: invite.ExpiresAt,
+	}
+	if invite.OrganizationId != uuid.Nil {
+		event.OrganizationID = invite.OrganizationId.String()
+	}
+
+	if err := i.redpandaClient.UserInvited(ctx, event); err != nil {
+		log.Error(ctx, "failed to send user invited event", zap.Error(err))
+
+		return err
+	}
+
+	return nil
+}
+
+// checkPasswordPolicy returns services.PasswordPolicyError if the password breaks the policy
+// of the organization of the user
+func (i *Invites) checkPasswordPolicy(ctx context.Context, user models.User, newPassword string) error {
+	var settings models.PasswordPolicySettings
+	if user.OrganizationId != uuid.Nil {
+		org, err := i.organizations.Get(ctx, user.OrganizationId)
+		if err != nil {
+			return err
+		}
+
+		settings = org.Settings.PasswordPolicy
+	}
+
+	violations, err := i.passwordPolicy.CheckWith(settings, newPassword, user.Email, user.UserInfo.Name, user.UserInfo.Surname)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return &services.PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// tokens are random, a fast hash is enough to keep them from leaking with the database
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+
+	return sum[:]
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, tokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
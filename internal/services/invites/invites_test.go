@@ -0,0 +1,351 @@
+package invites
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/clients/redpanda"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/password"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryInviteStorage struct {
+	users   map[uuid.UUID]models.User
+	invites map[uuid.UUID]models.Invite
+}
+
+func (s *memoryInviteStorage) SaveInvite(ctx context.Context, invite models.Invite, emailScope uuid.UUID) (uuid.UUID, error) {
+	for _, user := range s.users {
+		if user.Email == invite.Email {
+			return uuid.Nil, storage.ErrUserExists
+		}
+	}
+
+	invite.UserId = uuid.New()
+	invite.CreatedAt = time.Now()
+
+	s.users[invite.UserId] = models.User{
+		UserInfo:       models.UserInfo{Id: invite.UserId, Name: invite.Name, Surname: invite.Surname},
+		UserAuth:       models.UserAuth{Id: invite.UserId, Email: invite.Email},
+		OrganizationId: invite.OrganizationId,
+	}
+	s.invites[invite.UserId] = invite
+
+	return invite.UserId, nil
+}
+
+func (s *memoryInviteStorage) ProvideInvite(ctx context.Context, organizationId, userId uuid.UUID) (models.Invite, error) {
+	invite, ok := s.invites[userId]
+	if !ok || invite.OrganizationId != organizationId {
+		return models.Invite{}, storage.ErrInviteNotFound
+	}
+
+	return invite, nil
+}
+
+func (s *memoryInviteStorage) ProvideInviteByToken(ctx context.Context, tokenHash []byte) (models.Invite, error) {
+	for _, invite := range s.invites {
+		if bytes.Equal(invite.TokenHash, tokenHash) && invite.ExpiresAt.After(time.Now()) {
+			return invite, nil
+		}
+	}
+
+	return models.Invite{}, storage.ErrInviteNotFound
+}
+
+func (s *memoryInviteStorage) ProvideInvites(ctx context.Context, organizationId uuid.UUID) ([]models.Invite, error) {
+	invites := make([]models.Invite, 0)
+	for _, invite := range s.invites {
+		if invite.OrganizationId == organizationId {
+			invites = append(invites, invite)
+		}
+	}
+
+	return invites, nil
+}
+
+func (s *memoryInviteStorage) UpdateInviteToken(ctx context.Context, userId uuid.UUID, tokenHash []byte, expiresAt time.Time) error {
+	invite, ok := s.invites[userId]
+	if !ok {
+		return storage.ErrInviteNotFound
+	}
+
+	invite.TokenHash = tokenHash
+	invite.ExpiresAt = expiresAt
+	s.invites[userId] = invite
+
+	return nil
+}
+
+func (s *memoryInviteStorage) AcceptInvite(ctx context.Context, userId uuid.UUID, tokenHash []byte, passHash []byte) error {
+	invite, ok := s.invites[userId]
+	if !ok || !bytes.Equal(invite.TokenHash, tokenHash) || !invite.ExpiresAt.After(time.Now()) {
+		return storage.ErrInviteNotFound
+	}
+
+	delete(s.invites, userId)
+
+	user := s.users[userId]
+	user.PassHash = passHash
+	s.users[userId] = user
+
+	return nil
+}
+
+func (s *memoryInviteStorage) DeleteInvite(ctx context.Context, organizationId, userId uuid.UUID) error {
+	invite, ok := s.invites[userId]
+	if !ok || invite.OrganizationId != organizationId {
+		return storage.ErrInviteNotFound
+	}
+
+	delete(s.invites, userId)
+	delete(s.users, userId)
+
+	return nil
+}
+
+func (s *memoryInviteStorage) ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+type organizationProvider map[uuid.UUID]models.Organization
+
+func (p organizationProvider) Get(ctx context.Context, id uuid.UUID) (models.Organization, error) {
+	org, ok := p[id]
+	if !ok {
+		return models.Organization{}, services.ErrOrganizationNotFound
+	}
+
+	return org, nil
+}
+
+type tokenIssuer struct {
+	userId uuid.UUID
+}
+
+func (i *tokenIssuer) IssueTokens(ctx context.Context, userId uuid.UUID, clientId, scope string, accessTokenTTL time.Duration) (models.JWTokens, error) {
+	i.userId = userId
+
+	return models.JWTokens{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+type plainHasher struct{}
+
+func (plainHasher) Hash(password string) ([]byte, error) {
+	return []byte(password), nil
+}
+
+type redpandaClient struct {
+	events []*redpanda.UserInvitedEvent
+}
+
+func (c *redpandaClient) UserInvited(ctx context.Context, event *redpanda.UserInvitedEvent) error {
+	c.events = append(c.events, event)
+
+	return nil
+}
+
+type testSetup struct {
+	service  *Invites
+	storage  *memoryInviteStorage
+	issuer   *tokenIssuer
+	redpanda *redpandaClient
+	org      models.Organization
+}
+
+func setupService(t *testing.T) (testSetup, context.Context) {
+	t.Helper()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	org := models.Organization{
+		Id:   uuid.New(),
+		Slug: "acme",
+		Name: "Acme",
+		Settings: models.OrganizationSettings{
+			PasswordPolicy: models.PasswordPolicySettings{MinLength: 12},
+		},
+	}
+
+	s := testSetup{
+		storage: &memoryInviteStorage{
+			users:   map[uuid.UUID]models.User{},
+			invites: map[uuid.UUID]models.Invite{},
+		},
+		issuer:   &tokenIssuer{},
+		redpanda: &redpandaClient{},
+		org:      org,
+	}
+
+	policy := password.NewPolicy(password.PolicyConfig{MinLength: 8, MaxLength: 64}, nil)
+
+	s.service = New(ctx, s.redpanda, s.storage, s.storage, organizationProvider{org.Id: org}, s.issuer,
+		plainHasher{}, policy, time.Hour)
+
+	return s, ctx
+}
+
+func TestInviteAccept(t *testing.T) {
+	s, ctx := setupService(t)
+
+	admin := uuid.New()
+
+	invite, err := s.service.Invite(ctx, models.Invite{
+		OrganizationId: s.org.Id,
+		Email:          "jane@example.com",
+		Name:           "Jane",
+		Surname:        "Doe",
+		InvitedBy:      admin,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(s.redpanda.events) != 1 {
+		t.Fatalf("expected one event, got %d", len(s.redpanda.events))
+	}
+
+	event := s.redpanda.events[0]
+	if event.UserID != invite.UserId.String() || event.OrganizationID != s.org.Id.String() || event.InvitedBy != admin.String() {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	if _, err := s.service.Invite(ctx, models.Invite{OrganizationId: s.org.Id, Email: "jane@example.com"}); !errors.Is(err, services.ErrUserAlreadyExists) {
+		t.Errorf("expected user already exists, got %v", err)
+	}
+
+	// the policy of the organization asks for 12 characters
+	var policyErr *services.PasswordPolicyError
+	if _, err := s.service.Accept(ctx, event.Token, "short-pass"); !errors.As(err, &policyErr) {
+		t.Errorf("expected password policy error, got %v", err)
+	}
+
+	tokens, err := s.service.Accept(ctx, event.Token, "long-enough-pass")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tokens.AccessToken == "" || s.issuer.userId != invite.UserId {
+		t.Errorf("tokens are not issued for the invited user")
+	}
+
+	if string(s.storage.users[invite.UserId].PassHash) != "long-enough-pass" {
+		t.Errorf("password is not set")
+	}
+
+	if _, err := s.service.Accept(ctx, event.Token, "long-enough-pass"); !errors.Is(err, services.ErrInviteNotFound) {
+		t.Errorf("expected invite not found on a second accept, got %v", err)
+	}
+
+	if _, err := s.service.Accept(ctx, "not-a-token", "long-enough-pass"); !errors.Is(err, services.ErrInviteNotFound) {
+		t.Errorf("expected invite not found for an invalid token, got %v", err)
+	}
+}
+
+func TestResendRevoke(t *testing.T) {
+	s, ctx := setupService(t)
+
+	invite, err := s.service.Invite(ctx, models.Invite{
+		OrganizationId: s.org.Id,
+		Email:          "jane@example.com",
+		Name:           "Jane",
+		Surname:        "Doe",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := s.service.Resend(ctx, uuid.Nil, invite.UserId); !errors.Is(err, services.ErrInviteNotFound) {
+		t.Errorf("expected invite not found outside of the organization, got %v", err)
+	}
+
+	if err := s.service.Resend(ctx, s.org.Id, invite.UserId); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(s.redpanda.events) != 2 {
+		t.Fatalf("expected two events, got %d", len(s.redpanda.events))
+	}
+
+	// the resent token replaces the first one
+	if _, err := s.service.Accept(ctx, s.redpanda.events[0].Token, "long-enough-pass"); !errors.Is(err, services.ErrInviteNotFound) {
+		t.Errorf("expected invite not found for the first token, got %v", err)
+	}
+
+	invites, err := s.service.List(ctx, s.org.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(invites) != 1 || invites[0].UserId != invite.UserId {
+		t.Errorf("unexpected invites: %+v", invites)
+	}
+
+	if err := s.service.Revoke(ctx, s.org.Id, invite.UserId); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := s.service.Accept(ctx, s.redpanda.events[1].Token, "long-enough-pass"); !errors.Is(err, services.ErrInviteNotFound) {
+		t.Errorf("expected invite not found after revoke, got %v", err)
+	}
+
+	if err := s.service.Revoke(ctx, s.org.Id, invite.UserId); !errors.Is(err, services.ErrInviteNotFound) {
+		t.Errorf("expected invite not found, got %v", err)
+	}
+}
+
+// invite tokens outlive the signing keys, they are opaque and accepted until the invite expires
+func TestAcceptOpaqueToken(t *testing.T) {
+	s, ctx := setupService(t)
+
+	invite, err := s.service.Invite(ctx, models.Invite{
+		OrganizationId: s.org.Id,
+		Email:          "jane@example.com",
+		Name:           "Jane",
+		Surname:        "Doe",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	token := s.redpanda.events[0].Token
+	if strings.Contains(token, ".") {
+		t.Errorf("invite token is a jwt: %s", token)
+	}
+
+	stored := s.storage.invites[invite.UserId]
+	if len(stored.TokenHash) == 0 || bytes.Contains(stored.TokenHash, []byte(token)) {
+		t.Errorf("unexpected token hash: %x", stored.TokenHash)
+	}
+
+	// an expired invite is not accepted
+	stored.ExpiresAt = time.Now().Add(-time.Minute)
+	s.storage.invites[invite.UserId] = stored
+
+	if _, err := s.service.Accept(ctx, token, "long-enough-pass"); !errors.Is(err, services.ErrInviteNotFound) {
+		t.Errorf("expected invite not found for an expired invite, got %v", err)
+	}
+
+	stored.ExpiresAt = time.Now().Add(time.Minute)
+	s.storage.invites[invite.UserId] = stored
+
+	if _, err := s.service.Accept(ctx, token, "long-enough-pass"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
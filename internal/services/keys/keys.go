@@ -0,0 +1,282 @@
+package keys
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// new keys are published this long before they start signing,
+	// so verifiers that cache the key set have time to pick them up
+	publishDelay  = 5 * time.Minute
+	checkInterval = time.Minute
+)
+
+var ErrNoSigningKey = errors.New("no signing key")
+
+type KeyStorage interface {
+	RotateSigningKey(ctx context.Context, key models.SigningKey, retireAt time.Time) error
+	ProvideSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	DeleteExpiredSigningKeys(ctx context.Context) error
+}
+
+// Manager keeps the ring of signing keys. The newest published key signs tokens,
+// older keys stay in the key set until every token they signed has expired
+type Manager struct {
+	log *logger.Logger
+
+	keyStorage KeyStorage
+
+	updateInterval time.Duration
+	tokenTTL       time.Duration
+
+	mu          sync.RWMutex
+	signingKey  *ecdsa.PrivateKey
+	keySet      jwks.JWKS
+	subscribers []chan jwks.JWKS
+
+	stopCh chan struct{}
+}
+
+func New(ctx context.Context, keyStorage KeyStorage, updateInterval, tokenTTL time.Duration) (*Manager, error) {
+	const op = "keys.New"
+
+	m := &Manager{
+		log:            logger.GetLoggerFromCtx(ctx),
+		keyStorage:     keyStorage,
+		updateInterval: updateInterval,
+		tokenTTL:       tokenTTL,
+		stopCh:         make(chan struct{}),
+	}
+
+	if err := m.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// Run rotates the keys every update interval until Stop is called
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.refresh(ctx); err != nil {
+				m.log.Error(ctx, "failed to refresh signing keys", zap.Error(err))
+			}
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+func (m *Manager) Stop(ctx context.Context) {
+	close(m.stopCh)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ch := range m.subscribers {
+		close(ch)
+	}
+	m.subscribers = nil
+
+	m.log.Info(ctx, "key manager stopped")
+}
+
+func (m *Manager) SigningKey() *ecdsa.PrivateKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.signingKey
+}
+
+func (m *Manager) PublicKeys(ctx context.Context) (jwks.JWKS, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.keySet, nil
+}
+
+// Subscribe returns a channel that receives the current key set and every update of it
+func (m *Manager) Subscribe() <-chan jwks.JWKS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan jwks.JWKS, 1)
+	ch <- m.keySet
+	m.subscribers = append(m.subscribers, ch)
+
+	return ch
+}
+
+// refresh reloads the ring, so that several instances converge on the same keys,
+// and rotates it when the newest key is older than the update interval
+func (m *Manager) refresh(ctx context.Context) error {
+	const op = "keys.Manager.refresh"
+
+	now := time.Now()
+
+	keys, err := m.keyStorage.ProvideSigningKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if m.rotationDue(keys, now) {
+		if err := m.rotate(ctx, now); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if keys, err = m.keyStorage.ProvideSigningKeys(ctx); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if err := m.keyStorage.DeleteExpiredSigningKeys(ctx); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	signingKey, err := m.selectSigningKey(keys, now)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	publicKeys := make([]*ecdsa.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		privateKey, err := jwt.DecodePrivateKey(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		publicKeys = append(publicKeys, &privateKey.PublicKey)
+	}
+
+	m.update(signingKey, jwks.NewJWKS(publicKeys...))
+
+	return nil
+}
+
+func (m *Manager) rotationDue(keys []models.SigningKey, now time.Time) bool {
+	if len(keys) == 0 {
+		return true
+	}
+
+	newest := keys[len(keys)-1]
+
+	return !newest.CreatedAt.Add(m.updateInterval).After(now) || !m.canSign(newest, now)
+}
+
+func (m *Manager) rotate(ctx context.Context, now time.Time) error {
+	const op = "keys.Manager.rotate"
+
+	privateKey, _, err := jwt.GenerateKeys()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	encoded, err := jwt.EncodePrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	key := models.SigningKey{
+		Id:         jwks.KeyID(&privateKey.PublicKey),
+		PrivateKey: encoded,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(m.updateInterval + publishDelay + m.tokenTTL),
+	}
+
+	// previous keys keep signing until the new one is published
+	// and stay valid until the tokens signed by them expire
+	retireAt := now.Add(publishDelay + m.tokenTTL)
+
+	if err := m.keyStorage.RotateSigningKey(ctx, key, retireAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.log.Info(ctx, "signing key rotated", zap.String("kid", key.Id))
+
+	return nil
+}
+
+// selectSigningKey prefers the newest key published at least publishDelay ago
+func (m *Manager) selectSigningKey(keys []models.SigningKey, now time.Time) (*ecdsa.PrivateKey, error) {
+	const op = "keys.Manager.selectSigningKey"
+
+	var selected *models.SigningKey
+	for i := range keys {
+		if !m.canSign(keys[i], now) {
+			continue
+		}
+
+		if selected == nil || !keys[i].CreatedAt.Add(publishDelay).After(now) {
+			selected = &keys[i]
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoSigningKey)
+	}
+
+	privateKey, err := jwt.DecodePrivateKey(selected.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return privateKey, nil
+}
+
+// canSign reports whether a token signed now expires before the key does
+func (m *Manager) canSign(key models.SigningKey, now time.Time) bool {
+	return !key.ExpiresAt.Before(now.Add(m.tokenTTL))
+}
+
+func (m *Manager) update(signingKey *ecdsa.PrivateKey, keySet jwks.JWKS) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.signingKey = signingKey
+
+	if sameKeys(m.keySet, keySet) {
+		return
+	}
+
+	m.keySet = keySet
+
+	for _, ch := range m.subscribers {
+		// drop the stale set if the subscriber has not read it yet
+		select {
+		case <-ch:
+		default:
+		}
+
+		ch <- keySet
+	}
+}
+
+func sameKeys(a, b jwks.JWKS) bool {
+	if len(a.Keys) != len(b.Keys) {
+		return false
+	}
+
+	for i := range a.Keys {
+		if a.Keys[i].Kid != b.Keys[i].Kid {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,127 @@
+package keys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryKeyStorage struct {
+	keys []models.SigningKey
+}
+
+func (s *memoryKeyStorage) RotateSigningKey(ctx context.Context, key models.SigningKey, retireAt time.Time) error {
+	for i := range s.keys {
+		if s.keys[i].ExpiresAt.After(retireAt) {
+			s.keys[i].ExpiresAt = retireAt
+		}
+	}
+
+	s.keys = append(s.keys, key)
+
+	return nil
+}
+
+func (s *memoryKeyStorage) ProvideSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	keys := make([]models.SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if key.ExpiresAt.After(time.Now()) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *memoryKeyStorage) DeleteExpiredSigningKeys(ctx context.Context) error {
+	keys, _ := s.ProvideSigningKeys(context.Background())
+	s.keys = keys
+
+	return nil
+}
+
+func TestNewCreatesSigningKey(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	keyStorage := &memoryKeyStorage{}
+
+	manager, err := New(ctx, keyStorage, time.Hour, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(keyStorage.keys) != 1 {
+		t.Fatalf("unexpected number of stored keys: %d", len(keyStorage.keys))
+	}
+
+	keySet, err := manager.PublicKeys(ctx)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(keySet.Keys) != 1 {
+		t.Fatalf("unexpected number of keys: %d", len(keySet.Keys))
+	}
+
+	if keySet.Keys[0].Kid != jwks.KeyID(&manager.SigningKey().PublicKey) {
+		t.Errorf("signing key is not published")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	keyStorage := &memoryKeyStorage{}
+
+	manager, err := New(ctx, keyStorage, time.Hour, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	oldKid := jwks.KeyID(&manager.SigningKey().PublicKey)
+	keySetCh := manager.Subscribe()
+	<-keySetCh
+
+	// age the key past the update interval
+	keyStorage.keys[0].CreatedAt = keyStorage.keys[0].CreatedAt.Add(-time.Hour)
+
+	if err := manager.refresh(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(keyStorage.keys) != 2 {
+		t.Fatalf("unexpected number of stored keys: %d", len(keyStorage.keys))
+	}
+
+	keySet := <-keySetCh
+	if len(keySet.Keys) != 2 {
+		t.Fatalf("unexpected number of keys: %d", len(keySet.Keys))
+	}
+
+	// the new key is published but does not sign until publishDelay passes
+	if kid := jwks.KeyID(&manager.SigningKey().PublicKey); kid != oldKid {
+		t.Errorf("unexpected signing key: %v", kid)
+	}
+
+	keyStorage.keys[1].CreatedAt = keyStorage.keys[1].CreatedAt.Add(-publishDelay)
+
+	if err := manager.refresh(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if kid := jwks.KeyID(&manager.SigningKey().PublicKey); kid != keyStorage.keys[1].Id {
+		t.Errorf("unexpected signing key: %v", kid)
+	}
+
+	manager.Stop(ctx)
+}
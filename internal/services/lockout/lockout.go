@@ -0,0 +1,145 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type Config struct {
+	// failures per email before the account is locked
+	MaxAttempts int64 `yaml:"max_attempts" env-required:"true" env:"LOCKOUT_MAX_ATTEMPTS"`
+	// failures per client ip before the ip is locked
+	IPMaxAttempts int64 `yaml:"ip_max_attempts" env-required:"true" env:"LOCKOUT_IP_MAX_ATTEMPTS"`
+	// the first lock lasts BaseDelay, every next failure doubles it up to MaxDelay
+	BaseDelay time.Duration `yaml:"base_delay" env-required:"true" env:"LOCKOUT_BASE_DELAY"`
+	MaxDelay  time.Duration `yaml:"max_delay" env-required:"true" env:"LOCKOUT_MAX_DELAY"`
+	// failures are forgotten after this long without a new one
+	Window time.Duration `yaml:"window" env-required:"true" env:"LOCKOUT_WINDOW"`
+}
+
+type AttemptsStorage interface {
+	IncrLoginFailures(ctx context.Context, subject string, window time.Duration) (int64, error)
+	ResetLoginFailures(ctx context.Context, subject string) error
+	LockLogin(ctx context.Context, subject string, ttl time.Duration) error
+	LoginLockTTL(ctx context.Context, subject string) (time.Duration, error)
+}
+
+// Lockout counts failed logins by email and by client ip
+type Lockout struct {
+	log *logger.Logger
+
+	attemptsStorage AttemptsStorage
+
+	cfg Config
+}
+
+func New(ctx context.Context, attemptsStorage AttemptsStorage, cfg Config) *Lockout {
+	return &Lockout{
+		log:             logger.GetLoggerFromCtx(ctx),
+		attemptsStorage: attemptsStorage,
+		cfg:             cfg,
+	}
+}
+
+// Check returns services.ErrAccountLocked if the email or the ip is locked
+func (l *Lockout) Check(ctx context.Context, email, ip string) error {
+	const op = "lockout.Check"
+
+	for _, subject := range subjects(email, ip) {
+		ttl, err := l.attemptsStorage.LoginLockTTL(ctx, subject)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if ttl > 0 {
+			return fmt.Errorf("%s: %w", op, services.ErrAccountLocked)
+		}
+	}
+
+	return nil
+}
+
+// Fail records a failed login. If it locks the account,
+// the time until which it is locked is returned
+func (l *Lockout) Fail(ctx context.Context, email, ip string) (time.Time, error) {
+	const op = "lockout.Fail"
+
+	var lockedUntil time.Time
+
+	for _, subject := range subjects(email, ip) {
+		failures, err := l.attemptsStorage.IncrLoginFailures(ctx, subject, l.cfg.Window)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		maxAttempts := l.cfg.MaxAttempts
+		if strings.HasPrefix(subject, ipSubject) {
+			maxAttempts = l.cfg.IPMaxAttempts
+		}
+
+		delay := l.delay(failures, maxAttempts)
+		if delay == 0 {
+			continue
+		}
+
+		if err := l.attemptsStorage.LockLogin(ctx, subject, delay); err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if subject == emailSubject+normalizeEmail(email) {
+			lockedUntil = time.Now().Add(delay)
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+// Reset forgets the failures of the email after a successful login.
+// Failures of the ip are kept, so that one known password does not unlock guessing others,
+// they expire after the window
+func (l *Lockout) Reset(ctx context.Context, email string) error {
+	const op = "lockout.Reset"
+
+	if err := l.attemptsStorage.ResetLoginFailures(ctx, emailSubject+normalizeEmail(email)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// delay returns the lock duration after the given number of failures
+func (l *Lockout) delay(failures, maxAttempts int64) time.Duration {
+	if maxAttempts <= 0 || failures < maxAttempts {
+		return 0
+	}
+
+	delay := l.cfg.BaseDelay
+	for i := maxAttempts; i < failures && delay < l.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, l.cfg.MaxDelay)
+}
+
+const (
+	emailSubject = "email:"
+	ipSubject    = "ip:"
+)
+
+func subjects(email, ip string) []string {
+	subjects := []string{emailSubject + normalizeEmail(email)}
+	if ip != "" {
+		subjects = append(subjects, ipSubject+ip)
+	}
+
+	return subjects
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
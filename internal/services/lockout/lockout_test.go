@@ -0,0 +1,173 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryAttemptsStorage struct {
+	failures map[string]int64
+	locks    map[string]time.Duration
+}
+
+func newMemoryAttemptsStorage() *memoryAttemptsStorage {
+	return &memoryAttemptsStorage{
+		failures: make(map[string]int64),
+		locks:    make(map[string]time.Duration),
+	}
+}
+
+func (s *memoryAttemptsStorage) IncrLoginFailures(ctx context.Context, subject string, window time.Duration) (int64, error) {
+	s.failures[subject]++
+
+	return s.failures[subject], nil
+}
+
+func (s *memoryAttemptsStorage) ResetLoginFailures(ctx context.Context, subject string) error {
+	delete(s.failures, subject)
+
+	return nil
+}
+
+func (s *memoryAttemptsStorage) LockLogin(ctx context.Context, subject string, ttl time.Duration) error {
+	s.locks[subject] = ttl
+
+	return nil
+}
+
+func (s *memoryAttemptsStorage) LoginLockTTL(ctx context.Context, subject string) (time.Duration, error) {
+	return s.locks[subject], nil
+}
+
+// unlock simulates the lock expiring
+func (s *memoryAttemptsStorage) unlock() {
+	s.locks = make(map[string]time.Duration)
+}
+
+func TestLockout(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	attemptsStorage := newMemoryAttemptsStorage()
+
+	lockout := New(ctx, attemptsStorage, Config{
+		MaxAttempts:   3,
+		IPMaxAttempts: 100,
+		BaseDelay:     time.Minute,
+		MaxDelay:      5 * time.Minute,
+		Window:        time.Hour,
+	})
+
+	email := "John.Doe@example.com"
+	ip := "10.0.0.1"
+
+	for i := 0; i < 2; i++ {
+		lockedUntil, err := lockout.Fail(ctx, email, ip)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !lockedUntil.IsZero() {
+			t.Fatalf("locked after %d failures", i+1)
+		}
+	}
+
+	if err := lockout.Check(ctx, email, ip); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	lockedUntil, err := lockout.Fail(ctx, email, ip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if lockedUntil.IsZero() {
+		t.Fatalf("not locked after max attempts")
+	}
+
+	// emails are case insensitive
+	if err := lockout.Check(ctx, "john.doe@example.com", "10.0.0.2"); !errors.Is(err, services.ErrAccountLocked) {
+		t.Errorf("expected account locked, got %v", err)
+	}
+
+	// every next failure doubles the lock up to the max delay
+	expected := []time.Duration{2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for _, delay := range expected {
+		attemptsStorage.unlock()
+
+		if _, err := lockout.Fail(ctx, email, ip); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := attemptsStorage.locks["email:john.doe@example.com"]; got != delay {
+			t.Errorf("unexpected lock duration: %s, expected %s", got, delay)
+		}
+	}
+
+	attemptsStorage.unlock()
+
+	if err := lockout.Reset(ctx, email); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	lockedUntil, err = lockout.Fail(ctx, email, ip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !lockedUntil.IsZero() {
+		t.Errorf("locked after reset")
+	}
+
+	// the ip keeps its failures, a successful login does not unlock guessing other passwords
+	if got := attemptsStorage.failures["ip:"+ip]; got != 8 {
+		t.Errorf("unexpected ip failures: %d", got)
+	}
+}
+
+func TestLockoutByIP(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	attemptsStorage := newMemoryAttemptsStorage()
+
+	lockout := New(ctx, attemptsStorage, Config{
+		MaxAttempts:   3,
+		IPMaxAttempts: 5,
+		BaseDelay:     time.Minute,
+		MaxDelay:      time.Hour,
+		Window:        time.Hour,
+	})
+
+	ip := "10.0.0.1"
+
+	// a different email every time
+	for i := 0; i < 5; i++ {
+		lockedUntil, err := lockout.Fail(ctx, string(rune('a'+i))+"@example.com", ip)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// only email locks are reported
+		if !lockedUntil.IsZero() {
+			t.Errorf("email locked after a single failure")
+		}
+	}
+
+	if err := lockout.Check(ctx, "z@example.com", ip); !errors.Is(err, services.ErrAccountLocked) {
+		t.Errorf("expected account locked, got %v", err)
+	}
+
+	if err := lockout.Check(ctx, "z@example.com", "10.0.0.2"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/device"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/totp"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	recoveryCodesCount = 10
+	recoveryCodeLen    = 10
+)
+
+type UserProvider interface {
+	ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error)
+}
+
+type MFAStorage interface {
+	SaveTOTP(ctx context.Context, userId uuid.UUID, secret string) error
+	ProvideTOTP(ctx context.Context, userId uuid.UUID) (models.TOTP, error)
+	UseTOTPStep(ctx context.Context, userId uuid.UUID, step int64) error
+	ConfirmTOTP(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error
+	DeleteTOTP(ctx context.Context, userId uuid.UUID) error
+	ReplaceRecoveryCodes(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error
+	UseRecoveryCode(ctx context.Context, userId uuid.UUID, codeHash []byte) error
+}
+
+// Lockout counts the failed codes together with the failed logins of the account
+type Lockout interface {
+	Check(ctx context.Context, email, ip string) error
+	Fail(ctx context.Context, email, ip string) (time.Time, error)
+	Reset(ctx context.Context, email string) error
+}
+
+type MFA struct {
+	log *logger.Logger
+
+	userProvider UserProvider
+	mfaStorage   MFAStorage
+	lockout      Lockout
+
+	issuer string
+}
+
+func New(ctx context.Context, userProvider UserProvider, mfaStorage MFAStorage, lockout Lockout, issuer string) *MFA {
+	return &MFA{
+		log:          logger.GetLoggerFromCtx(ctx),
+		userProvider: userProvider,
+		mfaStorage:   mfaStorage,
+		lockout:      lockout,
+		issuer:       issuer,
+	}
+}
+
+// EnrollTOTP generates a secret that becomes active once ConfirmTOTP receives the first code
+func (m *MFA) EnrollTOTP(ctx context.Context, userId uuid.UUID) (models.TOTPEnrollment, error) {
+	const op = "mfa.EnrollTOTP"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	user, err := m.userProvider.ProvideUserById(ctx, userId)
+	if err != nil {
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+		}
+
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.mfaStorage.SaveTOTP(ctx, userId, secret); err != nil {
+		log.Error(ctx, "failed to save totp", zap.Error(err))
+
+		if errors.Is(err, storage.ErrTOTPAlreadyConfirmed) {
+			return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, services.ErrMFAAlreadyEnabled)
+		}
+
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(m.issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the second factor and returns the recovery codes
+func (m *MFA) ConfirmTOTP(ctx context.Context, userId uuid.UUID, code string) ([]string, error) {
+	const op = "mfa.ConfirmTOTP"
+
+	secret, err := m.mfaStorage.ProvideTOTP(ctx, userId)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, services.ErrMFANotEnabled)
+		}
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if secret.Confirmed {
+		return nil, fmt.Errorf("%s: %w", op, services.ErrMFAAlreadyEnabled)
+	}
+
+	err = m.limitAttempts(ctx, userId, func() error {
+		return m.verifyTOTP(ctx, secret, code)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.mfaStorage.ConfirmTOTP(ctx, userId, hashes); err != nil {
+		if errors.Is(err, storage.ErrTOTPAlreadyConfirmed) {
+			return nil, fmt.Errorf("%s: %w", op, services.ErrMFAAlreadyEnabled)
+		}
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns the second factor off, the user must prove they still own it
+func (m *MFA) DisableTOTP(ctx context.Context, userId uuid.UUID, code string) error {
+	const op = "mfa.DisableTOTP"
+
+	err := m.limitAttempts(ctx, userId, func() error {
+		return m.Verify(ctx, userId, code)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.mfaStorage.DeleteTOTP(ctx, userId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes invalidates the previous recovery codes
+func (m *MFA) RegenerateRecoveryCodes(ctx context.Context, userId uuid.UUID, code string) ([]string, error) {
+	const op = "mfa.RegenerateRecoveryCodes"
+
+	secret, err := m.provideEnabledTOTP(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = m.limitAttempts(ctx, userId, func() error {
+		return m.verifyTOTP(ctx, secret, code)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.mfaStorage.ReplaceRecoveryCodes(ctx, userId, hashes); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return codes, nil
+}
+
+func (m *MFA) Enabled(ctx context.Context, userId uuid.UUID) (bool, error) {
+	const op = "mfa.Enabled"
+
+	secret, err := m.mfaStorage.ProvideTOTP(ctx, userId)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return secret.Confirmed, nil
+}
+
+// Verify accepts either a totp code or an unused recovery code
+func (m *MFA) Verify(ctx context.Context, userId uuid.UUID, code string) error {
+	const op = "mfa.Verify"
+
+	secret, err := m.provideEnabledTOTP(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(code) == totp.Digits {
+		if err := m.verifyTOTP(ctx, secret, code); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return nil
+	}
+
+	if err := m.mfaStorage.UseRecoveryCode(ctx, userId, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (m *MFA) provideEnabledTOTP(ctx context.Context, userId uuid.UUID) (models.TOTP, error) {
+	secret, err := m.mfaStorage.ProvideTOTP(ctx, userId)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return models.TOTP{}, services.ErrMFANotEnabled
+		}
+
+		return models.TOTP{}, err
+	}
+
+	if !secret.Confirmed {
+		return models.TOTP{}, services.ErrMFANotEnabled
+	}
+
+	return secret, nil
+}
+
+func (m *MFA) verifyTOTP(ctx context.Context, secret models.TOTP, code string) error {
+	step, err := totp.Validate(secret.Secret, code, time.Now())
+	if err != nil {
+		return services.ErrInvalidCredentials
+	}
+
+	// a code can be used only once
+	if err := m.mfaStorage.UseTOTPStep(ctx, secret.UserId, step); err != nil {
+		if errors.Is(err, storage.ErrTOTPStepUsed) {
+			return services.ErrInvalidCredentials
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// limitAttempts runs the check of a code sent by the signed in user. Wrong codes count as
+// failed logins of the account, so the code can not be guessed after taking over a session
+func (m *MFA) limitAttempts(ctx context.Context, userId uuid.UUID, verify func() error) error {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	user, err := m.userProvider.ProvideUserById(ctx, userId)
+	if err != nil {
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return services.ErrUserNotFound
+		}
+
+		return err
+	}
+
+	ip := device.FromContext(ctx).IP
+
+	if err := m.lockout.Check(ctx, user.Email, ip); err != nil {
+		return err
+	}
+
+	if err := verify(); err != nil {
+		if !errors.Is(err, services.ErrInvalidCredentials) {
+			return err
+		}
+
+		lockedUntil, failErr := m.lockout.Fail(ctx, user.Email, ip)
+		if failErr != nil {
+			log.Error(ctx, "failed to count mfa failure", zap.Error(failErr))
+
+			return failErr
+		}
+
+		if !lockedUntil.IsZero() {
+			log.Error(ctx, "account locked", zap.String("ip", ip), zap.Time("locked_until", lockedUntil))
+
+			return services.ErrAccountLocked
+		}
+
+		return err
+	}
+
+	return m.lockout.Reset(ctx, user.Email)
+}
+
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([][]byte, recoveryCodesCount)
+
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// generateRecoveryCode returns a code like "abcde-fghij"
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, recoveryCodeLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))[:recoveryCodeLen]
+
+	return code[:recoveryCodeLen/2] + "-" + code[recoveryCodeLen/2:], nil
+}
+
+// recovery codes have enough entropy for a plain hash, so they can be looked up directly
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+
+	return sum[:]
+}
@@ -0,0 +1,290 @@
+package mfa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/totp"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryUserProvider struct {
+	user models.User
+}
+
+func (p *memoryUserProvider) ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error) {
+	if p.user.UserInfo.Id != id {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return p.user, nil
+}
+
+type memoryMFAStorage struct {
+	secrets       map[uuid.UUID]models.TOTP
+	recoveryCodes map[uuid.UUID][][]byte
+}
+
+func newMemoryMFAStorage() *memoryMFAStorage {
+	return &memoryMFAStorage{
+		secrets:       make(map[uuid.UUID]models.TOTP),
+		recoveryCodes: make(map[uuid.UUID][][]byte),
+	}
+}
+
+func (s *memoryMFAStorage) SaveTOTP(ctx context.Context, userId uuid.UUID, secret string) error {
+	if s.secrets[userId].Confirmed {
+		return storage.ErrTOTPAlreadyConfirmed
+	}
+
+	s.secrets[userId] = models.TOTP{UserId: userId, Secret: secret}
+
+	return nil
+}
+
+func (s *memoryMFAStorage) ProvideTOTP(ctx context.Context, userId uuid.UUID) (models.TOTP, error) {
+	secret, ok := s.secrets[userId]
+	if !ok {
+		return models.TOTP{}, storage.ErrTOTPNotFound
+	}
+
+	return secret, nil
+}
+
+func (s *memoryMFAStorage) UseTOTPStep(ctx context.Context, userId uuid.UUID, step int64) error {
+	secret := s.secrets[userId]
+	if secret.LastUsedStep >= step {
+		return storage.ErrTOTPStepUsed
+	}
+
+	secret.LastUsedStep = step
+	s.secrets[userId] = secret
+
+	return nil
+}
+
+func (s *memoryMFAStorage) ConfirmTOTP(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error {
+	secret := s.secrets[userId]
+	if secret.Confirmed {
+		return storage.ErrTOTPAlreadyConfirmed
+	}
+
+	secret.Confirmed = true
+	s.secrets[userId] = secret
+	s.recoveryCodes[userId] = codeHashes
+
+	return nil
+}
+
+func (s *memoryMFAStorage) DeleteTOTP(ctx context.Context, userId uuid.UUID) error {
+	delete(s.secrets, userId)
+	delete(s.recoveryCodes, userId)
+
+	return nil
+}
+
+func (s *memoryMFAStorage) ReplaceRecoveryCodes(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error {
+	s.recoveryCodes[userId] = codeHashes
+
+	return nil
+}
+
+func (s *memoryMFAStorage) UseRecoveryCode(ctx context.Context, userId uuid.UUID, codeHash []byte) error {
+	codes := s.recoveryCodes[userId]
+	for i := range codes {
+		if bytes.Equal(codes[i], codeHash) {
+			s.recoveryCodes[userId] = append(codes[:i], codes[i+1:]...)
+			return nil
+		}
+	}
+
+	return storage.ErrRecoveryCodeNotFound
+}
+
+// memoryLockout locks the email after maxAttempts failures
+type memoryLockout struct {
+	maxAttempts int
+	failures    map[string]int
+}
+
+func newMemoryLockout(maxAttempts int) *memoryLockout {
+	return &memoryLockout{
+		maxAttempts: maxAttempts,
+		failures:    make(map[string]int),
+	}
+}
+
+func (l *memoryLockout) Check(ctx context.Context, email, ip string) error {
+	if l.failures[email] >= l.maxAttempts {
+		return services.ErrAccountLocked
+	}
+
+	return nil
+}
+
+func (l *memoryLockout) Fail(ctx context.Context, email, ip string) (time.Time, error) {
+	l.failures[email]++
+	if l.failures[email] >= l.maxAttempts {
+		return time.Now().Add(time.Minute), nil
+	}
+
+	return time.Time{}, nil
+}
+
+func (l *memoryLockout) Reset(ctx context.Context, email string) error {
+	delete(l.failures, email)
+
+	return nil
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	userId := uuid.New()
+	userProvider := &memoryUserProvider{
+		user: models.User{
+			UserInfo: models.UserInfo{Id: userId},
+			UserAuth: models.UserAuth{Id: userId, Email: "john.doe@example.com"},
+		},
+	}
+	mfaStorage := newMemoryMFAStorage()
+
+	mfaService := New(ctx, userProvider, mfaStorage, newMemoryLockout(5), "apphelper")
+
+	enrollment, err := mfaService.EnrollTOTP(ctx, userId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	enabled, err := mfaService.Enabled(ctx, userId)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if enabled {
+		t.Errorf("mfa enabled before confirmation")
+	}
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recoveryCodes, err := mfaService.ConfirmTOTP(ctx, userId, code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(recoveryCodes) != recoveryCodesCount {
+		t.Errorf("unexpected number of recovery codes: %d", len(recoveryCodes))
+	}
+
+	enabled, err = mfaService.Enabled(ctx, userId)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !enabled {
+		t.Errorf("mfa is not enabled after confirmation")
+	}
+
+	// the same code can not be used twice
+	if err := mfaService.Verify(ctx, userId, code); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+
+	if err := mfaService.Verify(ctx, userId, recoveryCodes[0]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := mfaService.Verify(ctx, userId, recoveryCodes[0]); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+}
+
+func TestTOTPAttemptsLimited(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	userId := uuid.New()
+	userProvider := &memoryUserProvider{
+		user: models.User{
+			UserInfo: models.UserInfo{Id: userId},
+			UserAuth: models.UserAuth{Id: userId, Email: "john.doe@example.com"},
+		},
+	}
+	mfaStorage := newMemoryMFAStorage()
+	lockout := newMemoryLockout(3)
+
+	mfaService := New(ctx, userProvider, mfaStorage, lockout, "apphelper")
+
+	enrollment, err := mfaService.EnrollTOTP(ctx, userId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a code that differs from the valid one
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := mfaService.ConfirmTOTP(ctx, userId, wrongCode); !errors.Is(err, services.ErrInvalidCredentials) {
+			t.Errorf("expected invalid credentials, got %v", err)
+		}
+	}
+
+	if _, err := mfaService.ConfirmTOTP(ctx, userId, wrongCode); !errors.Is(err, services.ErrAccountLocked) {
+		t.Errorf("expected account locked, got %v", err)
+	}
+
+	// the valid code is rejected while the account is locked
+	if _, err := mfaService.ConfirmTOTP(ctx, userId, code); !errors.Is(err, services.ErrAccountLocked) {
+		t.Errorf("expected account locked, got %v", err)
+	}
+
+	if mfaStorage.secrets[userId].Confirmed {
+		t.Errorf("mfa is confirmed while the account is locked")
+	}
+
+	lockout.failures = make(map[string]int)
+
+	recoveryCodes, err := mfaService.ConfirmTOTP(ctx, userId, code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(mfaStorage.recoveryCodes[userId]) != len(recoveryCodes) {
+		t.Errorf("recovery codes are not stored with the confirmation")
+	}
+
+	if err := mfaService.DisableTOTP(ctx, userId, "wrong-code"); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+
+	if _, err := mfaService.RegenerateRecoveryCodes(ctx, userId, wrongCode); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+
+	if lockout.failures["john.doe@example.com"] != 2 {
+		t.Errorf("unexpected number of failures: %d", lockout.failures["john.doe@example.com"])
+	}
+}
@@ -0,0 +1,194 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	grantDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// consonants only, so user codes are easy to type and do not spell words (RFC 8628 section 6.1)
+	userCodeCharset  = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLen      = 8
+	userCodeAttempts = 5
+)
+
+// AuthorizeDevice starts the device flow of a client. The user enters the user code
+// on another device and the client polls the token endpoint with the device code
+func (o *OAuth) AuthorizeDevice(ctx context.Context, clientId, clientSecret, scope string) (models.DeviceCode, error) {
+	const op = "oauth.AuthorizeDevice"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	client, err := o.clients.AuthenticateClient(ctx, clientId, clientSecret)
+	if err != nil {
+		if errors.Is(err, services.ErrClientNotFound) || errors.Is(err, services.ErrInvalidCredentials) {
+			return models.DeviceCode{}, fmt.Errorf("%s: %w", op, oauthError(ErrCodeInvalidClient, "client authentication failed"))
+		}
+
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !slices.Contains(client.GrantTypes, grantDeviceCode) {
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, oauthError(ErrCodeUnauthorizedClient, "the client cannot use the device flow"))
+	}
+
+	for _, requested := range strings.Fields(scope) {
+		if !slices.Contains(client.Scopes, requested) {
+			return models.DeviceCode{}, fmt.Errorf("%s: %w", op, oauthError(ErrCodeInvalidScope, fmt.Sprintf("scope %q is not allowed", requested)))
+		}
+	}
+
+	deviceCode, err := randomCode()
+	if err != nil {
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	authorization := models.DeviceAuthorization{
+		ClientId: client.Id,
+		Scope:    scope,
+	}
+
+	var userCode string
+	for range userCodeAttempts {
+		if userCode, err = randomUserCode(); err != nil {
+			return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		err = o.deviceStorage.CreateDeviceAuthorization(ctx, deviceCode, userCode, authorization, o.cfg.DeviceCodeTTL)
+		if !errors.Is(err, storage.ErrUserCodeExists) {
+			break
+		}
+	}
+	if err != nil {
+		log.Error(ctx, "failed to create device authorization", zap.Error(err))
+
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	displayed := userCode[:userCodeLen/2] + "-" + userCode[userCodeLen/2:]
+
+	return models.DeviceCode{
+		DeviceCode:              deviceCode,
+		UserCode:                displayed,
+		VerificationURI:         o.cfg.DeviceVerificationURL,
+		VerificationURIComplete: withQuery(o.cfg.DeviceVerificationURL, url.Values{"user_code": {displayed}}),
+		ExpiresIn:               o.cfg.DeviceCodeTTL,
+		Interval:                o.cfg.DevicePollInterval,
+	}, nil
+}
+
+// ApproveDevice grants the device authorization of the user code to the user
+func (o *OAuth) ApproveDevice(ctx context.Context, userId uuid.UUID, userCode string) error {
+	const op = "oauth.ApproveDevice"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := o.deviceStorage.ApproveDeviceAuthorization(ctx, normalizeUserCode(userCode), userId); err != nil {
+		if errors.Is(err, storage.ErrUserCodeNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrUserCodeNotFound)
+		}
+
+		log.Error(ctx, "failed to approve device authorization", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "device authorization approved", zap.String("user_id", userId.String()))
+
+	return nil
+}
+
+// deviceCode answers a poll of the token endpoint, the tokens are issued once the user approves
+func (o *OAuth) deviceCode(ctx context.Context, client models.OAuthClient, req models.TokenRequest) (models.OAuthTokens, error) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if req.DeviceCode == "" {
+		return models.OAuthTokens{}, oauthError(ErrCodeInvalidRequest, "device_code is required")
+	}
+
+	allowed, err := o.deviceStorage.AllowDevicePoll(ctx, req.DeviceCode, o.cfg.DevicePollInterval)
+	if err != nil {
+		log.Error(ctx, "failed to throttle device poll", zap.Error(err))
+
+		return models.OAuthTokens{}, err
+	}
+
+	if !allowed {
+		return models.OAuthTokens{}, oauthError(ErrCodeSlowDown, "polling too fast")
+	}
+
+	authorization, err := o.deviceStorage.ProvideDeviceAuthorization(ctx, req.DeviceCode)
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return models.OAuthTokens{}, oauthError(ErrCodeExpiredToken, "invalid or expired device code")
+		}
+
+		log.Error(ctx, "failed to provide device authorization", zap.Error(err))
+
+		return models.OAuthTokens{}, err
+	}
+
+	if authorization.ClientId != client.Id {
+		return models.OAuthTokens{}, oauthError(ErrCodeInvalidGrant, "device code was issued to another client")
+	}
+
+	if !authorization.Approved {
+		return models.OAuthTokens{}, oauthError(ErrCodeAuthorizationPending, "the user has not approved the request yet")
+	}
+
+	deleted, err := o.deviceStorage.DeleteDeviceAuthorization(ctx, req.DeviceCode)
+	if err != nil {
+		log.Error(ctx, "failed to delete device authorization", zap.Error(err))
+
+		return models.OAuthTokens{}, err
+	}
+
+	if !deleted {
+		return models.OAuthTokens{}, oauthError(ErrCodeExpiredToken, "invalid or expired device code")
+	}
+
+	return o.issueTokens(ctx, client, models.AuthorizationGrant{
+		ClientId: client.Id,
+		UserId:   authorization.UserId,
+		Scope:    authorization.Scope,
+		AuthTime: authorization.ApprovedAt,
+	})
+}
+
+func randomUserCode() (string, error) {
+	code := make([]byte, userCodeLen)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeCharset))))
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = userCodeCharset[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// normalizeUserCode drops the separators and the case the user typed
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+
+		return r
+	}, strings.ToUpper(userCode))
+}
@@ -0,0 +1,168 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+)
+
+type memoryDeviceStorage struct {
+	authorizations map[string]models.DeviceAuthorization
+	userCodes      map[string]string
+	polled         map[string]bool
+}
+
+func newMemoryDeviceStorage() *memoryDeviceStorage {
+	return &memoryDeviceStorage{
+		authorizations: make(map[string]models.DeviceAuthorization),
+		userCodes:      make(map[string]string),
+		polled:         make(map[string]bool),
+	}
+}
+
+func (s *memoryDeviceStorage) CreateDeviceAuthorization(ctx context.Context, deviceCode, userCode string, authorization models.DeviceAuthorization, ttl time.Duration) error {
+	if _, ok := s.userCodes[userCode]; ok {
+		return storage.ErrUserCodeExists
+	}
+
+	s.userCodes[userCode] = deviceCode
+	s.authorizations[deviceCode] = authorization
+
+	return nil
+}
+
+func (s *memoryDeviceStorage) ProvideDeviceAuthorization(ctx context.Context, deviceCode string) (models.DeviceAuthorization, error) {
+	authorization, ok := s.authorizations[deviceCode]
+	if !ok {
+		return models.DeviceAuthorization{}, storage.ErrDeviceCodeNotFound
+	}
+
+	return authorization, nil
+}
+
+func (s *memoryDeviceStorage) ApproveDeviceAuthorization(ctx context.Context, userCode string, userId uuid.UUID) error {
+	deviceCode, ok := s.userCodes[userCode]
+	if !ok {
+		return storage.ErrUserCodeNotFound
+	}
+
+	delete(s.userCodes, userCode)
+
+	authorization := s.authorizations[deviceCode]
+	authorization.Approved = true
+	authorization.UserId = userId
+	authorization.ApprovedAt = time.Now()
+	s.authorizations[deviceCode] = authorization
+
+	return nil
+}
+
+func (s *memoryDeviceStorage) DeleteDeviceAuthorization(ctx context.Context, deviceCode string) (bool, error) {
+	_, ok := s.authorizations[deviceCode]
+	delete(s.authorizations, deviceCode)
+
+	return ok, nil
+}
+
+func (s *memoryDeviceStorage) AllowDevicePoll(ctx context.Context, deviceCode string, interval time.Duration) (bool, error) {
+	if s.polled[deviceCode] {
+		return false, nil
+	}
+
+	s.polled[deviceCode] = true
+
+	return true, nil
+}
+
+// wait simulates the poll interval passing
+func (s *memoryDeviceStorage) wait() {
+	s.polled = make(map[string]bool)
+}
+
+func TestDeviceFlow(t *testing.T) {
+	ctx, oauth, issuer, _ := setup(t)
+	deviceStorage := oauth.deviceStorage.(*memoryDeviceStorage)
+
+	deviceCode, err := oauth.AuthorizeDevice(ctx, deviceClientId, "", "openid profile")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(deviceCode.UserCode) != 9 || deviceCode.Interval != 5*time.Second {
+		t.Errorf("unexpected device code: %+v", deviceCode)
+	}
+
+	req := models.TokenRequest{
+		GrantType:  "urn:ietf:params:oauth:grant-type:device_code",
+		ClientId:   deviceClientId,
+		DeviceCode: deviceCode.DeviceCode,
+	}
+
+	expectError := func(code string) {
+		t.Helper()
+
+		var oauthErr *Error
+		if _, err := oauth.Exchange(ctx, req); !errors.As(err, &oauthErr) || oauthErr.Code != code {
+			t.Errorf("expected %s, got %v", code, err)
+		}
+	}
+
+	expectError(ErrCodeAuthorizationPending)
+	expectError(ErrCodeSlowDown)
+
+	userId := oauth.userProvider.(userProvider).user.UserInfo.Id
+
+	// the user code is accepted as typed by the user
+	if err := oauth.ApproveDevice(ctx, userId, " "+deviceCode.UserCode[:4]+deviceCode.UserCode[5:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := oauth.ApproveDevice(ctx, userId, deviceCode.UserCode); !errors.Is(err, services.ErrUserCodeNotFound) {
+		t.Errorf("user code is used twice: %v", err)
+	}
+
+	deviceStorage.wait()
+
+	tokens, err := oauth.Exchange(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || issuer.userId != userId || issuer.clientId != deviceClientId {
+		t.Errorf("unexpected tokens: %+v", tokens)
+	}
+
+	deviceStorage.wait()
+	expectError(ErrCodeExpiredToken)
+}
+
+func TestDeviceFlowClients(t *testing.T) {
+	ctx, oauth, _, _ := setup(t)
+
+	var oauthErr *Error
+	if _, err := oauth.AuthorizeDevice(ctx, clientId, "", "openid"); !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeUnauthorizedClient {
+		t.Errorf("expected unauthorized client, got %v", err)
+	}
+
+	deviceCode, err := oauth.AuthorizeDevice(ctx, deviceClientId, "", "openid")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// another client cannot redeem the device code
+	_, err = oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:    "urn:ietf:params:oauth:grant-type:device_code",
+		ClientId:     serviceClientId,
+		ClientSecret: serviceClientSecret,
+		DeviceCode:   deviceCode.DeviceCode,
+	})
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeUnauthorizedClient {
+		t.Errorf("expected unauthorized client, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package oauth
+
+// Error codes of the authorization and token endpoints (RFC 6749 sections 4.1.2.1 and 5.2)
+const (
+	ErrCodeInvalidRequest          = "invalid_request"
+	ErrCodeInvalidClient           = "invalid_client"
+	ErrCodeInvalidGrant            = "invalid_grant"
+	ErrCodeInvalidScope            = "invalid_scope"
+	ErrCodeUnauthorizedClient      = "unauthorized_client"
+	ErrCodeUnsupportedGrantType    = "unsupported_grant_type"
+	ErrCodeUnsupportedResponseType = "unsupported_response_type"
+)
+
+// Error codes of the device flow token requests (RFC 8628 section 3.5)
+const (
+	ErrCodeAuthorizationPending = "authorization_pending"
+	ErrCodeSlowDown             = "slow_down"
+	ErrCodeExpiredToken         = "expired_token"
+)
+
+// Error codes of protected resources (RFC 6750 section 3.1)
+const (
+	ErrCodeInvalidToken      = "invalid_token"
+	ErrCodeInsufficientScope = "insufficient_scope"
+)
+
+// Error is an OAuth error response
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *Error {
+	return &Error{
+		Code:        code,
+		Description: description,
+	}
+}
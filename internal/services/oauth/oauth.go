@@ -0,0 +1,616 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	responseTypeCode       = "code"
+	codeChallengeS256      = "S256"
+	grantAuthorizationCode = "authorization_code"
+	grantClientCredentials = "client_credentials"
+	grantRefreshToken      = "refresh_token"
+	codeLen                = 32
+)
+
+// code verifier of RFC 7636 section 4.1 and the base64url sha256 challenge of it
+var (
+	codeVerifierRe  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	codeChallengeRe = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+type Config struct {
+	// public url of the server, the iss claim of access and id tokens
+	Issuer string `yaml:"issuer" env-required:"true" env:"OAUTH_ISSUER"`
+	// page that authenticates the user and sends them back to the authorization endpoint
+	LoginURL string        `yaml:"login_url" env-required:"true" env:"OAUTH_LOGIN_URL"`
+	CodeTTL  time.Duration `yaml:"code_ttl" env-required:"true" env:"OAUTH_CODE_TTL"`
+
+	// page where the user enters the user code of the device flow
+	DeviceVerificationURL string        `yaml:"device_verification_url" env-required:"true" env:"OAUTH_DEVICE_VERIFICATION_URL"`
+	DeviceCodeTTL         time.Duration `yaml:"device_code_ttl" env-required:"true" env:"OAUTH_DEVICE_CODE_TTL"`
+	DevicePollInterval    time.Duration `yaml:"device_poll_interval" env-required:"true" env:"OAUTH_DEVICE_POLL_INTERVAL"`
+}
+
+type ClientProvider interface {
+	ProvideClient(ctx context.Context, clientId string) (models.OAuthClient, error)
+	AuthenticateClient(ctx context.Context, clientId, secret string) (models.OAuthClient, error)
+}
+
+type CodeStorage interface {
+	CreateAuthorizationCode(ctx context.Context, code string, grant models.AuthorizationGrant, ttl time.Duration) error
+	TakeAuthorizationCode(ctx context.Context, code string) (models.AuthorizationGrant, error)
+}
+
+type DeviceStorage interface {
+	CreateDeviceAuthorization(ctx context.Context, deviceCode, userCode string, authorization models.DeviceAuthorization, ttl time.Duration) error
+	ProvideDeviceAuthorization(ctx context.Context, deviceCode string) (models.DeviceAuthorization, error)
+	ApproveDeviceAuthorization(ctx context.Context, userCode string, userId uuid.UUID) error
+	DeleteDeviceAuthorization(ctx context.Context, deviceCode string) (bool, error)
+	AllowDevicePoll(ctx context.Context, deviceCode string, interval time.Duration) (bool, error)
+}
+
+type TokenIssuer interface {
+	IssueTokens(ctx context.Context, userId uuid.UUID, clientId, scope string, accessTokenTTL time.Duration) (models.JWTokens, error)
+	RefreshClientTokens(ctx context.Context, clientId, refreshToken string, accessTokenTTL time.Duration) (models.JWTokens, string, error)
+}
+
+type UserProvider interface {
+	ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error)
+}
+
+type KeyProvider interface {
+	SigningKey() *ecdsa.PrivateKey
+	PublicKeys(ctx context.Context) (jwks.JWKS, error)
+}
+
+type ServiceAccountAuthenticator interface {
+	Authenticate(ctx context.Context, assertion, audience string) (models.ServiceAccount, error)
+}
+
+// OAuth is the authorization server of the authorization code flow with PKCE,
+// the client credentials grant and the device flow, and the OpenID Connect provider
+type OAuth struct {
+	log *logger.Logger
+
+	clients       ClientProvider
+	codeStorage   CodeStorage
+	deviceStorage DeviceStorage
+	tokenIssuer   TokenIssuer
+	userProvider  UserProvider
+	keyProvider   KeyProvider
+
+	serviceAccounts ServiceAccountAuthenticator
+
+	accessTokenTTL time.Duration
+	// aud of the access tokens
+	audience string
+
+	cfg Config
+}
+
+func New(ctx context.Context,
+	clients ClientProvider,
+	codeStorage CodeStorage,
+	deviceStorage DeviceStorage,
+	tokenIssuer TokenIssuer,
+	userProvider UserProvider,
+	keyProvider KeyProvider,
+	serviceAccounts ServiceAccountAuthenticator,
+	accessTokenTTL time.Duration,
+	audience string,
+	cfg Config,
+) *OAuth {
+	return &OAuth{
+		log:            logger.GetLoggerFromCtx(ctx),
+		clients:        clients,
+		codeStorage:    codeStorage,
+		deviceStorage:  deviceStorage,
+		tokenIssuer:    tokenIssuer,
+		userProvider:   userProvider,
+		keyProvider:    keyProvider,
+		accessTokenTTL: accessTokenTTL,
+		audience:       audience,
+		cfg:            cfg,
+
+		serviceAccounts: serviceAccounts,
+	}
+}
+
+// Authorize returns the url to redirect the user agent to: the login page if the bearer token
+// does not authenticate the user, otherwise the client redirect uri with the code or an error.
+// An *Error is returned if the client or the redirect uri is invalid, the user must not be redirected then
+func (o *OAuth) Authorize(ctx context.Context, req models.AuthorizationRequest, bearerToken string) (string, error) {
+	const op = "oauth.Authorize"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	client, err := o.clients.ProvideClient(ctx, req.ClientId)
+	if err != nil {
+		if errors.Is(err, services.ErrClientNotFound) {
+			return "", fmt.Errorf("%s: %w", op, oauthError(ErrCodeInvalidClient, "unknown client"))
+		}
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	redirectURI, err := resolveRedirectURI(client, req.RedirectURI)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !slices.Contains(client.GrantTypes, grantAuthorizationCode) {
+		return errorRedirect(redirectURI, req.State, oauthError(ErrCodeUnauthorizedClient, "the client cannot use the authorization code flow")), nil
+	}
+
+	if err := validateAuthorizationRequest(client, req); err != nil {
+		return errorRedirect(redirectURI, req.State, err), nil
+	}
+
+	userId, err := o.authenticate(ctx, bearerToken)
+	if err != nil {
+		log.Info(ctx, "user is not authenticated, redirecting to login", zap.Error(err))
+
+		return loginRedirect(o.cfg.LoginURL, req), nil
+	}
+
+	code, err := randomCode()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	grant := models.AuthorizationGrant{
+		ClientId:      client.Id,
+		RedirectURI:   req.RedirectURI,
+		UserId:        userId,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      time.Now(),
+	}
+
+	if err := o.codeStorage.CreateAuthorizationCode(ctx, code, grant, o.cfg.CodeTTL); err != nil {
+		log.Error(ctx, "failed to create authorization code", zap.Error(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := url.Values{"code": {code}}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+
+	return withQuery(redirectURI, query), nil
+}
+
+// Exchange is the token endpoint, it redeems an authorization code, an approved device code or
+// a refresh token, or issues a token to a client or a service account for itself.
+// Request errors are returned as *Error
+func (o *OAuth) Exchange(ctx context.Context, req models.TokenRequest) (models.OAuthTokens, error) {
+	const op = "oauth.Exchange"
+
+	if !slices.Contains([]string{grantAuthorizationCode, grantClientCredentials, grantDeviceCode, grantRefreshToken}, req.GrantType) {
+		return models.OAuthTokens{}, fmt.Errorf("%s: %w", op, oauthError(ErrCodeUnsupportedGrantType, fmt.Sprintf("grant type %q is not supported", req.GrantType)))
+	}
+
+	// service accounts authenticate with an assertion instead of a client secret
+	if req.ClientAssertionType != "" || req.ClientAssertion != "" {
+		tokens, err := o.serviceAccountCredentials(ctx, req)
+		if err != nil {
+			return models.OAuthTokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return tokens, nil
+	}
+
+	client, err := o.clients.AuthenticateClient(ctx, req.ClientId, req.ClientSecret)
+	if err != nil {
+		if errors.Is(err, services.ErrClientNotFound) || errors.Is(err, services.ErrInvalidCredentials) {
+			return models.OAuthTokens{}, fmt.Errorf("%s: %w", op, oauthError(ErrCodeInvalidClient, "client authentication failed"))
+		}
+
+		return models.OAuthTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !allowsGrantType(client, req.GrantType) {
+		return models.OAuthTokens{}, fmt.Errorf("%s: %w", op, oauthError(ErrCodeUnauthorizedClient, "the grant type is not allowed for the client"))
+	}
+
+	var tokens models.OAuthTokens
+	switch req.GrantType {
+	case grantClientCredentials:
+		tokens, err = o.clientCredentials(ctx, client, req)
+	case grantDeviceCode:
+		tokens, err = o.deviceCode(ctx, client, req)
+	case grantRefreshToken:
+		tokens, err = o.refreshToken(ctx, client, req)
+	default:
+		tokens, err = o.authorizationCode(ctx, client, req)
+	}
+	if err != nil {
+		return models.OAuthTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// AuthenticateClient authenticates the client of the introspection and revocation endpoints.
+// Failed authentication is returned as *Error
+func (o *OAuth) AuthenticateClient(ctx context.Context, clientId, clientSecret string) (models.OAuthClient, error) {
+	const op = "oauth.AuthenticateClient"
+
+	client, err := o.clients.AuthenticateClient(ctx, clientId, clientSecret)
+	if err != nil {
+		if errors.Is(err, services.ErrClientNotFound) || errors.Is(err, services.ErrInvalidCredentials) {
+			return models.OAuthClient{}, fmt.Errorf("%s: %w", op, oauthError(ErrCodeInvalidClient, "client authentication failed"))
+		}
+
+		return models.OAuthClient{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return client, nil
+}
+
+func (o *OAuth) authorizationCode(ctx context.Context, client models.OAuthClient, req models.TokenRequest) (models.OAuthTokens, error) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if req.Code == "" || req.CodeVerifier == "" {
+		return models.OAuthTokens{}, oauthError(ErrCodeInvalidRequest, "code and code_verifier are required")
+	}
+
+	grant, err := o.codeStorage.TakeAuthorizationCode(ctx, req.Code)
+	if err != nil {
+		if errors.Is(err, storage.ErrAuthorizationCodeNotFound) {
+			return models.OAuthTokens{}, oauthError(ErrCodeInvalidGrant, "invalid or expired code")
+		}
+
+		log.Error(ctx, "failed to take authorization code", zap.Error(err))
+
+		return models.OAuthTokens{}, err
+	}
+
+	if grant.ClientId != client.Id || grant.RedirectURI != req.RedirectURI {
+		return models.OAuthTokens{}, oauthError(ErrCodeInvalidGrant, "code was issued to another client or redirect uri")
+	}
+
+	if !verifyCodeChallenge(req.CodeVerifier, grant.CodeChallenge) {
+		return models.OAuthTokens{}, oauthError(ErrCodeInvalidGrant, "invalid code_verifier")
+	}
+
+	return o.issueTokens(ctx, client, grant)
+}
+
+// issueTokens opens a session for the user of the grant, the tokens are issued to the client
+func (o *OAuth) issueTokens(ctx context.Context, client models.OAuthClient, grant models.AuthorizationGrant) (models.OAuthTokens, error) {
+	ttl := o.accessTokenTTLOf(client)
+
+	tokens, err := o.tokenIssuer.IssueTokens(ctx, grant.UserId, grant.ClientId, grant.Scope, ttl)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return models.OAuthTokens{}, oauthError(ErrCodeInvalidGrant, "user not found")
+		}
+
+		return models.OAuthTokens{}, err
+	}
+
+	idToken := ""
+	if hasScope(grant.Scope, scopeOpenID) {
+		if idToken, err = o.newIDToken(ctx, grant, ttl); err != nil {
+			return models.OAuthTokens{}, err
+		}
+	}
+
+	return models.OAuthTokens{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		IdToken:      idToken,
+		ExpiresIn:    ttl,
+		Scope:        grant.Scope,
+	}, nil
+}
+
+// refreshToken rotates a refresh token issued to the client (RFC 6749 section 6).
+// The tokens keep the scope of the grant, no id token is issued
+func (o *OAuth) refreshToken(ctx context.Context, client models.OAuthClient, req models.TokenRequest) (models.OAuthTokens, error) {
+	if req.RefreshToken == "" {
+		return models.OAuthTokens{}, oauthError(ErrCodeInvalidRequest, "refresh_token is required")
+	}
+
+	ttl := o.accessTokenTTLOf(client)
+
+	tokens, scope, err := o.tokenIssuer.RefreshClientTokens(ctx, client.Id, req.RefreshToken, ttl)
+	if err != nil {
+		if errors.Is(err, services.ErrNotAuthorized) || errors.Is(err, services.ErrUserNotFound) {
+			return models.OAuthTokens{}, oauthError(ErrCodeInvalidGrant, "invalid or expired refresh token")
+		}
+
+		return models.OAuthTokens{}, err
+	}
+
+	return models.OAuthTokens{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    ttl,
+		Scope:        scope,
+	}, nil
+}
+
+// clientCredentials issues an access token to a confidential client acting on its own behalf.
+// No refresh token is issued (RFC 6749 section 4.4.3)
+func (o *OAuth) clientCredentials(ctx context.Context, client models.OAuthClient, req models.TokenRequest) (models.OAuthTokens, error) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if len(client.SecretHash) == 0 {
+		return models.OAuthTokens{}, oauthError(ErrCodeUnauthorizedClient, "public clients cannot use client_credentials")
+	}
+
+	// all the scopes of the client are granted if none is requested
+	scope := strings.Join(client.Scopes, " ")
+	if req.Scope != "" {
+		for _, requested := range strings.Fields(req.Scope) {
+			if !slices.Contains(client.Scopes, requested) {
+				return models.OAuthTokens{}, oauthError(ErrCodeInvalidScope, fmt.Sprintf("scope %q is not allowed", requested))
+			}
+		}
+
+		scope = req.Scope
+	}
+
+	ttl := o.accessTokenTTLOf(client)
+
+	accessToken, err := jwt.NewAccessToken(jwt.AccessToken{
+		Issuer:   o.issuer(),
+		Audience: client.Id,
+		ClientId: client.Id,
+		Scope:    scope,
+	}, ttl, o.keyProvider.SigningKey())
+	if err != nil {
+		log.Error(ctx, "failed to generate client token", zap.Error(err))
+
+		return models.OAuthTokens{}, err
+	}
+
+	log.Info(ctx, "client token issued", zap.String("client_id", client.Id))
+
+	return models.OAuthTokens{
+		AccessToken: accessToken,
+		ExpiresIn:   ttl,
+		Scope:       scope,
+	}, nil
+}
+
+// serviceAccountCredentials issues an access token to a service account authenticated with
+// private_key_jwt (RFC 7523 section 2.2). The permissions of the account are granted,
+// the requested scope can narrow them. No refresh token is issued, a new assertion gets a new token
+func (o *OAuth) serviceAccountCredentials(ctx context.Context, req models.TokenRequest) (models.OAuthTokens, error) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if req.ClientAssertionType != jwt.ClientAssertionType || req.ClientAssertion == "" || req.ClientSecret != "" {
+		return models.OAuthTokens{}, oauthError(ErrCodeInvalidRequest, "invalid client assertion")
+	}
+
+	if req.GrantType != grantClientCredentials {
+		return models.OAuthTokens{}, oauthError(ErrCodeUnauthorizedClient, "service accounts can only use client_credentials")
+	}
+
+	account, err := o.serviceAccounts.Authenticate(ctx, req.ClientAssertion, o.issuer()+"/token")
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			return models.OAuthTokens{}, oauthError(ErrCodeInvalidClient, "client authentication failed")
+		}
+
+		return models.OAuthTokens{}, err
+	}
+
+	// client_id is optional, it must be the subject of the assertion if sent
+	if req.ClientId != "" && req.ClientId != account.Id.String() {
+		return models.OAuthTokens{}, oauthError(ErrCodeInvalidClient, "client authentication failed")
+	}
+
+	permissions := account.Permissions
+	if req.Scope != "" {
+		permissions = strings.Fields(req.Scope)
+		for _, requested := range permissions {
+			if !slices.Contains(account.Permissions, requested) {
+				return models.OAuthTokens{}, oauthError(ErrCodeInvalidScope, fmt.Sprintf("scope %q is not allowed", requested))
+			}
+		}
+	}
+
+	scope := strings.Join(permissions, " ")
+
+	accessToken, err := jwt.NewAccessToken(jwt.AccessToken{
+		Issuer:           o.issuer(),
+		Audience:         o.audience,
+		Scope:            scope,
+		Permissions:      permissions,
+		ServiceAccountId: account.Id,
+	}, o.accessTokenTTL, o.keyProvider.SigningKey())
+	if err != nil {
+		log.Error(ctx, "failed to generate service account token", zap.Error(err))
+
+		return models.OAuthTokens{}, err
+	}
+
+	log.Info(ctx, "service account token issued", zap.String("service_account_id", account.Id.String()))
+
+	return models.OAuthTokens{
+		AccessToken: accessToken,
+		ExpiresIn:   o.accessTokenTTL,
+		Scope:       scope,
+	}, nil
+}
+
+// allowsGrantType reports whether the client may use the grant type. Refresh tokens
+// are issued by the grants of users, the clients using them may refresh them
+func allowsGrantType(client models.OAuthClient, grantType string) bool {
+	if grantType == grantRefreshToken {
+		return slices.Contains(client.GrantTypes, grantAuthorizationCode) || slices.Contains(client.GrantTypes, grantDeviceCode)
+	}
+
+	return slices.Contains(client.GrantTypes, grantType)
+}
+
+// accessTokenTTLOf returns the access token lifetime of the client
+func (o *OAuth) accessTokenTTLOf(client models.OAuthClient) time.Duration {
+	if client.AccessTokenTTL > 0 {
+		return client.AccessTokenTTL
+	}
+
+	return o.accessTokenTTL
+}
+
+// issuer is the public url of the server without the trailing slash
+func (o *OAuth) issuer() string {
+	return strings.TrimSuffix(o.cfg.Issuer, "/")
+}
+
+// authenticate returns the user of a token issued by Login
+func (o *OAuth) authenticate(ctx context.Context, bearerToken string) (uuid.UUID, error) {
+	if bearerToken == "" {
+		return uuid.Nil, services.ErrNotAuthorized
+	}
+
+	keySet, err := o.keyProvider.PublicKeys(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	uid, err := jwt.VerifyUserToken(bearerToken, keySet, o.issuer(), o.audience)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(uid)
+}
+
+// resolveRedirectURI requires an exact match with a registered uri.
+// It may be omitted if the client has only one
+func resolveRedirectURI(client models.OAuthClient, redirectURI string) (string, error) {
+	if redirectURI == "" {
+		if len(client.RedirectURIs) == 1 {
+			return client.RedirectURIs[0], nil
+		}
+
+		return "", oauthError(ErrCodeInvalidRequest, "redirect_uri is required")
+	}
+
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return "", oauthError(ErrCodeInvalidRequest, "redirect_uri is not registered")
+	}
+
+	return redirectURI, nil
+}
+
+func validateAuthorizationRequest(client models.OAuthClient, req models.AuthorizationRequest) *Error {
+	if req.ResponseType != responseTypeCode {
+		return oauthError(ErrCodeUnsupportedResponseType, "only the code response type is supported")
+	}
+
+	// pkce is mandatory and the plain method is not accepted
+	if req.CodeChallengeMethod != codeChallengeS256 {
+		return oauthError(ErrCodeInvalidRequest, "code_challenge_method must be S256")
+	}
+
+	if !codeChallengeRe.MatchString(req.CodeChallenge) {
+		return oauthError(ErrCodeInvalidRequest, "invalid code_challenge")
+	}
+
+	for _, scope := range strings.Fields(req.Scope) {
+		if !slices.Contains(client.Scopes, scope) {
+			return oauthError(ErrCodeInvalidScope, fmt.Sprintf("scope %q is not allowed", scope))
+		}
+	}
+
+	return nil
+}
+
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if !codeVerifierRe.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func errorRedirect(redirectURI, state string, err *Error) string {
+	query := url.Values{
+		"error":             {err.Code},
+		"error_description": {err.Description},
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+
+	return withQuery(redirectURI, query)
+}
+
+// loginRedirect passes the authorization request to the login page,
+// which sends the user back to the authorization endpoint after the login
+func loginRedirect(loginURL string, req models.AuthorizationRequest) string {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientId,
+		"redirect_uri":          req.RedirectURI,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+		"nonce":                 req.Nonce,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	return withQuery(loginURL, query)
+}
+
+// withQuery adds the query to the uri keeping its own query parameters
+func withQuery(uri string, query url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	q := u.Query()
+	for key, values := range query {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func randomCode() (string, error) {
+	b := make([]byte, codeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
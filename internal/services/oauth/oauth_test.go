@@ -0,0 +1,637 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryCodeStorage map[string]models.AuthorizationGrant
+
+func (s memoryCodeStorage) CreateAuthorizationCode(ctx context.Context, code string, grant models.AuthorizationGrant, ttl time.Duration) error {
+	s[code] = grant
+
+	return nil
+}
+
+func (s memoryCodeStorage) TakeAuthorizationCode(ctx context.Context, code string) (models.AuthorizationGrant, error) {
+	grant, ok := s[code]
+	if !ok {
+		return models.AuthorizationGrant{}, storage.ErrAuthorizationCodeNotFound
+	}
+
+	delete(s, code)
+
+	return grant, nil
+}
+
+type clientProvider map[string]models.OAuthClient
+
+func (p clientProvider) ProvideClient(ctx context.Context, clientId string) (models.OAuthClient, error) {
+	client, ok := p[clientId]
+	if !ok {
+		return models.OAuthClient{}, services.ErrClientNotFound
+	}
+
+	return client, nil
+}
+
+func (p clientProvider) AuthenticateClient(ctx context.Context, clientId, secret string) (models.OAuthClient, error) {
+	client, err := p.ProvideClient(ctx, clientId)
+	if err != nil {
+		return models.OAuthClient{}, err
+	}
+
+	if string(client.SecretHash) != secret {
+		return models.OAuthClient{}, services.ErrInvalidCredentials
+	}
+
+	return client, nil
+}
+
+type tokenIssuer struct {
+	prKey    *ecdsa.PrivateKey
+	userId   uuid.UUID
+	clientId string
+
+	// the sessions by their refresh token
+	sessions map[string]models.RefreshToken
+}
+
+func (i *tokenIssuer) IssueTokens(ctx context.Context, userId uuid.UUID, clientId, scope string, accessTokenTTL time.Duration) (models.JWTokens, error) {
+	i.userId = userId
+	i.clientId = clientId
+
+	tokens, err := jwt.NewTokens(jwt.AccessToken{
+		Issuer:   issuerURL,
+		Audience: clientId,
+		User:     models.UserInfo{Id: userId},
+		ClientId: clientId,
+		Scope:    scope,
+	}, accessTokenTTL, i.prKey)
+	if err != nil {
+		return models.JWTokens{}, err
+	}
+
+	i.sessions[tokens.RefreshToken] = models.RefreshToken{UserId: userId, ClientId: clientId, Scope: scope}
+
+	return tokens, nil
+}
+
+func (i *tokenIssuer) RefreshClientTokens(ctx context.Context, clientId, refreshToken string, accessTokenTTL time.Duration) (models.JWTokens, string, error) {
+	session, ok := i.sessions[refreshToken]
+	if !ok || session.ClientId != clientId {
+		return models.JWTokens{}, "", services.ErrNotAuthorized
+	}
+
+	delete(i.sessions, refreshToken)
+
+	tokens, err := i.IssueTokens(ctx, session.UserId, session.ClientId, session.Scope, accessTokenTTL)
+	if err != nil {
+		return models.JWTokens{}, "", err
+	}
+
+	return tokens, session.Scope, nil
+}
+
+type userProvider struct {
+	user models.User
+}
+
+func (p userProvider) ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error) {
+	if id != p.user.UserInfo.Id {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return p.user, nil
+}
+
+type keyProvider struct {
+	prKey *ecdsa.PrivateKey
+}
+
+func (p keyProvider) SigningKey() *ecdsa.PrivateKey {
+	return p.prKey
+}
+
+func (p keyProvider) PublicKeys(ctx context.Context) (jwks.JWKS, error) {
+	return jwks.NewJWKS(&p.prKey.PublicKey), nil
+}
+
+// serviceAccounts authenticates the accounts by their assertions
+type serviceAccounts map[string]models.ServiceAccount
+
+func (a serviceAccounts) Authenticate(ctx context.Context, assertion, audience string) (models.ServiceAccount, error) {
+	account, ok := a[assertion]
+	if !ok || audience != issuerURL+"/token" {
+		return models.ServiceAccount{}, services.ErrInvalidCredentials
+	}
+
+	return account, nil
+}
+
+var serviceAccountId = uuid.New()
+
+const (
+	issuerURL           = "https://sso.example.com"
+	audience            = "apphelper"
+	clientId            = "web"
+	deviceClientId      = "cli"
+	serviceClientId     = "report"
+	serviceClientSecret = "secret"
+	redirectURI         = "https://app.example.com/callback"
+	verifier            = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	serviceAccountAssertion = "signed-assertion"
+)
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func setup(t *testing.T) (context.Context, *OAuth, *tokenIssuer, string) {
+	t.Helper()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	privKey, _, err := jwt.GenerateKeys()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userId := uuid.New()
+	tokens, err := jwt.NewTokens(jwt.AccessToken{
+		Issuer:   issuerURL,
+		Audience: audience,
+		User:     models.UserInfo{Id: userId},
+	}, time.Minute, privKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clients := clientProvider{
+		clientId: {
+			Id:           clientId,
+			RedirectURIs: []string{redirectURI},
+			GrantTypes:   []string{"authorization_code"},
+			Scopes:       []string{"openid", "profile"},
+		},
+		deviceClientId: {
+			Id:         deviceClientId,
+			GrantTypes: []string{"urn:ietf:params:oauth:grant-type:device_code"},
+			Scopes:     []string{"openid", "profile"},
+		},
+		serviceClientId: {
+			Id:             serviceClientId,
+			SecretHash:     []byte(serviceClientSecret),
+			GrantTypes:     []string{"client_credentials"},
+			Scopes:         []string{"users:read", "users:write"},
+			AccessTokenTTL: 30 * time.Second,
+		},
+	}
+
+	issuer := &tokenIssuer{prKey: privKey, sessions: map[string]models.RefreshToken{}}
+
+	user := models.User{
+		UserInfo: models.UserInfo{Id: userId, Name: "John", Surname: "Doe"},
+		UserAuth: models.UserAuth{Id: userId, Email: "john@example.com"},
+	}
+
+	accounts := serviceAccounts{
+		serviceAccountAssertion: {
+			Id:          serviceAccountId,
+			Name:        "report",
+			Permissions: []string{"journal:read", "sso:users:read"},
+		},
+	}
+
+	oauth := New(ctx, clients, memoryCodeStorage{}, newMemoryDeviceStorage(), issuer, userProvider{user}, keyProvider{privKey}, accounts, time.Minute, audience, Config{
+		Issuer:   issuerURL,
+		LoginURL: "https://sso.example.com/login",
+		CodeTTL:  time.Minute,
+
+		DeviceVerificationURL: "https://sso.example.com/device",
+		DeviceCodeTTL:         10 * time.Minute,
+		DevicePollInterval:    5 * time.Second,
+	})
+
+	return ctx, oauth, issuer, "Bearer " + tokens.AccessToken
+}
+
+func authorizationRequest() models.AuthorizationRequest {
+	return models.AuthorizationRequest{
+		ResponseType:        "code",
+		ClientId:            clientId,
+		RedirectURI:         redirectURI,
+		Scope:               "openid profile",
+		State:               "xyz",
+		CodeChallenge:       challenge(verifier),
+		CodeChallengeMethod: "S256",
+		Nonce:               "n-0S6_WzA2Mj",
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx, oauth, issuer, bearer := setup(t)
+
+	redirect, err := oauth.Authorize(ctx, authorizationRequest(), bearer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(redirect, redirectURI+"?") {
+		t.Errorf("unexpected redirect: %s", redirect)
+	}
+
+	if u.Query().Get("state") != "xyz" {
+		t.Errorf("state is not passed back")
+	}
+
+	tokenRequest := models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         u.Query().Get("code"),
+		RedirectURI:  redirectURI,
+		ClientId:     clientId,
+		CodeVerifier: verifier,
+	}
+
+	tokens, err := oauth.Exchange(ctx, tokenRequest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tokens.AccessToken == "" || tokens.Scope != "openid profile" {
+		t.Errorf("unexpected tokens: %+v", tokens)
+	}
+
+	if issuer.clientId != clientId || issuer.userId == uuid.Nil {
+		t.Errorf("tokens issued to %s for %s", issuer.clientId, issuer.userId)
+	}
+
+	// codes are one time
+	_, err = oauth.Exchange(ctx, tokenRequest)
+
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeInvalidGrant {
+		t.Errorf("expected invalid grant, got %v", err)
+	}
+}
+
+func TestRefreshTokenGrant(t *testing.T) {
+	ctx, oauth, _, bearer := setup(t)
+
+	redirect, err := oauth.Authorize(ctx, authorizationRequest(), bearer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u, _ := url.Parse(redirect)
+
+	tokens, err := oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         u.Query().Get("code"),
+		RedirectURI:  redirectURI,
+		ClientId:     clientId,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the refresh token of another client is not accepted and stays valid
+	for _, req := range []models.TokenRequest{
+		{GrantType: "refresh_token", ClientId: deviceClientId, RefreshToken: tokens.RefreshToken},
+		{GrantType: "refresh_token", ClientId: clientId, RefreshToken: "unknown"},
+	} {
+		_, err := oauth.Exchange(ctx, req)
+
+		var oauthErr *Error
+		if !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeInvalidGrant {
+			t.Errorf("expected invalid grant, got %v", err)
+		}
+	}
+
+	refreshed, err := oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:    "refresh_token",
+		ClientId:     clientId,
+		RefreshToken: tokens.RefreshToken,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == tokens.RefreshToken || refreshed.Scope != "openid profile" {
+		t.Errorf("unexpected tokens: %+v", refreshed)
+	}
+
+	claims, err := jwt.ParseAccessToken(refreshed.AccessToken, jwks.NewJWKS(&oauth.keyProvider.SigningKey().PublicKey), issuerURL, audience)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.ClientId != clientId || claims.Scope != "openid profile" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// clients that get no refresh tokens cannot use the grant
+	_, err = oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:    "refresh_token",
+		ClientId:     serviceClientId,
+		ClientSecret: serviceClientSecret,
+		RefreshToken: refreshed.RefreshToken,
+	})
+
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeUnauthorizedClient {
+		t.Errorf("expected unauthorized client, got %v", err)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	ctx, oauth, _, bearer := setup(t)
+
+	redirect, err := oauth.Authorize(ctx, authorizationRequest(), bearer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u, _ := url.Parse(redirect)
+
+	_, err = oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         u.Query().Get("code"),
+		RedirectURI:  redirectURI,
+		ClientId:     clientId,
+		CodeVerifier: strings.Repeat("a", 43),
+	})
+
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeInvalidGrant {
+		t.Errorf("expected invalid grant, got %v", err)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	ctx, oauth, _, bearer := setup(t)
+
+	// the user agent is not redirected to unregistered uris
+	req := authorizationRequest()
+	req.RedirectURI = "https://evil.example.com/callback"
+
+	var oauthErr *Error
+	if _, err := oauth.Authorize(ctx, req, bearer); !errors.As(err, &oauthErr) {
+		t.Errorf("expected oauth error, got %v", err)
+	}
+
+	req = authorizationRequest()
+	req.ClientId = "unknown"
+
+	if _, err := oauth.Authorize(ctx, req, bearer); !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeInvalidClient {
+		t.Errorf("expected invalid client, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*models.AuthorizationRequest)
+		code   string
+	}{
+		{
+			name:   "plain challenge",
+			modify: func(req *models.AuthorizationRequest) { req.CodeChallengeMethod = "plain" },
+			code:   ErrCodeInvalidRequest,
+		},
+		{
+			name:   "no challenge",
+			modify: func(req *models.AuthorizationRequest) { req.CodeChallenge = "" },
+			code:   ErrCodeInvalidRequest,
+		},
+		{
+			name:   "token response type",
+			modify: func(req *models.AuthorizationRequest) { req.ResponseType = "token" },
+			code:   ErrCodeUnsupportedResponseType,
+		},
+		{
+			name:   "scope not allowed",
+			modify: func(req *models.AuthorizationRequest) { req.Scope = "openid admin" },
+			code:   ErrCodeInvalidScope,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := authorizationRequest()
+			test.modify(&req)
+
+			redirect, err := oauth.Authorize(ctx, req, bearer)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			u, _ := url.Parse(redirect)
+			if u.Query().Get("error") != test.code || u.Query().Get("state") != "xyz" {
+				t.Errorf("unexpected redirect: %s", redirect)
+			}
+		})
+	}
+}
+
+func TestAuthorizeLoginRedirect(t *testing.T) {
+	ctx, oauth, _, _ := setup(t)
+
+	for _, bearer := range []string{"", "Bearer invalid"} {
+		redirect, err := oauth.Authorize(ctx, authorizationRequest(), bearer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		u, _ := url.Parse(redirect)
+		if u.Host != "sso.example.com" || u.Query().Get("code_challenge") != challenge(verifier) {
+			t.Errorf("unexpected redirect: %s", redirect)
+		}
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	ctx, oauth, issuer, bearer := setup(t)
+
+	tokens, err := oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:    "client_credentials",
+		ClientId:     serviceClientId,
+		ClientSecret: serviceClientSecret,
+		Scope:        "users:read",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tokens.RefreshToken != "" || tokens.Scope != "users:read" || tokens.ExpiresIn != 30*time.Second {
+		t.Errorf("unexpected tokens: %+v", tokens)
+	}
+
+	// machine tokens do not stand for a user
+	keySet := jwks.NewJWKS(&issuer.prKey.PublicKey)
+	if _, err := jwt.VerifyBearerTokenWithKeySet("Bearer "+tokens.AccessToken, keySet, issuerURL, audience); err == nil {
+		t.Errorf("machine token is accepted as a user token")
+	}
+
+	tests := []struct {
+		name string
+		req  models.TokenRequest
+		code string
+	}{
+		{
+			name: "wrong secret",
+			req:  models.TokenRequest{GrantType: "client_credentials", ClientId: serviceClientId, ClientSecret: "wrong"},
+			code: ErrCodeInvalidClient,
+		},
+		{
+			name: "scope not allowed",
+			req:  models.TokenRequest{GrantType: "client_credentials", ClientId: serviceClientId, ClientSecret: serviceClientSecret, Scope: "admin"},
+			code: ErrCodeInvalidScope,
+		},
+		{
+			name: "grant type not allowed",
+			req:  models.TokenRequest{GrantType: "client_credentials", ClientId: clientId},
+			code: ErrCodeUnauthorizedClient,
+		},
+		{
+			name: "unsupported grant type",
+			req:  models.TokenRequest{GrantType: "password", ClientId: serviceClientId, ClientSecret: serviceClientSecret},
+			code: ErrCodeUnsupportedGrantType,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := oauth.Exchange(ctx, test.req)
+
+			var oauthErr *Error
+			if !errors.As(err, &oauthErr) || oauthErr.Code != test.code {
+				t.Errorf("expected %s, got %v", test.code, err)
+			}
+		})
+	}
+
+	// clients without the authorization code grant are sent back with an error
+	req := authorizationRequest()
+	req.ClientId = serviceClientId
+	req.RedirectURI = ""
+
+	if _, err := oauth.Authorize(ctx, req, bearer); err == nil {
+		t.Errorf("expected error for a client without redirect uris")
+	}
+}
+
+func TestServiceAccountCredentials(t *testing.T) {
+	ctx, oauth, issuer, _ := setup(t)
+
+	tokens, err := oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:           "client_credentials",
+		ClientAssertionType: jwt.ClientAssertionType,
+		ClientAssertion:     serviceAccountAssertion,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tokens.RefreshToken != "" || tokens.Scope != "journal:read sso:users:read" || tokens.ExpiresIn != time.Minute {
+		t.Errorf("unexpected tokens: %+v", tokens)
+	}
+
+	claims, err := jwt.ParseAccessToken(tokens.AccessToken, jwks.NewJWKS(&issuer.prKey.PublicKey), issuerURL, audience)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.Subject != serviceAccountId.String() || claims.PrincipalType != jwks.PrincipalTypeService || claims.UserId != "" || claims.ClientId != "" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// a requested scope narrows the permissions
+	tokens, err = oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:           "client_credentials",
+		ClientId:            serviceAccountId.String(),
+		ClientAssertionType: jwt.ClientAssertionType,
+		ClientAssertion:     serviceAccountAssertion,
+		Scope:               "journal:read",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims, err = jwt.ParseAccessToken(tokens.AccessToken, jwks.NewJWKS(&issuer.prKey.PublicKey), issuerURL, audience)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(claims.Permissions) != 1 || claims.Permissions[0] != "journal:read" {
+		t.Errorf("unexpected permissions: %v", claims.Permissions)
+	}
+
+	tests := []struct {
+		name string
+		req  models.TokenRequest
+		code string
+	}{
+		{
+			name: "invalid assertion",
+			req:  models.TokenRequest{GrantType: "client_credentials", ClientAssertionType: jwt.ClientAssertionType, ClientAssertion: "forged"},
+			code: ErrCodeInvalidClient,
+		},
+		{
+			name: "wrong assertion type",
+			req:  models.TokenRequest{GrantType: "client_credentials", ClientAssertionType: "saml2-bearer", ClientAssertion: serviceAccountAssertion},
+			code: ErrCodeInvalidRequest,
+		},
+		{
+			name: "another client id",
+			req:  models.TokenRequest{GrantType: "client_credentials", ClientId: serviceClientId, ClientAssertionType: jwt.ClientAssertionType, ClientAssertion: serviceAccountAssertion},
+			code: ErrCodeInvalidClient,
+		},
+		{
+			name: "scope not allowed",
+			req:  models.TokenRequest{GrantType: "client_credentials", ClientAssertionType: jwt.ClientAssertionType, ClientAssertion: serviceAccountAssertion, Scope: "sso:users:write"},
+			code: ErrCodeInvalidScope,
+		},
+		{
+			name: "grant type not allowed",
+			req:  models.TokenRequest{GrantType: "authorization_code", ClientAssertionType: jwt.ClientAssertionType, ClientAssertion: serviceAccountAssertion},
+			code: ErrCodeUnauthorizedClient,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := oauth.Exchange(ctx, test.req)
+
+			var oauthErr *Error
+			if !errors.As(err, &oauthErr) || oauthErr.Code != test.code {
+				t.Errorf("expected %s, got %v", test.code, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+// Configuration returns the OpenID Connect discovery document
+func (o *OAuth) Configuration() models.OpenIDConfiguration {
+	issuer := o.issuer()
+
+	return models.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		DeviceAuthorizationEndpoint:       issuer + "/device_authorization",
+		TokenEndpoint:                     issuer + "/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		RevocationEndpoint:                issuer + "/revoke",
+		JwksURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{scopeOpenID, scopeProfile, scopeEmail},
+		ResponseTypesSupported:            []string{responseTypeCode},
+		GrantTypesSupported:               []string{grantAuthorizationCode, grantClientCredentials, grantDeviceCode, grantRefreshToken},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{"ES256"},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post", "private_key_jwt"},
+		CodeChallengeMethodsSupported:     []string{codeChallengeS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "given_name", "family_name", "email"},
+	}
+}
+
+// UserInfo returns the claims of the user released by the scope of the access token.
+// Invalid tokens are returned as *Error
+func (o *OAuth) UserInfo(ctx context.Context, bearerToken string) (map[string]any, error) {
+	const op = "oauth.UserInfo"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	keySet, err := o.keyProvider.PublicKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims, err := jwt.VerifyClientToken(bearerToken, keySet, o.issuer())
+	if err != nil {
+		log.Info(ctx, "invalid access token", zap.Error(err))
+
+		return nil, fmt.Errorf("%s: %w", op, oauthError(ErrCodeInvalidToken, "invalid access token"))
+	}
+
+	if !hasScope(claims.Scope, scopeOpenID) {
+		return nil, fmt.Errorf("%s: %w", op, oauthError(ErrCodeInsufficientScope, "the openid scope is required"))
+	}
+
+	userId, err := uuid.Parse(claims.UserId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, oauthError(ErrCodeInvalidToken, "invalid access token"))
+	}
+
+	user, err := o.userProvider.ProvideUserById(ctx, userId)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, oauthError(ErrCodeInvalidToken, "user not found"))
+		}
+
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	userInfo := userClaims(user, claims.Scope)
+	userInfo["sub"] = user.UserInfo.Id.String()
+
+	return userInfo, nil
+}
+
+func (o *OAuth) newIDToken(ctx context.Context, grant models.AuthorizationGrant, ttl time.Duration) (string, error) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	user, err := o.userProvider.ProvideUserById(ctx, grant.UserId)
+	if err != nil {
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		return "", err
+	}
+
+	idToken, err := jwt.NewIDToken(jwt.IDToken{
+		Issuer:     o.issuer(),
+		Subject:    user.UserInfo.Id.String(),
+		Audience:   grant.ClientId,
+		Nonce:      grant.Nonce,
+		AuthTime:   grant.AuthTime,
+		UserClaims: userClaims(user, grant.Scope),
+	}, ttl, o.keyProvider.SigningKey())
+	if err != nil {
+		log.Error(ctx, "failed to generate id token", zap.Error(err))
+
+		return "", err
+	}
+
+	return idToken, nil
+}
+
+// userClaims returns the standard claims of the user released by the scope (OpenID Connect Core section 5.4)
+func userClaims(user models.User, scope string) map[string]any {
+	claims := map[string]any{}
+
+	if hasScope(scope, scopeProfile) {
+		claims["name"] = strings.TrimSpace(user.Name + " " + user.Surname)
+		claims["given_name"] = user.Name
+		claims["family_name"] = user.Surname
+	}
+
+	if hasScope(scope, scopeEmail) {
+		claims["email"] = user.Email
+	}
+
+	return claims
+}
+
+func hasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
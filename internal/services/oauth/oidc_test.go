@@ -0,0 +1,123 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	golangjwt "github.com/golang-jwt/jwt/v5"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+)
+
+func exchange(t *testing.T, ctx context.Context, oauth *OAuth, req models.AuthorizationRequest, bearer string) models.OAuthTokens {
+	t.Helper()
+
+	redirect, err := oauth.Authorize(ctx, req, bearer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u, _ := url.Parse(redirect)
+
+	tokens, err := oauth.Exchange(ctx, models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         u.Query().Get("code"),
+		RedirectURI:  redirectURI,
+		ClientId:     clientId,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return tokens
+}
+
+func TestIDToken(t *testing.T) {
+	ctx, oauth, issuer, bearer := setup(t)
+
+	tokens := exchange(t, ctx, oauth, authorizationRequest(), bearer)
+	if tokens.IdToken == "" {
+		t.Fatalf("id token is not issued")
+	}
+
+	parsed, err := golangjwt.Parse(tokens.IdToken, func(t *golangjwt.Token) (interface{}, error) {
+		return &issuer.prKey.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims := parsed.Claims.(golangjwt.MapClaims)
+
+	expected := map[string]any{
+		"iss":         "https://sso.example.com",
+		"sub":         issuer.userId.String(),
+		"aud":         clientId,
+		"nonce":       "n-0S6_WzA2Mj",
+		"name":        "John Doe",
+		"given_name":  "John",
+		"family_name": "Doe",
+	}
+	for name, value := range expected {
+		if claims[name] != value {
+			t.Errorf("expected %s %v, got %v", name, value, claims[name])
+		}
+	}
+
+	if _, ok := claims["auth_time"]; !ok {
+		t.Errorf("auth_time is missing")
+	}
+
+	// the email scope was not requested
+	if _, ok := claims["email"]; ok {
+		t.Errorf("email is released without the email scope")
+	}
+
+	// no id token without the openid scope
+	req := authorizationRequest()
+	req.Scope = "profile"
+
+	if tokens := exchange(t, ctx, oauth, req, bearer); tokens.IdToken != "" {
+		t.Errorf("id token is issued without the openid scope")
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	ctx, oauth, issuer, bearer := setup(t)
+
+	tokens := exchange(t, ctx, oauth, authorizationRequest(), bearer)
+
+	claims, err := oauth.UserInfo(ctx, "Bearer "+tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims["sub"] != issuer.userId.String() || claims["given_name"] != "John" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	// tokens of Login are not issued to a client
+	var oauthErr *Error
+	if _, err := oauth.UserInfo(ctx, bearer); !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeInvalidToken {
+		t.Errorf("expected invalid token, got %v", err)
+	}
+
+	req := authorizationRequest()
+	req.Scope = "profile"
+	tokens = exchange(t, ctx, oauth, req, bearer)
+
+	if _, err := oauth.UserInfo(ctx, "Bearer "+tokens.AccessToken); !errors.As(err, &oauthErr) || oauthErr.Code != ErrCodeInsufficientScope {
+		t.Errorf("expected insufficient scope, got %v", err)
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	_, oauth, _, _ := setup(t)
+
+	config := oauth.Configuration()
+	if config.Issuer != "https://sso.example.com" || config.JwksURI != "https://sso.example.com/.well-known/jwks.json" {
+		t.Errorf("unexpected configuration: %+v", config)
+	}
+}
@@ -0,0 +1,222 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// the lifetimes an organization may set, like those of the clients
+	maxAccessTokenTTL  = 24 * time.Hour
+	maxRefreshTokenTTL = 90 * 24 * time.Hour
+	maxPasswordLength  = 64
+	maxPasswordScore   = 4
+	maxPasswordHistory = 24
+)
+
+var slugRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,63}$`)
+
+type OrganizationStorage interface {
+	SaveOrganization(ctx context.Context, org models.Organization) (models.Organization, error)
+	UpdateOrganizationSettings(ctx context.Context, id uuid.UUID, settings models.OrganizationSettings) error
+	ProvideOrganization(ctx context.Context, id uuid.UUID) (models.Organization, error)
+	ProvideOrganizations(ctx context.Context) ([]models.Organization, error)
+	SaveMember(ctx context.Context, member models.Member) error
+	UpdateMemberRoles(ctx context.Context, member models.Member) error
+	ProvideMembers(ctx context.Context, organizationId uuid.UUID) ([]models.Member, error)
+}
+
+// Organizations manages the organizations (tenants), their settings and members
+type Organizations struct {
+	log *logger.Logger
+
+	orgStorage OrganizationStorage
+}
+
+func New(ctx context.Context, orgStorage OrganizationStorage) *Organizations {
+	return &Organizations{
+		log:        logger.GetLoggerFromCtx(ctx),
+		orgStorage: orgStorage,
+	}
+}
+
+func (o *Organizations) Create(ctx context.Context, org models.Organization) (models.Organization, error) {
+	const op = "organizations.Create"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if !slugRe.MatchString(org.Slug) || org.Name == "" || len(org.Name) > 128 {
+		return models.Organization{}, fmt.Errorf("%s: %w", op, services.ErrInvalidOrganization)
+	}
+
+	if err := validateSettings(org.Settings); err != nil {
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	org, err := o.orgStorage.SaveOrganization(ctx, org)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrganizationExists) {
+			return models.Organization{}, fmt.Errorf("%s: %w", op, services.ErrOrganizationExists)
+		}
+
+		log.Error(ctx, "failed to save organization", zap.Error(err))
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "organization created", zap.String("organization_id", org.Id.String()), zap.String("slug", org.Slug))
+
+	return org, nil
+}
+
+// UpdateSettings replaces the settings of the organization.
+// Tokens issued before keep their lifetimes
+func (o *Organizations) UpdateSettings(ctx context.Context, id uuid.UUID, settings models.OrganizationSettings) error {
+	const op = "organizations.UpdateSettings"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := validateSettings(settings); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := o.orgStorage.UpdateOrganizationSettings(ctx, id, settings); err != nil {
+		if errors.Is(err, storage.ErrOrganizationNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrOrganizationNotFound)
+		}
+
+		log.Error(ctx, "failed to update organization settings", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "organization settings updated", zap.String("organization_id", id.String()))
+
+	return nil
+}
+
+func (o *Organizations) Get(ctx context.Context, id uuid.UUID) (models.Organization, error) {
+	const op = "organizations.Get"
+
+	org, err := o.orgStorage.ProvideOrganization(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrganizationNotFound) {
+			return models.Organization{}, fmt.Errorf("%s: %w", op, services.ErrOrganizationNotFound)
+		}
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return org, nil
+}
+
+func (o *Organizations) List(ctx context.Context) ([]models.Organization, error) {
+	const op = "organizations.List"
+
+	orgs, err := o.orgStorage.ProvideOrganizations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return orgs, nil
+}
+
+// AddMember adds a user that is not a member of any organization
+func (o *Organizations) AddMember(ctx context.Context, member models.Member) error {
+	const op = "organizations.AddMember"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := o.orgStorage.SaveMember(ctx, member); err != nil {
+		if memberErr := memberError(err); memberErr != nil {
+			return fmt.Errorf("%s: %w", op, memberErr)
+		}
+
+		log.Error(ctx, "failed to save member", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "member added",
+		zap.String("organization_id", member.OrganizationId.String()),
+		zap.String("user_id", member.UserId.String()),
+	)
+
+	return nil
+}
+
+// SetMemberRoles replaces the roles of the member in the organization
+func (o *Organizations) SetMemberRoles(ctx context.Context, member models.Member) error {
+	const op = "organizations.SetMemberRoles"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := o.orgStorage.UpdateMemberRoles(ctx, member); err != nil {
+		if memberErr := memberError(err); memberErr != nil {
+			return fmt.Errorf("%s: %w", op, memberErr)
+		}
+
+		log.Error(ctx, "failed to update member roles", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "member roles updated",
+		zap.String("organization_id", member.OrganizationId.String()),
+		zap.String("user_id", member.UserId.String()),
+	)
+
+	return nil
+}
+
+func (o *Organizations) Members(ctx context.Context, organizationId uuid.UUID) ([]models.Member, error) {
+	const op = "organizations.Members"
+
+	members, err := o.orgStorage.ProvideMembers(ctx, organizationId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// memberError maps the storage errors of a member, nil if it is not one of them
+func memberError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrOrganizationNotFound):
+		return services.ErrOrganizationNotFound
+	case errors.Is(err, storage.ErrUserNotFound):
+		return services.ErrUserNotFound
+	case errors.Is(err, storage.ErrRoleNotFound):
+		return services.ErrRoleNotFound
+	case errors.Is(err, storage.ErrUserExists):
+		return services.ErrUserAlreadyExists
+	case errors.Is(err, storage.ErrMemberExists):
+		return services.ErrMemberExists
+	case errors.Is(err, storage.ErrMemberNotFound):
+		return services.ErrMemberNotFound
+	}
+
+	return nil
+}
+
+func validateSettings(settings models.OrganizationSettings) error {
+	policy := settings.PasswordPolicy
+
+	switch {
+	case settings.AccessTokenTTL < 0 || settings.AccessTokenTTL > maxAccessTokenTTL,
+		settings.RefreshTokenTTL < 0 || settings.RefreshTokenTTL > maxRefreshTokenTTL,
+		policy.MinLength < 0 || policy.MinLength > maxPasswordLength,
+		policy.MinScore < 0 || policy.MinScore > maxPasswordScore,
+		policy.History < 0 || policy.History > maxPasswordHistory:
+		return services.ErrInvalidOrganization
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryOrganizationStorage struct {
+	orgs    map[uuid.UUID]models.Organization
+	members map[uuid.UUID]models.Member
+}
+
+func (s *memoryOrganizationStorage) SaveOrganization(ctx context.Context, org models.Organization) (models.Organization, error) {
+	for _, saved := range s.orgs {
+		if saved.Slug == org.Slug {
+			return models.Organization{}, storage.ErrOrganizationExists
+		}
+	}
+
+	org.Id = uuid.New()
+	s.orgs[org.Id] = org
+
+	return org, nil
+}
+
+func (s *memoryOrganizationStorage) UpdateOrganizationSettings(ctx context.Context, id uuid.UUID, settings models.OrganizationSettings) error {
+	org, ok := s.orgs[id]
+	if !ok {
+		return storage.ErrOrganizationNotFound
+	}
+
+	org.Settings = settings
+	s.orgs[id] = org
+
+	return nil
+}
+
+func (s *memoryOrganizationStorage) ProvideOrganization(ctx context.Context, id uuid.UUID) (models.Organization, error) {
+	org, ok := s.orgs[id]
+	if !ok {
+		return models.Organization{}, storage.ErrOrganizationNotFound
+	}
+
+	return org, nil
+}
+
+func (s *memoryOrganizationStorage) ProvideOrganizations(ctx context.Context) ([]models.Organization, error) {
+	orgs := make([]models.Organization, 0, len(s.orgs))
+	for _, org := range s.orgs {
+		orgs = append(orgs, org)
+	}
+
+	return orgs, nil
+}
+
+func (s *memoryOrganizationStorage) SaveMember(ctx context.Context, member models.Member) error {
+	if _, ok := s.orgs[member.OrganizationId]; !ok {
+		return storage.ErrOrganizationNotFound
+	}
+
+	if _, ok := s.members[member.UserId]; ok {
+		return storage.ErrMemberExists
+	}
+
+	s.members[member.UserId] = member
+
+	return nil
+}
+
+func (s *memoryOrganizationStorage) UpdateMemberRoles(ctx context.Context, member models.Member) error {
+	saved, ok := s.members[member.UserId]
+	if !ok || saved.OrganizationId != member.OrganizationId {
+		return storage.ErrMemberNotFound
+	}
+
+	s.members[member.UserId] = member
+
+	return nil
+}
+
+func (s *memoryOrganizationStorage) ProvideMembers(ctx context.Context, organizationId uuid.UUID) ([]models.Member, error) {
+	members := make([]models.Member, 0)
+	for _, member := range s.members {
+		if member.OrganizationId == organizationId {
+			members = append(members, member)
+		}
+	}
+
+	return members, nil
+}
+
+func setupService(t *testing.T) (*Organizations, context.Context) {
+	t.Helper()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	orgStorage := &memoryOrganizationStorage{
+		orgs:    map[uuid.UUID]models.Organization{},
+		members: map[uuid.UUID]models.Member{},
+	}
+
+	return New(ctx, orgStorage), ctx
+}
+
+func TestCreate(t *testing.T) {
+	service, ctx := setupService(t)
+
+	org, err := service.Create(ctx, models.Organization{Slug: "acme", Name: "Acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if org.Id == uuid.Nil {
+		t.Errorf("organization id is not set")
+	}
+
+	if _, err := service.Create(ctx, models.Organization{Slug: "acme", Name: "Acme"}); !errors.Is(err, services.ErrOrganizationExists) {
+		t.Errorf("expected organization exists, got %v", err)
+	}
+
+	for _, invalid := range []models.Organization{
+		{Slug: "Acme", Name: "Acme"},
+		{Slug: "-acme", Name: "Acme"},
+		{Slug: "globex"},
+		{Slug: "globex", Name: "Globex", Settings: models.OrganizationSettings{AccessTokenTTL: 48 * time.Hour}},
+		{Slug: "globex", Name: "Globex", Settings: models.OrganizationSettings{
+			PasswordPolicy: models.PasswordPolicySettings{MinScore: 5},
+		}},
+	} {
+		if _, err := service.Create(ctx, invalid); !errors.Is(err, services.ErrInvalidOrganization) {
+			t.Errorf("expected invalid organization for %+v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestUpdateSettings(t *testing.T) {
+	service, ctx := setupService(t)
+
+	org, err := service.Create(ctx, models.Organization{Slug: "acme", Name: "Acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	settings := models.OrganizationSettings{
+		AccessTokenTTL:   5 * time.Minute,
+		OpenRegistration: true,
+		PasswordPolicy:   models.PasswordPolicySettings{MinLength: 16, RequireSymbol: true},
+	}
+
+	if err := service.UpdateSettings(ctx, org.Id, settings); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	org, err = service.Get(ctx, org.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if org.Settings != settings {
+		t.Errorf("unexpected settings: %+v", org.Settings)
+	}
+
+	if err := service.UpdateSettings(ctx, uuid.New(), settings); !errors.Is(err, services.ErrOrganizationNotFound) {
+		t.Errorf("expected organization not found, got %v", err)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	service, ctx := setupService(t)
+
+	org, err := service.Create(ctx, models.Organization{Slug: "acme", Name: "Acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	other, err := service.Create(ctx, models.Organization{Slug: "globex", Name: "Globex"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userId := uuid.New()
+
+	if err := service.AddMember(ctx, models.Member{OrganizationId: org.Id, UserId: userId}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a user is a member of a single organization
+	if err := service.AddMember(ctx, models.Member{OrganizationId: other.Id, UserId: userId}); !errors.Is(err, services.ErrMemberExists) {
+		t.Errorf("expected member exists, got %v", err)
+	}
+
+	if err := service.SetMemberRoles(ctx, models.Member{OrganizationId: other.Id, UserId: userId, Roles: []string{"admin"}}); !errors.Is(err, services.ErrMemberNotFound) {
+		t.Errorf("expected member not found, got %v", err)
+	}
+
+	if err := service.SetMemberRoles(ctx, models.Member{OrganizationId: org.Id, UserId: userId, Roles: []string{"teacher"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	members, err := service.Members(ctx, org.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(members) != 1 || members[0].UserId != userId || len(members[0].Roles) != 1 || members[0].Roles[0] != "teacher" {
+		t.Errorf("unexpected members: %+v", members)
+	}
+
+	members, err = service.Members(ctx, other.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(members) != 0 {
+		t.Errorf("unexpected members of another organization: %+v", members)
+	}
+}
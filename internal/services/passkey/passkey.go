@@ -0,0 +1,315 @@
+package passkey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const defaultPasskeyName = "Passkey"
+
+type Config struct {
+	RPID          string        `yaml:"rp_id" env-required:"true" env:"WEBAUTHN_RP_ID"`
+	RPDisplayName string        `yaml:"rp_display_name" env-required:"true" env:"WEBAUTHN_RP_DISPLAY_NAME"`
+	RPOrigins     []string      `yaml:"rp_origins" env-required:"true" env:"WEBAUTHN_RP_ORIGINS"`
+	CeremonyTTL   time.Duration `yaml:"ceremony_ttl" env-required:"true" env:"WEBAUTHN_CEREMONY_TTL"`
+}
+
+type UserProvider interface {
+	ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error)
+}
+
+type PasskeyStorage interface {
+	SavePasskey(ctx context.Context, passkey models.Passkey) error
+	ProvidePasskeys(ctx context.Context, userId uuid.UUID) ([]models.Passkey, error)
+	UpdatePasskeyUsage(ctx context.Context, passkey models.Passkey) error
+}
+
+type CeremonyStorage interface {
+	CreatePasskeyCeremony(ctx context.Context, ceremonyToken string, sessionData []byte, ttl time.Duration) error
+	TakePasskeyCeremony(ctx context.Context, ceremonyToken string) ([]byte, error)
+}
+
+type Passkeys struct {
+	log *logger.Logger
+
+	userProvider   UserProvider
+	passkeyStorage PasskeyStorage
+	ceremonies     CeremonyStorage
+
+	webAuthn    *webauthn.WebAuthn
+	ceremonyTTL time.Duration
+}
+
+func New(ctx context.Context, userProvider UserProvider, passkeyStorage PasskeyStorage, ceremonies CeremonyStorage, cfg Config) (*Passkeys, error) {
+	const op = "passkey.New"
+
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    cfg.CeremonyTTL,
+		TimeoutUVD: cfg.CeremonyTTL,
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Passkeys{
+		log:            logger.GetLoggerFromCtx(ctx),
+		userProvider:   userProvider,
+		passkeyStorage: passkeyStorage,
+		ceremonies:     ceremonies,
+		webAuthn:       webAuthn,
+		ceremonyTTL:    cfg.CeremonyTTL,
+	}, nil
+}
+
+// BeginRegistration returns the options for navigator.credentials.create
+func (p *Passkeys) BeginRegistration(ctx context.Context, userId uuid.UUID) (models.PasskeyCeremony, error) {
+	const op = "passkey.BeginRegistration"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	user, err := p.provideUser(ctx, userId)
+	if err != nil {
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		return models.PasskeyCeremony{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// passkeys are discoverable credentials, so the login does not need the email
+	creation, session, err := p.webAuthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(user.descriptors()),
+	)
+	if err != nil {
+		return models.PasskeyCeremony{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ceremony, err := p.newCeremony(ctx, creation, session)
+	if err != nil {
+		return models.PasskeyCeremony{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ceremony, nil
+}
+
+// FinishRegistration verifies the attestation created by the authenticator and stores the passkey
+func (p *Passkeys) FinishRegistration(ctx context.Context, userId uuid.UUID, ceremonyToken, name string, response []byte) error {
+	const op = "passkey.FinishRegistration"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	session, err := p.takeCeremony(ctx, ceremonyToken)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := p.provideUser(ctx, userId)
+	if err != nil {
+		log.Error(ctx, "failed to provide user", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+	}
+
+	credential, err := p.webAuthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		log.Error(ctx, "failed to verify passkey attestation", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+	}
+
+	if name == "" {
+		name = defaultPasskeyName
+	}
+
+	passkey := models.Passkey{
+		Id:              credential.ID,
+		UserId:          userId,
+		Name:            name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      make([]string, len(credential.Transport)),
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	for i, transport := range credential.Transport {
+		passkey.Transports[i] = string(transport)
+	}
+
+	if err := p.passkeyStorage.SavePasskey(ctx, passkey); err != nil {
+		log.Error(ctx, "failed to save passkey", zap.Error(err))
+
+		if errors.Is(err, storage.ErrPasskeyExists) {
+			return fmt.Errorf("%s: %w", op, services.ErrPasskeyExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// BeginLogin returns the options for navigator.credentials.get.
+// The user is identified by the passkey the authenticator picks
+func (p *Passkeys) BeginLogin(ctx context.Context) (models.PasskeyCeremony, error) {
+	const op = "passkey.BeginLogin"
+
+	assertion, session, err := p.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return models.PasskeyCeremony{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ceremony, err := p.newCeremony(ctx, assertion, session)
+	if err != nil {
+		return models.PasskeyCeremony{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ceremony, nil
+}
+
+// FinishLogin verifies the assertion and returns the id of the passkey owner
+func (p *Passkeys) FinishLogin(ctx context.Context, ceremonyToken string, response []byte) (uuid.UUID, error) {
+	const op = "passkey.FinishLogin"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	session, err := p.takeCeremony(ctx, ceremonyToken)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+	}
+
+	var owner *user
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userId, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+
+		owner, err = p.provideUser(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+
+		return owner, nil
+	}
+
+	_, credential, err := p.webAuthn.ValidatePasskeyLogin(handler, session, parsed)
+	if err != nil {
+		log.Error(ctx, "failed to verify passkey assertion", zap.Error(err))
+
+		return uuid.Nil, fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+	}
+
+	passkey := owner.passkey(credential.ID)
+	passkey.SignCount = credential.Authenticator.SignCount
+	passkey.CloneWarning = credential.Authenticator.CloneWarning
+	passkey.BackupState = credential.Flags.BackupState
+
+	if err := p.passkeyStorage.UpdatePasskeyUsage(ctx, passkey); err != nil {
+		log.Error(ctx, "failed to update passkey", zap.Error(err))
+
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// a sign count that did not grow means the private key may have been copied.
+	// The warning is never cleared, so the passkey can not be used anymore
+	if passkey.CloneWarning {
+		log.Error(ctx, "passkey sign count went backwards, the authenticator may be cloned",
+			zap.String("user_id", passkey.UserId.String()),
+		)
+
+		return uuid.Nil, fmt.Errorf("%s: %w", op, services.ErrNotAuthorized)
+	}
+
+	return passkey.UserId, nil
+}
+
+func (p *Passkeys) newCeremony(ctx context.Context, options any, session *webauthn.SessionData) (models.PasskeyCeremony, error) {
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return models.PasskeyCeremony{}, err
+	}
+
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return models.PasskeyCeremony{}, err
+	}
+
+	token := uuid.NewString()
+	if err := p.ceremonies.CreatePasskeyCeremony(ctx, token, sessionData, p.ceremonyTTL); err != nil {
+		return models.PasskeyCeremony{}, err
+	}
+
+	return models.PasskeyCeremony{
+		Token:   token,
+		Options: optionsJSON,
+	}, nil
+}
+
+func (p *Passkeys) takeCeremony(ctx context.Context, ceremonyToken string) (webauthn.SessionData, error) {
+	sessionData, err := p.ceremonies.TakePasskeyCeremony(ctx, ceremonyToken)
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyCeremonyNotFound) {
+			return webauthn.SessionData{}, services.ErrNotAuthorized
+		}
+
+		return webauthn.SessionData{}, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(sessionData, &session); err != nil {
+		return webauthn.SessionData{}, err
+	}
+
+	return session, nil
+}
+
+func (p *Passkeys) provideUser(ctx context.Context, userId uuid.UUID) (*user, error) {
+	u, err := p.userProvider.ProvideUserById(ctx, userId)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, services.ErrUserNotFound
+		}
+
+		return nil, err
+	}
+
+	passkeys, err := p.passkeyStorage.ProvidePasskeys(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user{User: u, passkeys: passkeys}, nil
+}
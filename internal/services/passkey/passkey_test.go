@@ -0,0 +1,396 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+type memoryUserProvider struct {
+	user models.User
+}
+
+func (p *memoryUserProvider) ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error) {
+	if p.user.UserInfo.Id != id {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return p.user, nil
+}
+
+type memoryPasskeyStorage struct {
+	passkeys []models.Passkey
+}
+
+func (s *memoryPasskeyStorage) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.Id, passkey.Id) {
+			return storage.ErrPasskeyExists
+		}
+	}
+
+	s.passkeys = append(s.passkeys, passkey)
+
+	return nil
+}
+
+func (s *memoryPasskeyStorage) ProvidePasskeys(ctx context.Context, userId uuid.UUID) ([]models.Passkey, error) {
+	passkeys := make([]models.Passkey, 0)
+	for _, p := range s.passkeys {
+		if p.UserId == userId {
+			passkeys = append(passkeys, p)
+		}
+	}
+
+	return passkeys, nil
+}
+
+func (s *memoryPasskeyStorage) UpdatePasskeyUsage(ctx context.Context, passkey models.Passkey) error {
+	for i := range s.passkeys {
+		if bytes.Equal(s.passkeys[i].Id, passkey.Id) {
+			s.passkeys[i].SignCount = passkey.SignCount
+			s.passkeys[i].CloneWarning = passkey.CloneWarning
+			s.passkeys[i].BackupState = passkey.BackupState
+			return nil
+		}
+	}
+
+	return storage.ErrPasskeyNotFound
+}
+
+type memoryCeremonyStorage struct {
+	ceremonies map[string][]byte
+}
+
+func (s *memoryCeremonyStorage) CreatePasskeyCeremony(ctx context.Context, ceremonyToken string, sessionData []byte, ttl time.Duration) error {
+	s.ceremonies[ceremonyToken] = sessionData
+
+	return nil
+}
+
+func (s *memoryCeremonyStorage) TakePasskeyCeremony(ctx context.Context, ceremonyToken string) ([]byte, error) {
+	sessionData, ok := s.ceremonies[ceremonyToken]
+	if !ok {
+		return nil, storage.ErrPasskeyCeremonyNotFound
+	}
+
+	delete(s.ceremonies, ceremonyToken)
+
+	return sessionData, nil
+}
+
+// softwareAuthenticator creates and uses a single passkey, like a platform authenticator would
+type softwareAuthenticator struct {
+	credentialId []byte
+	privateKey   *ecdsa.PrivateKey
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	credentialId := make([]byte, 16)
+	if _, err := rand.Read(credentialId); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return &softwareAuthenticator{
+		credentialId: credentialId,
+		privateKey:   privateKey,
+	}
+}
+
+// create answers navigator.credentials.create with a "none" attestation
+func (a *softwareAuthenticator) create(t *testing.T, options []byte) []byte {
+	var creation struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				Id string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &creation); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userHandle, err := base64.RawURLEncoding.DecodeString(creation.PublicKey.User.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.userHandle = userHandle
+
+	publicKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.privateKey.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.privateKey.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// user present, user verified, attested credential data included
+	authData := a.authenticatorData(0x45)
+	authData = append(authData, make([]byte, 16)...) // aaguid
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialId)))
+	authData = append(authData, a.credentialId...)
+	authData = append(authData, publicKey...)
+
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encode(clientData(t, "webauthn.create", creation.PublicKey.Challenge)),
+		"attestationObject": encode(attestationObject),
+	})
+}
+
+// get answers navigator.credentials.get
+func (a *softwareAuthenticator) get(t *testing.T, options []byte) []byte {
+	var assertion struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &assertion); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a.signCount++
+
+	// user present, user verified
+	authData := a.authenticatorData(0x05)
+	clientDataJSON := clientData(t, "webauthn.get", assertion.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.privateKey, digest[:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encode(clientDataJSON),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+func (a *softwareAuthenticator) authenticatorData(flags byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(testRPID))
+
+	authData := append(rpIdHash[:], flags)
+	return binary.BigEndian.AppendUint32(authData, a.signCount)
+}
+
+func (a *softwareAuthenticator) credential(t *testing.T, response map[string]string) []byte {
+	credential, err := json.Marshal(map[string]interface{}{
+		"id":       encode(a.credentialId),
+		"rawId":    encode(a.credentialId),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return credential
+}
+
+func clientData(t *testing.T, ceremonyType, challenge string) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return data
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	userId := uuid.New()
+	userProvider := &memoryUserProvider{
+		user: models.User{
+			UserInfo: models.UserInfo{Id: userId, Name: "John", Surname: "Doe"},
+			UserAuth: models.UserAuth{Id: userId, Email: "john.doe@example.com"},
+		},
+	}
+	passkeyStorage := &memoryPasskeyStorage{}
+	ceremonies := &memoryCeremonyStorage{ceremonies: make(map[string][]byte)}
+
+	passkeyService, err := New(ctx, userProvider, passkeyStorage, ceremonies, Config{
+		RPID:          testRPID,
+		RPDisplayName: "AppHelper",
+		RPOrigins:     []string{testOrigin},
+		CeremonyTTL:   time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	authenticator := newSoftwareAuthenticator(t)
+
+	// registration
+	ceremony, err := passkeyService.BeginRegistration(ctx, userId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	response := authenticator.create(t, ceremony.Options)
+	if err := passkeyService.FinishRegistration(ctx, userId, ceremony.Token, "laptop", response); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(passkeyStorage.passkeys) != 1 {
+		t.Fatalf("unexpected number of passkeys: %d", len(passkeyStorage.passkeys))
+	}
+
+	// a ceremony can be finished only once
+	err = passkeyService.FinishRegistration(ctx, userId, ceremony.Token, "laptop", response)
+	if !errors.Is(err, services.ErrNotAuthorized) {
+		t.Errorf("expected not authorized, got %v", err)
+	}
+
+	// login
+	ceremony, err = passkeyService.BeginLogin(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loggedIn, err := passkeyService.FinishLogin(ctx, ceremony.Token, authenticator.get(t, ceremony.Options))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if loggedIn != userId {
+		t.Errorf("unexpected user: %s", loggedIn)
+	}
+
+	if passkeyStorage.passkeys[0].SignCount != 1 {
+		t.Errorf("sign count is not updated: %d", passkeyStorage.passkeys[0].SignCount)
+	}
+}
+
+func TestPasskeyCloneDetection(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	userId := uuid.New()
+	userProvider := &memoryUserProvider{
+		user: models.User{
+			UserInfo: models.UserInfo{Id: userId, Name: "John", Surname: "Doe"},
+			UserAuth: models.UserAuth{Id: userId, Email: "john.doe@example.com"},
+		},
+	}
+	passkeyStorage := &memoryPasskeyStorage{}
+	ceremonies := &memoryCeremonyStorage{ceremonies: make(map[string][]byte)}
+
+	passkeyService, err := New(ctx, userProvider, passkeyStorage, ceremonies, Config{
+		RPID:          testRPID,
+		RPDisplayName: "AppHelper",
+		RPOrigins:     []string{testOrigin},
+		CeremonyTTL:   time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	authenticator := newSoftwareAuthenticator(t)
+
+	ceremony, err := passkeyService.BeginRegistration(ctx, userId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := passkeyService.FinishRegistration(ctx, userId, ceremony.Token, "", authenticator.create(t, ceremony.Options)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	authenticator.signCount = 10
+
+	ceremony, err = passkeyService.BeginLogin(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := passkeyService.FinishLogin(ctx, ceremony.Token, authenticator.get(t, ceremony.Options)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a copy of the key with an older counter
+	clone := *authenticator
+	clone.signCount = 3
+
+	ceremony, err = passkeyService.BeginLogin(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = passkeyService.FinishLogin(ctx, ceremony.Token, clone.get(t, ceremony.Options))
+	if !errors.Is(err, services.ErrNotAuthorized) {
+		t.Errorf("expected not authorized, got %v", err)
+	}
+
+	if !passkeyStorage.passkeys[0].CloneWarning {
+		t.Errorf("clone warning is not stored")
+	}
+
+	// the original authenticator is locked out as well
+	ceremony, err = passkeyService.BeginLogin(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = passkeyService.FinishLogin(ctx, ceremony.Token, authenticator.get(t, ceremony.Options))
+	if !errors.Is(err, services.ErrNotAuthorized) {
+		t.Errorf("expected not authorized, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package passkey
+
+import (
+	"bytes"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+)
+
+// user adapts a user and their passkeys to webauthn.User
+type user struct {
+	models.User
+	passkeys []models.Passkey
+}
+
+func (u *user) WebAuthnID() []byte {
+	return u.UserInfo.Id[:]
+}
+
+func (u *user) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *user) WebAuthnDisplayName() string {
+	return u.Name + " " + u.Surname
+}
+
+func (u *user) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.passkeys))
+
+	for i, passkey := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, len(passkey.Transports))
+		for j, transport := range passkey.Transports {
+			transports[j] = protocol.AuthenticatorTransport(transport)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              passkey.Id,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:       passkey.AAGUID,
+				SignCount:    passkey.SignCount,
+				CloneWarning: passkey.CloneWarning,
+			},
+		}
+	}
+
+	return credentials
+}
+
+func (u *user) descriptors() []protocol.CredentialDescriptor {
+	credentials := u.WebAuthnCredentials()
+	descriptors := make([]protocol.CredentialDescriptor, len(credentials))
+
+	for i := range credentials {
+		descriptors[i] = credentials[i].Descriptor()
+	}
+
+	return descriptors
+}
+
+func (u *user) passkey(id []byte) models.Passkey {
+	for _, passkey := range u.passkeys {
+		if bytes.Equal(passkey.Id, id) {
+			return passkey
+		}
+	}
+
+	return models.Passkey{}
+}
@@ -0,0 +1,280 @@
+package personaltokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	golangjwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	secretLen = 32
+
+	// last_used_at is not written on every request
+	lastUsedPrecision = time.Minute
+)
+
+var nameRe = regexp.MustCompile(`^[\p{L}\p{N} ._-]{1,64}$`)
+
+type TokenStorage interface {
+	SavePersonalAccessToken(ctx context.Context, token models.PersonalAccessToken) error
+	ProvidePersonalAccessToken(ctx context.Context, tokenHash []byte) (models.PersonalAccessToken, error)
+	ProvidePersonalAccessTokens(ctx context.Context, userId uuid.UUID) ([]models.PersonalAccessToken, error)
+	UpdatePersonalAccessTokenLastUsed(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error
+	DeletePersonalAccessToken(ctx context.Context, userId, id uuid.UUID) error
+}
+
+type UserProvider interface {
+	ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error)
+}
+
+type RoleProvider interface {
+	UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+	MemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+}
+
+// PersonalTokens manages the personal access tokens of users and verifies them
+type PersonalTokens struct {
+	log *logger.Logger
+
+	tokenStorage TokenStorage
+	userProvider UserProvider
+	roles        RoleProvider
+
+	// iss and aud of the claims of the tokens
+	issuer   string
+	audience string
+}
+
+func New(ctx context.Context,
+	tokenStorage TokenStorage,
+	userProvider UserProvider,
+	roles RoleProvider,
+	issuer string,
+	audience string,
+) *PersonalTokens {
+	return &PersonalTokens{
+		log:          logger.GetLoggerFromCtx(ctx),
+		tokenStorage: tokenStorage,
+		userProvider: userProvider,
+		roles:        roles,
+		issuer:       issuer,
+		audience:     audience,
+	}
+}
+
+// Create creates a token of the user and returns it with the token itself, which is not stored.
+// The scopes must be permissions the user has
+func (p *PersonalTokens) Create(ctx context.Context, token models.PersonalAccessToken) (models.PersonalAccessToken, string, error) {
+	const op = "personaltokens.Create"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if !nameRe.MatchString(token.Name) || (!token.ExpiresAt.IsZero() && !token.ExpiresAt.After(time.Now())) {
+		return models.PersonalAccessToken{}, "", fmt.Errorf("%s: %w", op, services.ErrInvalidPersonalAccessToken)
+	}
+
+	user, err := p.userProvider.ProvideUserById(ctx, token.UserId)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.PersonalAccessToken{}, "", fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+		}
+
+		return models.PersonalAccessToken{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	permissions, err := p.userPermissions(ctx, user)
+	if err != nil {
+		return models.PersonalAccessToken{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, scope := range token.Scopes {
+		if !slices.Contains(permissions, scope) {
+			return models.PersonalAccessToken{}, "", fmt.Errorf("%s: %w", op, services.ErrInvalidPersonalAccessToken)
+		}
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return models.PersonalAccessToken{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token.Id = uuid.New()
+	token.TokenHash = hashToken(secret)
+	token.Scopes = slices.Compact(slices.Sorted(slices.Values(token.Scopes)))
+	token.LastUsedAt = time.Time{}
+	token.CreatedAt = time.Now()
+
+	if err := p.tokenStorage.SavePersonalAccessToken(ctx, token); err != nil {
+		log.Error(ctx, "failed to save personal access token", zap.Error(err))
+
+		return models.PersonalAccessToken{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "personal access token created", zap.String("user_id", token.UserId.String()), zap.String("token_id", token.Id.String()))
+
+	return token, secret, nil
+}
+
+// List returns the tokens of the user
+func (p *PersonalTokens) List(ctx context.Context, userId uuid.UUID) ([]models.PersonalAccessToken, error) {
+	const op = "personaltokens.List"
+
+	tokens, err := p.tokenStorage.ProvidePersonalAccessTokens(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// Revoke deletes a token of the user, it stops working at once
+func (p *PersonalTokens) Revoke(ctx context.Context, userId, id uuid.UUID) error {
+	const op = "personaltokens.Revoke"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := p.tokenStorage.DeletePersonalAccessToken(ctx, userId, id); err != nil {
+		if errors.Is(err, storage.ErrPersonalAccessTokenNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrPersonalAccessTokenNotFound)
+		}
+
+		log.Error(ctx, "failed to delete personal access token", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "personal access token revoked", zap.String("user_id", userId.String()), zap.String("token_id", id.String()))
+
+	return nil
+}
+
+// VerifyPersonalAccessToken returns the claims of a token as if it were an access token of its user.
+// The permissions are the scopes the user still has, unknown and expired tokens wrap jwt.ErrUnauthorized
+func (p *PersonalTokens) VerifyPersonalAccessToken(ctx context.Context, secret string) (jwks.Claims, error) {
+	const op = "personaltokens.VerifyPersonalAccessToken"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if !strings.HasPrefix(secret, authorization.PersonalAccessTokenPrefix) {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, jwt.ErrUnauthorized)
+	}
+
+	token, err := p.tokenStorage.ProvidePersonalAccessToken(ctx, hashToken(secret))
+	if err != nil {
+		if errors.Is(err, storage.ErrPersonalAccessTokenNotFound) {
+			return jwks.Claims{}, fmt.Errorf("%s: %w", op, jwt.ErrUnauthorized)
+		}
+
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	if !token.ExpiresAt.IsZero() && !token.ExpiresAt.After(now) {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, jwt.ErrUnauthorized)
+	}
+
+	user, err := p.userProvider.ProvideUserById(ctx, token.UserId)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return jwks.Claims{}, fmt.Errorf("%s: %w", op, jwt.ErrUnauthorized)
+		}
+
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	permissions, err := p.userPermissions(ctx, user)
+	if err != nil {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if now.Sub(token.LastUsedAt) > lastUsedPrecision {
+		if err := p.tokenStorage.UpdatePersonalAccessTokenLastUsed(ctx, token.Id, now); err != nil {
+			// the token is still valid
+			log.Error(ctx, "failed to update personal access token last use", zap.Error(err))
+		}
+	}
+
+	claims := jwks.Claims{
+		RegisteredClaims: golangjwt.RegisteredClaims{
+			ID:       token.Id.String(),
+			Issuer:   p.issuer,
+			Subject:  user.UserInfo.Id.String(),
+			Audience: golangjwt.ClaimStrings{p.audience},
+			IssuedAt: golangjwt.NewNumericDate(token.CreatedAt),
+		},
+		UserId:  user.UserInfo.Id.String(),
+		Name:    user.UserInfo.Name,
+		Surname: user.UserInfo.Surname,
+		Scope:   strings.Join(token.Scopes, " "),
+		Permissions: slices.DeleteFunc(slices.Clone(token.Scopes), func(scope string) bool {
+			return !slices.Contains(permissions, scope)
+		}),
+	}
+	if !token.ExpiresAt.IsZero() {
+		claims.ExpiresAt = golangjwt.NewNumericDate(token.ExpiresAt)
+	}
+	if user.OrganizationId != uuid.Nil {
+		claims.OrganizationId = user.OrganizationId.String()
+	}
+
+	return claims, nil
+}
+
+// userPermissions returns the permissions the roles of the user grant, as in its access tokens
+func (p *PersonalTokens) userPermissions(ctx context.Context, user models.User) ([]string, error) {
+	roles, err := p.roles.UserRoles(ctx, user.UserInfo.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.OrganizationId != uuid.Nil {
+		memberRoles, err := p.roles.MemberRoles(ctx, user.UserInfo.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, memberRoles...)
+	}
+
+	var permissions []string
+	for _, role := range roles {
+		for _, permission := range role.Permissions {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	return permissions, nil
+}
+
+// tokens are random, a fast hash is enough to keep them from leaking with the database
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+
+	return sum[:]
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, secretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return authorization.PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
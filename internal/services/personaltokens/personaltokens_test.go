@@ -0,0 +1,228 @@
+package personaltokens
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryTokenStorage map[uuid.UUID]models.PersonalAccessToken
+
+func (s memoryTokenStorage) SavePersonalAccessToken(ctx context.Context, token models.PersonalAccessToken) error {
+	s[token.Id] = token
+
+	return nil
+}
+
+func (s memoryTokenStorage) ProvidePersonalAccessToken(ctx context.Context, tokenHash []byte) (models.PersonalAccessToken, error) {
+	for _, token := range s {
+		if string(token.TokenHash) == string(tokenHash) {
+			return token, nil
+		}
+	}
+
+	return models.PersonalAccessToken{}, storage.ErrPersonalAccessTokenNotFound
+}
+
+func (s memoryTokenStorage) ProvidePersonalAccessTokens(ctx context.Context, userId uuid.UUID) ([]models.PersonalAccessToken, error) {
+	tokens := make([]models.PersonalAccessToken, 0)
+	for _, token := range s {
+		if token.UserId == userId {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (s memoryTokenStorage) UpdatePersonalAccessTokenLastUsed(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error {
+	token := s[id]
+	token.LastUsedAt = lastUsedAt
+	s[id] = token
+
+	return nil
+}
+
+func (s memoryTokenStorage) DeletePersonalAccessToken(ctx context.Context, userId, id uuid.UUID) error {
+	token, ok := s[id]
+	if !ok || token.UserId != userId {
+		return storage.ErrPersonalAccessTokenNotFound
+	}
+
+	delete(s, id)
+
+	return nil
+}
+
+type userProvider map[uuid.UUID]models.User
+
+func (p userProvider) ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error) {
+	user, ok := p[id]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// roleProvider grants the roles to every user
+type roleProvider struct {
+	roles       []models.Role
+	memberRoles []models.Role
+}
+
+func (p *roleProvider) UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	return p.roles, nil
+}
+
+func (p *roleProvider) MemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	return p.memberRoles, nil
+}
+
+func setupService(t *testing.T) (*PersonalTokens, memoryTokenStorage, *roleProvider, models.User, context.Context) {
+	t.Helper()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userId := uuid.New()
+	user := models.User{
+		UserInfo:       models.UserInfo{Id: userId, Name: "John", Surname: "Doe"},
+		UserAuth:       models.UserAuth{Id: userId, Email: "john@example.com"},
+		OrganizationId: uuid.New(),
+	}
+
+	tokenStorage := memoryTokenStorage{}
+	roles := &roleProvider{
+		roles:       []models.Role{{Name: "reader", Permissions: []string{"sso:users:read"}}},
+		memberRoles: []models.Role{{Name: "teacher", Permissions: []string{"journal:write"}}},
+	}
+
+	service := New(ctx, tokenStorage, userProvider{userId: user}, roles, "https://sso.example.com", "apphelper")
+
+	return service, tokenStorage, roles, user, ctx
+}
+
+func TestCreateVerify(t *testing.T) {
+	service, tokenStorage, roles, user, ctx := setupService(t)
+
+	token, secret, err := service.Create(ctx, models.PersonalAccessToken{
+		UserId: user.UserInfo.Id,
+		Name:   "ci",
+		Scopes: []string{"journal:write", "sso:users:read"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(secret, authorization.PersonalAccessTokenPrefix) {
+		t.Errorf("token has no prefix: %q", secret)
+	}
+
+	if strings.Contains(string(tokenStorage[token.Id].TokenHash), secret) {
+		t.Errorf("token is stored in clear")
+	}
+
+	claims, err := service.VerifyPersonalAccessToken(ctx, secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.UserId != user.UserInfo.Id.String() || claims.OrganizationId != user.OrganizationId.String() || len(claims.Permissions) != 2 {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	if tokenStorage[token.Id].LastUsedAt.IsZero() {
+		t.Errorf("last use is not tracked")
+	}
+
+	// the token loses the permissions the user loses
+	roles.memberRoles = nil
+
+	claims, err = service.VerifyPersonalAccessToken(ctx, secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !slices.Equal(claims.Permissions, []string{"sso:users:read"}) {
+		t.Errorf("unexpected permissions: %v", claims.Permissions)
+	}
+
+	if _, err := service.VerifyPersonalAccessToken(ctx, secret+"x"); !errors.Is(err, jwt.ErrUnauthorized) {
+		t.Errorf("expected unauthorized, got %v", err)
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	service, _, _, user, ctx := setupService(t)
+
+	for _, invalid := range []models.PersonalAccessToken{
+		{UserId: user.UserInfo.Id, Name: ""},
+		{UserId: user.UserInfo.Id, Name: "ci", Scopes: []string{"sso:roles:write"}},
+		{UserId: user.UserInfo.Id, Name: "ci", ExpiresAt: time.Now().Add(-time.Minute)},
+	} {
+		if _, _, err := service.Create(ctx, invalid); !errors.Is(err, services.ErrInvalidPersonalAccessToken) {
+			t.Errorf("expected invalid personal access token for %+v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestExpireRevoke(t *testing.T) {
+	service, tokenStorage, _, user, ctx := setupService(t)
+
+	token, secret, err := service.Create(ctx, models.PersonalAccessToken{
+		UserId:    user.UserInfo.Id,
+		Name:      "ci",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims, err := service.VerifyPersonalAccessToken(ctx, secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.ExpiresAt == nil || len(claims.Permissions) != 0 {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	expired := tokenStorage[token.Id]
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	tokenStorage[token.Id] = expired
+
+	if _, err := service.VerifyPersonalAccessToken(ctx, secret); !errors.Is(err, jwt.ErrUnauthorized) {
+		t.Errorf("expected unauthorized for an expired token, got %v", err)
+	}
+
+	if err := service.Revoke(ctx, uuid.New(), token.Id); !errors.Is(err, services.ErrPersonalAccessTokenNotFound) {
+		t.Errorf("expected not found for a token of another user, got %v", err)
+	}
+
+	if err := service.Revoke(ctx, user.UserInfo.Id, token.Id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tokens, err := service.List(ctx, user.UserInfo.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tokens) != 0 {
+		t.Errorf("unexpected tokens: %+v", tokens)
+	}
+}
@@ -0,0 +1,255 @@
+package roles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// RoleAdmin is created by the migrations and cannot be deleted, so that someone can always manage the roles
+const RoleAdmin = "admin"
+
+// permissions of sso, see the admin role in the migrations
+const (
+	PermissionUsersRead          = "sso:users:read"
+	PermissionUsersWrite         = "sso:users:write"
+	PermissionRolesWrite         = "sso:roles:write"
+	PermissionClientsWrite       = "sso:clients:write"
+	PermissionOrganizationsRead  = "sso:organizations:read"
+	PermissionOrganizationsWrite = "sso:organizations:write"
+
+	PermissionServiceAccountsWrite = "sso:service_accounts:write"
+	PermissionUsersImpersonate     = "sso:users:impersonate"
+	PermissionTokensRead           = "sso:tokens:read"
+	PermissionTokensWrite          = "sso:tokens:write"
+)
+
+// platformPermissions manage sso across the organizations,
+// the roles a user has in an organization do not grant them
+var platformPermissions = []string{
+	PermissionRolesWrite,
+	PermissionClientsWrite,
+	PermissionOrganizationsRead,
+	PermissionOrganizationsWrite,
+	PermissionServiceAccountsWrite,
+	PermissionUsersImpersonate,
+	PermissionTokensRead,
+	PermissionTokensWrite,
+}
+
+// PlatformPermissions returns the permissions that manage sso across the organizations
+func PlatformPermissions() []string {
+	return slices.Clone(platformPermissions)
+}
+
+// permissions are "<service>:<resource>:<action>", e.g. "report:reports:read"
+var (
+	roleNameRe   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+	permissionRe = regexp.MustCompile(`^[a-z0-9_-]+(:[a-z0-9_-]+){1,3}$`)
+)
+
+type RoleStorage interface {
+	SaveRole(ctx context.Context, role models.Role) error
+	UpdateRole(ctx context.Context, role models.Role) error
+	DeleteRole(ctx context.Context, name string) error
+	ProvideRoles(ctx context.Context) ([]models.Role, error)
+	ProvideUserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+	ProvideMemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error)
+	AssignRole(ctx context.Context, userId uuid.UUID, role string) error
+	UnassignRole(ctx context.Context, userId uuid.UUID, role string) error
+}
+
+// Roles manages the roles and their assignment to users
+type Roles struct {
+	log *logger.Logger
+
+	roleStorage RoleStorage
+}
+
+func New(ctx context.Context, roleStorage RoleStorage) *Roles {
+	return &Roles{
+		log:         logger.GetLoggerFromCtx(ctx),
+		roleStorage: roleStorage,
+	}
+}
+
+func (r *Roles) Create(ctx context.Context, role models.Role) error {
+	const op = "roles.Create"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := validateRole(role); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := r.roleStorage.SaveRole(ctx, role); err != nil {
+		if errors.Is(err, storage.ErrRoleExists) {
+			return fmt.Errorf("%s: %w", op, services.ErrRoleExists)
+		}
+
+		log.Error(ctx, "failed to save role", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "role created", zap.String("role", role.Name))
+
+	return nil
+}
+
+// Update replaces the description and the permissions of the role.
+// Tokens issued before keep the old permissions until they expire
+func (r *Roles) Update(ctx context.Context, role models.Role) error {
+	const op = "roles.Update"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := validateRole(role); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := r.roleStorage.UpdateRole(ctx, role); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrRoleNotFound)
+		}
+
+		log.Error(ctx, "failed to update role", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "role updated", zap.String("role", role.Name))
+
+	return nil
+}
+
+func (r *Roles) Delete(ctx context.Context, name string) error {
+	const op = "roles.Delete"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if name == RoleAdmin {
+		return fmt.Errorf("%s: %w", op, services.ErrInvalidRole)
+	}
+
+	if err := r.roleStorage.DeleteRole(ctx, name); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrRoleNotFound)
+		}
+
+		log.Error(ctx, "failed to delete role", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "role deleted", zap.String("role", name))
+
+	return nil
+}
+
+func (r *Roles) List(ctx context.Context) ([]models.Role, error) {
+	const op = "roles.List"
+
+	roles, err := r.roleStorage.ProvideRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+func (r *Roles) Assign(ctx context.Context, userId uuid.UUID, role string) error {
+	const op = "roles.Assign"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := r.roleStorage.AssignRole(ctx, userId, role); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrRoleNotFound)
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrUserNotFound)
+		}
+
+		log.Error(ctx, "failed to assign role", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "role assigned", zap.String("user_id", userId.String()), zap.String("role", role))
+
+	return nil
+}
+
+func (r *Roles) Unassign(ctx context.Context, userId uuid.UUID, role string) error {
+	const op = "roles.Unassign"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := r.roleStorage.UnassignRole(ctx, userId, role); err != nil {
+		if errors.Is(err, storage.ErrRoleNotAssigned) {
+			return fmt.Errorf("%s: %w", op, services.ErrRoleNotAssigned)
+		}
+
+		log.Error(ctx, "failed to unassign role", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "role unassigned", zap.String("user_id", userId.String()), zap.String("role", role))
+
+	return nil
+}
+
+// UserRoles returns the roles of the user
+func (r *Roles) UserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	const op = "roles.UserRoles"
+
+	roles, err := r.roleStorage.ProvideUserRoles(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// MemberRoles returns the roles the user has in their organization without the platform permissions
+func (r *Roles) MemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	const op = "roles.MemberRoles"
+
+	roles, err := r.roleStorage.ProvideMemberRoles(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := range roles {
+		permissions := make([]string, 0, len(roles[i].Permissions))
+		for _, permission := range roles[i].Permissions {
+			if !slices.Contains(platformPermissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+
+		roles[i].Permissions = permissions
+	}
+
+	return roles, nil
+}
+
+func validateRole(role models.Role) error {
+	if !roleNameRe.MatchString(role.Name) || len(role.Description) > 256 {
+		return services.ErrInvalidRole
+	}
+
+	for _, permission := range role.Permissions {
+		if !permissionRe.MatchString(permission) {
+			return services.ErrInvalidRole
+		}
+	}
+
+	return nil
+}
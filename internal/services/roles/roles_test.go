@@ -0,0 +1,230 @@
+package roles
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type memoryRoleStorage struct {
+	roles       map[string]models.Role
+	userRoles   map[uuid.UUID][]string
+	memberRoles map[uuid.UUID][]string
+}
+
+func (s *memoryRoleStorage) SaveRole(ctx context.Context, role models.Role) error {
+	if _, ok := s.roles[role.Name]; ok {
+		return storage.ErrRoleExists
+	}
+
+	s.roles[role.Name] = role
+
+	return nil
+}
+
+func (s *memoryRoleStorage) UpdateRole(ctx context.Context, role models.Role) error {
+	if _, ok := s.roles[role.Name]; !ok {
+		return storage.ErrRoleNotFound
+	}
+
+	s.roles[role.Name] = role
+
+	return nil
+}
+
+func (s *memoryRoleStorage) DeleteRole(ctx context.Context, name string) error {
+	if _, ok := s.roles[name]; !ok {
+		return storage.ErrRoleNotFound
+	}
+
+	delete(s.roles, name)
+	for userId, roles := range s.userRoles {
+		s.userRoles[userId] = slices.DeleteFunc(roles, func(role string) bool { return role == name })
+	}
+
+	return nil
+}
+
+func (s *memoryRoleStorage) ProvideRoles(ctx context.Context) ([]models.Role, error) {
+	roles := make([]models.Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+func (s *memoryRoleStorage) ProvideUserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	roles := make([]models.Role, 0)
+	for _, name := range s.userRoles[userId] {
+		roles = append(roles, s.roles[name])
+	}
+
+	return roles, nil
+}
+
+func (s *memoryRoleStorage) ProvideMemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	roles := make([]models.Role, 0)
+	for _, name := range s.memberRoles[userId] {
+		roles = append(roles, s.roles[name])
+	}
+
+	return roles, nil
+}
+
+func (s *memoryRoleStorage) AssignRole(ctx context.Context, userId uuid.UUID, role string) error {
+	if _, ok := s.roles[role]; !ok {
+		return storage.ErrRoleNotFound
+	}
+
+	if !slices.Contains(s.userRoles[userId], role) {
+		s.userRoles[userId] = append(s.userRoles[userId], role)
+	}
+
+	return nil
+}
+
+func (s *memoryRoleStorage) UnassignRole(ctx context.Context, userId uuid.UUID, role string) error {
+	if !slices.Contains(s.userRoles[userId], role) {
+		return storage.ErrRoleNotAssigned
+	}
+
+	s.userRoles[userId] = slices.DeleteFunc(s.userRoles[userId], func(r string) bool { return r == role })
+
+	return nil
+}
+
+func setup(t *testing.T) (context.Context, *Roles) {
+	ctx, roles, _ := setupStorage(t)
+
+	return ctx, roles
+}
+
+func setupStorage(t *testing.T) (context.Context, *Roles, *memoryRoleStorage) {
+	t.Helper()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	roleStorage := &memoryRoleStorage{
+		roles: map[string]models.Role{
+			RoleAdmin: {Name: RoleAdmin, Permissions: []string{"sso:roles:write"}},
+		},
+		userRoles:   map[uuid.UUID][]string{},
+		memberRoles: map[uuid.UUID][]string{},
+	}
+
+	return ctx, New(ctx, roleStorage), roleStorage
+}
+
+func TestAssignRole(t *testing.T) {
+	ctx, roles := setup(t)
+
+	role := models.Role{Name: "teacher", Permissions: []string{"report:reports:read", "schedule:lessons:write"}}
+	if err := roles.Create(ctx, role); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := roles.Create(ctx, role); !errors.Is(err, services.ErrRoleExists) {
+		t.Errorf("expected role exists, got %v", err)
+	}
+
+	userId := uuid.New()
+	if err := roles.Assign(ctx, userId, "teacher"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := roles.Assign(ctx, userId, "student"); !errors.Is(err, services.ErrRoleNotFound) {
+		t.Errorf("expected role not found, got %v", err)
+	}
+
+	userRoles, err := roles.UserRoles(ctx, userId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(userRoles) != 1 || userRoles[0].Name != "teacher" {
+		t.Errorf("unexpected roles: %v", userRoles)
+	}
+
+	if err := roles.Unassign(ctx, userId, "teacher"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := roles.Unassign(ctx, userId, "teacher"); !errors.Is(err, services.ErrRoleNotAssigned) {
+		t.Errorf("expected role not assigned, got %v", err)
+	}
+}
+
+func TestInvalidRole(t *testing.T) {
+	ctx, roles := setup(t)
+
+	tests := []struct {
+		name string
+		role models.Role
+	}{
+		{
+			name: "empty name",
+			role: models.Role{Permissions: []string{"report:reports:read"}},
+		},
+		{
+			name: "upper case name",
+			role: models.Role{Name: "Teacher"},
+		},
+		{
+			name: "permission without a service",
+			role: models.Role{Name: "teacher", Permissions: []string{"read"}},
+		},
+		{
+			name: "empty permission",
+			role: models.Role{Name: "teacher", Permissions: []string{""}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := roles.Create(ctx, test.role); !errors.Is(err, services.ErrInvalidRole) {
+				t.Errorf("expected invalid role, got %v", err)
+			}
+		})
+	}
+
+	if err := roles.Delete(ctx, RoleAdmin); !errors.Is(err, services.ErrInvalidRole) {
+		t.Errorf("admin role is deleted: %v", err)
+	}
+}
+
+func TestMemberRoles(t *testing.T) {
+	ctx, roles, roleStorage := setupStorage(t)
+
+	role := models.Role{Name: "principal", Permissions: []string{PermissionUsersWrite, PermissionRolesWrite}}
+	if err := roles.Create(ctx, role); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userId := uuid.New()
+	roleStorage.memberRoles[userId] = []string{"principal"}
+
+	memberRoles, err := roles.MemberRoles(ctx, userId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(memberRoles) != 1 || !slices.Equal(memberRoles[0].Permissions, []string{PermissionUsersWrite}) {
+		t.Errorf("unexpected member roles: %v", memberRoles)
+	}
+
+	// the role itself keeps its permissions
+	if !slices.Equal(roleStorage.roles["principal"].Permissions, role.Permissions) {
+		t.Errorf("unexpected role permissions: %v", roleStorage.roles["principal"].Permissions)
+	}
+}
@@ -0,0 +1,214 @@
+package serviceaccounts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// assertions are short-lived, their ids are remembered until they expire
+const maxAssertionLifetime = 5 * time.Minute
+
+var (
+	nameRe       = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+	permissionRe = regexp.MustCompile(`^[a-z0-9_-]+(:[a-z0-9_-]+){1,3}$`)
+)
+
+type AccountStorage interface {
+	SaveServiceAccount(ctx context.Context, account models.ServiceAccount) error
+	ProvideServiceAccount(ctx context.Context, id uuid.UUID) (models.ServiceAccount, error)
+	ProvideServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error)
+	UpdateServiceAccountKey(ctx context.Context, id uuid.UUID, publicKey *ecdsa.PublicKey) error
+	DisableServiceAccount(ctx context.Context, id uuid.UUID) error
+}
+
+type AssertionStorage interface {
+	UseClientAssertion(ctx context.Context, subject, jti string, ttl time.Duration) error
+}
+
+// ServiceAccounts is the registry of service accounts, the principals of internal services
+type ServiceAccounts struct {
+	log *logger.Logger
+
+	accountStorage   AccountStorage
+	assertionStorage AssertionStorage
+}
+
+func New(ctx context.Context, accountStorage AccountStorage, assertionStorage AssertionStorage) *ServiceAccounts {
+	return &ServiceAccounts{
+		log:              logger.GetLoggerFromCtx(ctx),
+		accountStorage:   accountStorage,
+		assertionStorage: assertionStorage,
+	}
+}
+
+// Create registers a service account and returns it with its id
+func (s *ServiceAccounts) Create(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error) {
+	const op = "serviceaccounts.Create"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := validateServiceAccount(account); err != nil {
+		return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	account.Id = uuid.New()
+	account.Permissions = slices.Compact(slices.Sorted(slices.Values(account.Permissions)))
+	account.Disabled = false
+	account.CreatedAt = time.Now()
+
+	if err := s.accountStorage.SaveServiceAccount(ctx, account); err != nil {
+		if errors.Is(err, storage.ErrServiceAccountExists) {
+			return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, services.ErrServiceAccountExists)
+		}
+
+		log.Error(ctx, "failed to save service account", zap.Error(err))
+
+		return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "service account created", zap.String("service_account_id", account.Id.String()))
+
+	return account, nil
+}
+
+func (s *ServiceAccounts) List(ctx context.Context) ([]models.ServiceAccount, error) {
+	const op = "serviceaccounts.List"
+
+	accounts, err := s.accountStorage.ProvideServiceAccounts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return accounts, nil
+}
+
+// RotateKey replaces the public key of an account, assertions signed with the old key stop working at once
+func (s *ServiceAccounts) RotateKey(ctx context.Context, id uuid.UUID, publicKey *ecdsa.PublicKey) error {
+	const op = "serviceaccounts.RotateKey"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if publicKey == nil {
+		return fmt.Errorf("%s: %w", op, services.ErrInvalidServiceAccount)
+	}
+
+	if err := s.accountStorage.UpdateServiceAccountKey(ctx, id, publicKey); err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrServiceAccountNotFound)
+		}
+
+		log.Error(ctx, "failed to update service account key", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "service account key rotated", zap.String("service_account_id", id.String()))
+
+	return nil
+}
+
+// Disable stops the account from getting tokens, the tokens it has are valid until they expire
+func (s *ServiceAccounts) Disable(ctx context.Context, id uuid.UUID) error {
+	const op = "serviceaccounts.Disable"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if err := s.accountStorage.DisableServiceAccount(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			return fmt.Errorf("%s: %w", op, services.ErrServiceAccountNotFound)
+		}
+
+		log.Error(ctx, "failed to disable service account", zap.Error(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(ctx, "service account disabled", zap.String("service_account_id", id.String()))
+
+	return nil
+}
+
+// Authenticate returns the account a private_key_jwt assertion is signed by. The audience
+// is the token endpoint the assertion is sent to. Invalid, replayed assertions and assertions
+// of unknown or disabled accounts return services.ErrInvalidCredentials
+func (s *ServiceAccounts) Authenticate(ctx context.Context, assertion, audience string) (models.ServiceAccount, error) {
+	const op = "serviceaccounts.Authenticate"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	var (
+		account    models.ServiceAccount
+		storageErr error
+	)
+
+	claims, err := jwt.ParseClientAssertion(assertion, func(subject string) (*ecdsa.PublicKey, error) {
+		id, err := uuid.Parse(subject)
+		if err != nil {
+			return nil, err
+		}
+
+		account, err = s.accountStorage.ProvideServiceAccount(ctx, id)
+		if err != nil {
+			if !errors.Is(err, storage.ErrServiceAccountNotFound) {
+				storageErr = err
+			}
+
+			return nil, err
+		}
+
+		if account.Disabled {
+			return nil, services.ErrInvalidCredentials
+		}
+
+		return account.PublicKey, nil
+	}, audience, maxAssertionLifetime)
+	if storageErr != nil {
+		log.Error(ctx, "failed to provide service account", zap.Error(storageErr))
+
+		return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, storageErr)
+	}
+	if err != nil {
+		log.Info(ctx, "invalid client assertion", zap.Error(err))
+
+		return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+	}
+
+	// the replay window ends when the assertion expires
+	ttl := time.Until(claims.ExpiresAt) + jwt.Leeway
+	if err := s.assertionStorage.UseClientAssertion(ctx, claims.Subject, claims.Id, ttl); err != nil {
+		if errors.Is(err, storage.ErrClientAssertionReused) {
+			log.Info(ctx, "client assertion reused", zap.String("service_account_id", claims.Subject))
+
+			return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, services.ErrInvalidCredentials)
+		}
+
+		log.Error(ctx, "failed to use client assertion", zap.Error(err))
+
+		return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return account, nil
+}
+
+func validateServiceAccount(account models.ServiceAccount) error {
+	if !nameRe.MatchString(account.Name) || account.PublicKey == nil {
+		return services.ErrInvalidServiceAccount
+	}
+
+	for _, permission := range account.Permissions {
+		if !permissionRe.MatchString(permission) {
+			return services.ErrInvalidServiceAccount
+		}
+	}
+
+	return nil
+}
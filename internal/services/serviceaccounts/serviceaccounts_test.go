@@ -0,0 +1,215 @@
+package serviceaccounts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+const tokenEndpoint = "https://sso.example.com/token"
+
+type memoryAccountStorage map[uuid.UUID]models.ServiceAccount
+
+func (s memoryAccountStorage) SaveServiceAccount(ctx context.Context, account models.ServiceAccount) error {
+	for _, saved := range s {
+		if saved.Name == account.Name {
+			return storage.ErrServiceAccountExists
+		}
+	}
+
+	s[account.Id] = account
+
+	return nil
+}
+
+func (s memoryAccountStorage) ProvideServiceAccount(ctx context.Context, id uuid.UUID) (models.ServiceAccount, error) {
+	account, ok := s[id]
+	if !ok {
+		return models.ServiceAccount{}, storage.ErrServiceAccountNotFound
+	}
+
+	return account, nil
+}
+
+func (s memoryAccountStorage) ProvideServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	accounts := make([]models.ServiceAccount, 0, len(s))
+	for _, account := range s {
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+func (s memoryAccountStorage) UpdateServiceAccountKey(ctx context.Context, id uuid.UUID, publicKey *ecdsa.PublicKey) error {
+	account, ok := s[id]
+	if !ok {
+		return storage.ErrServiceAccountNotFound
+	}
+
+	account.PublicKey = publicKey
+	s[id] = account
+
+	return nil
+}
+
+func (s memoryAccountStorage) DisableServiceAccount(ctx context.Context, id uuid.UUID) error {
+	account, ok := s[id]
+	if !ok {
+		return storage.ErrServiceAccountNotFound
+	}
+
+	account.Disabled = true
+	s[id] = account
+
+	return nil
+}
+
+type memoryAssertionStorage map[string]struct{}
+
+func (s memoryAssertionStorage) UseClientAssertion(ctx context.Context, subject, jti string, ttl time.Duration) error {
+	if _, ok := s[subject+jti]; ok {
+		return storage.ErrClientAssertionReused
+	}
+
+	s[subject+jti] = struct{}{}
+
+	return nil
+}
+
+func setupService(t *testing.T) (*ServiceAccounts, models.ServiceAccount, *ecdsa.PrivateKey, context.Context) {
+	t.Helper()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prKey, _, err := jwt.GenerateKeys()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	service := New(ctx, memoryAccountStorage{}, memoryAssertionStorage{})
+
+	account, err := service.Create(ctx, models.ServiceAccount{
+		Name:        "report",
+		PublicKey:   &prKey.PublicKey,
+		Permissions: []string{"sso:users:read", "journal:read", "journal:read"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return service, account, prKey, ctx
+}
+
+func TestAuthenticate(t *testing.T) {
+	service, account, prKey, ctx := setupService(t)
+
+	if len(account.Permissions) != 2 {
+		t.Errorf("unexpected permissions: %v", account.Permissions)
+	}
+
+	assertion, err := jwt.NewClientAssertion(account.Id.String(), tokenEndpoint, time.Minute, prKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	authenticated, err := service.Authenticate(ctx, assertion, tokenEndpoint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if authenticated.Id != account.Id {
+		t.Errorf("unexpected account: %+v", authenticated)
+	}
+
+	if _, err := service.Authenticate(ctx, assertion, tokenEndpoint); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials for a replayed assertion, got %v", err)
+	}
+
+	otherKey, _, err := jwt.GenerateKeys()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, invalid := range map[string]func() (string, error){
+		"wrong audience": func() (string, error) {
+			return jwt.NewClientAssertion(account.Id.String(), "https://other.example.com/token", time.Minute, prKey)
+		},
+		"wrong key": func() (string, error) {
+			return jwt.NewClientAssertion(account.Id.String(), tokenEndpoint, time.Minute, otherKey)
+		},
+		"unknown account": func() (string, error) {
+			return jwt.NewClientAssertion(uuid.NewString(), tokenEndpoint, time.Minute, prKey)
+		},
+		"long-lived": func() (string, error) {
+			return jwt.NewClientAssertion(account.Id.String(), tokenEndpoint, time.Hour, prKey)
+		},
+	} {
+		assertion, err := invalid()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := service.Authenticate(ctx, assertion, tokenEndpoint); !errors.Is(err, services.ErrInvalidCredentials) {
+			t.Errorf("%s: expected invalid credentials, got %v", name, err)
+		}
+	}
+}
+
+func TestRotateDisable(t *testing.T) {
+	service, account, prKey, ctx := setupService(t)
+
+	newKey, _, err := jwt.GenerateKeys()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := service.RotateKey(ctx, account.Id, &newKey.PublicKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertion, err := jwt.NewClientAssertion(account.Id.String(), tokenEndpoint, time.Minute, prKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := service.Authenticate(ctx, assertion, tokenEndpoint); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials for the old key, got %v", err)
+	}
+
+	if err := service.Disable(ctx, account.Id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertion, err = jwt.NewClientAssertion(account.Id.String(), tokenEndpoint, time.Minute, newKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := service.Authenticate(ctx, assertion, tokenEndpoint); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("expected invalid credentials for a disabled account, got %v", err)
+	}
+
+	if err := service.Disable(ctx, uuid.New()); !errors.Is(err, services.ErrServiceAccountNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	if _, err := service.Create(ctx, models.ServiceAccount{Name: "report", PublicKey: &newKey.PublicKey}); !errors.Is(err, services.ErrServiceAccountExists) {
+		t.Errorf("expected exists, got %v", err)
+	}
+
+	if _, err := service.Create(ctx, models.ServiceAccount{Name: "Report!", PublicKey: &newKey.PublicKey}); !errors.Is(err, services.ErrInvalidServiceAccount) {
+		t.Errorf("expected invalid service account, got %v", err)
+	}
+}
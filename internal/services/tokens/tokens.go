@@ -0,0 +1,358 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// token type hints of RFC 7009 section 2.1
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+
+	TokenTypePersonalAccess = "personal_access_token"
+)
+
+type KeyProvider interface {
+	PublicKeys(ctx context.Context) (jwks.JWKS, error)
+}
+
+type SessionStorage interface {
+	ProvideRefreshToken(ctx context.Context, refreshToken string) (models.RefreshToken, error)
+	DeleteSession(ctx context.Context, refreshToken string) error
+}
+
+type RevocationStorage interface {
+	RevokeAccessToken(ctx context.Context, jti string, ttl time.Duration) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type PersonalAccessTokens interface {
+	VerifyPersonalAccessToken(ctx context.Context, token string) (jwks.Claims, error)
+	Revoke(ctx context.Context, userId, id uuid.UUID) error
+}
+
+// Tokens introspects and revokes the access and refresh tokens issued by the server
+type Tokens struct {
+	log *logger.Logger
+
+	keyProvider       KeyProvider
+	sessionStorage    SessionStorage
+	revocationStorage RevocationStorage
+	personalTokens    PersonalAccessTokens
+
+	// iss and aud of the access tokens
+	issuer   string
+	audience string
+}
+
+func New(ctx context.Context,
+	keyProvider KeyProvider,
+	sessionStorage SessionStorage,
+	revocationStorage RevocationStorage,
+	personalTokens PersonalAccessTokens,
+	issuer string,
+	audience string,
+) *Tokens {
+	return &Tokens{
+		log:               logger.GetLoggerFromCtx(ctx),
+		keyProvider:       keyProvider,
+		sessionStorage:    sessionStorage,
+		revocationStorage: revocationStorage,
+		personalTokens:    personalTokens,
+		issuer:            issuer,
+		audience:          audience,
+	}
+}
+
+// Introspect returns the state of an access or a refresh token. The hint only decides
+// which kind is looked up first, unknown, expired and revoked tokens are reported as inactive.
+// Personal access tokens are told apart by their prefix
+func (t *Tokens) Introspect(ctx context.Context, token, tokenTypeHint string) (models.TokenIntrospection, error) {
+	const op = "tokens.Introspect"
+
+	if strings.HasPrefix(token, authorization.PersonalAccessTokenPrefix) {
+		introspection, err := t.introspectPersonalAccessToken(ctx, token)
+		if err != nil {
+			return models.TokenIntrospection{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return introspection, nil
+	}
+
+	for _, tokenType := range lookupOrder(tokenTypeHint) {
+		var (
+			introspection models.TokenIntrospection
+			err           error
+		)
+
+		switch tokenType {
+		case TokenTypeAccess:
+			introspection, err = t.introspectAccessToken(ctx, token)
+		case TokenTypeRefresh:
+			introspection, err = t.introspectRefreshToken(ctx, token)
+		}
+		if err != nil {
+			return models.TokenIntrospection{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if introspection.Active {
+			return introspection, nil
+		}
+	}
+
+	return models.TokenIntrospection{}, nil
+}
+
+// Revoke revokes an access token until it expires, ends the session of a refresh token
+// or deletes a personal access token. If clientId is set, only the tokens issued to the client
+// are revoked and others are services.ErrNotAuthorized (RFC 7009 section 2.1).
+// Invalid tokens are not an error (RFC 7009 section 2.2)
+func (t *Tokens) Revoke(ctx context.Context, clientId, token, tokenTypeHint string) error {
+	const op = "tokens.Revoke"
+	log := logger.GetLoggerFromCtx(ctx)
+
+	if strings.HasPrefix(token, authorization.PersonalAccessTokenPrefix) {
+		if err := t.revokePersonalAccessToken(ctx, clientId, token); err != nil {
+			log.Error(ctx, "failed to revoke token", zap.String("token_type", TokenTypePersonalAccess), zap.Error(err))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return nil
+	}
+
+	for _, tokenType := range lookupOrder(tokenTypeHint) {
+		var (
+			revoked bool
+			err     error
+		)
+
+		switch tokenType {
+		case TokenTypeAccess:
+			revoked, err = t.revokeAccessToken(ctx, clientId, token)
+		case TokenTypeRefresh:
+			revoked, err = t.revokeRefreshToken(ctx, clientId, token)
+		}
+		if err != nil {
+			log.Error(ctx, "failed to revoke token", zap.String("token_type", tokenType), zap.Error(err))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if revoked {
+			log.Info(ctx, "token revoked", zap.String("token_type", tokenType))
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (t *Tokens) introspectAccessToken(ctx context.Context, token string) (models.TokenIntrospection, error) {
+	claims, ok, err := t.parseAccessToken(ctx, token)
+	if err != nil || !ok {
+		return models.TokenIntrospection{}, err
+	}
+
+	if claims.ID != "" {
+		revoked, err := t.revocationStorage.IsRevoked(ctx, claims.ID)
+		if err != nil {
+			return models.TokenIntrospection{}, err
+		}
+
+		if revoked {
+			return models.TokenIntrospection{}, nil
+		}
+	}
+
+	return models.TokenIntrospection{
+		Active:    true,
+		TokenType: TokenTypeAccess,
+		Subject:   claims.Subject,
+		ClientId:  claims.ClientId,
+		Audience:  claims.Audience,
+		Scope:     claims.Scope,
+		JTI:       claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+func (t *Tokens) introspectPersonalAccessToken(ctx context.Context, token string) (models.TokenIntrospection, error) {
+	claims, err := t.personalTokens.VerifyPersonalAccessToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrUnauthorized) {
+			return models.TokenIntrospection{}, nil
+		}
+
+		return models.TokenIntrospection{}, err
+	}
+
+	introspection := models.TokenIntrospection{
+		Active:         true,
+		TokenType:      TokenTypePersonalAccess,
+		Subject:        claims.Subject,
+		Audience:       claims.Audience,
+		Scope:          claims.Scope,
+		JTI:            claims.ID,
+		OrganizationId: claims.OrganizationId,
+		Permissions:    claims.Permissions,
+	}
+	if claims.ExpiresAt != nil {
+		introspection.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	return introspection, nil
+}
+
+func (t *Tokens) introspectRefreshToken(ctx context.Context, token string) (models.TokenIntrospection, error) {
+	refreshToken, err := t.sessionStorage.ProvideRefreshToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return models.TokenIntrospection{}, nil
+		}
+
+		return models.TokenIntrospection{}, err
+	}
+
+	return models.TokenIntrospection{
+		Active:    true,
+		TokenType: TokenTypeRefresh,
+		Subject:   refreshToken.UserId.String(),
+		ClientId:  refreshToken.ClientId,
+		Scope:     refreshToken.Scope,
+		ExpiresAt: refreshToken.ExpiresAt,
+	}, nil
+}
+
+func (t *Tokens) revokeAccessToken(ctx context.Context, clientId, token string) (bool, error) {
+	claims, ok, err := t.parseAccessToken(ctx, token)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if clientId != "" && claims.ClientId != clientId {
+		return false, services.ErrNotAuthorized
+	}
+
+	// the token is accepted for the leeway after it expires
+	ttl := time.Until(claims.ExpiresAt.Time) + jwt.Leeway
+	if err := t.revocationStorage.RevokeAccessToken(ctx, claims.ID, ttl); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (t *Tokens) revokeRefreshToken(ctx context.Context, clientId, token string) (bool, error) {
+	if clientId != "" {
+		refreshToken, err := t.sessionStorage.ProvideRefreshToken(ctx, token)
+		if err != nil {
+			if errors.Is(err, storage.ErrSessionNotFound) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		if refreshToken.ClientId != clientId {
+			return false, services.ErrNotAuthorized
+		}
+	}
+
+	if err := t.sessionStorage.DeleteSession(ctx, token); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// revokePersonalAccessToken deletes the token of its user. Personal access tokens are not
+// issued to clients, so a client can not revoke them
+func (t *Tokens) revokePersonalAccessToken(ctx context.Context, clientId, token string) error {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	claims, err := t.personalTokens.VerifyPersonalAccessToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrUnauthorized) {
+			return nil
+		}
+
+		return err
+	}
+
+	if clientId != "" {
+		return services.ErrNotAuthorized
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := t.personalTokens.Revoke(ctx, userId, id); err != nil {
+		if errors.Is(err, services.ErrPersonalAccessTokenNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	log.Info(ctx, "token revoked", zap.String("token_type", TokenTypePersonalAccess))
+
+	return nil
+}
+
+// parseAccessToken reports false for tokens that are not valid access tokens
+func (t *Tokens) parseAccessToken(ctx context.Context, token string) (jwks.Claims, bool, error) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	keySet, err := t.keyProvider.PublicKeys(ctx)
+	if err != nil {
+		return jwks.Claims{}, false, err
+	}
+
+	claims, err := jwt.ParseAccessToken(token, keySet, t.issuer, t.audience)
+	if err != nil {
+		log.Debug(ctx, "token is not a valid access token", zap.Error(err))
+
+		return jwks.Claims{}, false, nil
+	}
+
+	if claims.ID == "" || claims.Subject == "" {
+		return jwks.Claims{}, false, nil
+	}
+
+	return claims, true, nil
+}
+
+func lookupOrder(tokenTypeHint string) []string {
+	if tokenTypeHint == TokenTypeRefresh {
+		return []string{TokenTypeRefresh, TokenTypeAccess}
+	}
+
+	return []string{TokenTypeAccess, TokenTypeRefresh}
+}
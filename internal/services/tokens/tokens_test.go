@@ -0,0 +1,315 @@
+package tokens
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	golangjwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/services"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+type keyProvider struct {
+	prKey *ecdsa.PrivateKey
+}
+
+func (p keyProvider) PublicKeys(ctx context.Context) (jwks.JWKS, error) {
+	return jwks.NewJWKS(&p.prKey.PublicKey), nil
+}
+
+type memorySessionStorage map[string]models.RefreshToken
+
+func (s memorySessionStorage) ProvideRefreshToken(ctx context.Context, refreshToken string) (models.RefreshToken, error) {
+	token, ok := s[refreshToken]
+	if !ok {
+		return models.RefreshToken{}, storage.ErrSessionNotFound
+	}
+
+	return token, nil
+}
+
+func (s memorySessionStorage) DeleteSession(ctx context.Context, refreshToken string) error {
+	if _, ok := s[refreshToken]; !ok {
+		return storage.ErrSessionNotFound
+	}
+
+	delete(s, refreshToken)
+
+	return nil
+}
+
+type memoryRevocationStorage map[string]time.Duration
+
+func (s memoryRevocationStorage) RevokeAccessToken(ctx context.Context, jti string, ttl time.Duration) error {
+	s[jti] = ttl
+
+	return nil
+}
+
+func (s memoryRevocationStorage) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	_, ok := s[jti]
+
+	return ok, nil
+}
+
+// personalTokens knows the tokens by their value
+type personalTokens map[string]jwks.Claims
+
+func (p personalTokens) VerifyPersonalAccessToken(ctx context.Context, token string) (jwks.Claims, error) {
+	claims, ok := p[token]
+	if !ok {
+		return jwks.Claims{}, jwt.ErrUnauthorized
+	}
+
+	return claims, nil
+}
+
+func (p personalTokens) Revoke(ctx context.Context, userId, id uuid.UUID) error {
+	for token, claims := range p {
+		if claims.Subject == userId.String() && claims.ID == id.String() {
+			delete(p, token)
+			return nil
+		}
+	}
+
+	return services.ErrPersonalAccessTokenNotFound
+}
+
+var (
+	patUserId = uuid.New()
+	patOrgId  = uuid.New()
+	patId     = uuid.New()
+)
+
+const (
+	personalAccessToken = "ahp_token"
+
+	issuer   = "https://sso.example.com"
+	audience = "apphelper"
+)
+
+func setup(t *testing.T) (context.Context, *Tokens, *ecdsa.PrivateKey, memorySessionStorage) {
+	t.Helper()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sessions := memorySessionStorage{}
+	pats := personalTokens{
+		personalAccessToken: {
+			RegisteredClaims: golangjwt.RegisteredClaims{ID: patId.String(), Subject: patUserId.String()},
+			UserId:           patUserId.String(),
+			OrganizationId:   patOrgId.String(),
+			Scope:            "sso:users:read",
+			Permissions:      []string{"sso:users:read"},
+		},
+	}
+
+	return ctx, New(ctx, keyProvider{prKey: prKey}, sessions, memoryRevocationStorage{}, pats, issuer, audience), prKey, sessions
+}
+
+func TestAccessToken(t *testing.T) {
+	ctx, tokens, prKey, _ := setup(t)
+
+	user := models.UserInfo{Id: uuid.New(), Name: "John", Surname: "Doe"}
+
+	issued, err := jwt.NewTokens(jwt.AccessToken{
+		Issuer:   issuer,
+		Audience: audience,
+		User:     user,
+		ClientId: "web",
+		Scope:    "openid",
+	}, time.Minute, prKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	accessToken := issued.AccessToken
+
+	introspection, err := tokens.Introspect(ctx, accessToken, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !introspection.Active || introspection.TokenType != TokenTypeAccess || introspection.Subject != user.Id.String() ||
+		introspection.ClientId != "web" || introspection.Scope != "openid" || introspection.JTI == "" {
+		t.Errorf("unexpected introspection: %+v", introspection)
+	}
+
+	if err := tokens.Revoke(ctx, "", accessToken, TokenTypeRefresh); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if introspection, err = tokens.Introspect(ctx, accessToken, TokenTypeAccess); err != nil || introspection.Active {
+		t.Errorf("revoked token is active: %+v, %v", introspection, err)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	ctx, tokens, prKey, _ := setup(t)
+
+	accessToken, err := jwt.NewAccessToken(jwt.AccessToken{
+		Issuer:   issuer,
+		Audience: audience,
+		ClientId: "report",
+		Scope:    "users:read",
+	}, time.Minute, prKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	introspection, err := tokens.Introspect(ctx, accessToken, TokenTypeAccess)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !introspection.Active || introspection.Subject != "report" || introspection.ClientId != "report" {
+		t.Errorf("unexpected introspection: %+v", introspection)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	ctx, tokens, _, sessions := setup(t)
+
+	userId := uuid.New()
+	sessions["refresh"] = models.RefreshToken{UserId: userId, SessionId: "session", ExpiresAt: time.Now().Add(time.Hour)}
+
+	// the hint is wrong, the access token lookup fails first
+	introspection, err := tokens.Introspect(ctx, "refresh", TokenTypeAccess)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !introspection.Active || introspection.TokenType != TokenTypeRefresh || introspection.Subject != userId.String() {
+		t.Errorf("unexpected introspection: %+v", introspection)
+	}
+
+	if err := tokens.Revoke(ctx, "", "refresh", TokenTypeRefresh); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if introspection, err = tokens.Introspect(ctx, "refresh", TokenTypeRefresh); err != nil || introspection.Active {
+		t.Errorf("revoked token is active: %+v, %v", introspection, err)
+	}
+}
+
+func TestUnknownToken(t *testing.T) {
+	ctx, tokens, prKey, _ := setup(t)
+
+	introspection, err := tokens.Introspect(ctx, "unknown", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if introspection.Active || introspection.Subject != "" {
+		t.Errorf("unexpected introspection: %+v", introspection)
+	}
+
+	if err := tokens.Revoke(ctx, "", "unknown", ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// signed with our key for another environment
+	accessToken, err := jwt.NewAccessToken(jwt.AccessToken{
+		Issuer:   issuer,
+		Audience: "staging",
+		User:     models.UserInfo{Id: uuid.New()},
+	}, time.Minute, prKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if introspection, err := tokens.Introspect(ctx, accessToken, TokenTypeAccess); err != nil || introspection.Active {
+		t.Errorf("token of another audience is active: %+v, %v", introspection, err)
+	}
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	ctx, tokens, _, _ := setup(t)
+
+	introspection, err := tokens.Introspect(ctx, personalAccessToken, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !introspection.Active || introspection.TokenType != TokenTypePersonalAccess || introspection.Subject != patUserId.String() ||
+		introspection.OrganizationId != patOrgId.String() || len(introspection.Permissions) != 1 {
+		t.Errorf("unexpected introspection: %+v", introspection)
+	}
+
+	if !introspection.ExpiresAt.IsZero() {
+		t.Errorf("unexpected expiration: %v", introspection.ExpiresAt)
+	}
+
+	if introspection, err := tokens.Introspect(ctx, "ahp_unknown", TokenTypeAccess); err != nil || introspection.Active {
+		t.Errorf("unknown personal access token is active: %+v, %v", introspection, err)
+	}
+
+	// personal access tokens are not issued to clients
+	if err := tokens.Revoke(ctx, "web", personalAccessToken, ""); !errors.Is(err, services.ErrNotAuthorized) {
+		t.Errorf("expected not authorized, got %v", err)
+	}
+
+	if err := tokens.Revoke(ctx, "", personalAccessToken, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if introspection, err := tokens.Introspect(ctx, personalAccessToken, ""); err != nil || introspection.Active {
+		t.Errorf("revoked token is active: %+v, %v", introspection, err)
+	}
+
+	if err := tokens.Revoke(ctx, "", personalAccessToken, ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRevokeClientTokens(t *testing.T) {
+	ctx, tokens, prKey, sessions := setup(t)
+
+	accessToken, err := jwt.NewAccessToken(jwt.AccessToken{
+		Issuer:   issuer,
+		Audience: audience,
+		User:     models.UserInfo{Id: uuid.New()},
+		ClientId: "web",
+	}, time.Minute, prKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sessions["refresh"] = models.RefreshToken{UserId: uuid.New(), SessionId: "session", ClientId: "web", ExpiresAt: time.Now().Add(time.Hour)}
+
+	for _, token := range []string{accessToken, "refresh"} {
+		if err := tokens.Revoke(ctx, "mobile", token, ""); !errors.Is(err, services.ErrNotAuthorized) {
+			t.Errorf("expected not authorized, got %v", err)
+		}
+
+		if introspection, err := tokens.Introspect(ctx, token, ""); err != nil || !introspection.Active {
+			t.Errorf("token revoked by another client: %+v, %v", introspection, err)
+		}
+
+		if err := tokens.Revoke(ctx, "web", token, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if introspection, err := tokens.Introspect(ctx, token, ""); err != nil || introspection.Active {
+			t.Errorf("revoked token is active: %+v, %v", introspection, err)
+		}
+	}
+}
@@ -6,6 +6,57 @@ var (
 	ErrUserExists                  = errors.New("user already exists")
 	ErrUserNotFound                = errors.New("user not found")
 	ErrSessionNotFound             = errors.New("session not found")
+	ErrRefreshTokenReused          = errors.New("refresh token reused")
 	ErrVerificationCodeNotFound    = errors.New("verification code not found")
 	ErrChangePasswordTokenNotFound = errors.New("change password token not found")
 )
+
+var (
+	ErrTOTPNotFound         = errors.New("totp not found")
+	ErrTOTPAlreadyConfirmed = errors.New("totp already confirmed")
+	ErrTOTPStepUsed         = errors.New("totp step already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+)
+
+var (
+	ErrPasskeyExists           = errors.New("passkey already exists")
+	ErrPasskeyNotFound         = errors.New("passkey not found")
+	ErrPasskeyCeremonyNotFound = errors.New("passkey ceremony not found")
+)
+
+var (
+	ErrAuthorizationCodeNotFound = errors.New("authorization code not found")
+	ErrClientExists              = errors.New("client already exists")
+	ErrClientNotFound            = errors.New("client not found")
+	ErrDeviceCodeNotFound        = errors.New("device code not found")
+	ErrUserCodeExists            = errors.New("user code already exists")
+	ErrUserCodeNotFound          = errors.New("user code not found")
+)
+
+var (
+	ErrRoleExists      = errors.New("role already exists")
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrRoleNotAssigned = errors.New("role not assigned")
+)
+
+var (
+	ErrOrganizationExists   = errors.New("organization already exists")
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrMemberExists         = errors.New("user is already a member of an organization")
+	ErrMemberNotFound       = errors.New("member not found")
+)
+
+var (
+	ErrInviteNotFound = errors.New("invite not found")
+)
+
+var (
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+)
+
+var (
+	ErrServiceAccountExists   = errors.New("service account already exists")
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrClientAssertionReused  = errors.New("client assertion reused")
+)
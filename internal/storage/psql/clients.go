@@ -0,0 +1,105 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (s *Storage) SaveClient(ctx context.Context, client models.OAuthClient) error {
+	const op = "psql.SaveClient"
+
+	query := `INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, grant_types, scopes,
+		access_token_ttl_seconds) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := s.pool.Exec(ctx, query,
+		client.Id,
+		client.Name,
+		client.SecretHash,
+		client.RedirectURIs,
+		client.GrantTypes,
+		client.Scopes,
+		int64(client.AccessTokenTTL/time.Second),
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" {
+				return fmt.Errorf("%s: %w", op, storage.ErrClientExists)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvideClient(ctx context.Context, clientId string) (models.OAuthClient, error) {
+	const op = "psql.ProvideClient"
+
+	query := `SELECT name, secret_hash, redirect_uris, grant_types, scopes, access_token_ttl_seconds,
+		disabled, created_at FROM oauth_clients WHERE id = $1`
+
+	client := models.OAuthClient{Id: clientId}
+	var ttlSeconds int64
+
+	err := s.pool.QueryRow(ctx, query, clientId).Scan(
+		&client.Name,
+		&client.SecretHash,
+		&client.RedirectURIs,
+		&client.GrantTypes,
+		&client.Scopes,
+		&ttlSeconds,
+		&client.Disabled,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return models.OAuthClient{}, fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+		}
+
+		return models.OAuthClient{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	client.AccessTokenTTL = time.Duration(ttlSeconds) * time.Second
+
+	return client, nil
+}
+
+func (s *Storage) UpdateClientSecret(ctx context.Context, clientId string, secretHash []byte) error {
+	const op = "psql.UpdateClientSecret"
+
+	tag, err := s.pool.Exec(ctx, `UPDATE oauth_clients SET secret_hash = $1 WHERE id = $2`, secretHash, clientId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) DisableClient(ctx context.Context, clientId string) error {
+	const op = "psql.DisableClient"
+
+	tag, err := s.pool.Exec(ctx, `UPDATE oauth_clients SET disabled = TRUE WHERE id = $1`, clientId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+)
+
+// SaveImpersonation appends an impersonation to the audit trail
+func (s *Storage) SaveImpersonation(ctx context.Context, impersonation models.Impersonation) error {
+	const op = "psql.SaveImpersonation"
+
+	query := `INSERT INTO impersonations (id, actor_id, user_id, reason, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := s.pool.Exec(ctx, query,
+		impersonation.Id,
+		impersonation.ActorId,
+		impersonation.UserId,
+		impersonation.Reason,
+		impersonation.CreatedAt,
+		impersonation.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
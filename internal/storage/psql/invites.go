@@ -0,0 +1,218 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const inviteColumns = `i.user_id, m.organization_id, u.email, u.name, u.surname, i.invited_by, i.token_hash, i.expires_at, i.created_at
+	FROM invites i
+	JOIN users u ON u.id = i.user_id
+	LEFT JOIN organization_members m ON m.user_id = i.user_id`
+
+// SaveInvite creates the invited user without a password and its invite, returns the user id
+func (s *Storage) SaveInvite(ctx context.Context, invite models.Invite, emailScope uuid.UUID) (uuid.UUID, error) {
+	const op = "psql.SaveInvite"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := insertUser(ctx, tx, invite.OrganizationId, emailScope, invite.Name, invite.Surname, invite.Email, []byte{})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `INSERT INTO invites (user_id, invited_by, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+
+	if _, err := tx.Exec(ctx, query, userId, nullUUID(invite.InvitedBy), invite.TokenHash, invite.ExpiresAt); err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return userId, nil
+}
+
+// ProvideInvite returns the invite of the user if the user is in the organization
+func (s *Storage) ProvideInvite(ctx context.Context, organizationId, userId uuid.UUID) (models.Invite, error) {
+	const op = "psql.ProvideInvite"
+
+	query := `SELECT ` + inviteColumns + `
+		WHERE i.user_id = $1 AND m.organization_id IS NOT DISTINCT FROM $2`
+
+	invite, err := scanInvite(s.pool.QueryRow(ctx, query, userId, nullUUID(organizationId)))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return models.Invite{}, fmt.Errorf("%s: %w", op, storage.ErrInviteNotFound)
+		}
+
+		return models.Invite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invite, nil
+}
+
+// ProvideInviteByToken returns the invite the token was sent for, expired invites are not found
+func (s *Storage) ProvideInviteByToken(ctx context.Context, tokenHash []byte) (models.Invite, error) {
+	const op = "psql.ProvideInviteByToken"
+
+	query := `SELECT ` + inviteColumns + `
+		WHERE i.token_hash = $1 AND i.expires_at > now()`
+
+	invite, err := scanInvite(s.pool.QueryRow(ctx, query, tokenHash))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return models.Invite{}, fmt.Errorf("%s: %w", op, storage.ErrInviteNotFound)
+		}
+
+		return models.Invite{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invite, nil
+}
+
+// ProvideInvites returns the pending invites of the organization, expired ones included
+func (s *Storage) ProvideInvites(ctx context.Context, organizationId uuid.UUID) ([]models.Invite, error) {
+	const op = "psql.ProvideInvites"
+
+	query := `SELECT ` + inviteColumns + `
+		WHERE m.organization_id IS NOT DISTINCT FROM $1
+		ORDER BY i.created_at`
+
+	rows, err := s.pool.Query(ctx, query, nullUUID(organizationId))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	invites := make([]models.Invite, 0)
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		invites = append(invites, invite)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invites, nil
+}
+
+// UpdateInviteToken replaces the token of the invite, the previous one stops working
+func (s *Storage) UpdateInviteToken(ctx context.Context, userId uuid.UUID, tokenHash []byte, expiresAt time.Time) error {
+	const op = "psql.UpdateInviteToken"
+
+	query := `UPDATE invites SET token_hash = $2, expires_at = $3 WHERE user_id = $1`
+
+	tag, err := s.pool.Exec(ctx, query, userId, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrInviteNotFound)
+	}
+
+	return nil
+}
+
+// AcceptInvite sets the password of the invited user and deletes the invite. The email
+// is verified since the invite was sent to it. storage.ErrInviteNotFound is returned
+// if the token is not the current one of the invite or has expired
+func (s *Storage) AcceptInvite(ctx context.Context, userId uuid.UUID, tokenHash []byte, passHash []byte) error {
+	const op = "psql.AcceptInvite"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `DELETE FROM invites WHERE user_id = $1 AND token_hash = $2 AND expires_at > now()`
+
+	tag, err := tx.Exec(ctx, query, userId, tokenHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrInviteNotFound)
+	}
+
+	query = `UPDATE users SET pass_hash = $2, verified = TRUE WHERE id = $1`
+
+	if _, err := tx.Exec(ctx, query, userId, passHash); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteInvite revokes the invite of a user in the organization and deletes the invited user
+func (s *Storage) DeleteInvite(ctx context.Context, organizationId, userId uuid.UUID) error {
+	const op = "psql.DeleteInvite"
+
+	query := `DELETE FROM users u
+		USING invites i
+		WHERE i.user_id = u.id AND u.id = $1
+		AND (SELECT organization_id FROM organization_members WHERE user_id = u.id) IS NOT DISTINCT FROM $2`
+
+	tag, err := s.pool.Exec(ctx, query, userId, nullUUID(organizationId))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrInviteNotFound)
+	}
+
+	return nil
+}
+
+func scanInvite(row pgx.Row) (models.Invite, error) {
+	var (
+		invite         models.Invite
+		organizationId uuid.NullUUID
+		invitedBy      uuid.NullUUID
+	)
+
+	err := row.Scan(
+		&invite.UserId,
+		&organizationId,
+		&invite.Email,
+		&invite.Name,
+		&invite.Surname,
+		&invitedBy,
+		&invite.TokenHash,
+		&invite.ExpiresAt,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		return models.Invite{}, err
+	}
+
+	invite.OrganizationId = organizationId.UUID
+	invite.InvitedBy = invitedBy.UUID
+
+	return invite, nil
+}
@@ -0,0 +1,80 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+)
+
+// RotateSigningKey stores the new key and shortens the lifetime of the previous keys to retireAt
+func (s *Storage) RotateSigningKey(ctx context.Context, key models.SigningKey, retireAt time.Time) error {
+	const op = "psql.RotateSigningKey"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE signing_keys SET expires_at = LEAST(expires_at, $1)`
+
+	if _, err := tx.Exec(ctx, query, retireAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query = `INSERT INTO signing_keys (id, private_key, created_at, expires_at) VALUES ($1, $2, $3, $4)`
+
+	if _, err := tx.Exec(ctx, query, key.Id, key.PrivateKey, key.CreatedAt, key.ExpiresAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ProvideSigningKeys returns the keys that have not expired, oldest first
+func (s *Storage) ProvideSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	const op = "psql.ProvideSigningKeys"
+
+	query := `SELECT id, private_key, created_at, expires_at FROM signing_keys WHERE expires_at > now() ORDER BY created_at`
+
+	rows, err := s.pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	keys := make([]models.SigningKey, 0)
+	for rows.Next() {
+		var key models.SigningKey
+
+		if err := rows.Scan(&key.Id, &key.PrivateKey, &key.CreatedAt, &key.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) DeleteExpiredSigningKeys(ctx context.Context) error {
+	const op = "psql.DeleteExpiredSigningKeys"
+
+	query := `DELETE FROM signing_keys WHERE expires_at <= now()`
+
+	if _, err := s.pool.Exec(ctx, query); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SaveTOTP stores a new unconfirmed secret, replacing a previous unconfirmed one
+func (s *Storage) SaveTOTP(ctx context.Context, userId uuid.UUID, secret string) error {
+	const op = "psql.SaveTOTP"
+
+	query := `INSERT INTO mfa_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+		WHERE mfa_totp.confirmed = FALSE`
+
+	tag, err := s.pool.Exec(ctx, query, userId, secret)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPAlreadyConfirmed)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvideTOTP(ctx context.Context, userId uuid.UUID) (models.TOTP, error) {
+	const op = "psql.ProvideTOTP"
+
+	query := `SELECT secret, confirmed, last_used_step FROM mfa_totp WHERE user_id = $1`
+
+	totp := models.TOTP{UserId: userId}
+	if err := s.pool.QueryRow(ctx, query, userId).Scan(&totp.Secret, &totp.Confirmed, &totp.LastUsedStep); err != nil {
+		if err == pgx.ErrNoRows {
+			return models.TOTP{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+		}
+
+		return models.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return totp, nil
+}
+
+// UseTOTPStep records the step of an accepted code.
+// It fails if the same or a later step has already been used
+func (s *Storage) UseTOTPStep(ctx context.Context, userId uuid.UUID, step int64) error {
+	const op = "psql.UseTOTPStep"
+
+	query := `UPDATE mfa_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+
+	tag, err := s.pool.Exec(ctx, query, userId, step)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPStepUsed)
+	}
+
+	return nil
+}
+
+// DeleteTOTP removes the secret together with the recovery codes
+func (s *Storage) DeleteTOTP(ctx context.Context, userId uuid.UUID) error {
+	const op = "psql.DeleteTOTP"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_totp WHERE user_id = $1`, userId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConfirmTOTP enables the secret in the same transaction that stores the recovery codes,
+// so the second factor is never enabled without them
+func (s *Storage) ConfirmTOTP(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error {
+	const op = "psql.ConfirmTOTP"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE mfa_totp SET confirmed = TRUE WHERE user_id = $1 AND confirmed = FALSE`, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPAlreadyConfirmed)
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error {
+	const op = "psql.ReplaceRecoveryCodes"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userId uuid.UUID, codeHashes [][]byte) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+	for _, codeHash := range codeHashes {
+		if _, err := tx.Exec(ctx, query, userId, codeHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused code as used
+func (s *Storage) UseRecoveryCode(ctx context.Context, userId uuid.UUID, codeHash []byte) error {
+	const op = "psql.UseRecoveryCode"
+
+	query := `UPDATE mfa_recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	tag, err := s.pool.Exec(ctx, query, userId, codeHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SaveOrganization returns the organization with its id and creation time
+func (s *Storage) SaveOrganization(ctx context.Context, org models.Organization) (models.Organization, error) {
+	const op = "psql.SaveOrganization"
+
+	query := `INSERT INTO organizations (slug, name, scoped_emails, settings) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+
+	row := s.pool.QueryRow(ctx, query, org.Slug, org.Name, org.ScopedEmails, org.Settings)
+	if err := row.Scan(&org.Id, &org.CreatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" {
+				return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrganizationExists)
+			}
+		}
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return org, nil
+}
+
+func (s *Storage) UpdateOrganizationSettings(ctx context.Context, id uuid.UUID, settings models.OrganizationSettings) error {
+	const op = "psql.UpdateOrganizationSettings"
+
+	tag, err := s.pool.Exec(ctx, `UPDATE organizations SET settings = $1 WHERE id = $2`, settings, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrOrganizationNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvideOrganization(ctx context.Context, id uuid.UUID) (models.Organization, error) {
+	const op = "psql.ProvideOrganization"
+
+	query := `SELECT id, slug, name, scoped_emails, settings, created_at FROM organizations WHERE id = $1`
+
+	org, err := scanOrganization(s.pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrganizationNotFound)
+		}
+
+		return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return org, nil
+}
+
+func (s *Storage) ProvideOrganizations(ctx context.Context) ([]models.Organization, error) {
+	const op = "psql.ProvideOrganizations"
+
+	rows, err := s.pool.Query(ctx, `SELECT id, slug, name, scoped_emails, settings, created_at FROM organizations ORDER BY slug`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	orgs := make([]models.Organization, 0)
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		orgs = append(orgs, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return orgs, nil
+}
+
+// SaveMember adds the user to the organization with the roles. In organizations that scope
+// the emails storage.ErrUserExists is returned if another member has the email of the user
+func (s *Storage) SaveMember(ctx context.Context, member models.Member) error {
+	const op = "psql.SaveMember"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO organization_members (user_id, organization_id) VALUES ($1, $2)`
+
+	if _, err := tx.Exec(ctx, query, member.UserId, member.OrganizationId); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" {
+				return fmt.Errorf("%s: %w", op, storage.ErrMemberExists)
+			}
+			// foreign key violation
+			if pgErr.Code == "23503" {
+				if pgErr.ConstraintName == "organization_members_user_id_fkey" {
+					return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+				}
+
+				return fmt.Errorf("%s: %w", op, storage.ErrOrganizationNotFound)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the email of the user moves to the scope of the organization
+	query = `UPDATE users SET email_scope = o.id
+		FROM organizations o
+		WHERE users.id = $1 AND o.id = $2 AND o.scoped_emails`
+
+	if _, err := tx.Exec(ctx, query, member.UserId, member.OrganizationId); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := saveMemberRoles(ctx, tx, member.UserId, member.Roles); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UpdateMemberRoles replaces the roles of the member of the organization
+func (s *Storage) UpdateMemberRoles(ctx context.Context, member models.Member) error {
+	const op = "psql.UpdateMemberRoles"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	// locks the member, so that it is not removed meanwhile
+	query := `SELECT 1 FROM organization_members WHERE user_id = $1 AND organization_id = $2 FOR UPDATE`
+
+	var exists int
+	if err := tx.QueryRow(ctx, query, member.UserId, member.OrganizationId).Scan(&exists); err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM organization_member_roles WHERE user_id = $1`, member.UserId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := saveMemberRoles(ctx, tx, member.UserId, member.Roles); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvideMembers(ctx context.Context, organizationId uuid.UUID) ([]models.Member, error) {
+	const op = "psql.ProvideMembers"
+
+	query := `SELECT m.user_id, m.created_at, COALESCE(array_agg(r.role ORDER BY r.role) FILTER (WHERE r.role IS NOT NULL), '{}')
+		FROM organization_members m LEFT JOIN organization_member_roles r ON r.user_id = m.user_id
+		WHERE m.organization_id = $1 GROUP BY m.user_id, m.created_at ORDER BY m.created_at`
+
+	rows, err := s.pool.Query(ctx, query, organizationId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	members := make([]models.Member, 0)
+	for rows.Next() {
+		member := models.Member{OrganizationId: organizationId}
+		if err := rows.Scan(&member.UserId, &member.CreatedAt, &member.Roles); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+func saveMemberRoles(ctx context.Context, tx pgx.Tx, userId uuid.UUID, roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	query := `INSERT INTO organization_member_roles (user_id, role) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`
+
+	if _, err := tx.Exec(ctx, query, userId, roles); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23503" {
+				return storage.ErrRoleNotFound
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func scanOrganization(row pgx.Row) (models.Organization, error) {
+	var org models.Organization
+	if err := row.Scan(&org.Id, &org.Slug, &org.Name, &org.ScopedEmails, &org.Settings, &org.CreatedAt); err != nil {
+		return models.Organization{}, err
+	}
+
+	return org, nil
+}
@@ -0,0 +1,111 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (s *Storage) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	const op = "psql.SavePasskey"
+
+	query := `INSERT INTO passkeys (id, user_id, name, public_key, attestation_type, transports, aaguid,
+		sign_count, backup_eligible, backup_state) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := s.pool.Exec(ctx, query,
+		passkey.Id,
+		passkey.UserId,
+		passkey.Name,
+		passkey.PublicKey,
+		passkey.AttestationType,
+		passkey.Transports,
+		passkey.AAGUID,
+		int64(passkey.SignCount),
+		passkey.BackupEligible,
+		passkey.BackupState,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" {
+				return fmt.Errorf("%s: %w", op, storage.ErrPasskeyExists)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvidePasskeys(ctx context.Context, userId uuid.UUID) ([]models.Passkey, error) {
+	const op = "psql.ProvidePasskeys"
+
+	query := `SELECT id, name, public_key, attestation_type, transports, aaguid, sign_count, clone_warning,
+		backup_eligible, backup_state, created_at, COALESCE(last_used_at, created_at)
+		FROM passkeys WHERE user_id = $1 ORDER BY created_at`
+
+	rows, err := s.pool.Query(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	passkeys := make([]models.Passkey, 0)
+	for rows.Next() {
+		passkey := models.Passkey{UserId: userId}
+		var signCount int64
+
+		err := rows.Scan(
+			&passkey.Id,
+			&passkey.Name,
+			&passkey.PublicKey,
+			&passkey.AttestationType,
+			&passkey.Transports,
+			&passkey.AAGUID,
+			&signCount,
+			&passkey.CloneWarning,
+			&passkey.BackupEligible,
+			&passkey.BackupState,
+			&passkey.CreatedAt,
+			&passkey.LastUsedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		passkey.SignCount = uint32(signCount)
+		passkeys = append(passkeys, passkey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return passkeys, nil
+}
+
+// UpdatePasskeyUsage stores the state reported by the authenticator on login
+func (s *Storage) UpdatePasskeyUsage(ctx context.Context, passkey models.Passkey) error {
+	const op = "psql.UpdatePasskeyUsage"
+
+	query := `UPDATE passkeys SET sign_count = $2, clone_warning = $3, backup_state = $4, last_used_at = now()
+		WHERE id = $1`
+
+	tag, err := s.pool.Exec(ctx, query, passkey.Id, int64(passkey.SignCount), passkey.CloneWarning, passkey.BackupState)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+)
+
+// ChangePasswordWithHistory moves the current hash to the history, sets the new one
+// and keeps only the historySize newest hashes in the history
+func (s *Storage) ChangePasswordWithHistory(ctx context.Context, userId uuid.UUID, passHash []byte, historySize int) error {
+	const op = "psql.ChangePasswordWithHistory"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if historySize > 0 {
+		query := `INSERT INTO password_history (user_id, pass_hash) SELECT id, pass_hash FROM users WHERE id = $1`
+		if _, err := tx.Exec(ctx, query, userId); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET pass_hash = $1 WHERE id = $2`, passHash, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	query := `DELETE FROM password_history WHERE user_id = $1 AND id NOT IN (
+		SELECT id FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2
+	)`
+	if _, err := tx.Exec(ctx, query, userId, historySize); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ProvidePasswordHistory returns up to limit previous password hashes, the newest first
+func (s *Storage) ProvidePasswordHistory(ctx context.Context, userId uuid.UUID, limit int) ([][]byte, error) {
+	const op = "psql.ProvidePasswordHistory"
+
+	query := `SELECT pass_hash FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2`
+
+	rows, err := s.pool.Query(ctx, query, userId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var hashes [][]byte
+	for rows.Next() {
+		var hash []byte
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,148 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const personalAccessTokenColumns = `id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at`
+
+func (s *Storage) SavePersonalAccessToken(ctx context.Context, token models.PersonalAccessToken) error {
+	const op = "psql.SavePersonalAccessToken"
+
+	query := `INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := s.pool.Exec(ctx, query,
+		token.Id,
+		token.UserId,
+		token.Name,
+		token.TokenHash,
+		token.Scopes,
+		nullTime(token.ExpiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ProvidePersonalAccessToken returns the token with the hash, expired ones included
+func (s *Storage) ProvidePersonalAccessToken(ctx context.Context, tokenHash []byte) (models.PersonalAccessToken, error) {
+	const op = "psql.ProvidePersonalAccessToken"
+
+	query := `SELECT ` + personalAccessTokenColumns + ` FROM personal_access_tokens WHERE token_hash = $1`
+
+	token, err := scanPersonalAccessToken(s.pool.QueryRow(ctx, query, tokenHash))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return models.PersonalAccessToken{}, fmt.Errorf("%s: %w", op, storage.ErrPersonalAccessTokenNotFound)
+		}
+
+		return models.PersonalAccessToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (s *Storage) ProvidePersonalAccessTokens(ctx context.Context, userId uuid.UUID) ([]models.PersonalAccessToken, error) {
+	const op = "psql.ProvidePersonalAccessTokens"
+
+	query := `SELECT ` + personalAccessTokenColumns + ` FROM personal_access_tokens
+		WHERE user_id = $1 ORDER BY created_at`
+
+	rows, err := s.pool.Query(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	tokens := make([]models.PersonalAccessToken, 0)
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+func (s *Storage) UpdatePersonalAccessTokenLastUsed(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error {
+	const op = "psql.UpdatePersonalAccessTokenLastUsed"
+
+	if _, err := s.pool.Exec(ctx, `UPDATE personal_access_tokens SET last_used_at = $2 WHERE id = $1`, id, lastUsedAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeletePersonalAccessToken deletes the token if it belongs to the user
+func (s *Storage) DeletePersonalAccessToken(ctx context.Context, userId, id uuid.UUID) error {
+	const op = "psql.DeletePersonalAccessToken"
+
+	tag, err := s.pool.Exec(ctx, `DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`, id, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPersonalAccessTokenNotFound)
+	}
+
+	return nil
+}
+
+func scanPersonalAccessToken(row pgx.Row) (models.PersonalAccessToken, error) {
+	var (
+		token      models.PersonalAccessToken
+		expiresAt  *time.Time
+		lastUsedAt *time.Time
+	)
+
+	err := row.Scan(
+		&token.Id,
+		&token.UserId,
+		&token.Name,
+		&token.TokenHash,
+		&token.Scopes,
+		&expiresAt,
+		&lastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return models.PersonalAccessToken{}, err
+	}
+
+	if expiresAt != nil {
+		token.ExpiresAt = *expiresAt
+	}
+	if lastUsedAt != nil {
+		token.LastUsedAt = *lastUsedAt
+	}
+
+	return token, nil
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,177 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (s *Storage) SaveRole(ctx context.Context, role models.Role) error {
+	const op = "psql.SaveRole"
+
+	query := `INSERT INTO roles (name, description, permissions) VALUES ($1, $2, $3)`
+
+	if _, err := s.pool.Exec(ctx, query, role.Name, role.Description, role.Permissions); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" {
+				return fmt.Errorf("%s: %w", op, storage.ErrRoleExists)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateRole(ctx context.Context, role models.Role) error {
+	const op = "psql.UpdateRole"
+
+	query := `UPDATE roles SET description = $1, permissions = $2 WHERE name = $3`
+
+	tag, err := s.pool.Exec(ctx, query, role.Description, role.Permissions, role.Name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+	}
+
+	return nil
+}
+
+// DeleteRole deletes the role and unassigns it from its users
+func (s *Storage) DeleteRole(ctx context.Context, name string) error {
+	const op = "psql.DeleteRole"
+
+	tag, err := s.pool.Exec(ctx, `DELETE FROM roles WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvideRoles(ctx context.Context) ([]models.Role, error) {
+	const op = "psql.ProvideRoles"
+
+	rows, err := s.pool.Query(ctx, `SELECT name, description, permissions, created_at FROM roles ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+func (s *Storage) ProvideUserRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	const op = "psql.ProvideUserRoles"
+
+	query := `SELECT r.name, r.description, r.permissions, r.created_at FROM roles r
+		JOIN user_roles ur ON ur.role = r.name WHERE ur.user_id = $1 ORDER BY r.name`
+
+	rows, err := s.pool.Query(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// ProvideMemberRoles returns the roles the user has in their organization
+func (s *Storage) ProvideMemberRoles(ctx context.Context, userId uuid.UUID) ([]models.Role, error) {
+	const op = "psql.ProvideMemberRoles"
+
+	query := `SELECT r.name, r.description, r.permissions, r.created_at FROM roles r
+		JOIN organization_member_roles mr ON mr.role = r.name WHERE mr.user_id = $1 ORDER BY r.name`
+
+	rows, err := s.pool.Query(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// AssignRole assigns the role to the user, assigning it again is not an error
+func (s *Storage) AssignRole(ctx context.Context, userId uuid.UUID, role string) error {
+	const op = "psql.AssignRole"
+
+	query := `INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+	if _, err := s.pool.Exec(ctx, query, userId, role); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			// foreign key violation
+			if pgErr.Code == "23503" {
+				if pgErr.ConstraintName == "user_roles_user_id_fkey" {
+					return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+				}
+
+				return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UnassignRole(ctx context.Context, userId uuid.UUID, role string) error {
+	const op = "psql.UnassignRole"
+
+	tag, err := s.pool.Exec(ctx, `DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, userId, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRoleNotAssigned)
+	}
+
+	return nil
+}
+
+func scanRoles(rows pgx.Rows) ([]models.Role, error) {
+	defer rows.Close()
+
+	roles := make([]models.Role, 0)
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.Name, &role.Description, &role.Permissions, &role.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
@@ -0,0 +1,160 @@
+package psql
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const serviceAccountColumns = `id, name, public_key, permissions, disabled, created_at`
+
+func (s *Storage) SaveServiceAccount(ctx context.Context, account models.ServiceAccount) error {
+	const op = "psql.SaveServiceAccount"
+
+	publicKey, err := x509.MarshalPKIXPublicKey(account.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `INSERT INTO service_accounts (id, name, public_key, permissions, disabled)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = s.pool.Exec(ctx, query,
+		account.Id,
+		account.Name,
+		publicKey,
+		account.Permissions,
+		account.Disabled,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" {
+				return fmt.Errorf("%s: %w", op, storage.ErrServiceAccountExists)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvideServiceAccount(ctx context.Context, id uuid.UUID) (models.ServiceAccount, error) {
+	const op = "psql.ProvideServiceAccount"
+
+	query := `SELECT ` + serviceAccountColumns + ` FROM service_accounts WHERE id = $1`
+
+	account, err := scanServiceAccount(s.pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+		}
+
+		return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return account, nil
+}
+
+func (s *Storage) ProvideServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	const op = "psql.ProvideServiceAccounts"
+
+	rows, err := s.pool.Query(ctx, `SELECT `+serviceAccountColumns+` FROM service_accounts ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	accounts := make([]models.ServiceAccount, 0)
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return accounts, nil
+}
+
+func (s *Storage) UpdateServiceAccountKey(ctx context.Context, id uuid.UUID, publicKey *ecdsa.PublicKey) error {
+	const op = "psql.UpdateServiceAccountKey"
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.pool.Exec(ctx, `UPDATE service_accounts SET public_key = $2 WHERE id = $1`, id, der)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) DisableServiceAccount(ctx context.Context, id uuid.UUID) error {
+	const op = "psql.DisableServiceAccount"
+
+	tag, err := s.pool.Exec(ctx, `UPDATE service_accounts SET disabled = TRUE WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+	}
+
+	return nil
+}
+
+func scanServiceAccount(row pgx.Row) (models.ServiceAccount, error) {
+	var (
+		account   models.ServiceAccount
+		publicKey []byte
+	)
+
+	err := row.Scan(
+		&account.Id,
+		&account.Name,
+		&publicKey,
+		&account.Permissions,
+		&account.Disabled,
+		&account.CreatedAt,
+	)
+	if err != nil {
+		return models.ServiceAccount{}, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return models.ServiceAccount{}, err
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return models.ServiceAccount{}, fmt.Errorf("public key of service account %s is not an ecdsa key", account.Id)
+	}
+	account.PublicKey = ecdsaKey
+
+	return account, nil
+}
@@ -42,43 +42,83 @@ func New(ctx context.Context, cfg PsqlConfig) (*Storage, error) {
 	}, nil
 }
 
-func (s *Storage) CrateUser(ctx context.Context, name, surname, email string, passHash []byte) (uuid.UUID, error) {
+// CrateUser creates a user whose email is unique in the email scope,
+// the user is added to the organization unless it is uuid.Nil
+func (s *Storage) CrateUser(ctx context.Context, organizationId, emailScope uuid.UUID, name, surname, email string, passHash []byte) (uuid.UUID, error) {
 	const op = "psql.CreateUser"
 
-	query := `INSERT INTO users (name, surname, email, pass_hash) VALUES ($1, $2, $3, $4) RETURNING id`
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	id, err := insertUser(ctx, tx, organizationId, emailScope, name, surname, email, passHash)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
 
-	row := s.pool.QueryRow(ctx, query, name, surname, email, passHash)
+func insertUser(ctx context.Context, tx pgx.Tx, organizationId, emailScope uuid.UUID, name, surname, email string, passHash []byte) (uuid.UUID, error) {
+	query := `INSERT INTO users (name, surname, email, email_scope, pass_hash) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	row := tx.QueryRow(ctx, query, name, surname, email, emailScope, passHash)
 
 	var id uuid.NullUUID
 	if err := row.Scan(&id); err != nil {
 		if err == pgx.ErrNoRows {
-			return uuid.Nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			return uuid.Nil, storage.ErrUserNotFound
 		}
 
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			if pgErr.Code == "23505" {
-				return uuid.Nil, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+				return uuid.Nil, storage.ErrUserExists
 			}
 		}
 
-		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+		return uuid.Nil, err
+	}
+
+	if organizationId != uuid.Nil {
+		query := `INSERT INTO organization_members (user_id, organization_id) VALUES ($1, $2)`
+
+		if _, err := tx.Exec(ctx, query, id.UUID, organizationId); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) {
+				if pgErr.Code == "23503" {
+					return uuid.Nil, storage.ErrOrganizationNotFound
+				}
+			}
+
+			return uuid.Nil, err
+		}
 	}
 
 	return id.UUID, nil
 }
 
+// ProvideUserById returns the user with its organization whatever it is,
+// it is only used with ids sso issued itself
 func (s *Storage) ProvideUserById(ctx context.Context, id uuid.UUID) (models.User, error) {
 	const op = "psql.ProvideUserById"
 
-	query := `SELECT name, surname, email, pass_hash FROM users WHERE id = $1`
+	query := `SELECT u.name, u.surname, u.email, u.pass_hash, m.organization_id
+		FROM users u LEFT JOIN organization_members m ON m.user_id = u.id WHERE u.id = $1`
 
 	row := s.pool.QueryRow(ctx, query, id)
 
 	var user models.User
+	var organizationId uuid.NullUUID
 	user.UserInfo.Id = id
 	user.UserAuth.Id = id
-	if err := row.Scan(&user.Name, &user.Surname, &user.Email, &user.PassHash); err != nil {
+	if err := row.Scan(&user.Name, &user.Surname, &user.Email, &user.PassHash, &organizationId); err != nil {
 		if err == pgx.ErrNoRows {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
 		}
@@ -86,19 +126,23 @@ func (s *Storage) ProvideUserById(ctx context.Context, id uuid.UUID) (models.Use
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
+	user.OrganizationId = organizationId.UUID
+
 	return user, nil
 }
 
-func (s *Storage) ProvideUserByEmail(ctx context.Context, Email string) (models.User, error) {
+func (s *Storage) ProvideUserByEmail(ctx context.Context, emailScope uuid.UUID, Email string) (models.User, error) {
 	const op = "psql.ProvideUserByLogin"
 
-	query := `SELECT id, name, surname, pass_hash FROM users WHERE email = $1`
+	query := `SELECT u.id, u.name, u.surname, u.pass_hash, m.organization_id
+		FROM users u LEFT JOIN organization_members m ON m.user_id = u.id
+		WHERE u.email_scope = $1 AND u.email = $2`
 
-	row := s.pool.QueryRow(ctx, query, Email)
+	row := s.pool.QueryRow(ctx, query, emailScope, Email)
 
 	var user models.User
-	var id uuid.NullUUID
-	err := row.Scan(&id, &user.Name, &user.Surname, &user.PassHash)
+	var id, organizationId uuid.NullUUID
+	err := row.Scan(&id, &user.Name, &user.Surname, &user.PassHash, &organizationId)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -110,26 +154,32 @@ func (s *Storage) ProvideUserByEmail(ctx context.Context, Email string) (models.
 	user.UserInfo.Id = id.UUID
 	user.UserAuth.Id = id.UUID
 	user.UserAuth.Email = Email
+	user.OrganizationId = organizationId.UUID
 
 	return user, nil
 }
 
-func (s *Storage) ProvideUsersById(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+// ProvideUsersById returns the users of the organization among ids, uuid.Nil stands for
+// the users that are not members of any and models.AnyOrganization for all the users
+func (s *Storage) ProvideUsersById(ctx context.Context, organizationId uuid.UUID, ids []uuid.UUID) ([]models.User, error) {
 	const op = "psql.ProvideUsersById"
 
 	if len(ids) == 0 {
 		return nil, nil
 	}
 
-	args := make([]interface{}, 0, len(ids))
+	args := make([]interface{}, 0, len(ids)+2)
 	inParams := make([]string, 0, len(ids))
 
+	args = append(args, nullUUID(organizationId), organizationId == models.AnyOrganization)
 	for i, id := range ids {
 		args = append(args, interface{}(id))
-		inParams = append(inParams, fmt.Sprintf("$%d", i+1))
+		inParams = append(inParams, fmt.Sprintf("$%d", i+3))
 	}
 
-	query := fmt.Sprintf(`SELECT id, name, surname, email, pass_hash FROM users WHERE id in (%s)`, strings.Join(inParams, ","))
+	query := fmt.Sprintf(`SELECT u.id, u.name, u.surname, u.email, u.pass_hash, m.organization_id
+		FROM users u LEFT JOIN organization_members m ON m.user_id = u.id
+		WHERE u.id in (%s) AND ($2 OR m.organization_id IS NOT DISTINCT FROM $1)`, strings.Join(inParams, ","))
 
 	users := make([]models.User, 0)
 	rows, err := s.pool.Query(ctx, query, args...)
@@ -139,9 +189,9 @@ func (s *Storage) ProvideUsersById(ctx context.Context, ids []uuid.UUID) ([]mode
 
 	for rows.Next() {
 		var user models.User
-		var id uuid.NullUUID
+		var id, orgId uuid.NullUUID
 
-		err := rows.Scan(&id, &user.Name, &user.Surname, &user.Email, &user.PassHash)
+		err := rows.Scan(&id, &user.Name, &user.Surname, &user.Email, &user.PassHash, &orgId)
 		if err != nil {
 			if err == pgx.ErrNoRows {
 				return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -152,6 +202,7 @@ func (s *Storage) ProvideUsersById(ctx context.Context, ids []uuid.UUID) ([]mode
 
 		user.UserInfo.Id = id.UUID
 		user.UserAuth.Id = id.UUID
+		user.OrganizationId = orgId.UUID
 
 		users = append(users, user)
 	}
@@ -159,53 +210,76 @@ func (s *Storage) ProvideUsersById(ctx context.Context, ids []uuid.UUID) ([]mode
 	return users, nil
 }
 
-func (s *Storage) UpdateUser(ctx context.Context, user models.UserInfo) error {
+// UpdateUser updates the user if it is in the organization, see ProvideUsersById
+func (s *Storage) UpdateUser(ctx context.Context, organizationId uuid.UUID, user models.UserInfo) error {
 	const op = "psql.UpdateUser"
 
-	query := `UPDATE users SET name = $1, surname = $2 WHERE id = $3`
+	query := `UPDATE users u SET name = $1, surname = $2 WHERE u.id = $3
+		AND ($5 OR (SELECT organization_id FROM organization_members WHERE user_id = u.id) IS NOT DISTINCT FROM $4)`
 
-	_, err := s.pool.Exec(ctx, query, user.Name, user.Surname, user.Id)
+	tag, err := s.pool.Exec(ctx, query, user.Name, user.Surname, user.Id, nullUUID(organizationId), organizationId == models.AnyOrganization)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
-		}
-
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
 	return nil
 }
 
-func (s *Storage) ChangePassword(ctx context.Context, email string, newPassword []byte) error {
+func (s *Storage) ChangePassword(ctx context.Context, userId uuid.UUID, newPassword []byte) error {
 	const op = "psql.ChangePassword"
 
-	query := `UPDATE users SET pass_hash = $1 WHERE email = $2`
+	query := `UPDATE users SET pass_hash = $1 WHERE id = $2`
 
-	_, err := s.pool.Exec(ctx, query, newPassword, email)
+	tag, err := s.pool.Exec(ctx, query, newPassword, userId)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
-		}
-
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
 	return nil
 }
 
-func (s *Storage) DeleteUser(ctx context.Context, id uuid.UUID) error {
+// DeleteUser deletes the user if it is in the organization, see ProvideUsersById
+func (s *Storage) DeleteUser(ctx context.Context, organizationId uuid.UUID, id uuid.UUID) error {
 	const op = "psql.DeleteUser"
 
-	query := `DELETE FROM users WHERE id = $1`
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
 
-	_, err := s.pool.Exec(ctx, query, id)
+	if _, err := tx.Exec(ctx, `DELETE FROM password_history WHERE user_id = $1`, id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `DELETE FROM users u WHERE u.id = $1
+		AND ($3 OR (SELECT organization_id FROM organization_members WHERE user_id = u.id) IS NOT DISTINCT FROM $2)`
+
+	tag, err := tx.Exec(ctx, query, id, nullUUID(organizationId), organizationId == models.AnyOrganization)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
-		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
 
+	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
+
+// nullUUID maps uuid.Nil to NULL
+func nullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: id != uuid.Nil}
+}
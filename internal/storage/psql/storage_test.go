@@ -40,13 +40,13 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	id, err := db.CrateUser(ctx, "John", "Doe", "john.doe@example.com", []byte{})
+	id, err := db.CrateUser(ctx, uuid.Nil, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte{})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	// clear
-	if err := db.DeleteUser(ctx, id); err != nil {
+	if err := db.DeleteUser(ctx, uuid.Nil, id); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
@@ -62,7 +62,7 @@ func TestProvideUserById(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	id, err := db.CrateUser(ctx, "John", "Doe", "john.doe@example.com", []byte{})
+	id, err := db.CrateUser(ctx, uuid.Nil, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte{})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -93,7 +93,7 @@ func TestProvideUserById(t *testing.T) {
 	}
 
 	// clear
-	if err := db.DeleteUser(ctx, id); err != nil {
+	if err := db.DeleteUser(ctx, uuid.Nil, id); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
@@ -108,12 +108,12 @@ func TestProvideUsers(t *testing.T) {
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
-	id, err := db.CrateUser(ctx, "John", "Doe", "john.doe@example.com", []byte{})
+	id, err := db.CrateUser(ctx, uuid.Nil, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte{})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	users, err := db.ProvideUsersById(ctx, []uuid.UUID{id})
+	users, err := db.ProvideUsersById(ctx, uuid.Nil, []uuid.UUID{id})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -132,7 +132,51 @@ func TestProvideUsers(t *testing.T) {
 	}
 
 	// clear
-	if err := db.DeleteUser(ctx, id); err != nil {
+	if err := db.DeleteUser(ctx, uuid.Nil, id); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestProvideUsersAcrossOrganizations(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	db, err := New(ctx, cfg)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	org, err := db.SaveOrganization(ctx, models.Organization{Slug: "acme-" + uuid.NewString()[:8], Name: "Acme"})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	id, err := db.CrateUser(ctx, org.Id, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte{})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the member is not among the users outside of any organization
+	users, err := db.ProvideUsersById(ctx, uuid.Nil, []uuid.UUID{id})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("unexpected users: %v", users)
+	}
+
+	users, err = db.ProvideUsersById(ctx, models.AnyOrganization, []uuid.UUID{id})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(users) != 1 || users[0].UserInfo.Id != id || users[0].OrganizationId != org.Id {
+		t.Errorf("unexpected users: %v", users)
+	}
+
+	// clear
+	if err := db.DeleteUser(ctx, models.AnyOrganization, id); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
@@ -148,12 +192,12 @@ func TestProvideUserByEmail(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	id, err := db.CrateUser(ctx, "John", "Doe", "john.doe@example.com", []byte{})
+	id, err := db.CrateUser(ctx, uuid.Nil, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte{})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	user, err := db.ProvideUserByEmail(ctx, "john.doe@example.com")
+	user, err := db.ProvideUserByEmail(ctx, uuid.Nil, "john.doe@example.com")
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -172,7 +216,7 @@ func TestProvideUserByEmail(t *testing.T) {
 	}
 
 	// clear
-	if err := db.DeleteUser(ctx, id); err != nil {
+	if err := db.DeleteUser(ctx, uuid.Nil, id); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
@@ -188,7 +232,7 @@ func TestUpdateUser(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	id, err := db.CrateUser(ctx, "John", "Doe", "john.doe@example.com", []byte{})
+	id, err := db.CrateUser(ctx, uuid.Nil, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte{})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -196,7 +240,7 @@ func TestUpdateUser(t *testing.T) {
 	newName := "Johnny"
 	newSurname := "Doe2"
 
-	if err := db.UpdateUser(ctx, models.UserInfo{
+	if err := db.UpdateUser(ctx, uuid.Nil, models.UserInfo{
 		Id:      id,
 		Name:    newName,
 		Surname: newSurname,
@@ -223,7 +267,7 @@ func TestUpdateUser(t *testing.T) {
 	}
 
 	// clear
-	if err := db.DeleteUser(ctx, id); err != nil {
+	if err := db.DeleteUser(ctx, uuid.Nil, id); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
@@ -239,12 +283,12 @@ func TestChangePassword(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	id, err := db.CrateUser(ctx, "John", "Doe", "john.doe@example.com", []byte("password"))
+	id, err := db.CrateUser(ctx, uuid.Nil, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte("password"))
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	if err := db.ChangePassword(ctx, "john.doe@example.com", []byte("new-password")); err != nil {
+	if err := db.ChangePassword(ctx, id, []byte("new-password")); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
@@ -258,9 +302,64 @@ func TestChangePassword(t *testing.T) {
 	}
 
 	// clear
-	if err := db.DeleteUser(ctx, id); err != nil {
+	if err := db.DeleteUser(ctx, uuid.Nil, id); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestChangePasswordWithHistory(t *testing.T) {
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	db, err := New(ctx, cfg)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	id, err := db.CrateUser(ctx, uuid.Nil, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte("password-1"))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, password := range []string{"password-2", "password-3", "password-4"} {
+		if err := db.ChangePasswordWithHistory(ctx, id, []byte(password), 2); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	user, err := db.ProvideUserById(ctx, id)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if string(user.PassHash) != "password-4" {
+		t.Errorf("unexpected user password: %v", user.PassHash)
+	}
+
+	history, err := db.ProvidePasswordHistory(ctx, id, 10)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(history) != 2 || string(history[0]) != "password-3" || string(history[1]) != "password-2" {
+		t.Errorf("unexpected password history: %q", history)
+	}
+
+	// clear
+	if err := db.DeleteUser(ctx, uuid.Nil, id); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
+
+	history, err = db.ProvidePasswordHistory(ctx, id, 10)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(history) != 0 {
+		t.Errorf("password history is not purged")
+	}
 }
 
 func TestDeleteUser(t *testing.T) {
@@ -274,12 +373,12 @@ func TestDeleteUser(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	id, err := db.CrateUser(ctx, "John", "Doe", "john.doe@example.com", []byte{})
+	id, err := db.CrateUser(ctx, uuid.Nil, uuid.Nil, "John", "Doe", "john.doe@example.com", []byte{})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	if err := db.DeleteUser(ctx, id); err != nil {
+	if err := db.DeleteUser(ctx, uuid.Nil, id); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
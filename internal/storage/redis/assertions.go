@@ -0,0 +1,28 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+)
+
+const clientAssertionPrefix = "client_assertion:"
+
+// UseClientAssertion remembers the jti of an assertion of the subject until the assertion
+// expires, an assertion used before is rejected (RFC 7523 section 3)
+func (s *Storage) UseClientAssertion(ctx context.Context, subject, jti string, ttl time.Duration) error {
+	const op = "redis.UseClientAssertion"
+
+	ok, err := s.client.SetNX(ctx, clientAssertionPrefix+subject+":"+s.hashToken(jti), 1, ttl).Result()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrClientAssertionReused)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Failed login counters and locks are keyed by a subject like "email:<email>" or "ip:<ip>"
+const (
+	loginFailuresPrefix = "login_failures:"
+	loginLockPrefix     = "login_lock:"
+)
+
+// KEYS: failures
+// ARGV: window ms
+var incrFailuresScript = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return failures
+`)
+
+// IncrLoginFailures returns the number of failures including this one.
+// The counter is forgotten after window without failures
+func (s *Storage) IncrLoginFailures(ctx context.Context, subject string, window time.Duration) (int64, error) {
+	const op = "redis.IncrLoginFailures"
+
+	failures, err := incrFailuresScript.Run(ctx, s.client, []string{loginFailuresPrefix + subject}, window.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, nil
+}
+
+func (s *Storage) ResetLoginFailures(ctx context.Context, subject string) error {
+	const op = "redis.ResetLoginFailures"
+
+	if err := s.client.Del(ctx, loginFailuresPrefix+subject).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LockLogin(ctx context.Context, subject string, ttl time.Duration) error {
+	const op = "redis.LockLogin"
+
+	if err := s.client.Set(ctx, loginLockPrefix+subject, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LoginLockTTL returns how long the subject stays locked, zero if it is not locked
+func (s *Storage) LoginLockTTL(ctx context.Context, subject string) (time.Duration, error) {
+	const op = "redis.LoginLockTTL"
+
+	ttl, err := s.client.PTTL(ctx, loginLockPrefix+subject).Result()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// -2 if the key does not exist, -1 if it has no expiration
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// device authorizations keyed by the device code hash, the user code points to the device code
+const (
+	deviceCodePrefix = "device_code:"
+	userCodePrefix   = "device_user_code:"
+	devicePollPrefix = "device_poll:"
+)
+
+func (s *Storage) CreateDeviceAuthorization(ctx context.Context, deviceCode, userCode string, authorization models.DeviceAuthorization, ttl time.Duration) error {
+	const op = "redis.CreateDeviceAuthorization"
+
+	deviceKey := deviceCodePrefix + s.hashToken(deviceCode)
+
+	// user codes are short, a collision with a pending one is possible
+	ok, err := s.client.SetNX(ctx, userCodePrefix+s.hashToken(userCode), deviceKey, ttl).Result()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserCodeExists)
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, deviceKey, "client_id", authorization.ClientId, "scope", authorization.Scope, "approved", 0)
+		pipe.Expire(ctx, deviceKey, ttl)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvideDeviceAuthorization(ctx context.Context, deviceCode string) (models.DeviceAuthorization, error) {
+	const op = "redis.ProvideDeviceAuthorization"
+
+	fields, err := s.client.HGetAll(ctx, deviceCodePrefix+s.hashToken(deviceCode)).Result()
+	if err != nil {
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(fields) == 0 {
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+	}
+
+	authorization := models.DeviceAuthorization{
+		ClientId: fields["client_id"],
+		Scope:    fields["scope"],
+		Approved: fields["approved"] == "1",
+	}
+
+	if authorization.Approved {
+		if authorization.UserId, err = uuid.Parse(fields["user_id"]); err != nil {
+			return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		approvedAt, err := strconv.ParseInt(fields["approved_at"], 10, 64)
+		if err != nil {
+			return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		authorization.ApprovedAt = time.Unix(approvedAt, 0)
+	}
+
+	return authorization, nil
+}
+
+// ApproveDeviceAuthorization approves the authorization of the user code for the user.
+// The user code can be used only once
+func (s *Storage) ApproveDeviceAuthorization(ctx context.Context, userCode string, userId uuid.UUID) error {
+	const op = "redis.ApproveDeviceAuthorization"
+
+	deviceKey, err := s.client.GetDel(ctx, userCodePrefix+s.hashToken(userCode)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserCodeNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	ttl, err := s.client.TTL(ctx, deviceKey).Result()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ttl <= 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserCodeNotFound)
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, deviceKey, "approved", 1, "user_id", userId.String(), "approved_at", time.Now().Unix())
+		pipe.Expire(ctx, deviceKey, ttl)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteDeviceAuthorization reports whether this call deleted the authorization,
+// so that concurrent polls cannot both redeem it
+func (s *Storage) DeleteDeviceAuthorization(ctx context.Context, deviceCode string) (bool, error) {
+	const op = "redis.DeleteDeviceAuthorization"
+
+	deleted, err := s.client.Del(ctx, deviceCodePrefix+s.hashToken(deviceCode)).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted == 1, nil
+}
+
+// AllowDevicePoll reports whether the interval has passed since the previous poll of the device code
+func (s *Storage) AllowDevicePoll(ctx context.Context, deviceCode string, interval time.Duration) (bool, error) {
+	const op = "redis.AllowDevicePoll"
+
+	ok, err := s.client.SetNX(ctx, devicePollPrefix+s.hashToken(deviceCode), 1, interval).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ok, nil
+}
@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const mfaChallengePrefix = "mfa_challenge:"
+
+func (s *Storage) CreateMFAChallenge(ctx context.Context, challengeToken string, userId uuid.UUID, ttl time.Duration) error {
+	const op = "redis.CreateMFAChallenge"
+
+	key := mfaChallengePrefix + s.hashToken(challengeToken)
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", userId.String(), "attempts", 0)
+		pipe.Expire(ctx, key, ttl)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ProvideMFAChallenge(ctx context.Context, challengeToken string) (uuid.UUID, error) {
+	const op = "redis.ProvideMFAChallenge"
+
+	userId, err := s.client.HGet(ctx, mfaChallengePrefix+s.hashToken(challengeToken), "user_id").Result()
+	if err != nil {
+		if err == redis.Nil {
+			return uuid.Nil, fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+		}
+
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// IncrMFAChallengeAttempts returns the number of failed attempts including this one
+func (s *Storage) IncrMFAChallengeAttempts(ctx context.Context, challengeToken string) (int64, error) {
+	const op = "redis.IncrMFAChallengeAttempts"
+
+	attempts, err := s.client.HIncrBy(ctx, mfaChallengePrefix+s.hashToken(challengeToken), "attempts", 1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) DeleteMFAChallenge(ctx context.Context, challengeToken string) error {
+	const op = "redis.DeleteMFAChallenge"
+
+	if err := s.client.Del(ctx, mfaChallengePrefix+s.hashToken(challengeToken)).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
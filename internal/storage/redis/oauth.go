@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// authorization codes of the oauth code flow, keyed by the code hash
+const authorizationCodePrefix = "oauth_code:"
+
+func (s *Storage) CreateAuthorizationCode(ctx context.Context, code string, grant models.AuthorizationGrant, ttl time.Duration) error {
+	const op = "redis.CreateAuthorizationCode"
+
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.client.Set(ctx, authorizationCodePrefix+s.hashToken(code), data, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TakeAuthorizationCode returns the grant and deletes it, so a code can be exchanged only once
+func (s *Storage) TakeAuthorizationCode(ctx context.Context, code string) (models.AuthorizationGrant, error) {
+	const op = "redis.TakeAuthorizationCode"
+
+	data, err := s.client.GetDel(ctx, authorizationCodePrefix+s.hashToken(code)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return models.AuthorizationGrant{}, fmt.Errorf("%s: %w", op, storage.ErrAuthorizationCodeNotFound)
+		}
+
+		return models.AuthorizationGrant{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var grant models.AuthorizationGrant
+	if err := json.Unmarshal(data, &grant); err != nil {
+		return models.AuthorizationGrant{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return grant, nil
+}
@@ -0,0 +1,39 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// webauthn session data between the begin and finish calls of a ceremony
+const passkeyCeremonyPrefix = "passkey_ceremony:"
+
+func (s *Storage) CreatePasskeyCeremony(ctx context.Context, ceremonyToken string, sessionData []byte, ttl time.Duration) error {
+	const op = "redis.CreatePasskeyCeremony"
+
+	if err := s.client.Set(ctx, passkeyCeremonyPrefix+s.hashToken(ceremonyToken), sessionData, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TakePasskeyCeremony returns the session data and deletes it, so a challenge can be answered only once
+func (s *Storage) TakePasskeyCeremony(ctx context.Context, ceremonyToken string) ([]byte, error) {
+	const op = "redis.TakePasskeyCeremony"
+
+	sessionData, err := s.client.GetDel(ctx, passkeyCeremonyPrefix+s.hashToken(ceremonyToken)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrPasskeyCeremonyNotFound)
+		}
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessionData, nil
+}
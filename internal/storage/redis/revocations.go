@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/pkg/authorization"
+)
+
+// RevokeAccessToken denies the token id until the token expires
+func (s *Storage) RevokeAccessToken(ctx context.Context, jti string, ttl time.Duration) error {
+	const op = "redis.RevokeAccessToken"
+
+	if err := s.client.Set(ctx, authorization.RevokedTokenPrefix+jti, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "redis.IsRevoked"
+
+	n, err := s.client.Exists(ctx, authorization.RevokedTokenPrefix+jti).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,523 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// A session is a family of refresh tokens issued by rotation from a single login.
+// Rotated tokens are kept until they expire so that their reuse can be detected.
+// Every user has an index of their session ids.
+// Refresh tokens are only stored as their keyed hash.
+// Sessions opened by an OAuth grant keep the client and the scope they were granted.
+// The scripts below read the session id of a token and then touch the keys of the session
+// and of its user, which can not be passed in KEYS in advance. They need a single redis node,
+// see New
+const (
+	refreshTokenPrefix = "refresh_token:"
+	sessionPrefix      = "session:"
+	userSessionsPrefix = "user_sessions:"
+)
+
+// extends the ttl of the user index, never shortens it
+const extendIndexTTL = `
+local function extendTTL(key, ttl)
+	if redis.call('PTTL', key) < tonumber(ttl) then
+		redis.call('PEXPIRE', key, ttl)
+	end
+end
+`
+
+// KEYS: token, session, user index
+// ARGV: ttl ms, user id, session id, refresh token hash, user agent, ip, now, client id, scope
+var createScript = redis.NewScript(extendIndexTTL + `
+redis.call('HSET', KEYS[1], 'user_id', ARGV[2], 'session_id', ARGV[3], 'rotated', '0')
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[2], 'user_id', ARGV[2], 'refresh_token', ARGV[4],
+	'user_agent', ARGV[5], 'ip', ARGV[6], 'created_at', ARGV[7], 'last_used_at', ARGV[7],
+	'client_id', ARGV[8], 'scope', ARGV[9])
+redis.call('PEXPIRE', KEYS[2], ARGV[1])
+redis.call('SADD', KEYS[3], ARGV[3])
+extendTTL(KEYS[3], ARGV[1])
+return 1
+`)
+
+// returns 1 on success, 0 if the session does not exist
+// and -1 if the token was already rotated, in which case the session is revoked.
+// KEYS: old token, new token
+// ARGV: ttl ms, new refresh token hash, user agent, ip, now
+var rotateScript = redis.NewScript(extendIndexTTL + `
+local token = redis.call('HMGET', KEYS[1], 'user_id', 'session_id', 'rotated')
+if not token[1] then
+	return 0
+end
+
+local sessionKey = '` + sessionPrefix + `' .. token[2]
+local indexKey = '` + userSessionsPrefix + `' .. token[1]
+if redis.call('EXISTS', sessionKey) == 0 then
+	return 0
+end
+
+if token[3] == '1' then
+	local current = redis.call('HGET', sessionKey, 'refresh_token')
+	if current then
+		redis.call('DEL', '` + refreshTokenPrefix + `' .. current)
+	end
+	redis.call('DEL', sessionKey)
+	redis.call('SREM', indexKey, token[2])
+	return -1
+end
+
+redis.call('HSET', KEYS[1], 'rotated', '1')
+redis.call('HSET', KEYS[2], 'user_id', token[1], 'session_id', token[2], 'rotated', '0')
+redis.call('PEXPIRE', KEYS[2], ARGV[1])
+redis.call('HSET', sessionKey, 'refresh_token', ARGV[2], 'user_agent', ARGV[3], 'ip', ARGV[4], 'last_used_at', ARGV[5])
+redis.call('PEXPIRE', sessionKey, ARGV[1])
+extendTTL(indexKey, ARGV[1])
+return 1
+`)
+
+// revokes the session of the refresh token unless the token was rotated
+// KEYS: token
+var deleteScript = redis.NewScript(`
+local token = redis.call('HMGET', KEYS[1], 'user_id', 'session_id', 'rotated')
+if not token[1] or token[3] == '1' then
+	return 0
+end
+
+redis.call('DEL', KEYS[1], '` + sessionPrefix + `' .. token[2])
+redis.call('SREM', '` + userSessionsPrefix + `' .. token[1], token[2])
+return 1
+`)
+
+// KEYS: session, user index
+// ARGV: user id, session id
+var revokeScript = redis.NewScript(`
+local session = redis.call('HMGET', KEYS[1], 'user_id', 'refresh_token')
+if session[1] ~= ARGV[1] then
+	return 0
+end
+
+redis.call('DEL', KEYS[1], '` + refreshTokenPrefix + `' .. session[2])
+redis.call('SREM', KEYS[2], ARGV[2])
+return 1
+`)
+
+// KEYS: user index
+var revokeAllScript = redis.NewScript(`
+local ids = redis.call('SMEMBERS', KEYS[1])
+for _, id in ipairs(ids) do
+	local sessionKey = '` + sessionPrefix + `' .. id
+	local current = redis.call('HGET', sessionKey, 'refresh_token')
+	if current then
+		redis.call('DEL', '` + refreshTokenPrefix + `' .. current)
+	end
+	redis.call('DEL', sessionKey)
+end
+redis.call('DEL', KEYS[1])
+return #ids
+`)
+
+// legacySessionsEnd ends the migration of the sessions stored before sessions had ids,
+// a bare key of the refresh token set to the user id. Such tokens expire within the
+// refresh token ttl, 30 days by default, of the upgrade. The migration is to be removed after
+var legacySessionsEnd = time.Date(2026, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// opens a session for a token stored before sessions had ids, keeping its ttl
+// KEYS: token, token hash, session, user index
+// ARGV: user id, session id, token hash, now
+var migrateScript = redis.NewScript(extendIndexTTL + `
+if redis.call('TYPE', KEYS[1]).ok ~= 'string' or redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl <= 0 then
+	return 0
+end
+
+redis.call('HSET', KEYS[2], 'user_id', ARGV[1], 'session_id', ARGV[2], 'rotated', '0')
+redis.call('PEXPIRE', KEYS[2], ttl)
+redis.call('HSET', KEYS[3], 'user_id', ARGV[1], 'refresh_token', ARGV[3],
+	'user_agent', '', 'ip', '', 'created_at', ARGV[4], 'last_used_at', ARGV[4],
+	'client_id', '', 'scope', '')
+redis.call('PEXPIRE', KEYS[3], ttl)
+redis.call('SADD', KEYS[4], ARGV[2])
+extendTTL(KEYS[4], ttl)
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// CreateSession opens a session of the user of the token for the refresh token,
+// the session id is generated
+func (s *Storage) CreateSession(ctx context.Context, token models.RefreshToken, refreshToken string, device models.Device, tokenTTL time.Duration) error {
+	const op = "redis.CreateSession"
+
+	sessionId := uuid.NewString()
+	tokenHash := s.hashToken(refreshToken)
+	keys := []string{
+		refreshTokenPrefix + tokenHash,
+		sessionPrefix + sessionId,
+		userSessionsPrefix + token.UserId.String(),
+	}
+	args := []interface{}{
+		tokenTTL.Milliseconds(),
+		token.UserId.String(),
+		sessionId,
+		tokenHash,
+		device.UserAgent,
+		device.IP,
+		time.Now().Unix(),
+		token.ClientId,
+		token.Scope,
+	}
+
+	if err := createScript.Run(ctx, s.client, keys, args...).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// migrateLegacySession opens a session for a refresh token stored before sessions had ids
+// and reports whether it did. It is called when a token is not found, until legacySessionsEnd
+func (s *Storage) migrateLegacySession(ctx context.Context, refreshToken string) (bool, error) {
+	if time.Now().After(legacySessionsEnd) {
+		return false, nil
+	}
+
+	// the bare keys are uuids, a token of another shape can not name a key of sso
+	if _, err := uuid.Parse(refreshToken); err != nil {
+		return false, nil
+	}
+
+	value, err := s.client.Get(ctx, refreshToken).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	// the user id was written as the binary of uuid.UUID
+	userId, err := uuid.FromBytes(value)
+	if err != nil {
+		return false, nil
+	}
+
+	sessionId := uuid.NewString()
+	tokenHash := s.hashToken(refreshToken)
+	keys := []string{
+		refreshToken,
+		refreshTokenPrefix + tokenHash,
+		sessionPrefix + sessionId,
+		userSessionsPrefix + userId.String(),
+	}
+
+	res, err := migrateScript.Run(ctx, s.client, keys, userId.String(), sessionId, tokenHash, time.Now().Unix()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
+func (s *Storage) UpdateSession(ctx context.Context, oldRefreshToken, newRefreshToken string, device models.Device, tokenTTL time.Duration) error {
+	const op = "redis.UpdateSession"
+
+	err := s.updateSession(ctx, oldRefreshToken, newRefreshToken, device, tokenTTL)
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		migrated, migrateErr := s.migrateLegacySession(ctx, oldRefreshToken)
+		if migrateErr != nil {
+			return fmt.Errorf("%s: %w", op, migrateErr)
+		}
+		if migrated {
+			err = s.updateSession(ctx, oldRefreshToken, newRefreshToken, device, tokenTTL)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) updateSession(ctx context.Context, oldRefreshToken, newRefreshToken string, device models.Device, tokenTTL time.Duration) error {
+	newTokenHash := s.hashToken(newRefreshToken)
+	keys := []string{refreshTokenPrefix + s.hashToken(oldRefreshToken), refreshTokenPrefix + newTokenHash}
+	args := []interface{}{
+		tokenTTL.Milliseconds(),
+		newTokenHash,
+		device.UserAgent,
+		device.IP,
+		time.Now().Unix(),
+	}
+
+	res, err := rotateScript.Run(ctx, s.client, keys, args...).Int()
+	if err != nil {
+		return err
+	}
+
+	switch res {
+	case 0:
+		return storage.ErrSessionNotFound
+	case -1:
+		return storage.ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+// ProvideSession returns the session of a refresh token whether or not the token was rotated,
+// so that UpdateSession detects its reuse. ExpiresAt is not set
+func (s *Storage) ProvideSession(ctx context.Context, refreshToken string) (models.RefreshToken, error) {
+	const op = "redis.ProvideSession"
+
+	token, err := s.provideTokenSession(ctx, refreshToken)
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		migrated, migrateErr := s.migrateLegacySession(ctx, refreshToken)
+		if migrateErr != nil {
+			return models.RefreshToken{}, fmt.Errorf("%s: %w", op, migrateErr)
+		}
+		if migrated {
+			token, err = s.provideTokenSession(ctx, refreshToken)
+		}
+	}
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (s *Storage) provideTokenSession(ctx context.Context, refreshToken string) (models.RefreshToken, error) {
+	fields, err := s.client.HMGet(ctx, refreshTokenPrefix+s.hashToken(refreshToken), "user_id", "session_id").Result()
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	userId, _ := fields[0].(string)
+	sessionId, _ := fields[1].(string)
+	if userId == "" {
+		return models.RefreshToken{}, storage.ErrSessionNotFound
+	}
+
+	return s.provideSession(ctx, userId, sessionId)
+}
+
+// ProvideRefreshToken returns an active refresh token, rotated tokens are reported as not found
+func (s *Storage) ProvideRefreshToken(ctx context.Context, refreshToken string) (models.RefreshToken, error) {
+	const op = "redis.ProvideRefreshToken"
+
+	token, err := s.provideRefreshToken(ctx, refreshToken)
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		migrated, migrateErr := s.migrateLegacySession(ctx, refreshToken)
+		if migrateErr != nil {
+			return models.RefreshToken{}, fmt.Errorf("%s: %w", op, migrateErr)
+		}
+		if migrated {
+			token, err = s.provideRefreshToken(ctx, refreshToken)
+		}
+	}
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (s *Storage) provideRefreshToken(ctx context.Context, refreshToken string) (models.RefreshToken, error) {
+	key := refreshTokenPrefix + s.hashToken(refreshToken)
+
+	var (
+		fieldsCmd *redis.SliceCmd
+		ttlCmd    *redis.DurationCmd
+	)
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fieldsCmd = pipe.HMGet(ctx, key, "user_id", "session_id", "rotated")
+		ttlCmd = pipe.PTTL(ctx, key)
+
+		return nil
+	})
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	fields := fieldsCmd.Val()
+	userId, _ := fields[0].(string)
+	sessionId, _ := fields[1].(string)
+	rotated, _ := fields[2].(string)
+
+	if userId == "" || rotated == "1" || ttlCmd.Val() <= 0 {
+		return models.RefreshToken{}, storage.ErrSessionNotFound
+	}
+
+	token, err := s.provideSession(ctx, userId, sessionId)
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	token.ExpiresAt = time.Now().Add(ttlCmd.Val())
+
+	return token, nil
+}
+
+// provideSession returns the session of the refresh token record,
+// the record outlives its session if the session was revoked by id
+func (s *Storage) provideSession(ctx context.Context, userId, sessionId string) (models.RefreshToken, error) {
+	fields, err := s.client.HMGet(ctx, sessionPrefix+sessionId, "user_id", "client_id", "scope").Result()
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	sessionUserId, _ := fields[0].(string)
+	if sessionUserId == "" || sessionUserId != userId {
+		return models.RefreshToken{}, storage.ErrSessionNotFound
+	}
+
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	clientId, _ := fields[1].(string)
+	scope, _ := fields[2].(string)
+
+	return models.RefreshToken{
+		UserId:    id,
+		SessionId: sessionId,
+		ClientId:  clientId,
+		Scope:     scope,
+	}, nil
+}
+
+func (s *Storage) DeleteSession(ctx context.Context, refreshToken string) error {
+	const op = "redis.DeleteSession"
+
+	err := s.deleteSession(ctx, refreshToken)
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		migrated, migrateErr := s.migrateLegacySession(ctx, refreshToken)
+		if migrateErr != nil {
+			return fmt.Errorf("%s: %w", op, migrateErr)
+		}
+		if migrated {
+			err = s.deleteSession(ctx, refreshToken)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) deleteSession(ctx context.Context, refreshToken string) error {
+	res, err := deleteScript.Run(ctx, s.client, []string{refreshTokenPrefix + s.hashToken(refreshToken)}).Int()
+	if err != nil {
+		return err
+	}
+
+	if res == 0 {
+		return storage.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (s *Storage) ListSessions(ctx context.Context, userId uuid.UUID) ([]models.Session, error) {
+	const op = "redis.ListSessions"
+
+	indexKey := userSessionsPrefix + userId.String()
+
+	ids, err := s.client.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, sessionPrefix+id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions := make([]models.Session, 0, len(ids))
+	expired := make([]interface{}, 0)
+
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			expired = append(expired, ids[i])
+			continue
+		}
+
+		sessions = append(sessions, models.Session{
+			Id:     ids[i],
+			UserId: userId,
+			Device: models.Device{
+				UserAgent: fields["user_agent"],
+				IP:        fields["ip"],
+			},
+			CreatedAt:  parseUnix(fields["created_at"]),
+			LastUsedAt: parseUnix(fields["last_used_at"]),
+		})
+	}
+
+	if len(expired) > 0 {
+		if err := s.client.SRem(ctx, indexKey, expired...).Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (s *Storage) RevokeSession(ctx context.Context, userId uuid.UUID, sessionId string) error {
+	const op = "redis.RevokeSession"
+
+	keys := []string{sessionPrefix + sessionId, userSessionsPrefix + userId.String()}
+
+	res, err := revokeScript.Run(ctx, s.client, keys, userId.String(), sessionId).Int()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if res == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeAllSessions(ctx context.Context, userId uuid.UUID) error {
+	const op = "redis.RevokeAllSessions"
+
+	if err := revokeAllScript.Run(ctx, s.client, []string{userSessionsPrefix + userId.String()}).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func parseUnix(value string) time.Time {
+	sec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, 0)
+}
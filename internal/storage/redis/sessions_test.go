@@ -0,0 +1,235 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const tokenTTL = time.Hour
+
+func newTestStorage(t *testing.T) (*Storage, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &Storage{client: client, tokenSecret: []byte("secret")}, server
+}
+
+func createSession(t *testing.T, s *Storage, userId uuid.UUID) string {
+	t.Helper()
+
+	refreshToken := uuid.NewString()
+	if err := s.CreateSession(context.Background(), models.RefreshToken{UserId: userId}, refreshToken, models.Device{}, tokenTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	return refreshToken
+}
+
+func TestRotateSession(t *testing.T) {
+	s, server := newTestStorage(t)
+	ctx := context.Background()
+	userId := uuid.New()
+
+	oldToken := createSession(t, s, userId)
+	newToken := uuid.NewString()
+
+	if err := s.UpdateSession(ctx, oldToken, newToken, models.Device{IP: "10.0.0.1"}, tokenTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.ProvideRefreshToken(ctx, newToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserId != userId {
+		t.Errorf("unexpected user %v", token.UserId)
+	}
+
+	if _, err := s.ProvideRefreshToken(ctx, oldToken); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("expected the rotated token not to be active, got %v", err)
+	}
+
+	// the rotated token still names its session so that its reuse is detected
+	session, err := s.ProvideSession(ctx, oldToken)
+	if err != nil || session.SessionId != token.SessionId {
+		t.Errorf("unexpected session %+v, %v", session, err)
+	}
+
+	// tokens are stored as their hash only
+	for _, key := range server.Keys() {
+		if key == refreshTokenPrefix+oldToken || key == refreshTokenPrefix+newToken {
+			t.Errorf("token stored in plaintext: %s", key)
+		}
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+	userId := uuid.New()
+
+	oldToken := createSession(t, s, userId)
+	newToken := uuid.NewString()
+
+	if err := s.UpdateSession(ctx, oldToken, newToken, models.Device{}, tokenTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.UpdateSession(ctx, oldToken, uuid.NewString(), models.Device{}, tokenTTL)
+	if !errors.Is(err, storage.ErrRefreshTokenReused) {
+		t.Fatalf("expected reuse, got %v", err)
+	}
+
+	// the whole family is revoked, including the token issued by the rotation
+	if _, err := s.ProvideRefreshToken(ctx, newToken); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("expected the current token to be revoked, got %v", err)
+	}
+
+	sessions, err := s.ListSessions(ctx, userId)
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("unexpected sessions %v, %v", sessions, err)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+	userId := uuid.New()
+
+	refreshToken := createSession(t, s, userId)
+	other := createSession(t, s, userId)
+
+	session, err := s.ProvideRefreshToken(ctx, refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a session is revoked only by id of its user
+	if err := s.RevokeSession(ctx, uuid.New(), session.SessionId); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Fatalf("expected not found for another user, got %v", err)
+	}
+
+	if err := s.RevokeSession(ctx, userId, session.SessionId); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ProvideRefreshToken(ctx, refreshToken); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("expected the token of the revoked session not to be found, got %v", err)
+	}
+
+	if _, err := s.ProvideRefreshToken(ctx, other); err != nil {
+		t.Errorf("unexpected error for the other session: %v", err)
+	}
+
+	sessions, err := s.ListSessions(ctx, userId)
+	if err != nil || len(sessions) != 1 {
+		t.Errorf("unexpected sessions %v, %v", sessions, err)
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+	userId, otherUserId := uuid.New(), uuid.New()
+
+	tokens := []string{createSession(t, s, userId), createSession(t, s, userId)}
+	otherToken := createSession(t, s, otherUserId)
+
+	if err := s.RevokeAllSessions(ctx, userId); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, refreshToken := range tokens {
+		if _, err := s.ProvideRefreshToken(ctx, refreshToken); !errors.Is(err, storage.ErrSessionNotFound) {
+			t.Errorf("expected the token to be revoked, got %v", err)
+		}
+	}
+
+	if _, err := s.ProvideRefreshToken(ctx, otherToken); err != nil {
+		t.Errorf("unexpected error for the session of another user: %v", err)
+	}
+}
+
+func TestMigrateLegacySession(t *testing.T) {
+	s, server := newTestStorage(t)
+	ctx := context.Background()
+	userId := uuid.New()
+
+	// sessions were stored as the refresh token set to the user id
+	refreshToken := uuid.NewString()
+	if err := s.client.Set(ctx, refreshToken, userId, tokenTTL).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.ProvideRefreshToken(ctx, refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserId != userId || token.SessionId == "" {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	if server.Exists(refreshToken) {
+		t.Error("the legacy key is not deleted")
+	}
+
+	if ttl := server.TTL(refreshTokenPrefix + s.hashToken(refreshToken)); ttl <= 0 || ttl > tokenTTL {
+		t.Errorf("unexpected ttl %v", ttl)
+	}
+
+	sessions, err := s.ListSessions(ctx, userId)
+	if err != nil || len(sessions) != 1 {
+		t.Errorf("unexpected sessions %v, %v", sessions, err)
+	}
+
+	// the migrated token rotates like any other
+	if err := s.UpdateSession(ctx, refreshToken, uuid.NewString(), models.Device{}, tokenTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	// keys that are not legacy sessions are left alone
+	key := uuid.NewString()
+	if err := s.client.Set(ctx, key, "value", tokenTTL).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ProvideRefreshToken(ctx, key); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if !server.Exists(key) {
+		t.Error("a key that is not a legacy session is deleted")
+	}
+}
+
+func TestMigrateLegacySessionEnded(t *testing.T) {
+	s, server := newTestStorage(t)
+	ctx := context.Background()
+
+	end := legacySessionsEnd
+	legacySessionsEnd = time.Now().Add(-time.Minute)
+	t.Cleanup(func() { legacySessionsEnd = end })
+
+	refreshToken := uuid.NewString()
+	if err := s.client.Set(ctx, refreshToken, uuid.New(), tokenTTL).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ProvideRefreshToken(ctx, refreshToken); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("expected not found after the end of the migration, got %v", err)
+	}
+
+	if !server.Exists(refreshToken) {
+		t.Error("the legacy key is migrated after the end of the migration")
+	}
+}
@@ -2,88 +2,66 @@ package redis
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"time"
-
-	"github.com/google/uuid"
-	"github.com/hesoyamTM/apphelper-sso/internal/storage"
+	"strings"
 
 	"github.com/redis/go-redis/v9"
 )
 
 type Storage struct {
 	client *redis.Client
+
+	tokenSecret []byte
 }
 
 type RedisConfig struct {
 	Host     string `yaml:"host" env-required:"true" env:"REDIS_HOST"`
 	Port     int    `yaml:"port" env-required:"true" env:"REDIS_PORT"`
 	Password string `yaml:"password" env-required:"true" env:"REDIS_PASSWORD"`
+
+	// key of the HMAC used to store refresh tokens
+	TokenSecret string `yaml:"token_secret" env-required:"true" env:"REDIS_TOKEN_SECRET"`
 }
 
-func New(ctx context.Context, cfg RedisConfig) *Storage {
+// ErrClusterMode is returned by New for a redis cluster node
+var ErrClusterMode = errors.New("redis cluster is not supported")
+
+// New connects to a single redis node. The session scripts build some of the keys they touch
+// inside the script instead of receiving them in KEYS, which redis cluster does not allow,
+// so a node with cluster mode enabled is refused
+func New(ctx context.Context, cfg RedisConfig) (*Storage, error) {
+	const op = "redis.New"
+
 	client := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
 		Password: cfg.Password,
 		DB:       0,
 	})
 
-	return &Storage{
-		client: client,
-	}
-}
-
-func (s *Storage) CreateSession(ctx context.Context, userId uuid.UUID, refreshToken string, tokenTTL time.Duration) error {
-	const op = "redis.CreateSession"
-
-	if err := s.client.Set(ctx, refreshToken, userId, tokenTTL).Err(); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
-	}
-
-	return nil
-}
-
-func (s *Storage) UpdateSession(ctx context.Context, oldRefreshToken, newRefreshToken string, tokenTTL time.Duration) error {
-	const op = "redis.UpdateSession"
-
-	if err := s.client.Rename(ctx, oldRefreshToken, newRefreshToken).Err(); err != nil {
-		if err == redis.Nil {
-			return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
-		}
-
-		return fmt.Errorf("%s: %w", op, err)
-	}
-
-	if err := s.client.Expire(ctx, newRefreshToken, tokenTTL).Err(); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
-	}
-
-	return nil
-}
-
-// returns user id
-func (s *Storage) ProvideUser(ctx context.Context, refreshToken string) (uuid.UUID, error) {
-	const op = "redis.ProvideUser"
-
-	var userId uuid.NullUUID
-	err := s.client.Get(ctx, refreshToken).Scan(&userId)
+	info, err := client.Info(ctx, "cluster").Result()
 	if err != nil {
-		if err == redis.Nil {
-			return uuid.Nil, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
-		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
-		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	if strings.Contains(info, "cluster_enabled:1") {
+		return nil, fmt.Errorf("%s: %w", op, ErrClusterMode)
 	}
 
-	return userId.UUID, nil
+	return &Storage{
+		client:      client,
+		tokenSecret: []byte(cfg.TokenSecret),
+	}, nil
 }
 
-func (s *Storage) DeleteSession(ctx context.Context, refreshToken string) error {
-	const op = "redis.DeleteSession"
-
-	if err := s.client.Del(ctx, refreshToken).Err(); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
-	}
+// hashToken returns the keyed hash of a secret token, so that
+// tokens can not be taken over by anyone able to read the storage
+func (s *Storage) hashToken(token string) string {
+	mac := hmac.New(sha256.New, s.tokenSecret)
+	mac.Write([]byte(token))
 
-	return nil
+	return hex.EncodeToString(mac.Sum(nil))
 }
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id VARCHAR(64) PRIMARY KEY,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+)
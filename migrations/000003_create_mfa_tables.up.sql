@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS mfa_totp (
+    user_id uuid PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash BYTEA NOT NULL,
+    used_at TIMESTAMPTZ,
+    PRIMARY KEY (user_id, code_hash)
+);
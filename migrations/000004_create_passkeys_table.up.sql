@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id BYTEA PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    public_key BYTEA NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL,
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    aaguid BYTEA,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    clone_warning BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS passkeys_user_id_idx ON passkeys (user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    secret_hash BYTEA,
+    redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+    grant_types TEXT[] NOT NULL DEFAULT '{}',
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    access_token_ttl_seconds BIGINT NOT NULL DEFAULT 0,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
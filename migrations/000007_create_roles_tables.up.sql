@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(64) PRIMARY KEY,
+    description VARCHAR(256) NOT NULL DEFAULT '',
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(64) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role)
+);
+
+INSERT INTO roles (name, description, permissions) VALUES (
+    'admin',
+    'manages users, roles and oauth clients',
+    '{sso:users:read,sso:users:write,sso:roles:write,sso:clients:write}'
+) ON CONFLICT DO NOTHING;
@@ -0,0 +1,10 @@
+UPDATE roles SET permissions = array_remove(array_remove(permissions, 'sso:organizations:read'), 'sso:organizations:write')
+WHERE name = 'admin';
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_scope_email_key;
+ALTER TABLE users DROP COLUMN IF EXISTS email_scope;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+DROP TABLE IF EXISTS organization_member_roles;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
\ No newline at end of file
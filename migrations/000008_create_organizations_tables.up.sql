@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(128) NOT NULL,
+    scoped_emails BOOLEAN NOT NULL DEFAULT FALSE,
+    settings JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- a user is a member of at most one organization
+CREATE TABLE IF NOT EXISTS organization_members (
+    user_id uuid PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    organization_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_organization_id_idx ON organization_members (organization_id);
+
+CREATE TABLE IF NOT EXISTS organization_member_roles (
+    user_id uuid NOT NULL REFERENCES organization_members(user_id) ON DELETE CASCADE,
+    role VARCHAR(64) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role)
+);
+
+-- emails are unique within their scope, the organization of the user if it scopes emails
+-- and the nil uuid otherwise
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_scope uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_email_scope_email_key UNIQUE (email_scope, email);
+
+UPDATE roles SET permissions = permissions || '{sso:organizations:read,sso:organizations:write}'
+WHERE name = 'admin' AND NOT permissions @> '{sso:organizations:write}';
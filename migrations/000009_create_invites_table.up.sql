@@ -0,0 +1,9 @@
+-- the invited user is created without a password, the invite is deleted once accepted.
+-- The invite token is opaque and only its hash is stored
+CREATE TABLE IF NOT EXISTS invites (
+    user_id uuid PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    invited_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,4 @@
+UPDATE roles SET permissions = array_remove(permissions, 'sso:service_accounts:write')
+WHERE name = 'admin';
+
+DROP TABLE IF EXISTS service_accounts;
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- service accounts have no password, public_key is the PKIX DER of the P-256 key their assertions are signed with
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id uuid PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+UPDATE roles SET permissions = permissions || '{sso:service_accounts:write}'
+WHERE name = 'admin' AND NOT permissions @> '{sso:service_accounts:write}';
@@ -0,0 +1,4 @@
+UPDATE roles SET permissions = array_remove(permissions, 'sso:users:impersonate')
+WHERE name = 'admin';
+
+DROP TABLE IF EXISTS impersonations;
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- the audit trail of admins acting as users, rows are never updated or deleted by sso
+CREATE TABLE IF NOT EXISTS impersonations (
+    id uuid PRIMARY KEY,
+    actor_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    reason VARCHAR(256) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS impersonations_user_id_idx ON impersonations (user_id);
+CREATE INDEX IF NOT EXISTS impersonations_actor_id_idx ON impersonations (actor_id);
+
+UPDATE roles SET permissions = permissions || '{sso:users:impersonate}'
+WHERE name = 'admin' AND NOT permissions @> '{sso:users:impersonate}';
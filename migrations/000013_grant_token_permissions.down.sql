@@ -0,0 +1,2 @@
+UPDATE roles SET permissions = array_remove(array_remove(permissions, 'sso:tokens:read'), 'sso:tokens:write')
+WHERE name = 'admin';
\ No newline at end of file
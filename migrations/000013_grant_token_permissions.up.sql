@@ -0,0 +1,3 @@
+-- introspection and revocation over grpc are for the services and admins trusted with any token
+UPDATE roles SET permissions = permissions || '{sso:tokens:read,sso:tokens:write}'
+WHERE name = 'admin' AND NOT permissions @> '{sso:tokens:write}';
@@ -0,0 +1,107 @@
+package authorization
+
+import (
+	"context"
+	"slices"
+
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// UserId returns the uid of the access token the request was authorized with
+func UserId(ctx context.Context) (string, bool) {
+	uid, ok := ctx.Value(Uid).(string)
+
+	return uid, ok && uid != ""
+}
+
+// ServiceAccountId returns the service account the access token the request was authorized with is issued to
+func ServiceAccountId(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ServiceAccount).(string)
+
+	return id, ok && id != ""
+}
+
+// ActorId returns the admin acting as the user of the access token the request was authorized with,
+// it is set only for impersonation tokens, e.g. to show the user was viewed by support
+func ActorId(ctx context.Context) (string, bool) {
+	actorId, ok := ctx.Value(Actor).(string)
+
+	return actorId, ok && actorId != ""
+}
+
+// HasPermission reports whether the access token the request was authorized with has the permission
+func HasPermission(ctx context.Context, permission string) bool {
+	permissions, _ := ctx.Value(Permissions).([]string)
+
+	return slices.Contains(permissions, permission)
+}
+
+// Interactive reports whether the request was authorized with an access token the user got from Login,
+// not with a personal access token, an impersonation token or the token of a service account
+func Interactive(ctx context.Context) bool {
+	interactive, _ := ctx.Value(LoginToken).(bool)
+
+	return interactive
+}
+
+// Organization returns the organization of the access token the request was authorized with,
+// it is not set for users outside of any organization
+func Organization(ctx context.Context) (string, bool) {
+	orgId, ok := ctx.Value(OrganizationId).(string)
+
+	return orgId, ok && orgId != ""
+}
+
+func withClaims(ctx context.Context, claims jwks.Claims, personal bool) context.Context {
+	ctx = context.WithValue(ctx, Uid, claims.UserId)
+	ctx = context.WithValue(ctx, LoginToken, !personal && claims.UserId != "" && claims.ClientId == "" && claims.Actor == nil)
+	ctx = context.WithValue(ctx, OrganizationId, claims.OrganizationId)
+	if claims.PrincipalType == jwks.PrincipalTypeService {
+		ctx = context.WithValue(ctx, ServiceAccount, claims.Subject)
+	}
+	if claims.Actor != nil {
+		ctx = context.WithValue(ctx, Actor, claims.Actor.Subject)
+	}
+
+	return context.WithValue(ctx, Permissions, claims.Permissions)
+}
+
+// incomingKeys are only set in the incoming metadata from a verified token
+var incomingKeys = []string{string(Uid), string(OrganizationId), string(ServiceAccount), string(Actor)}
+
+// withoutCallerClaims drops the uid, the service account, the actor and the organization sent by the caller from the incoming metadata
+func withoutCallerClaims(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || !slices.ContainsFunc(incomingKeys, func(key string) bool { return len(md.Get(key)) > 0 }) {
+		return ctx
+	}
+
+	md = md.Copy()
+	for _, key := range incomingKeys {
+		md.Delete(key)
+	}
+
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// withIncomingClaims sets the uid or the service account, the actor and the organization of a verified token in the incoming metadata
+func withIncomingClaims(ctx context.Context, claims jwks.Claims) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	md = md.Copy()
+	if claims.PrincipalType == jwks.PrincipalTypeService {
+		md.Set(string(ServiceAccount), claims.Subject)
+	} else {
+		md.Set(string(Uid), claims.UserId)
+	}
+	if claims.Actor != nil {
+		md.Set(string(Actor), claims.Actor.Subject)
+	}
+	if claims.OrganizationId != "" {
+		md.Set(string(OrganizationId), claims.OrganizationId)
+	}
+
+	return metadata.NewIncomingContext(ctx, md)
+}
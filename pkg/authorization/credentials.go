@@ -0,0 +1,137 @@
+package authorization
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// assertions are sent right away, a short lifetime keeps a leaked one from being replayed
+const assertionLifetime = time.Minute
+
+// ServiceAccountConfig is a service account of sso and the token endpoint it gets tokens from
+type ServiceAccountConfig struct {
+	// token endpoint of sso, the audience of the assertions
+	TokenURL   string
+	AccountId  string
+	PrivateKey *ecdsa.PrivateKey
+	// Scope narrows the permissions of the tokens if set
+	Scope string
+	// AllowInsecure sends the tokens over connections without transport security, e.g. in a local environment
+	AllowInsecure bool
+}
+
+type serviceAccountCredentials struct {
+	cfg    ServiceAccountConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+}
+
+// NewServiceAccountCredentials attaches an access token of the service account to every call.
+// The token is got with a private_key_jwt assertion (RFC 7523) and refreshed before it expires
+func NewServiceAccountCredentials(cfg ServiceAccountConfig) credentials.PerRPCCredentials {
+	return &serviceAccountCredentials{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *serviceAccountCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (c *serviceAccountCredentials) RequireTransportSecurity() bool {
+	return !c.cfg.AllowInsecure
+}
+
+// accessToken returns the cached token until three quarters of its lifetime have passed
+func (c *serviceAccountCredentials) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.refreshAt) {
+		return c.token, nil
+	}
+
+	issuedAt := time.Now()
+
+	token, expiresIn, err := c.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	c.token = token
+	c.refreshAt = issuedAt.Add(expiresIn * 3 / 4)
+
+	return token, nil
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (c *serviceAccountCredentials) requestToken(ctx context.Context) (string, time.Duration, error) {
+	const op = "authorization.requestToken"
+
+	assertion, err := jwt.NewClientAssertion(c.cfg.AccountId, c.cfg.TokenURL, assertionLifetime, c.cfg.PrivateKey)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {jwt.ClientAssertionType},
+		"client_assertion":      {assertion},
+	}
+	if c.cfg.Scope != "" {
+		form.Set("scope", c.cfg.Scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", 0, fmt.Errorf("%s: unexpected status %d: %w", op, resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("%s: %s: %s", op, token.Error, token.ErrorDescription)
+	}
+
+	if token.AccessToken == "" || token.ExpiresIn <= 0 {
+		return "", 0, fmt.Errorf("%s: invalid token response", op)
+	}
+
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
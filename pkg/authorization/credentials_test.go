@@ -0,0 +1,94 @@
+package authorization
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+)
+
+func TestServiceAccountCredentials(t *testing.T) {
+	prKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		tokenURL string
+		issued   int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_assertion_type") != jwt.ClientAssertionType {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"error": "invalid_request"})
+			return
+		}
+
+		assertion, err := jwt.ParseClientAssertion(r.PostFormValue("client_assertion"), func(subject string) (*ecdsa.PublicKey, error) {
+			return &prKey.PublicKey, nil
+		}, tokenURL, time.Minute)
+		if err != nil || assertion.Subject != "report" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"error": "invalid_client"})
+			return
+		}
+
+		issued++
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+		})
+	}))
+	defer server.Close()
+	tokenURL = server.URL + "/token"
+
+	creds := NewServiceAccountCredentials(ServiceAccountConfig{
+		TokenURL:   tokenURL,
+		AccountId:  "report",
+		PrivateKey: prKey,
+	})
+
+	if !creds.RequireTransportSecurity() {
+		t.Fatal("expected transport security to be required")
+	}
+
+	for range 2 {
+		md, err := creds.GetRequestMetadata(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if md["authorization"] != "Bearer token" {
+			t.Fatalf("unexpected metadata %v", md)
+		}
+	}
+
+	// the token is cached until it is about to expire
+	if issued != 1 {
+		t.Fatalf("expected one token request, got %d", issued)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds = NewServiceAccountCredentials(ServiceAccountConfig{
+		TokenURL:   tokenURL,
+		AccountId:  "report",
+		PrivateKey: otherKey,
+	})
+
+	if _, err := creds.GetRequestMetadata(context.Background()); err == nil {
+		t.Fatal("expected an error for an assertion signed with another key")
+	}
+}
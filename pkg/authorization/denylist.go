@@ -0,0 +1,34 @@
+package authorization
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RevokedTokenPrefix is the prefix of the redis keys of revoked access token ids.
+// sso sets them with the remaining lifetime of the token
+const RevokedTokenPrefix = "revoked_jti:"
+
+// DenyList reports whether an access token was revoked before it expired
+type DenyList interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type redisDenyList struct {
+	client redis.UniversalClient
+}
+
+// NewRedisDenyList reads the access tokens revoked by sso from its redis
+func NewRedisDenyList(client redis.UniversalClient) DenyList {
+	return &redisDenyList{client: client}
+}
+
+func (d *redisDenyList) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	n, err := d.client.Exists(ctx, RevokedTokenPrefix+jti).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
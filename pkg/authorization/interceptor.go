@@ -5,8 +5,9 @@ import (
 	"crypto/ecdsa"
 	"errors"
 	"log/slog"
+	"sync"
 
-	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -17,25 +18,60 @@ import (
 type ServerInterceptor struct {
 	log         *slog.Logger
 	authMethods map[string]bool
+	options     options
 
-	publicKey *ecdsa.PublicKey
+	mu     sync.RWMutex
+	keySet jwks.JWKS
 }
 
-func NewServer(log *slog.Logger, authMethods map[string]bool, pubKeyCh <-chan *ecdsa.PublicKey) *ServerInterceptor {
+func NewServer(log *slog.Logger, authMethods map[string]bool, pubKeyCh <-chan *ecdsa.PublicKey, opts ...Option) *ServerInterceptor {
 	interceptor := &ServerInterceptor{
 		log:         log,
 		authMethods: authMethods,
+		options:     newOptions(opts),
 	}
 
 	go func() {
-		for {
-			interceptor.publicKey = <-pubKeyCh
+		for publicKey := range pubKeyCh {
+			interceptor.setKeySet(jwks.NewJWKS(publicKey))
 		}
 	}()
 
 	return interceptor
 }
 
+// NewServerWithKeySet verifies tokens against the key set with the matching kid,
+// so tokens signed by any published key stay valid
+func NewServerWithKeySet(log *slog.Logger, authMethods map[string]bool, keySetCh <-chan jwks.JWKS, opts ...Option) *ServerInterceptor {
+	interceptor := &ServerInterceptor{
+		log:         log,
+		authMethods: authMethods,
+		options:     newOptions(opts),
+	}
+
+	go func() {
+		for keySet := range keySetCh {
+			interceptor.setKeySet(keySet)
+		}
+	}()
+
+	return interceptor
+}
+
+func (i *ServerInterceptor) setKeySet(keySet jwks.JWKS) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.keySet = keySet
+}
+
+func (i *ServerInterceptor) getKeySet() jwks.JWKS {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.keySet
+}
+
 func (i *ServerInterceptor) Unary() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 		i.log.Debug(info.FullMethod)
@@ -49,8 +85,12 @@ func (i *ServerInterceptor) Unary() grpc.UnaryServerInterceptor {
 	}
 }
 
+// authorize verifies the token of the method and sets its uid or service account, actor and organization
+// in the incoming metadata and the context, see UserId, ServiceAccountId, ActorId, Organization and HasPermission
 func (i *ServerInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
-	if !i.authMethods[method] {
+	ctx = withoutCallerClaims(ctx)
+
+	if !i.options.requiresAuth(i.authMethods, method) {
 		return ctx, nil
 	}
 
@@ -66,9 +106,9 @@ func (i *ServerInterceptor) authorize(ctx context.Context, method string) (conte
 		return nil, status.Error(codes.Unauthenticated, "authorization token is not provided")
 	}
 
-	uid, err := jwt.VerifyBearerToken(bearerToken[0], i.publicKey)
+	claims, personal, err := i.options.verify(ctx, bearerToken[0], i.getKeySet())
 	if err != nil {
-		if errors.Is(err, jwt.ErrUnauthorized) {
+		if errors.Is(err, ErrUnauthorized) {
 			i.log.Error("token time has expired")
 			return nil, status.Errorf(codes.Unauthenticated, "token time has expired")
 		}
@@ -77,5 +117,28 @@ func (i *ServerInterceptor) authorize(ctx context.Context, method string) (conte
 		return nil, status.Error(codes.Unauthenticated, "access token is invalid")
 	}
 
-	return metadata.AppendToOutgoingContext(ctx, "uid", uid), nil
+	if !i.options.isPrincipal(claims) {
+		i.log.Error("access token is not issued to a user or a service account")
+		return nil, status.Error(codes.Unauthenticated, "access token is invalid")
+	}
+
+	if i.options.denyList != nil {
+		revoked, err := i.options.denyList.IsRevoked(ctx, claims.ID)
+		if err != nil {
+			i.log.Error("failed to check token revocation", slog.String("Error", err.Error()))
+			return nil, status.Error(codes.Unavailable, "failed to check the access token")
+		}
+
+		if revoked {
+			i.log.Error("access token is revoked")
+			return nil, status.Error(codes.Unauthenticated, "access token is revoked")
+		}
+	}
+
+	if !i.options.permitted(method, claims) {
+		i.log.Error("permission denied", slog.String("method", method))
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	return withClaims(withIncomingClaims(ctx, claims), claims, personal), nil
 }
@@ -0,0 +1,324 @@
+package authorization
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	userMethod  = "/auth.Auth/UpdateUser"
+	adminMethod = "/auth.Auth/CreateRole"
+	permission  = "sso:roles:write"
+)
+
+func newTestInterceptor(t *testing.T) (*ServerInterceptor, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	prKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := &ServerInterceptor{
+		log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		authMethods: map[string]bool{userMethod: true},
+		options:     newOptions([]Option{WithPermissions(map[string]string{adminMethod: permission})}),
+	}
+	interceptor.setKeySet(jwks.NewJWKS(&prKey.PublicKey))
+
+	return interceptor, prKey
+}
+
+func incomingContext(t *testing.T, prKey *ecdsa.PrivateKey, userId uuid.UUID, permissions []string, pairs ...string) context.Context {
+	t.Helper()
+
+	token, err := jwt.NewAccessToken(jwt.AccessToken{
+		User:        models.UserInfo{Id: userId},
+		Permissions: permissions,
+	}, time.Minute, prKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := metadata.Pairs(append(pairs, "authorization", "Bearer "+token)...)
+
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestAuthorizeSetsUid(t *testing.T) {
+	interceptor, prKey := newTestInterceptor(t)
+	userId := uuid.New()
+
+	ctx, err := interceptor.authorize(incomingContext(t, prKey, userId, nil, "uid", uuid.NewString()), userMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid, ok := UserId(ctx)
+	if !ok || uid != userId.String() {
+		t.Fatalf("unexpected uid %q", uid)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if got := md.Get("uid"); len(got) != 1 || got[0] != userId.String() {
+		t.Fatalf("unexpected uid metadata %v", got)
+	}
+
+	if HasPermission(ctx, permission) {
+		t.Fatal("unexpected permission")
+	}
+}
+
+func TestAuthorizeDropsCallerUid(t *testing.T) {
+	interceptor, _ := newTestInterceptor(t)
+
+	md := metadata.Pairs("uid", uuid.NewString())
+	ctx, err := interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), "/auth.Auth/Login")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uid, ok := UserId(ctx); ok {
+		t.Fatalf("unexpected uid %q", uid)
+	}
+
+	md, _ = metadata.FromIncomingContext(ctx)
+	if got := md.Get("uid"); len(got) != 0 {
+		t.Fatalf("unexpected uid metadata %v", got)
+	}
+}
+
+func TestAuthorizeSetsOrganization(t *testing.T) {
+	interceptor, prKey := newTestInterceptor(t)
+	orgId := uuid.New()
+
+	token, err := jwt.NewAccessToken(jwt.AccessToken{
+		User:           models.UserInfo{Id: uuid.New()},
+		OrganizationId: orgId,
+	}, time.Minute, prKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := metadata.Pairs("authorization", "Bearer "+token)
+	ctx, err := interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), userMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := Organization(ctx); !ok || got != orgId.String() {
+		t.Fatalf("unexpected organization %q", got)
+	}
+
+	// a caller outside of any organization cannot claim one
+	ctx, err = interceptor.authorize(incomingContext(t, prKey, uuid.New(), nil, "org_id", orgId.String()), userMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := Organization(ctx); ok {
+		t.Fatalf("unexpected organization %q", got)
+	}
+
+	md, _ = metadata.FromIncomingContext(ctx)
+	if got := md.Get("org_id"); len(got) != 0 {
+		t.Fatalf("unexpected organization metadata %v", got)
+	}
+}
+
+func TestAuthorizePermissions(t *testing.T) {
+	interceptor, prKey := newTestInterceptor(t)
+
+	_, err := interceptor.authorize(incomingContext(t, prKey, uuid.New(), nil), adminMethod)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+
+	ctx, err := interceptor.authorize(incomingContext(t, prKey, uuid.New(), []string{permission}), adminMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !HasPermission(ctx, permission) {
+		t.Fatal("expected the permission of the token")
+	}
+}
+
+func TestAuthorizeRequiresToken(t *testing.T) {
+	interceptor, _ := newTestInterceptor(t)
+
+	md := metadata.Pairs("uid", uuid.NewString())
+	_, err := interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), userMethod)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+}
+
+type personalTokens map[string]jwks.Claims
+
+func (p personalTokens) VerifyPersonalAccessToken(ctx context.Context, token string) (jwks.Claims, error) {
+	claims, ok := p[token]
+	if !ok {
+		return jwks.Claims{}, ErrUnauthorized
+	}
+
+	return claims, nil
+}
+
+func TestAuthorizePersonalAccessToken(t *testing.T) {
+	interceptor, _ := newTestInterceptor(t)
+	userId := uuid.New()
+
+	token := PersonalAccessTokenPrefix + "token"
+	interceptor.options.personalAccessTokens = personalTokens{
+		token: {UserId: userId.String(), Permissions: []string{permission}},
+	}
+
+	md := metadata.Pairs("authorization", "Bearer "+token)
+	ctx, err := interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), adminMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uid, ok := UserId(ctx); !ok || uid != userId.String() {
+		t.Fatalf("unexpected uid %q", uid)
+	}
+
+	md = metadata.Pairs("authorization", "Bearer "+PersonalAccessTokenPrefix+"unknown")
+	_, err = interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), userMethod)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+}
+
+func TestAuthorizeServiceAccount(t *testing.T) {
+	interceptor, prKey := newTestInterceptor(t)
+	accountId := uuid.New()
+
+	token, err := jwt.NewAccessToken(jwt.AccessToken{
+		ServiceAccountId: accountId,
+		Permissions:      []string{permission},
+	}, time.Minute, prKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := metadata.Pairs("authorization", "Bearer "+token, "uid", uuid.NewString())
+	ctx, err := interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), adminMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, ok := ServiceAccountId(ctx); !ok || id != accountId.String() {
+		t.Fatalf("unexpected service account %q", id)
+	}
+
+	if uid, ok := UserId(ctx); ok {
+		t.Fatalf("unexpected uid %q", uid)
+	}
+
+	md, _ = metadata.FromIncomingContext(ctx)
+	if got := md.Get("service_account_id"); len(got) != 1 || got[0] != accountId.String() || len(md.Get("uid")) != 0 {
+		t.Fatalf("unexpected metadata %v", md)
+	}
+
+	// a client acting for itself is neither a user nor a service account
+	token, err = jwt.NewAccessToken(jwt.AccessToken{ClientId: "report"}, time.Minute, prKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md = metadata.Pairs("authorization", "Bearer "+token)
+	_, err = interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), userMethod)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+}
+
+func TestAuthorizeRejectsDelegatedTokens(t *testing.T) {
+	interceptor, prKey := newTestInterceptor(t)
+	userId := uuid.New()
+
+	tokens := map[string]jwt.AccessToken{
+		"client":        {User: models.UserInfo{Id: userId}, ClientId: "journal"},
+		"impersonation": {User: models.UserInfo{Id: userId}, ActorId: uuid.New()},
+	}
+
+	for name, accessToken := range tokens {
+		t.Run(name, func(t *testing.T) {
+			token, err := jwt.NewAccessToken(accessToken, time.Minute, prKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			md := metadata.Pairs("authorization", "Bearer "+token)
+			_, err = interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), userMethod)
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("expected unauthenticated, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSetsActor(t *testing.T) {
+	interceptor, prKey := newTestInterceptor(t)
+	interceptor.options.impersonation = true
+	userId, actorId := uuid.New(), uuid.New()
+
+	token, err := jwt.NewAccessToken(jwt.AccessToken{
+		User:    models.UserInfo{Id: userId},
+		ActorId: actorId,
+	}, time.Minute, prKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := metadata.Pairs("authorization", "Bearer "+token)
+	ctx, err := interceptor.authorize(metadata.NewIncomingContext(context.Background(), md), userMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uid, ok := UserId(ctx); !ok || uid != userId.String() {
+		t.Fatalf("unexpected uid %q", uid)
+	}
+
+	if got, ok := ActorId(ctx); !ok || got != actorId.String() {
+		t.Fatalf("unexpected actor %q", got)
+	}
+
+	md, _ = metadata.FromIncomingContext(ctx)
+	if got := md.Get("act"); len(got) != 1 || got[0] != actorId.String() {
+		t.Fatalf("unexpected actor metadata %v", got)
+	}
+
+	// a caller cannot claim to be viewed by support
+	ctx, err = interceptor.authorize(incomingContext(t, prKey, userId, nil, "act", actorId.String()), userMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := ActorId(ctx); ok {
+		t.Fatalf("unexpected actor %q", got)
+	}
+
+	md, _ = metadata.FromIncomingContext(ctx)
+	if got := md.Get("act"); len(got) != 0 {
+		t.Fatalf("unexpected actor metadata %v", got)
+	}
+}
@@ -0,0 +1,80 @@
+package authorization
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// FetchJWKS downloads the key set published by sso at /.well-known/jwks.json
+func FetchJWKS(ctx context.Context, url string) (jwks.JWKS, error) {
+	const op = "authorization.FetchJWKS"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return jwks.JWKS{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return jwks.JWKS{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return jwks.JWKS{}, fmt.Errorf("%s: unexpected status %d", op, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return jwks.JWKS{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keySet, err := jwks.ParseJWKS(body)
+	if err != nil {
+		return jwks.JWKS{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keySet, nil
+}
+
+// WatchJWKS fetches the key set every interval until ctx is done.
+// The returned channel can be passed to NewServerWithKeySet or NewAuthMiddlewareWithKeySet
+func WatchJWKS(ctx context.Context, url string, interval time.Duration) <-chan jwks.JWKS {
+	log := logger.GetLoggerFromCtx(ctx)
+	keySetCh := make(chan jwks.JWKS, 1)
+
+	go func() {
+		defer close(keySetCh)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			keySet, err := FetchJWKS(ctx, url)
+			if err != nil {
+				log.Error(ctx, "failed to fetch jwks", zap.Error(err))
+			} else {
+				select {
+				case keySetCh <- keySet:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return keySetCh
+}
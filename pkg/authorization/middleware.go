@@ -1,11 +1,11 @@
 package authorization
 
 import (
-	"context"
 	"crypto/ecdsa"
 	"net/http"
+	"sync"
 
-	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
 	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
 )
 
@@ -13,38 +13,107 @@ type ctxKey string
 type Middleware func(next http.Handler) http.Handler
 
 const (
-	Uid ctxKey = "uid"
+	Uid            ctxKey = "uid"
+	OrganizationId ctxKey = "org_id"
+	Permissions    ctxKey = "permissions"
+	ServiceAccount ctxKey = "service_account_id"
+	Actor          ctxKey = "act"
+	LoginToken     ctxKey = "login_token"
 )
 
-func NewAuthMiddleware(authMethods map[string]bool, publicKey *ecdsa.PublicKey) Middleware {
+func NewAuthMiddleware(authMethods map[string]bool, publicKey *ecdsa.PublicKey, opts ...Option) Middleware {
+	keySet := jwks.NewJWKS(publicKey)
+
+	return newAuthMiddleware(authMethods, func() jwks.JWKS { return keySet }, newOptions(opts))
+}
+
+// NewAuthMiddlewareWithKeySet verifies tokens against the latest key set received from keySetCh
+func NewAuthMiddlewareWithKeySet(authMethods map[string]bool, keySetCh <-chan jwks.JWKS, opts ...Option) Middleware {
+	var (
+		mu     sync.RWMutex
+		keySet jwks.JWKS
+	)
+
+	go func() {
+		for set := range keySetCh {
+			mu.Lock()
+			keySet = set
+			mu.Unlock()
+		}
+	}()
+
+	return newAuthMiddleware(authMethods, func() jwks.JWKS {
+		mu.RLock()
+		defer mu.RUnlock()
+
+		return keySet
+	}, newOptions(opts))
+}
+
+func newAuthMiddleware(authMethods map[string]bool, keySet func() jwks.JWKS, options options) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			l := logger.GetLoggerFromCtx(r.Context())
 
-			if !authMethods[r.URL.Path] {
+			if !options.requiresAuth(authMethods, r.URL.Path) {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			cookieToken := r.CookiesNamed("authorization")
-
-			if len(cookieToken) == 0 {
-				l.Error(r.Context(), "cookies is empty")
+			bearerToken := requestToken(r)
+			if bearerToken == "" {
+				l.Error(r.Context(), "authorization token is not provided")
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			bearerToken := cookieToken[0].Value
-			uid, err := jwt.VerifyBearerToken(bearerToken, publicKey)
+			claims, personal, err := options.verify(r.Context(), bearerToken, keySet())
 			if err != nil {
 				l.Error(r.Context(), err.Error())
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			r = r.WithContext(context.WithValue(r.Context(), Uid, uid))
+			if !options.isPrincipal(claims) {
+				l.Error(r.Context(), "access token is not issued to a user or a service account")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if options.denyList != nil {
+				revoked, err := options.denyList.IsRevoked(r.Context(), claims.ID)
+				if err != nil {
+					l.Error(r.Context(), "failed to check token revocation: "+err.Error())
+					http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+					return
+				}
+
+				if revoked {
+					l.Error(r.Context(), "access token is revoked")
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			if !options.permitted(r.URL.Path, claims) {
+				l.Error(r.Context(), "permission denied")
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			r = r.WithContext(withClaims(r.Context(), claims, personal))
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// requestToken returns the bearer token of the authorization cookie of the browser or,
+// for scripts, of the Authorization header
+func requestToken(r *http.Request) string {
+	if cookieToken := r.CookiesNamed("authorization"); len(cookieToken) > 0 {
+		return cookieToken[0].Value
+	}
+
+	return r.Header.Get("Authorization")
+}
@@ -0,0 +1,55 @@
+package authorization
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/internal/models"
+	"github.com/hesoyamTM/apphelper-sso/pkg/logger"
+)
+
+func TestMiddlewareCookieToken(t *testing.T) {
+	_, prKey := newTestInterceptor(t)
+	userId := uuid.New()
+
+	ctx, err := logger.New(context.Background(), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := jwt.NewAccessToken(jwt.AccessToken{User: models.UserInfo{Id: userId}}, time.Minute, prKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	personalToken := PersonalAccessTokenPrefix + "token"
+
+	middleware := NewAuthMiddleware(map[string]bool{"/user": true}, &prKey.PublicKey,
+		WithPersonalAccessTokens(personalTokens{personalToken: {UserId: userId.String()}}))
+
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if uid, ok := UserId(r.Context()); !ok || uid != userId.String() {
+			t.Errorf("unexpected uid %q", uid)
+		}
+	}))
+
+	// the cookie is set without the bearer prefix of the header
+	for name, token := range map[string]string{"access token": accessToken, "personal access token": personalToken} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/user", nil)
+			r.AddCookie(&http.Cookie{Name: "authorization", Value: token})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status %d", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package authorization
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+)
+
+type options struct {
+	denyList DenyList
+	issuer   string
+	audience string
+	// required permission by full gRPC method name or http path
+	permissions map[string]string
+
+	personalAccessTokens PersonalAccessTokens
+	impersonation        bool
+}
+
+type Option func(*options)
+
+// WithDenyList rejects access tokens revoked before they expired
+func WithDenyList(denyList DenyList) Option {
+	return func(o *options) {
+		o.denyList = denyList
+	}
+}
+
+// WithIssuer rejects tokens whose iss is not the issuer of sso
+func WithIssuer(issuer string) Option {
+	return func(o *options) {
+		o.issuer = issuer
+	}
+}
+
+// WithAudience rejects tokens whose aud does not contain the audience
+func WithAudience(audience string) Option {
+	return func(o *options) {
+		o.audience = audience
+	}
+}
+
+// WithPermissions requires the permission of the method in the permissions claim of the token.
+// Methods are full gRPC method names for the interceptor and paths for the middleware,
+// they require authentication even if they are not among the auth methods
+func WithPermissions(permissions map[string]string) Option {
+	return func(o *options) {
+		o.permissions = permissions
+	}
+}
+
+// WithPersonalAccessTokens accepts personal access tokens as well as access tokens
+func WithPersonalAccessTokens(tokens PersonalAccessTokens) Option {
+	return func(o *options) {
+		o.personalAccessTokens = tokens
+	}
+}
+
+// WithImpersonation accepts the tokens of admins impersonating a user, see ActorId.
+// They are rejected by default like the tokens issued to OAuth clients
+func WithImpersonation() Option {
+	return func(o *options) {
+		o.impersonation = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// requiresAuth reports whether the method requires a token
+func (o options) requiresAuth(authMethods map[string]bool, method string) bool {
+	_, ok := o.permissions[method]
+
+	return authMethods[method] || ok
+}
+
+// permitted reports whether the claims have the permission the method requires
+func (o options) permitted(method string, claims jwks.Claims) bool {
+	permission, ok := o.permissions[method]
+
+	return !ok || slices.Contains(claims.Permissions, permission)
+}
+
+// verify returns the claims of an access token or a personal access token and whether it is
+// a personal access token. The authorization cookie is set without the bearer prefix of the header
+func (o options) verify(ctx context.Context, token string, keySet jwks.JWKS) (jwks.Claims, bool, error) {
+	token = strings.TrimPrefix(token, "Bearer ")
+
+	if strings.HasPrefix(token, PersonalAccessTokenPrefix) && o.personalAccessTokens != nil {
+		claims, err := o.personalAccessTokens.VerifyPersonalAccessToken(ctx, token)
+
+		return claims, true, err
+	}
+
+	claims, err := jwt.VerifyAccessToken("Bearer "+token, keySet, o.issuer, o.audience)
+
+	return claims, false, err
+}
+
+// isPrincipal reports whether the token is issued by Login to a user or to a service account.
+// Tokens of clients, on behalf of a user or not, are for the client only
+func (o options) isPrincipal(claims jwks.Claims) bool {
+	if claims.ClientId != "" || (claims.Actor != nil && !o.impersonation) {
+		return false
+	}
+
+	return claims.UserId != "" || claims.PrincipalType == jwks.PrincipalTypeService
+}
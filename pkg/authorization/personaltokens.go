@@ -0,0 +1,109 @@
+package authorization
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hesoyamTM/apphelper-sso/internal/lib/jwt"
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
+
+	golangjwt "github.com/golang-jwt/jwt/v5"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token so that secret scanners
+// can find leaked ones and the token is not mistaken for a JWT
+const PersonalAccessTokenPrefix = "ahp_"
+
+// ErrUnauthorized is wrapped by the errors of PersonalAccessTokens that reject the token
+var ErrUnauthorized = jwt.ErrUnauthorized
+
+// PersonalAccessTokens verifies personal access tokens. The claims are those of the user
+// of the token with the permissions limited to its scopes, errors wrapping
+// ErrUnauthorized reject the token
+type PersonalAccessTokens interface {
+	VerifyPersonalAccessToken(ctx context.Context, token string) (jwks.Claims, error)
+}
+
+type introspectionClient struct {
+	url          string
+	clientId     string
+	clientSecret string
+	client       *http.Client
+}
+
+// NewIntrospectionClient verifies personal access tokens with the introspection endpoint of sso
+// at <issuer>/introspect, authenticated as a confidential client
+func NewIntrospectionClient(introspectionURL, clientId, clientSecret string) PersonalAccessTokens {
+	return &introspectionClient{
+		url:          introspectionURL,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type introspectionResponse struct {
+	Active      bool     `json:"active"`
+	Sub         string   `json:"sub"`
+	Scope       string   `json:"scope"`
+	Jti         string   `json:"jti"`
+	Exp         int64    `json:"exp"`
+	OrgId       string   `json:"org_id"`
+	Permissions []string `json:"permissions"`
+}
+
+func (c *introspectionClient) VerifyPersonalAccessToken(ctx context.Context, token string) (jwks.Claims, error) {
+	const op = "authorization.VerifyPersonalAccessToken"
+
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"personal_access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.clientId), url.QueryEscape(c.clientSecret))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return jwks.Claims{}, fmt.Errorf("%s: unexpected status %d", op, resp.StatusCode)
+	}
+
+	var introspection introspectionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&introspection); err != nil {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !introspection.Active || introspection.Sub == "" {
+		return jwks.Claims{}, fmt.Errorf("%s: %w", op, ErrUnauthorized)
+	}
+
+	claims := jwks.Claims{
+		RegisteredClaims: golangjwt.RegisteredClaims{
+			ID:      introspection.Jti,
+			Subject: introspection.Sub,
+		},
+		UserId:         introspection.Sub,
+		Scope:          introspection.Scope,
+		OrganizationId: introspection.OrgId,
+		Permissions:    introspection.Permissions,
+	}
+	if introspection.Exp != 0 {
+		claims.ExpiresAt = golangjwt.NewNumericDate(time.Unix(introspection.Exp, 0))
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,53 @@
+package authorization
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+)
+
+func TestIntrospectionClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if clientId, secret, ok := r.BasicAuth(); !ok || clientId != "report" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.PostFormValue("token") != PersonalAccessTokenPrefix+"token" {
+			json.NewEncoder(w).Encode(map[string]any{"active": false})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"active":      true,
+			"sub":         "user",
+			"org_id":      "org",
+			"permissions": []string{permission},
+		})
+	}))
+	defer server.Close()
+
+	client := NewIntrospectionClient(server.URL, "report", "secret")
+
+	claims, err := client.VerifyPersonalAccessToken(context.Background(), PersonalAccessTokenPrefix+"token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.UserId != "user" || claims.OrganizationId != "org" || len(claims.Permissions) != 1 || claims.ExpiresAt != nil {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := client.VerifyPersonalAccessToken(context.Background(), PersonalAccessTokenPrefix+"unknown"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected unauthorized, got %v", err)
+	}
+
+	client = NewIntrospectionClient(server.URL, "report", "wrong")
+	if _, err := client.VerifyPersonalAccessToken(context.Background(), PersonalAccessTokenPrefix+"token"); err == nil || errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected an error of the endpoint, got %v", err)
+	}
+}
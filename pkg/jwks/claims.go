@@ -0,0 +1,31 @@
+package jwks
+
+import "github.com/golang-jwt/jwt/v5"
+
+// PrincipalTypeService is the principal_type of tokens issued to service accounts
+const PrincipalTypeService = "service"
+
+// Claims are the claims of an access token. UserId is empty for tokens issued to
+// a client or a service account for itself, ClientId is empty for tokens issued by Login
+type Claims struct {
+	jwt.RegisteredClaims
+	UserId   string `json:"uid,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Surname  string `json:"surname,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// organization of the user, the tenant the token is valid in
+	OrganizationId string `json:"org_id,omitempty"`
+	// roles of the user and the union of their permissions
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// PrincipalType is set for principals other than users and clients
+	PrincipalType string `json:"principal_type,omitempty"`
+	// Actor is the admin impersonating the user (RFC 8693 section 4.1)
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// Actor is the act claim, the party acting as the subject of the token
+type Actor struct {
+	Subject string `json:"sub"`
+}
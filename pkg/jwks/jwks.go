@@ -0,0 +1,139 @@
+// Package jwks is the public format of the tokens sso signs: the key set it publishes
+// at /.well-known/jwks.json and the claims of its access tokens
+package jwks
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	keyType  = "EC"
+	curve    = "P-256"
+	keyUse   = "sig"
+	keyAlg   = "ES256"
+	coordLen = 32
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrInvalidKey  = errors.New("invalid key")
+)
+
+// JWK is a public EC signing key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyID returns the RFC 7638 thumbprint of the public key
+func KeyID(publicKey *ecdsa.PublicKey) string {
+	x, y := encodeCoords(publicKey)
+
+	// members must be in lexicographic order and without whitespace
+	thumbprint := fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, curve, keyType, x, y)
+	sum := sha256.Sum256([]byte(thumbprint))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func NewJWK(publicKey *ecdsa.PublicKey) JWK {
+	x, y := encodeCoords(publicKey)
+
+	return JWK{
+		Kty: keyType,
+		Crv: curve,
+		X:   x,
+		Y:   y,
+		Kid: KeyID(publicKey),
+		Use: keyUse,
+		Alg: keyAlg,
+	}
+}
+
+func NewJWKS(publicKeys ...*ecdsa.PublicKey) JWKS {
+	keys := make([]JWK, 0, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		keys = append(keys, NewJWK(publicKey))
+	}
+
+	return JWKS{Keys: keys}
+}
+
+func ParseJWKS(data []byte) (JWKS, error) {
+	const op = "jwks.ParseJWKS"
+
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return JWKS{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return set, nil
+}
+
+func (k JWK) PublicKey() (*ecdsa.PublicKey, error) {
+	const op = "jwks.JWK.PublicKey"
+
+	if k.Kty != keyType || k.Crv != curve {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidKey)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil || len(x) != coordLen {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidKey)
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil || len(y) != coordLen {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidKey)
+	}
+
+	// ecdh rejects points that are not on the curve
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidKey)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
+
+// Key returns the public key with the given key id
+func (s JWKS) Key(kid string) (*ecdsa.PublicKey, error) {
+	const op = "jwks.JWKS.Key"
+
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key.PublicKey()
+		}
+	}
+
+	return nil, fmt.Errorf("%s: %w", op, ErrKeyNotFound)
+}
+
+func encodeCoords(publicKey *ecdsa.PublicKey) (string, string) {
+	x := publicKey.X.FillBytes(make([]byte, coordLen))
+	y := publicKey.Y.FillBytes(make([]byte, coordLen))
+
+	return base64.RawURLEncoding.EncodeToString(x), base64.RawURLEncoding.EncodeToString(y)
+}
@@ -1,12 +1,11 @@
 package tests
 
 import (
-	"crypto/ecdsa"
-	"crypto/x509"
-	"encoding/pem"
+	"context"
 	"testing"
 	"time"
 
+	"github.com/hesoyamTM/apphelper-sso/pkg/jwks"
 	"github.com/hesoyamTM/apphelper-sso/tests/suite"
 
 	"github.com/brianvoe/gofakeit"
@@ -17,11 +16,7 @@ import (
 )
 
 const (
-	passLen   = 20
-	publicKey = `-----BEGIN PUBLIC KEY-----
-MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAELQSAFUnyfqA7OCojTTtUXPltZ1Bc
-+dSeYmQq+Zr5qBGcUzbf7dnkZLSVeueXFyOlkPUfKVEpSKlwP6XROuEBlA==
------END PUBLIC KEY-----`
+	passLen = 20
 )
 
 func TestRegisterLoginHappyPath(t *testing.T) {
@@ -41,7 +36,7 @@ func TestRegisterLoginHappyPath(t *testing.T) {
 	loginTime := time.Now()
 	require.NoError(t, err)
 
-	checkJWT(t, respReg.GetAccessToken(), name, surname, loginTime.Add(st.Cfg.AccessTokenTTL))
+	checkJWT(ctx, st, respReg.GetAccessToken(), name, surname, loginTime.Add(st.Cfg.AccessTokenTTL))
 
 	require.NoError(t, err)
 	assert.NotEmpty(t, respReg.GetAccessToken())
@@ -56,7 +51,7 @@ func TestRegisterLoginHappyPath(t *testing.T) {
 
 	require.NoError(t, err)
 
-	checkJWT(t, respLog.GetAccessToken(), name, surname, loginTime.Add(st.Cfg.AccessTokenTTL))
+	checkJWT(ctx, st, respLog.GetAccessToken(), name, surname, loginTime.Add(st.Cfg.AccessTokenTTL))
 }
 
 func TestRegisterLogin_DuplicatedRegistration(t *testing.T) {
@@ -211,11 +206,29 @@ func randPass() string {
 	return gofakeit.Password(true, true, true, true, true, passLen)
 }
 
-func checkJWT(t *testing.T, token, name, surname string, exp time.Time) {
+func checkJWT(ctx context.Context, st *suite.Suite, token, name, surname string, exp time.Time) {
+	t := st.T
 	require.NotEmpty(t, token)
 
+	respKeys, err := st.AuthClient.GetJWKS(ctx, &ssov1.GetJWKSRequest{})
+	require.NoError(t, err)
+
+	keySet := jwks.JWKS{}
+	for _, key := range respKeys.GetKeys() {
+		keySet.Keys = append(keySet.Keys, jwks.JWK{
+			Kty: key.GetKty(),
+			Crv: key.GetCrv(),
+			X:   key.GetX(),
+			Y:   key.GetY(),
+			Kid: key.GetKid(),
+			Use: key.GetUse(),
+			Alg: key.GetAlg(),
+		})
+	}
+
 	tokenParsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		return decode(publicKey)
+		kid, _ := t.Header["kid"].(string)
+		return keySet.Key(kid)
 	})
 
 	require.NoError(t, err)
@@ -230,16 +243,3 @@ func checkJWT(t *testing.T, token, name, surname string, exp time.Time) {
 
 	assert.InDelta(t, exp.Unix(), claims["exp"].(float64), deltaSeconds)
 }
-
-func decode(pemEncodedPub string) (*ecdsa.PublicKey, error) {
-	blockPub, _ := pem.Decode([]byte(pemEncodedPub))
-	x509EncodedPub := blockPub.Bytes
-	genericPublicKey, err := x509.ParsePKIXPublicKey(x509EncodedPub)
-	if err != nil {
-		return nil, err
-	}
-
-	publicKey := genericPublicKey.(*ecdsa.PublicKey)
-
-	return publicKey, nil
-}